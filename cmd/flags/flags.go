@@ -3,12 +3,16 @@ package flags
 import (
 	"fmt"
 	"os"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/JulienBalestra/monitoring/pkg/archive"
 	"github.com/JulienBalestra/monitoring/pkg/datadog"
 	"github.com/JulienBalestra/monitoring/pkg/datadog/forward"
 	"github.com/JulienBalestra/monitoring/pkg/monitoring"
+	"github.com/JulienBalestra/monitoring/pkg/tagger"
+	"github.com/JulienBalestra/monitoring/pkg/tagger/replication"
 	"github.com/spf13/pflag"
 	"go.uber.org/zap"
 )
@@ -17,8 +21,39 @@ const (
 	DatadogAPIKeyFlag         = "datadog-api-key"
 	DatadogAPPKeyFlag         = "datadog-app-key"
 	DatadogClientSendInterval = "datadog-client-send-interval"
+	DatadogSeriesAPIFlag      = "datadog-series-api"
+	DatadogMetadataSyncFlag   = "datadog-metadata-sync-interval"
+	DatadogCompressionFlag    = "datadog-compression-level"
+	DatadogHostTagsSyncFlag   = "datadog-host-tags-sync-interval"
+	DatadogAPIURLFlag         = "datadog-api-url"
+	DatadogLogsURLFlag        = "datadog-logs-url"
+	DatadogDestinationFlag    = "datadog-destination"
+	DatadogAPIKeyFileFlag     = "datadog-api-key-file"
+	DatadogAPPKeyFileFlag     = "datadog-app-key-file"
+	DatadogKeyFilesCheckFlag  = "datadog-key-files-check-interval"
+	DatadogKeysValidationFlag = "datadog-keys-validation"
 
-	HostnameFlag = "hostname"
+	ArchiveDirectoryFlag       = "archive-directory"
+	ArchiveSegmentSizeFlag     = "archive-segment-size"
+	ArchiveSegmentDurationFlag = "archive-segment-duration"
+	ArchiveRetentionFlag       = "archive-retention"
+	ArchiveMaxSegmentsFlag     = "archive-max-segments"
+
+	CAFileFlag                    = "ca-file"
+	DatadogInsecureSkipVerifyFlag = "datadog-insecure-skip-verify"
+
+	HostnameFlag             = "hostname"
+	StateDirectoryFlag       = "state-directory"
+	TaggerSnapshotMaxAgeFlag = "tagger-snapshot-max-age"
+	TaggerTTLFlag            = "tagger-ttl"
+	TaggerKeyTTLFlag         = "tagger-key-ttl"
+	TaggerEnrichmentFileFlag = "tagger-enrichment-file"
+
+	TaggerReplicationAddressFlag   = "tagger-replication-address"
+	TaggerReplicationCertFileFlag  = "tagger-replication-cert-file"
+	TaggerReplicationKeyFileFlag   = "tagger-replication-key-file"
+	TaggerReplicationTokenFileFlag = "tagger-replication-token-file"
+	TaggerReplicationPeerFlag      = "tagger-replication-peer"
 )
 
 func AddFlags(fs *pflag.FlagSet, monitoringConfig *monitoring.Config) {
@@ -31,9 +66,123 @@ func AddFlags(fs *pflag.FlagSet, monitoringConfig *monitoring.Config) {
 	fs.StringSliceVar(&monitoringConfig.HostTags, "datadog-host-tags", nil, "datadog host tags")
 	fs.StringVarP(&monitoringConfig.DatadogClientConfig.DatadogAPIKey, DatadogAPIKeyFlag, "i", "", "datadog API key")
 	fs.StringVarP(&monitoringConfig.DatadogClientConfig.DatadogAPPKey, DatadogAPPKeyFlag, "p", "", "datadog APP key")
+	fs.StringVar(&monitoringConfig.DatadogAPIKeyFile, DatadogAPIKeyFileFlag, "", "file of the datadog API key, re-read to rotate the key")
+	fs.StringVar(&monitoringConfig.DatadogAPPKeyFile, DatadogAPPKeyFileFlag, "", "file of the datadog APP key, re-read to rotate the key")
+	fs.DurationVar(&monitoringConfig.KeyFilesCheckInterval, DatadogKeyFilesCheckFlag, datadog.DefaultKeyFilesCheckInterval, "datadog key files check interval")
+	fs.StringVar(&monitoringConfig.KeysValidation, DatadogKeysValidationFlag, monitoring.KeysValidationFatal, fmt.Sprintf("datadog keys validation at startup - %s %s %s", monitoring.KeysValidationFatal, monitoring.KeysValidationWarn, monitoring.KeysValidationOff))
 	fs.StringVar(&monitoringConfig.Hostname, HostnameFlag, hostname, "datadog host tag")
 	fs.DurationVar(&monitoringConfig.DatadogClientConfig.SendInterval, DatadogClientSendInterval, time.Second*35, "datadog client send interval to the API >= "+datadog.MinimalSendInterval.String())
+	fs.StringVar(&monitoringConfig.DatadogClientConfig.SeriesAPI, DatadogSeriesAPIFlag, datadog.SeriesAPIV1, fmt.Sprintf("datadog series API - %s %s %s", datadog.SeriesAPIV1, datadog.SeriesAPIV2, datadog.SeriesAPIV2Protobuf))
+	fs.IntVar(&monitoringConfig.DatadogClientConfig.CompressionLevel, DatadogCompressionFlag, datadog.DefaultCompressionLevel, "datadog series payload zlib compression level from 1 (fastest) to 9 (smallest), 9 saves ~5% of the size for three times the CPU")
+	fs.DurationVar(&monitoringConfig.MetadataSyncInterval, DatadogMetadataSyncFlag, datadog.DefaultMetadataSyncInterval, "datadog metric metadata sync interval, requires the APP key, 0 to disable")
+	fs.DurationVar(&monitoringConfig.HostTagsSyncInterval, DatadogHostTagsSyncFlag, datadog.DefaultHostTagsSyncInterval, "datadog host tags sync interval, requires the APP key, 0 to disable")
+	fs.StringVar(&monitoringConfig.DatadogClientConfig.APIURL, DatadogAPIURLFlag, datadog.DefaultAPIURL, "datadog API base URL")
+	fs.StringVar(&monitoringConfig.DatadogClientConfig.LogsURL, DatadogLogsURLFlag, datadog.DefaultLogsURL, "datadog logs intake base URL")
+	fs.Var(&destinationsValue{destinations: &monitoringConfig.DatadogClientConfig.Destinations}, DatadogDestinationFlag, "additional datadog org receiving the series, repeatable - name=team,site=datadoghq.eu,api-key-env=TEAM_DATADOG_API_KEY,metrics=network.*|temperature.*")
+	fs.StringVar(&monitoringConfig.CAFile, CAFileFlag, "", "PEM certificate authorities of the datadog client and the collectors, defaults to the system ones with an embedded bundle as fallback")
+	fs.BoolVar(&monitoringConfig.DatadogInsecureSkipVerify, DatadogInsecureSkipVerifyFlag, false, "accept any certificate from the datadog APIs")
 	fs.StringVarP(&monitoringConfig.ConfigFile, "config-file", "c", "/etc/monitoring/config.yaml", "monitoring configuration file")
+	fs.StringVar(&monitoringConfig.StateDirectory, StateDirectoryFlag, "", "directory to persist the state across restarts, empty to disable")
+	fs.DurationVar(&monitoringConfig.TaggerTTL, TaggerTTLFlag, 0, "default TTL of the tagger tags, 0 never expires them")
+	fs.Var(&keyTTLsValue{ttls: &monitoringConfig.TaggerKeyTTLs}, TaggerKeyTTLFlag, "TTL of the tagger tags of a key, repeatable - lease=24h")
+	fs.StringVar(&monitoringConfig.TaggerEnrichmentFile, TaggerEnrichmentFileFlag, "", "YAML or CSV file of static tags added to the tagger entities by MAC, IP, lease or wireguard public key, reloaded on change")
+	fs.StringVar(&monitoringConfig.TaggerReplicationAddress, TaggerReplicationAddressFlag, "", "address exporting the tagger to the peers like :8126, empty to disable")
+	fs.StringVar(&monitoringConfig.TaggerReplicationCertFile, TaggerReplicationCertFileFlag, "", "PEM certificate serving the tagger replication over TLS")
+	fs.StringVar(&monitoringConfig.TaggerReplicationKeyFile, TaggerReplicationKeyFileFlag, "", "PEM key of the tagger replication certificate")
+	fs.StringVar(&monitoringConfig.TaggerReplicationTokenFile, TaggerReplicationTokenFileFlag, "", "file of the token shared by the tagger replication server and its peers")
+	fs.Var(&peersValue{peers: &monitoringConfig.TaggerReplicationPeers}, TaggerReplicationPeerFlag, fmt.Sprintf("peer exporting its tagger, repeatable - url=http://router:8126,keys=lease|vendor,entities=192.168.1.*,conflict=%s|%s|%s", tagger.LocalWins, tagger.RemoteWins, tagger.NewestWins))
+	fs.DurationVar(&monitoringConfig.TaggerSnapshotMaxAge, TaggerSnapshotMaxAgeFlag, tagger.DefaultSnapshotMaxAge, "age after which the tags of the tagger snapshot aren't restored")
+	fs.StringVar(&monitoringConfig.Archive.Directory, ArchiveDirectoryFlag, "", "directory archiving the flushed series in newline-delimited JSON, empty to disable")
+	fs.Int64Var(&monitoringConfig.Archive.SegmentSize, ArchiveSegmentSizeFlag, archive.DefaultSegmentSize, "archive segment size in bytes before its rotation")
+	fs.DurationVar(&monitoringConfig.Archive.SegmentDuration, ArchiveSegmentDurationFlag, archive.DefaultSegmentDuration, "archive segment duration before its rotation")
+	fs.DurationVar(&monitoringConfig.Archive.Retention, ArchiveRetentionFlag, archive.DefaultRetention, "retention of the rotated archive segments, 0 to keep them")
+	fs.IntVar(&monitoringConfig.Archive.MaxSegments, ArchiveMaxSegmentsFlag, 0, "maximum number of rotated archive segments, 0 for no limit")
 	fs.StringVar(&monitoringConfig.ZapLevel, "log-level", "info", fmt.Sprintf("log level - %s %s %s %s %s %s %s", zap.DebugLevel, zap.InfoLevel, zap.WarnLevel, zap.ErrorLevel, zap.DPanicLevel, zap.PanicLevel, zap.FatalLevel))
 	fs.StringSliceVar(&monitoringConfig.ZapConfig.OutputPaths, "log-output", append(monitoringConfig.ZapConfig.OutputPaths, forward.DatadogZapOutput), "log output")
 }
+
+// destinationsValue appends a datadog.Destination for each flag occurrence
+type destinationsValue struct {
+	destinations *[]*datadog.Destination
+}
+
+func (v *destinationsValue) String() string {
+	names := make([]string, 0, len(*v.destinations))
+	for _, d := range *v.destinations {
+		names = append(names, d.Name)
+	}
+	return "[" + strings.Join(names, ",") + "]"
+}
+
+func (v *destinationsValue) Set(s string) error {
+	d, err := datadog.ParseDestination(s)
+	if err != nil {
+		return err
+	}
+	*v.destinations = append(*v.destinations, d)
+	return nil
+}
+
+func (v *destinationsValue) Type() string {
+	return "destination"
+}
+
+// peersValue appends a tagger replication peer for each flag occurrence
+type peersValue struct {
+	peers *[]*replication.Peer
+}
+
+func (v *peersValue) String() string {
+	names := make([]string, 0, len(*v.peers))
+	for _, p := range *v.peers {
+		names = append(names, p.Name)
+	}
+	return "[" + strings.Join(names, ",") + "]"
+}
+
+func (v *peersValue) Set(s string) error {
+	p, err := replication.ParsePeer(s)
+	if err != nil {
+		return err
+	}
+	*v.peers = append(*v.peers, p)
+	return nil
+}
+
+func (v *peersValue) Type() string {
+	return "peer"
+}
+
+// keyTTLsValue sets the TTL of a tag key for each flag occurrence like lease=24h
+type keyTTLsValue struct {
+	ttls *map[string]time.Duration
+}
+
+func (v *keyTTLsValue) String() string {
+	keys := make([]string, 0, len(*v.ttls))
+	for k, ttl := range *v.ttls {
+		keys = append(keys, k+"="+ttl.String())
+	}
+	sort.Strings(keys)
+	return "[" + strings.Join(keys, ",") + "]"
+}
+
+func (v *keyTTLsValue) Set(s string) error {
+	i := strings.Index(s, "=")
+	if i <= 0 {
+		return fmt.Errorf("invalid key TTL %q, must be key=duration", s)
+	}
+	ttl, err := time.ParseDuration(s[i+1:])
+	if err != nil {
+		return fmt.Errorf("invalid key TTL %q: %v", s, err)
+	}
+	if *v.ttls == nil {
+		*v.ttls = make(map[string]time.Duration)
+	}
+	(*v.ttls)[s[:i]] = ttl
+	return nil
+}
+
+func (v *keyTTLsValue) Type() string {
+	return "key=duration"
+}
@@ -0,0 +1,28 @@
+package metadata
+
+import (
+	"fmt"
+
+	// the collectors register their metric metadata at init time
+	_ "github.com/JulienBalestra/monitoring/pkg/collector/catalog"
+	"github.com/JulienBalestra/monitoring/pkg/metrics"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v2"
+)
+
+func NewCommand() *cobra.Command {
+	return &cobra.Command{
+		Short: "print the metadata of the metrics emitted by the collectors",
+		Long:  "print the metadata of the metrics emitted by the collectors",
+		Use:   "metadata",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := yaml.Marshal(metrics.DefaultRegistry.All())
+			if err != nil {
+				return err
+			}
+			fmt.Print(string(b))
+			return nil
+		},
+	}
+}
@@ -0,0 +1,161 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/JulienBalestra/dry/pkg/env"
+	"github.com/JulienBalestra/monitoring/cmd/flags"
+	"github.com/JulienBalestra/monitoring/pkg/archive"
+	"github.com/JulienBalestra/monitoring/pkg/datadog"
+	"github.com/JulienBalestra/monitoring/pkg/metrics"
+	"github.com/JulienBalestra/monitoring/pkg/tlsconfig"
+	"github.com/spf13/cobra"
+)
+
+const (
+	SinkDatadog = "datadog"
+	SinkStdout  = "stdout"
+
+	defaultBatchSize = 1000
+	sendTimeout      = time.Second * 30
+)
+
+type sink interface {
+	send(ctx context.Context, series []metrics.Series) error
+}
+
+type datadogSink struct {
+	client *datadog.Client
+}
+
+func (s *datadogSink) send(ctx context.Context, series []metrics.Series) error {
+	ctx, cancel := context.WithTimeout(ctx, sendTimeout)
+	defer cancel()
+	return s.client.SendSeries(ctx, series)
+}
+
+// stdoutSink writes the series as newline-delimited JSON
+type stdoutSink struct {
+	encoder *json.Encoder
+}
+
+func (s *stdoutSink) send(_ context.Context, series []metrics.Series) error {
+	for i := range series {
+		err := s.encoder.Encode(&series[i])
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func NewCommand(ctx context.Context) *cobra.Command {
+	directory, from, to, sinkName, caFile := "", "", "", SinkDatadog, ""
+	batchSize := defaultBatchSize
+	datadogConfig := &datadog.Config{}
+	cmd := &cobra.Command{
+		Short: "re-send a time range of the archived series to a sink",
+		Long:  "re-send a time range of the archived series to a sink, Datadog only accepts the points of the last hour",
+		Use:   "replay",
+		Args:  cobra.NoArgs,
+	}
+	fs := cmd.Flags()
+	fs.StringVar(&directory, flags.ArchiveDirectoryFlag, "", "directory of the archived series")
+	fs.StringVar(&from, "from", "", "RFC3339 start of the range, defaults to one hour ago")
+	fs.StringVar(&to, "to", "", "RFC3339 end of the range, defaults to now")
+	fs.StringVar(&sinkName, "sink", SinkDatadog, fmt.Sprintf("sink of the series - %s %s", SinkDatadog, SinkStdout))
+	fs.IntVar(&batchSize, "batch-size", defaultBatchSize, "series sent per request")
+	fs.StringVarP(&datadogConfig.DatadogAPIKey, flags.DatadogAPIKeyFlag, "i", "", "datadog API key")
+	fs.StringVar(&datadogConfig.APIURL, flags.DatadogAPIURLFlag, datadog.DefaultAPIURL, "datadog API base URL")
+	fs.StringVar(&caFile, flags.CAFileFlag, "", "PEM certificate authorities of the datadog API, defaults to the system ones with an embedded bundle as fallback")
+	fs.StringVar(&datadogConfig.SeriesAPI, flags.DatadogSeriesAPIFlag, datadog.SeriesAPIV1, fmt.Sprintf("datadog series API - %s %s %s", datadog.SeriesAPIV1, datadog.SeriesAPIV2, datadog.SeriesAPIV2Protobuf))
+
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		if directory == "" {
+			return fmt.Errorf("flag --%s must be set", flags.ArchiveDirectoryFlag)
+		}
+		if batchSize <= 0 {
+			return fmt.Errorf("invalid batch size %d", batchSize)
+		}
+		now := time.Now()
+		fromTime, err := parseTime(from, now.Add(-time.Hour))
+		if err != nil {
+			return err
+		}
+		toTime, err := parseTime(to, now)
+		if err != nil {
+			return err
+		}
+		if toTime.Before(fromTime) {
+			return fmt.Errorf("--to %s is before --from %s", toTime.Format(time.RFC3339), fromTime.Format(time.RFC3339))
+		}
+
+		var s sink
+		switch sinkName {
+		case SinkDatadog:
+			err = env.DefaultFromEnv(&datadogConfig.DatadogAPIKey, flags.DatadogAPIKeyFlag, "DATADOG_API_KEY")
+			if err != nil {
+				return err
+			}
+			err = datadog.ValidSeriesAPI(datadogConfig.SeriesAPI)
+			if err != nil {
+				return err
+			}
+			datadogConfig.TLSConfig, err = (&tlsconfig.Config{CAFile: caFile}).TLSConfig()
+			if err != nil {
+				return err
+			}
+			s = &datadogSink{client: datadog.NewClient(datadogConfig)}
+		case SinkStdout:
+			s = &stdoutSink{encoder: json.NewEncoder(cmd.OutOrStdout())}
+		default:
+			return fmt.Errorf("invalid sink %q, must be one of %s, %s", sinkName, SinkDatadog, SinkStdout)
+		}
+		replayed, err := replay(ctx, directory, fromTime, toTime, batchSize, s)
+		_, _ = fmt.Fprintf(cmd.ErrOrStderr(), "replayed %d series from %s to %s\n", replayed, fromTime.Format(time.RFC3339), toTime.Format(time.RFC3339))
+		return err
+	}
+	return cmd
+}
+
+func parseTime(s string, defaultTime time.Time) (time.Time, error) {
+	if s == "" {
+		return defaultTime, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// replay sends the archived series by batches and returns the number of sent series
+func replay(ctx context.Context, directory string, from, to time.Time, batchSize int, s sink) (int, error) {
+	replayed := 0
+	batch := make([]metrics.Series, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := s.send(ctx, batch)
+		if err != nil {
+			return err
+		}
+		replayed += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	err := archive.Read(directory, from, to, func(series *metrics.Series) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		batch = append(batch, *series)
+		if len(batch) < batchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return replayed, err
+	}
+	return replayed, flush()
+}
@@ -10,6 +10,8 @@ import (
 	"github.com/JulienBalestra/dry/pkg/signals"
 	"github.com/JulienBalestra/dry/pkg/version"
 	"github.com/JulienBalestra/monitoring/cmd/flags"
+	"github.com/JulienBalestra/monitoring/cmd/metadata"
+	"github.com/JulienBalestra/monitoring/cmd/replay"
 	"github.com/JulienBalestra/monitoring/pkg/monitoring"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
@@ -26,6 +28,8 @@ func NewRootCommand(ctx context.Context) *cobra.Command {
 		Use:   "monitoring",
 	}
 	root.AddCommand(version.NewCommand())
+	root.AddCommand(metadata.NewCommand())
+	root.AddCommand(replay.NewCommand(ctx))
 	fs := &pflag.FlagSet{}
 
 	pidFilePath := ""
@@ -39,13 +43,18 @@ func NewRootCommand(ctx context.Context) *cobra.Command {
 
 	root.Flags().AddFlagSet(fs)
 	root.PreRunE = func(cmd *cobra.Command, args []string) error {
-		err := env.DefaultFromEnv(&monitoringConfig.DatadogClientConfig.DatadogAPIKey, flags.DatadogAPIKeyFlag, "DATADOG_API_KEY")
-		if err != nil {
-			return err
+		// the key files are read by monitoring.NewMonitoring
+		if monitoringConfig.DatadogAPIKeyFile == "" {
+			err := env.DefaultFromEnv(&monitoringConfig.DatadogClientConfig.DatadogAPIKey, flags.DatadogAPIKeyFlag, "DATADOG_API_KEY")
+			if err != nil {
+				return err
+			}
 		}
-		err = env.DefaultFromEnv(&monitoringConfig.DatadogClientConfig.DatadogAPPKey, flags.DatadogAPPKeyFlag, "DATADOG_APP_KEY")
-		if err != nil {
-			return err
+		if monitoringConfig.DatadogAPPKeyFile == "" {
+			err := env.DefaultFromEnv(&monitoringConfig.DatadogClientConfig.DatadogAPPKey, flags.DatadogAPPKeyFlag, "DATADOG_APP_KEY")
+			if err != nil {
+				return err
+			}
 		}
 		tz, err := time.LoadLocation(timezone)
 		if err != nil {
@@ -0,0 +1,273 @@
+// Package archive appends the flushed series to local newline-delimited JSON segments
+// the segments are rotated by size and age, gzipped and removed after the retention
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JulienBalestra/monitoring/pkg/datadog"
+	"github.com/JulienBalestra/monitoring/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultSegmentSize     = 16 << 20
+	DefaultSegmentDuration = time.Hour
+	DefaultRetention       = time.Hour * 24 * 7
+
+	segmentPrefix       = "series-"
+	segmentExt          = ".ndjson"
+	compressedExt       = segmentExt + ".gz"
+	segmentTimeLayout   = "20060102T150405.000Z"
+	segmentWriterBuffer = 64 << 10
+)
+
+type Config struct {
+	Directory string
+	// SegmentSize in bytes rotates the current segment, defaults to DefaultSegmentSize
+	SegmentSize int64
+	// SegmentDuration rotates the current segment, defaults to DefaultSegmentDuration
+	SegmentDuration time.Duration
+	// Retention removes the rotated segments started before, 0 keeps them
+	Retention time.Duration
+	// MaxSegments keeps the most recent rotated segments, 0 keeps them all
+	MaxSegments int
+}
+
+// Writer appends every archived batch to the current segment, one series per line
+type Writer struct {
+	conf *Config
+	mu   *sync.Mutex
+
+	f       *os.File
+	bw      *bufio.Writer
+	size    int64
+	started time.Time
+
+	now func() time.Time
+}
+
+// NewWriter compresses the segments left by a previous run and applies the retention
+func NewWriter(conf *Config) (*Writer, error) {
+	if conf.SegmentSize <= 0 {
+		conf.SegmentSize = DefaultSegmentSize
+	}
+	if conf.SegmentDuration <= 0 {
+		conf.SegmentDuration = DefaultSegmentDuration
+	}
+	err := os.MkdirAll(conf.Directory, 0755)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{
+		conf: conf,
+		mu:   &sync.Mutex{},
+		now:  time.Now,
+	}
+	segments, err := listSegments(conf.Directory)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range segments {
+		if s.compressed {
+			continue
+		}
+		err = compressSegment(s.path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return w, w.applyRetention()
+}
+
+// Archive appends the series as they are flushed by the datadog.Client
+func (w *Writer) Archive(series datadog.SeriesIterator) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := w.now()
+	if w.f == nil {
+		err := w.open(now)
+		if err != nil {
+			return err
+		}
+	}
+	var err error
+	series(func(s *metrics.Series) {
+		if err != nil {
+			return
+		}
+		b, marshalErr := json.Marshal(s)
+		if marshalErr != nil {
+			// like NaN values, they aren't sent either
+			zap.L().Debug("skipping series", zap.String("metric", s.Metric), zap.Error(marshalErr))
+			return
+		}
+		b = append(b, '\n')
+		var n int
+		n, err = w.bw.Write(b)
+		w.size += int64(n)
+	})
+	if err != nil {
+		return err
+	}
+	err = w.bw.Flush()
+	if err != nil {
+		return err
+	}
+	if w.size >= w.conf.SegmentSize || now.Sub(w.started) >= w.conf.SegmentDuration {
+		return w.rotate()
+	}
+	return nil
+}
+
+// Close rotates the current segment
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	return w.rotate()
+}
+
+func (w *Writer) open(now time.Time) error {
+	name := filepath.Join(w.conf.Directory, segmentPrefix+now.UTC().Format(segmentTimeLayout)+segmentExt)
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	w.f = f
+	w.bw = bufio.NewWriterSize(f, segmentWriterBuffer)
+	w.size = 0
+	w.started = now
+	return nil
+}
+
+func (w *Writer) rotate() error {
+	f := w.f
+	w.f, w.bw = nil, nil
+	err := f.Close()
+	if err != nil {
+		return err
+	}
+	err = compressSegment(f.Name())
+	if err != nil {
+		return err
+	}
+	return w.applyRetention()
+}
+
+func (w *Writer) applyRetention() error {
+	segments, err := listSegments(w.conf.Directory)
+	if err != nil {
+		return err
+	}
+	var rotated []*segment
+	for _, s := range segments {
+		if s.compressed {
+			rotated = append(rotated, s)
+		}
+	}
+	threshold := time.Time{}
+	if w.conf.Retention > 0 {
+		threshold = w.now().Add(-w.conf.Retention)
+	}
+	for i, s := range rotated {
+		tooMany := w.conf.MaxSegments > 0 && len(rotated)-i > w.conf.MaxSegments
+		if !tooMany && !s.started.Before(threshold) {
+			continue
+		}
+		err = os.Remove(s.path)
+		if err != nil {
+			return err
+		}
+		zap.L().Debug("removed archive segment", zap.String("segment", s.path))
+	}
+	return nil
+}
+
+// compressSegment replaces the segment by its gzip
+func compressSegment(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	tmp := dst.Name()
+	zw, _ := gzip.NewWriterLevel(dst, gzip.BestSpeed)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	closeErr := dst.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, strings.TrimSuffix(path, segmentExt)+compressedExt)
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Remove(path)
+}
+
+type segment struct {
+	path       string
+	started    time.Time
+	compressed bool
+}
+
+// listSegments returns the segments sorted by their start
+func listSegments(directory string) ([]*segment, error) {
+	entries, err := ioutil.ReadDir(directory)
+	if err != nil {
+		return nil, err
+	}
+	var segments []*segment
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, segmentPrefix) {
+			continue
+		}
+		s := &segment{path: filepath.Join(directory, name)}
+		switch {
+		case strings.HasSuffix(name, compressedExt):
+			s.compressed = true
+			name = strings.TrimSuffix(name, compressedExt)
+		case strings.HasSuffix(name, segmentExt):
+			name = strings.TrimSuffix(name, segmentExt)
+		default:
+			continue
+		}
+		s.started, err = time.Parse(segmentTimeLayout, strings.TrimPrefix(name, segmentPrefix))
+		if err != nil {
+			continue
+		}
+		segments = append(segments, s)
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].started.Before(segments[j].started)
+	})
+	return segments, nil
+}
@@ -0,0 +1,192 @@
+package archive
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JulienBalestra/monitoring/pkg/datadog"
+	"github.com/JulienBalestra/monitoring/pkg/datadog/datadogtest"
+	"github.com/JulienBalestra/monitoring/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestWriter(t *testing.T, conf *Config, now *time.Time) *Writer {
+	w, err := NewWriter(conf)
+	require.NoError(t, err)
+	w.now = func() time.Time { return *now }
+	return w
+}
+
+func batch(now time.Time, names ...string) datadog.SeriesIterator {
+	var series []metrics.Series
+	for _, m := range names {
+		series = append(series, metrics.Series{
+			Metric: m,
+			Points: [][]float64{{float64(now.Unix()), 1}},
+			Type:   metrics.TypeGauge,
+			Host:   "router",
+			Tags:   []string{"collector:test"},
+		})
+	}
+	return datadog.SliceIterator(series)
+}
+
+func readAll(t *testing.T, directory string, from, to time.Time) []string {
+	var names []string
+	require.NoError(t, Read(directory, from, to, func(s *metrics.Series) error {
+		names = append(names, s.Metric)
+		return nil
+	}))
+	return names
+}
+
+func segmentNames(t *testing.T, directory string) []string {
+	segments, err := listSegments(directory)
+	require.NoError(t, err)
+	var names []string
+	for _, s := range segments {
+		names = append(names, filepath.Base(s.path))
+	}
+	return names
+}
+
+func TestWriterRotation(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	w := newTestWriter(t, &Config{Directory: dir, SegmentSize: 1 << 20, SegmentDuration: time.Hour}, &now)
+
+	require.NoError(t, w.Archive(batch(now, "a", "b")))
+	assert.Equal(t, []string{"series-20261018T120000.000Z.ndjson"}, segmentNames(t, dir))
+
+	// rotated by age
+	now = now.Add(time.Hour)
+	require.NoError(t, w.Archive(batch(now, "c")))
+	assert.Equal(t, []string{"series-20261018T120000.000Z.ndjson.gz"}, segmentNames(t, dir))
+
+	// rotated by size
+	w.conf.SegmentSize = 1
+	now = now.Add(time.Minute)
+	require.NoError(t, w.Archive(batch(now, "d")))
+	now = now.Add(time.Minute)
+	require.NoError(t, w.Archive(batch(now, "e")))
+	require.NoError(t, w.Close())
+	assert.Equal(t, []string{
+		"series-20261018T120000.000Z.ndjson.gz",
+		"series-20261018T130100.000Z.ndjson.gz",
+		"series-20261018T130200.000Z.ndjson.gz",
+	}, segmentNames(t, dir))
+
+	start := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, []string{"a", "b", "c", "d", "e"}, readAll(t, dir, start, now))
+	assert.Equal(t, []string{"c", "d"}, readAll(t, dir, start.Add(time.Hour), start.Add(time.Hour+time.Minute)))
+	assert.Empty(t, readAll(t, dir, now.Add(time.Second), now.Add(time.Hour)))
+}
+
+func TestWriterRetention(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	w := newTestWriter(t, &Config{Directory: dir, SegmentSize: 1, Retention: time.Hour * 24, MaxSegments: 2}, &now)
+
+	for i := 0; i < 3; i++ {
+		require.NoError(t, w.Archive(batch(now, "a")))
+		now = now.Add(time.Hour)
+	}
+	assert.Equal(t, []string{
+		"series-20261018T130000.000Z.ndjson.gz",
+		"series-20261018T140000.000Z.ndjson.gz",
+	}, segmentNames(t, dir))
+
+	now = now.Add(time.Hour * 23)
+	require.NoError(t, w.Archive(batch(now, "a")))
+	assert.Equal(t, []string{
+		"series-20261018T140000.000Z.ndjson.gz",
+		"series-20261019T140000.000Z.ndjson.gz",
+	}, segmentNames(t, dir))
+}
+
+func TestNewWriterCompressesLeftovers(t *testing.T) {
+	dir := t.TempDir()
+	leftover := filepath.Join(dir, "series-20261018T120000.000Z.ndjson")
+	require.NoError(t, os.WriteFile(leftover, []byte(`{"metric":"a","points":[[1792324800,1]],"host":"router"}`+"\n"+`{"metric":"b","poi`), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "counters.json"), []byte("{}"), 0644))
+
+	_, err := NewWriter(&Config{Directory: dir})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"series-20261018T120000.000Z.ndjson.gz"}, segmentNames(t, dir))
+	assert.FileExists(t, filepath.Join(dir, "counters.json"))
+
+	// the truncated line is part of a rotated segment
+	err = Read(dir, time.Unix(0, 0), time.Unix(1792324800, 0), func(*metrics.Series) error { return nil })
+	assert.Error(t, err)
+}
+
+func TestReadCurrentSegment(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "series-20261018T120000.000Z.ndjson"), []byte(`{"metric":"a","points":[[1792324800,1],[1792324860,2]],"host":"router"}`+"\n"+`{"metric":"b","poi`), 0644))
+
+	var series []*metrics.Series
+	require.NoError(t, Read(dir, time.Unix(1792324830, 0), time.Unix(1792324900, 0), func(s *metrics.Series) error {
+		series = append(series, s)
+		return nil
+	}))
+	require.Len(t, series, 1)
+	assert.Equal(t, [][]float64{{1792324860, 2}}, series[0].Points)
+}
+
+func TestArchiveFailedFlushes(t *testing.T) {
+	intake := datadogtest.NewIntake()
+	defer intake.Close()
+	dir := t.TempDir()
+	w, err := NewWriter(&Config{Directory: dir})
+	require.NoError(t, err)
+	defer w.Close()
+
+	conf := intake.Configure(&datadog.Config{
+		Host:          "router",
+		DatadogAPIKey: "api-key-12345678",
+		Archive:       w,
+	})
+	c := datadog.NewClient(conf)
+	// below the minimal send interval to flush several times in the test
+	conf.SendInterval = time.Millisecond * 50
+	intake.Fail("/api/v1/series", http.StatusInternalServerError, 3)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+	now := time.Now().Truncate(time.Second)
+	count := func(ts time.Time, value float64) metrics.Series {
+		return metrics.Series{
+			Metric: "dnsmasq.queries",
+			Points: [][]float64{{float64(ts.Unix()), value}},
+			Type:   metrics.TypeCount,
+			Host:   "router",
+			Tags:   []string{"collector:test"},
+		}
+	}
+	c.ChanSeries <- count(now.Add(-time.Second*2), 3)
+	// the failed flushes keep the series in the store without archiving them again
+	intake.WaitFor(t, time.Second*5, func() bool { return len(intake.Requests("/api/v1/series")) == 2 })
+	c.ChanSeries <- count(now.Add(-time.Second), 5)
+	intake.WaitFor(t, time.Second*5, func() bool { return len(intake.Series("dnsmasq.queries")) > 0 })
+	cancel()
+	<-done
+
+	var points [][]float64
+	require.NoError(t, Read(dir, now.Add(-time.Minute), now, func(s *metrics.Series) error {
+		points = append(points, s.Points...)
+		return nil
+	}))
+	assert.ElementsMatch(t, [][]float64{
+		{float64(now.Add(-time.Second * 2).Unix()), 3},
+		{float64(now.Add(-time.Second).Unix()), 5},
+	}, points)
+}
@@ -0,0 +1,94 @@
+package archive
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/JulienBalestra/monitoring/pkg/metrics"
+)
+
+const maxLineSize = 4 << 20
+
+// Read calls fn with the archived series having points between from and to, the other points are removed
+// the series are read in the order of the segments, a series is returned once per archived batch
+func Read(directory string, from, to time.Time, fn func(*metrics.Series) error) error {
+	segments, err := listSegments(directory)
+	if err != nil {
+		return err
+	}
+	fromTs, toTs := float64(from.Unix()), float64(to.Unix())
+	// the points of a batch can be older than the segment, up to the max age of the series
+	lastStart := to.Add(metrics.SeriesMaxAge)
+	for i, s := range segments {
+		if s.started.After(lastStart) {
+			break
+		}
+		// the points of a segment are older than the next one
+		if i+1 < len(segments) && segments[i+1].started.Before(from) {
+			continue
+		}
+		err = readSegment(s, func(series *metrics.Series) error {
+			points := series.Points[:0]
+			for _, p := range series.Points {
+				if len(p) == 2 && p[0] >= fromTs && p[0] <= toTs {
+					points = append(points, p)
+				}
+			}
+			if len(points) == 0 {
+				return nil
+			}
+			series.Points = points
+			return fn(series)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read segment %s: %v", s.path, err)
+		}
+	}
+	return nil
+}
+
+func readSegment(s *segment, fn func(*metrics.Series) error) error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if s.compressed {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64<<10), maxLineSize)
+	for scanner.Scan() {
+		series := &metrics.Series{}
+		err = json.Unmarshal(scanner.Bytes(), series)
+		if err != nil && !s.compressed {
+			// the last line of the current segment can be partially written
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = fn(series)
+		if err != nil {
+			return err
+		}
+	}
+	err = scanner.Err()
+	if err == io.ErrUnexpectedEOF {
+		// truncated by a crash
+		return nil
+	}
+	return err
+}
@@ -3,6 +3,8 @@ package catalog
 import (
 	"testing"
 
+	"github.com/JulienBalestra/monitoring/pkg/metrics"
+
 	"github.com/stretchr/testify/assert"
 
 	"github.com/stretchr/testify/require"
@@ -19,3 +21,33 @@ func TestGenerateCollectorConfigFile(t *testing.T) {
 	err := GenerateCollectorConfigFile("./fixtures/gen-collectors.yaml")
 	require.NoError(t, err)
 }
+
+// datadogUnits are the units of https://docs.datadoghq.com/metrics/units/ used by the collectors
+var datadogUnits = map[string]struct{}{
+	"byte":           {},
+	"connection":     {},
+	"degree celsius": {},
+	"device":         {},
+	"error":          {},
+	"event":          {},
+	"item":           {},
+	"key":            {},
+	"message":        {},
+	"millisecond":    {},
+	"operation":      {},
+	"packet":         {},
+	"query":          {},
+	"request":        {},
+	"second":         {},
+	"watt":           {},
+}
+
+func TestMetadataUnits(t *testing.T) {
+	for name, m := range metrics.DefaultRegistry.All() {
+		if m.Unit == "" {
+			continue
+		}
+		_, ok := datadogUnits[m.Unit]
+		assert.True(t, ok, "%s has the unit %q unknown to Datadog", name, m.Unit)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"sort"
 	"strconv"
-	"strings"
 	"time"
 
 	"github.com/JulienBalestra/dry/pkg/fnv"
@@ -26,17 +25,12 @@ const (
 type Collector struct {
 	conf     *collector.Config
 	measures *metrics.Measures
-	replacer *strings.Replacer
 }
 
 func NewBluetooth(conf *collector.Config) collector.Collector {
 	return collector.WithDefaults(&Collector{
 		conf:     conf,
 		measures: metrics.NewMeasures(conf.MetricsClient.ChanSeries),
-		replacer: strings.NewReplacer(
-			":", "-",
-			" ", "-",
-		),
 	})
 }
 
@@ -139,16 +133,17 @@ func (c *Collector) Collect(ctx context.Context) error {
 			}
 			for _, device := range devices {
 				var newTags []*tagger.Tag
-				if device.Properties.AddressType != "" {
-					newTags = append(newTags, tagger.NewTagUnsafe("address-type", c.replacer.Replace(device.Properties.AddressType)))
-				}
-				alias := c.replacer.Replace(device.Properties.Alias)
-				if alias != "" {
-					newTags = append(newTags, tagger.NewTagUnsafe(exported.AliasKey, alias))
-				}
-				name := c.replacer.Replace(device.Properties.Name)
-				if name != "" {
-					newTags = append(newTags, tagger.NewTagUnsafe(exported.NameKey, name))
+				// the properties are set by the devices, the empty or invalid ones are ignored
+				for key, value := range map[string]string{
+					"address-type":    device.Properties.AddressType,
+					exported.AliasKey: device.Properties.Alias,
+					exported.NameKey:  device.Properties.Name,
+				} {
+					tag, err := tagger.NewTag(key, value)
+					if err != nil {
+						continue
+					}
+					newTags = append(newTags, tag)
 				}
 
 				sort.Strings(device.Properties.UUIDs)
@@ -157,7 +152,7 @@ func (c *Collector) Collect(ctx context.Context) error {
 					h = fnv.AddString(h, elt)
 				}
 
-				macAddress := c.replacer.Replace(device.Properties.Address)
+				macAddress := macvendor.NormaliseMacAddress(device.Properties.Address)
 				vendor, ok := macvendor.GetVendor(macAddress)
 				if ok {
 					newTags = append(newTags, tagger.NewTagUnsafe(exported.MacVendorKey, vendor))
@@ -178,9 +173,9 @@ func (c *Collector) Collect(ctx context.Context) error {
 					"paired:"+strconv.FormatBool(device.Properties.Paired),
 				)
 				dzctx := zctx.With(
-					zap.String("name", name),
+					zap.String("name", device.Properties.Name),
 					zap.String("mac", macAddress),
-					zap.String("alias", alias),
+					zap.String("alias", device.Properties.Alias),
 					zap.String("vendor", vendor),
 
 					zap.String("addressType", device.Properties.AddressType),
@@ -2,9 +2,11 @@ package datadog
 
 import (
 	"context"
+	"strconv"
 	"time"
 
 	"github.com/JulienBalestra/monitoring/pkg/collector"
+	"github.com/JulienBalestra/monitoring/pkg/datadog"
 	"github.com/JulienBalestra/monitoring/pkg/metrics"
 )
 
@@ -14,17 +16,86 @@ const (
 	clientPrefix = "client."
 
 	// metrics
-	clientSentByteMetrics   = clientPrefix + "sent.metrics.bytes"
-	clientSentSeriesMetrics = clientPrefix + "sent.metrics.series"
+	clientSentByteMetrics             = clientPrefix + "sent.metrics.bytes"
+	clientSentUncompressedByteMetrics = clientPrefix + "sent.metrics.uncompressed_bytes"
+	clientSentSeriesMetrics           = clientPrefix + "sent.metrics.series"
+	clientSentPointsMetrics           = clientPrefix + "sent.metrics.points"
+	clientFlushPoints                 = clientPrefix + "metrics.flush.points"
+	clientLastFlushAge                = clientPrefix + "metrics.last_flush.age"
 
 	clientSentSeriesErrors         = clientPrefix + "metrics.errors"
 	clientMetricsStoreAggregations = clientPrefix + "metrics.store.aggregations"
+	clientDroppedSeries            = clientPrefix + "metrics.dropped"
+	clientArchiveErrors            = clientPrefix + "metrics.archive.errors"
 
 	// logs
 	clientSentLogsBytes = clientPrefix + "sent.logs.bytes"
 	SentLogsErrors      = clientPrefix + "logs.errors"
+	clientSentLogs      = clientPrefix + "sent.logs"
+	clientDroppedLogs   = clientPrefix + "logs.dropped"
+
+	// events
+	clientSentEvents       = clientPrefix + "sent.events"
+	clientSentEventsErrors = clientPrefix + "events.errors"
+	clientDroppedEvents    = clientPrefix + "events.dropped"
+
+	// service checks
+	clientSentServiceChecks       = clientPrefix + "sent.service_checks"
+	clientSentServiceChecksErrors = clientPrefix + "service_checks.errors"
+	clientDroppedServiceChecks    = clientPrefix + "service_checks.dropped"
+
+	// host tags
+	clientHostTagsUpdates = clientPrefix + "host_tags.updates"
+	clientHostTagsErrors  = clientPrefix + "host_tags.errors"
+	clientHostTagsSynced  = clientPrefix + "host_tags.synced"
+
+	// keys
+	clientKeyRotations = clientPrefix + "keys.rotations"
+
+	// http
+	clientHTTPRequests      = clientPrefix + "http.requests"
+	clientHTTPLatencyBucket = clientPrefix + "http.latency.bucket"
+	clientHTTPLatencySum    = clientPrefix + "http.latency.sum"
+
+	clientQueueDepth = clientPrefix + "queue.depth"
 )
 
+func init() {
+	tags := []string{"collector"}
+	// the series metrics of the additional orgs are tagged with their destination
+	seriesTags := []string{"collector", "destination"}
+	metrics.RegisterMetadata(map[string]*metrics.Metadata{
+		clientSentByteMetrics:             {Type: metrics.TypeCount, Unit: "byte", Description: "compressed bytes of series sent to Datadog", TagKeys: seriesTags},
+		clientSentUncompressedByteMetrics: {Type: metrics.TypeCount, Unit: "byte", Description: "uncompressed bytes of series sent to Datadog", TagKeys: seriesTags},
+		clientSentSeriesMetrics:           {Type: metrics.TypeCount, Description: "series sent to Datadog", TagKeys: seriesTags},
+		clientSentPointsMetrics:           {Type: metrics.TypeCount, Description: "points sent to Datadog", TagKeys: seriesTags},
+		clientFlushPoints:                 {Type: metrics.TypeGauge, Description: "points of the last successful flush", TagKeys: seriesTags},
+		clientLastFlushAge:                {Type: metrics.TypeGauge, Unit: "second", Description: "time since the last successful flush of series", TagKeys: seriesTags},
+		clientHTTPRequests:                {Type: metrics.TypeCount, Unit: "request", Description: "requests to Datadog by endpoint and status code, 0 when the request failed without response", TagKeys: []string{"collector", "destination", "endpoint", "status_code"}},
+		clientHTTPLatencyBucket:           {Type: metrics.TypeCount, Unit: "request", Description: "requests to Datadog with a latency lower or equal to the le tag in seconds", TagKeys: []string{"collector", "destination", "endpoint", "le"}},
+		clientHTTPLatencySum:              {Type: metrics.TypeCount, Unit: "second", Description: "sum of the latency of the requests to Datadog", TagKeys: []string{"collector", "destination", "endpoint"}},
+		clientQueueDepth:                  {Type: metrics.TypeGauge, Description: "items waiting in the queues of the client", TagKeys: []string{"collector", "destination", "queue"}},
+		clientSentSeriesErrors:            {Type: metrics.TypeCount, Unit: "error", Description: "failures to send series", TagKeys: seriesTags},
+		clientMetricsStoreAggregations:    {Type: metrics.TypeCount, Description: "series merged in the aggregation store", TagKeys: seriesTags},
+		clientDroppedSeries:               {Type: metrics.TypeCount, Description: "series dropped because the queue of the destination was full", TagKeys: seriesTags},
+		clientArchiveErrors:               {Type: metrics.TypeCount, Unit: "error", Description: "failures to archive the flushed series", TagKeys: tags},
+		clientSentLogsBytes:               {Type: metrics.TypeCount, Unit: "byte", Description: "compressed bytes of logs sent to Datadog", TagKeys: tags},
+		SentLogsErrors:                    {Type: metrics.TypeCount, Unit: "error", Description: "failures to send logs", TagKeys: tags},
+		clientSentLogs:                    {Type: metrics.TypeCount, Unit: "message", Description: "log entries sent to Datadog", TagKeys: tags},
+		clientDroppedLogs:                 {Type: metrics.TypeCount, Unit: "message", Description: "log entries dropped because the buffer was full or the intake failed", TagKeys: tags},
+		clientSentEvents:                  {Type: metrics.TypeCount, Unit: "event", Description: "events sent to Datadog", TagKeys: tags},
+		clientSentEventsErrors:            {Type: metrics.TypeCount, Unit: "error", Description: "failures to send events", TagKeys: tags},
+		clientDroppedEvents:               {Type: metrics.TypeCount, Unit: "event", Description: "events dropped because the queue was full or the API rejected them", TagKeys: tags},
+		clientSentServiceChecks:           {Type: metrics.TypeCount, Unit: "item", Description: "service checks sent to Datadog", TagKeys: tags},
+		clientSentServiceChecksErrors:     {Type: metrics.TypeCount, Unit: "error", Description: "failures to send service checks", TagKeys: tags},
+		clientDroppedServiceChecks:        {Type: metrics.TypeCount, Unit: "item", Description: "service checks dropped because the queue was full or the API rejected them", TagKeys: tags},
+		clientHostTagsUpdates:             {Type: metrics.TypeCount, Unit: "operation", Description: "updates of the Datadog host tags", TagKeys: tags},
+		clientHostTagsErrors:              {Type: metrics.TypeCount, Unit: "error", Description: "failures to reconcile the Datadog host tags", TagKeys: tags},
+		clientKeyRotations:                {Type: metrics.TypeCount, Description: "rotations of the Datadog keys read from files", TagKeys: tags},
+		clientHostTagsSynced:              {Type: metrics.TypeGauge, Description: "1 when the last host tags reconciliation succeeded", TagKeys: tags},
+	})
+}
+
 type Collector struct {
 	conf *collector.Config
 
@@ -103,6 +174,20 @@ func (c *Collector) Collect(_ context.Context) error {
 			Time:  now,
 			Tags:  tags,
 		},
+		{
+			Name:  clientArchiveErrors,
+			Value: c.conf.MetricsClient.Stats.ArchiveErrors,
+			Host:  c.conf.Host,
+			Time:  now,
+			Tags:  tags,
+		},
+		{
+			Name:  clientKeyRotations,
+			Value: c.conf.MetricsClient.Stats.KeyRotations,
+			Host:  c.conf.Host,
+			Time:  now,
+			Tags:  tags,
+		},
 		{
 			Name:  SentLogsErrors,
 			Value: c.conf.MetricsClient.Stats.SentLogsErrors,
@@ -117,10 +202,219 @@ func (c *Collector) Collect(_ context.Context) error {
 			Time:  now,
 			Tags:  tags,
 		},
+		{
+			Name:  clientSentLogs,
+			Value: c.conf.MetricsClient.Stats.SentLogs,
+			Host:  c.conf.Host,
+			Time:  now,
+			Tags:  tags,
+		},
+		{
+			Name:  clientDroppedLogs,
+			Value: c.conf.MetricsClient.Stats.DroppedLogs,
+			Host:  c.conf.Host,
+			Time:  now,
+			Tags:  tags,
+		},
+		{
+			Name:  clientSentEvents,
+			Value: c.conf.MetricsClient.Stats.SentEvents,
+			Host:  c.conf.Host,
+			Time:  now,
+			Tags:  tags,
+		},
+		{
+			Name:  clientSentEventsErrors,
+			Value: c.conf.MetricsClient.Stats.SentEventsErrors,
+			Host:  c.conf.Host,
+			Time:  now,
+			Tags:  tags,
+		},
+		{
+			Name:  clientDroppedEvents,
+			Value: c.conf.MetricsClient.Stats.DroppedEvents,
+			Host:  c.conf.Host,
+			Time:  now,
+			Tags:  tags,
+		},
+		{
+			Name:  clientSentServiceChecks,
+			Value: c.conf.MetricsClient.Stats.SentServiceChecks,
+			Host:  c.conf.Host,
+			Time:  now,
+			Tags:  tags,
+		},
+		{
+			Name:  clientSentServiceChecksErrors,
+			Value: c.conf.MetricsClient.Stats.SentServiceChecksErrors,
+			Host:  c.conf.Host,
+			Time:  now,
+			Tags:  tags,
+		},
+		{
+			Name:  clientDroppedServiceChecks,
+			Value: c.conf.MetricsClient.Stats.DroppedServiceChecks,
+			Host:  c.conf.Host,
+			Time:  now,
+			Tags:  tags,
+		},
+		{
+			Name:  clientHostTagsUpdates,
+			Value: c.conf.MetricsClient.Stats.HostTagsUpdates,
+			Host:  c.conf.Host,
+			Time:  now,
+			Tags:  tags,
+		},
+		{
+			Name:  clientHostTagsErrors,
+			Value: c.conf.MetricsClient.Stats.HostTagsErrors,
+			Host:  c.conf.Host,
+			Time:  now,
+			Tags:  tags,
+		},
+	}
+	gauges := []*metrics.Sample{
+		{
+			Name:  clientHostTagsSynced,
+			Value: c.conf.MetricsClient.Stats.HostTagsSynced,
+			Host:  c.conf.Host,
+			Time:  now,
+			Tags:  tags,
+		},
 	}
+	counts, flushGauges := seriesSamples(c.conf.MetricsClient.Stats, c.conf.Host, now, tags)
+	samples = append(samples, counts...)
+	gauges = append(gauges, flushGauges...)
+	samples = append(samples, httpSamples(c.conf.MetricsClient.Stats, c.conf.Host, now, tags)...)
 	c.conf.MetricsClient.Stats.RUnlock()
+	gauges = append(gauges, queueSamples(c.conf.MetricsClient.QueueDepth(), c.conf.Host, now, tags)...)
+
+	for _, d := range c.conf.MetricsClient.Destinations() {
+		destinationTags := append(append(make([]string, 0, len(tags)+1), tags...), "destination:"+d.Name)
+		d.ClientMetrics.RLock()
+		samples = append(samples, destinationSamples(d, c.conf.Host, now, destinationTags)...)
+		counts, flushGauges = seriesSamples(d.ClientMetrics, c.conf.Host, now, destinationTags)
+		samples = append(samples, counts...)
+		gauges = append(gauges, flushGauges...)
+		samples = append(samples, httpSamples(d.ClientMetrics, c.conf.Host, now, destinationTags)...)
+		d.ClientMetrics.RUnlock()
+		gauges = append(gauges, queueSamples(d.QueueDepth(), c.conf.Host, now, destinationTags)...)
+	}
 	for _, s := range samples {
 		_ = c.measures.Count(s)
 	}
+	for _, s := range gauges {
+		c.measures.Gauge(s)
+	}
 	return nil
 }
+
+// destinationSamples must be called with the read lock of the ClientMetrics
+func destinationSamples(d *datadog.Destination, host string, now time.Time, tags []string) []*metrics.Sample {
+	samples := make([]*metrics.Sample, 0, 5)
+	for name, value := range map[string]float64{
+		clientSentByteMetrics:          d.ClientMetrics.SentSeriesBytes,
+		clientSentSeriesMetrics:        d.ClientMetrics.SentSeries,
+		clientSentSeriesErrors:         d.ClientMetrics.SentSeriesErrors,
+		clientMetricsStoreAggregations: d.ClientMetrics.StoreAggregations,
+		clientDroppedSeries:            d.ClientMetrics.DroppedSeries,
+	} {
+		samples = append(samples, &metrics.Sample{
+			Name:  name,
+			Value: value,
+			Host:  host,
+			Time:  now,
+			Tags:  tags,
+		})
+	}
+	return samples
+}
+
+// seriesSamples returns the counts and the gauges of the flushes, it must be called with the read lock of the ClientMetrics
+func seriesSamples(stats *datadog.ClientMetrics, host string, now time.Time, tags []string) ([]*metrics.Sample, []*metrics.Sample) {
+	counts := []*metrics.Sample{
+		{
+			Name:  clientSentUncompressedByteMetrics,
+			Value: stats.SentSeriesUncompressedBytes,
+			Host:  host,
+			Time:  now,
+			Tags:  tags,
+		},
+		{
+			Name:  clientSentPointsMetrics,
+			Value: stats.SentPoints,
+			Host:  host,
+			Time:  now,
+			Tags:  tags,
+		},
+	}
+	gauges := []*metrics.Sample{
+		{
+			Name:  clientFlushPoints,
+			Value: stats.LastFlushPoints,
+			Host:  host,
+			Time:  now,
+			Tags:  tags,
+		},
+		{
+			Name:  clientLastFlushAge,
+			Value: now.Sub(stats.LastSuccessfulFlush).Seconds(),
+			Host:  host,
+			Time:  now,
+			Tags:  tags,
+		},
+	}
+	return counts, gauges
+}
+
+// httpSamples must be called with the read lock of the ClientMetrics
+func httpSamples(stats *datadog.ClientMetrics, host string, now time.Time, tags []string) []*metrics.Sample {
+	var samples []*metrics.Sample
+	for endpoint, e := range stats.Endpoints {
+		endpointTags := append(append(make([]string, 0, len(tags)+1), tags...), "endpoint:"+endpoint)
+		samples = append(samples, &metrics.Sample{
+			Name:  clientHTTPLatencySum,
+			Value: e.LatencySeconds,
+			Host:  host,
+			Time:  now,
+			Tags:  endpointTags,
+		})
+		for statusCode, value := range e.StatusCodes {
+			samples = append(samples, &metrics.Sample{
+				Name:  clientHTTPRequests,
+				Value: value,
+				Host:  host,
+				Time:  now,
+				Tags:  append(endpointTags[:len(endpointTags):len(endpointTags)], "status_code:"+strconv.Itoa(statusCode)),
+			})
+		}
+		for i, value := range e.LatencyBuckets {
+			le := "inf"
+			if i < len(datadog.LatencyBuckets) {
+				le = strconv.FormatFloat(datadog.LatencyBuckets[i], 'f', -1, 64)
+			}
+			samples = append(samples, &metrics.Sample{
+				Name:  clientHTTPLatencyBucket,
+				Value: value,
+				Host:  host,
+				Time:  now,
+				Tags:  append(endpointTags[:len(endpointTags):len(endpointTags)], "le:"+le),
+			})
+		}
+	}
+	return samples
+}
+
+func queueSamples(depths map[string]float64, host string, now time.Time, tags []string) []*metrics.Sample {
+	samples := make([]*metrics.Sample, 0, len(depths))
+	for queue, depth := range depths {
+		samples = append(samples, &metrics.Sample{
+			Name:  clientQueueDepth,
+			Value: depth,
+			Host:  host,
+			Time:  now,
+			Tags:  append(append(make([]string, 0, len(tags)+1), tags...), "queue:"+queue),
+		})
+	}
+	return samples
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/JulienBalestra/monitoring/pkg/collector"
 	"github.com/JulienBalestra/monitoring/pkg/collector/collectors/dnsmasq/exported"
+	"github.com/JulienBalestra/monitoring/pkg/datadog"
 	"github.com/JulienBalestra/monitoring/pkg/macvendor"
 	"github.com/JulienBalestra/monitoring/pkg/metrics"
 	"github.com/JulienBalestra/monitoring/pkg/tagger"
@@ -36,11 +37,26 @@ const (
 1586868164 60:01:94:4e:dd:8a 192.168.1.114 ESP_4EDD8A *
 */
 
+func init() {
+	metrics.RegisterMetadata(map[string]*metrics.Metadata{
+		"dnsmasq.dhcp.lease": {
+			Type:        metrics.TypeGauge,
+			Unit:        "second",
+			Description: "remaining time of the DHCP lease",
+			TagKeys:     []string{"collector", "ip", "lease", "mac", "vendor"},
+		},
+	})
+}
+
 type Collector struct {
 	conf     *collector.Config
 	measures *metrics.Measures
 
 	splitSep []byte
+
+	// macs already seen in the leases, the first collection only records them
+	macs      map[string]struct{}
+	collected bool
 }
 
 func NewDNSMasqDHCP(conf *collector.Config) collector.Collector {
@@ -49,6 +65,7 @@ func NewDNSMasqDHCP(conf *collector.Config) collector.Collector {
 		measures: metrics.NewMeasures(conf.MetricsClient.ChanSeries),
 
 		splitSep: []byte{'\n'},
+		macs:     make(map[string]struct{}),
 	})
 }
 
@@ -134,17 +151,42 @@ func (c *Collector) Collect(_ context.Context) error {
 		}
 		macAddress = strings.ReplaceAll(macAddress, ":", "-")
 		macAddressTag := tagger.NewTagUnsafe("mac", macAddress)
-		vendorTag := tagger.NewTagUnsafe("vendor", macvendor.GetVendorWithMacOrUnknown(macAddress))
+		vendor := macvendor.GetVendorWithMacOrUnknown(macAddress)
+		vendorTag := tagger.NewTagUnsafe("vendor", vendor)
 		ipAddressTag := tagger.NewTagUnsafe("ip", ipAddress)
-		leaseNameTag := tagger.NewTagUnsafe(exported.LeaseKey, leaseName)
-		if leaseName == "*" {
+		c.conf.Tagger.Update(macAddress, vendorTag)
+		// the lease name is the hostname sent by the device
+		leaseNameTag, err := tagger.NewTag(exported.LeaseKey, leaseName)
+		if leaseName != "*" && err != nil {
+			zap.L().Warn("skipping invalid lease name",
+				zap.String("lease", leaseName),
+				zap.String("mac", macAddress),
+				zap.Error(err),
+			)
+		}
+		if leaseName == "*" || err != nil {
 			leaseNameTag = tagger.NewTagUnsafe(exported.LeaseKey, dhcpWildcardLeaseValue)
-			c.conf.Tagger.Update(ipAddress, macAddressTag, vendorTag)
-			c.conf.Tagger.Update(macAddress, ipAddressTag, vendorTag)
+			c.conf.Tagger.Relink(macAddressTag, ipAddressTag)
 		} else {
-			c.conf.Tagger.Update(leaseName, macAddressTag, ipAddressTag, vendorTag)
-			c.conf.Tagger.Update(ipAddress, leaseNameTag, macAddressTag, vendorTag)
-			c.conf.Tagger.Update(macAddress, ipAddressTag, leaseNameTag, vendorTag)
+			// the IP and the lease name share the tags of the MAC address
+			c.conf.Tagger.Relink(macAddressTag, ipAddressTag, leaseNameTag)
+		}
+		if _, ok := c.macs[macAddress]; !ok {
+			c.macs[macAddress] = struct{}{}
+			if c.collected {
+				c.conf.SubmitEvent(&datadog.Event{
+					Title:          "new device " + macAddress + " in the DHCP leases",
+					Text:           "lease " + leaseName + " with ip " + ipAddress + " from the vendor " + vendor,
+					AlertType:      datadog.AlertTypeInfo,
+					AggregationKey: CollectorName + "-new-mac",
+					Tags: []string{
+						leaseNameTag.String(),
+						macAddressTag.String(),
+						ipAddressTag.String(),
+						vendorTag.String(),
+					},
+				})
+			}
 		}
 		c.measures.Gauge(&metrics.Sample{
 			Name:  "dnsmasq.dhcp.lease",
@@ -159,6 +201,7 @@ func (c *Collector) Collect(_ context.Context) error {
 			),
 		})
 	}
+	c.collected = true
 	c.measures.Purge()
 	return nil
 }
@@ -0,0 +1,42 @@
+package dhcp
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/JulienBalestra/monitoring/pkg/collector"
+	"github.com/JulienBalestra/monitoring/pkg/datadog"
+	"github.com/JulienBalestra/monitoring/pkg/tagger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCollectNormaliseLeaseNames(t *testing.T) {
+	leases := path.Join(t.TempDir(), "dnsmasq.leases")
+	require.NoError(t, os.WriteFile(leases, []byte(`1586873170 cc:61:e5:8f:78:ea 192.168.1.149 John's-iPhone *
+1586870968 90:78:b2:5c:07:af 192.168.1.148 john_s-iphone *
+1586869194 b8:8a:ec:fa:76:59 192.168.1.101 ___ *
+`), 0600))
+
+	tags := tagger.NewTagger()
+	c := NewDNSMasqDHCP(&collector.Config{
+		MetricsClient:   datadog.NewClient(&datadog.Config{ChanSize: 1000}),
+		Tagger:          tags,
+		Host:            "router",
+		CollectInterval: time.Second,
+		Options: map[string]string{
+			optionDNSMasqLeaseFile: leases,
+		},
+	})
+	require.NoError(t, c.Collect(context.Background()))
+
+	assert.Equal(t, float64(1), tags.Stats().TagCollisions)
+	// the last lease normalised to the same name takes it
+	assert.Equal(t, []string{"ip:192.168.1.149"}, tags.Aliases("cc-61-e5-8f-78-ea"))
+	assert.Equal(t, []string{"ip:192.168.1.148", "lease:john_s-iphone"}, tags.Aliases("90-78-b2-5c-07-af"))
+	// the invalid lease name is skipped
+	assert.Equal(t, []string{"ip:192.168.1.101"}, tags.Aliases("b8-8a-ec-fa-76-59"))
+}
@@ -26,6 +26,12 @@ const (
 	optionLogFacilityKey = "log-facility-file"
 )
 
+func init() {
+	metrics.RegisterMetadata(map[string]*metrics.Metadata{
+		dnsmasqQueryMetric: {Type: metrics.TypeCount, Unit: "query", Description: "DNS queries received by dnsmasq", TagKeys: []string{"collector", "domain", "lease", "type"}},
+	})
+}
+
 type Collector struct {
 	conf     *collector.Config
 	measures *metrics.Measures
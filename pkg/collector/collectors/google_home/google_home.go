@@ -2,7 +2,6 @@ package google_home
 
 import (
 	"context"
-	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -14,6 +13,7 @@ import (
 	"github.com/JulienBalestra/monitoring/pkg/macvendor"
 	"github.com/JulienBalestra/monitoring/pkg/metrics"
 	"github.com/JulienBalestra/monitoring/pkg/tagger"
+	"github.com/JulienBalestra/monitoring/pkg/tlsconfig"
 	"go.uber.org/zap"
 )
 
@@ -23,12 +23,26 @@ const (
 	OptionIP = "ip"
 )
 
+func init() {
+	tags := []string{"build-version", "cast-build-revision", "collector", "device-name", "mac"}
+	metrics.RegisterMetadata(map[string]*metrics.Metadata{
+		"up.time":                   {Type: metrics.TypeGauge, Unit: "second", Description: "time since the device booted", TagKeys: tags},
+		"network.wireless.rssi.dbm": {Type: metrics.TypeGauge, Description: "received signal strength indicator in dBm", TagKeys: tags},
+		"network.wireless.noise":    {Type: metrics.TypeGauge, Description: "noise level in dBm", TagKeys: tags},
+		"google.home.has_update":    {Type: metrics.TypeGauge, Description: "1 when an update is available", TagKeys: tags},
+		"google.home.connected":     {Type: metrics.TypeGauge, Description: "1 when the device is connected", TagKeys: tags},
+		"google.home.setup_state":   {Type: metrics.TypeGauge, Description: "setup state of the device", TagKeys: tags},
+	})
+}
+
 type Collector struct {
 	conf     *collector.Config
 	measures *metrics.Measures
 
 	client *http.Client
-	url    string
+	// clientErr is returned by every collection when the TLS options are invalid
+	clientErr error
+	url       string
 }
 
 type EurekaInfo struct {
@@ -47,22 +61,21 @@ type EurekaInfo struct {
 }
 
 func NewGoogleHome(conf *collector.Config) collector.Collector {
-	return collector.WithDefaults(&Collector{
+	c := &Collector{
 		conf:     conf,
 		measures: metrics.NewMeasures(conf.MetricsClient.ChanSeries),
-		client: &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			},
-		},
-		url: fmt.Sprintf("https://%s:8443/setup/eureka_info", conf.Options[OptionIP]),
-	})
+		url:      fmt.Sprintf("https://%s:8443/setup/eureka_info", conf.Options[OptionIP]),
+	}
+	collector.WithDefaults(c)
+	c.client, c.clientErr = tlsconfig.NewHTTPClient(conf.Options, conf.CAFile)
+	return c
 }
 
 func (c *Collector) SubmittedSeries() float64 {
 	return c.measures.GetTotalSubmittedSeries()
 }
 
+// DefaultOptions verify the certificate, pin the self-signed certificate of the device with tlsconfig.OptionPinnedSHA256
 func (c *Collector) DefaultOptions() map[string]string {
 	return map[string]string{}
 }
@@ -92,6 +105,9 @@ func (c *Collector) Tags() []string {
 }
 
 func (c *Collector) Collect(ctx context.Context) error {
+	if c.clientErr != nil {
+		return c.clientErr
+	}
 	ipAddress, ok := c.conf.Options[OptionIP]
 	if !ok {
 		zap.L().Error("missing option", zap.String("options", OptionIP))
@@ -122,12 +138,22 @@ func (c *Collector) Collect(ctx context.Context) error {
 	}
 	macAddress := macvendor.NormaliseMacAddress(e.MacAddress)
 	bssid := macvendor.NormaliseMacAddress(e.BSSID)
-	c.conf.Tagger.Update(macAddress,
-		tagger.NewTagUnsafe("ip", ipAddress),
-		tagger.NewTagUnsafe("ssid", e.SSID),
+	c.conf.Tagger.Relink(tagger.NewTagUnsafe("mac", macAddress), tagger.NewTagUnsafe("ip", ipAddress))
+	deviceTags := []*tagger.Tag{
 		tagger.NewTagUnsafe("bssid", bssid),
 		tagger.NewTagUnsafe("vendor", macvendor.GetVendorWithMacOrUnknown(macAddress)),
-	)
+	}
+	ssidTag, err := tagger.NewTag("ssid", e.SSID)
+	if err != nil {
+		zap.L().Warn("skipping invalid ssid",
+			zap.String("ssid", e.SSID),
+			zap.String("mac", macAddress),
+			zap.Error(err),
+		)
+	} else {
+		deviceTags = append(deviceTags, ssidTag)
+	}
+	c.conf.Tagger.Update(macAddress, deviceTags...)
 	now, tags := time.Now(), append(c.conf.Tagger.GetUnstable(macAddress), c.Tags()...)
 	tags = append(tags,
 		"mac:"+macAddress,
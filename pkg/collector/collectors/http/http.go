@@ -2,10 +2,9 @@ package http_collector
 
 import (
 	"context"
-	"crypto/tls"
 	"errors"
-	"net"
 	"io"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
@@ -15,7 +14,9 @@ import (
 	"go.uber.org/zap"
 
 	"github.com/JulienBalestra/monitoring/pkg/collector"
+	"github.com/JulienBalestra/monitoring/pkg/datadog"
 	"github.com/JulienBalestra/monitoring/pkg/metrics"
+	"github.com/JulienBalestra/monitoring/pkg/tlsconfig"
 )
 
 const (
@@ -23,24 +24,37 @@ const (
 
 	OptionURL    = "url"
 	OptionMethod = "method"
+
+	ServiceCheck = "http.can_connect"
 )
 
+func init() {
+	metrics.RegisterMetadata(map[string]*metrics.Metadata{
+		"latency.http": {
+			Type:        metrics.TypeGauge,
+			Unit:        "millisecond",
+			Description: "time to get the HTTP response headers",
+			TagKeys:     []string{"code", "collector", "host-target", "ip", "method", "path", "port", "scheme", "url"},
+		},
+	})
+}
+
 type Collector struct {
 	conf     *collector.Config
 	measures *metrics.Measures
 	client   *http.Client
+	// clientErr is returned by every collection when the TLS options are invalid
+	clientErr error
 }
 
 func NewHTTP(conf *collector.Config) collector.Collector {
-	return collector.WithDefaults(&Collector{
+	c := &Collector{
 		conf:     conf,
 		measures: metrics.NewMeasures(conf.MetricsClient.ChanSeries),
-		client: &http.Client{
-			Transport: &http.Transport{
-				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-			},
-		},
-	})
+	}
+	collector.WithDefaults(c)
+	c.client, c.clientErr = tlsconfig.NewHTTPClient(conf.Options, conf.CAFile)
+	return c
 }
 
 func (c *Collector) SubmittedSeries() float64 {
@@ -77,7 +91,21 @@ func (c *Collector) Name() string {
 	return CollectorName
 }
 
+// statusCodeCheck is critical for the server errors and a warning for the client errors
+func statusCodeCheck(code int) datadog.CheckStatus {
+	switch {
+	case code >= 500:
+		return datadog.CheckCritical
+	case code >= 400:
+		return datadog.CheckWarning
+	}
+	return datadog.CheckOK
+}
+
 func (c *Collector) Collect(ctx context.Context) error {
+	if c.clientErr != nil {
+		return c.clientErr
+	}
 	s, ok := c.conf.Options[OptionURL]
 	if !ok {
 		zap.L().Error("missing option", zap.String("options", OptionURL))
@@ -101,14 +129,27 @@ func (c *Collector) Collect(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	checkTags := []string{"url:" + s, "method:" + m, "host-target:" + u.Host}
 	now := time.Now()
 	resp, err := c.client.Do(req)
 	latency := time.Since(now)
 	if err != nil {
+		c.conf.SubmitServiceCheck(&datadog.ServiceCheck{
+			Check:   ServiceCheck,
+			Status:  datadog.CheckCritical,
+			Message: err.Error(),
+			Tags:    checkTags,
+		})
 		return err
 	}
 	_, _ = io.Copy(io.Discard, resp.Body)
 	_ = resp.Body.Close()
+	c.conf.SubmitServiceCheck(&datadog.ServiceCheck{
+		Check:   ServiceCheck,
+		Status:  statusCodeCheck(resp.StatusCode),
+		Message: resp.Status,
+		Tags:    checkTags,
+	})
 	tags := c.Tags()
 	ipAddress := u.Host
 	if net.ParseIP(ipAddress) == nil {
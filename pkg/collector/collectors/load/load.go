@@ -16,6 +16,15 @@ const (
 	CollectorName = "load"
 )
 
+func init() {
+	tags := []string{"collector"}
+	metrics.RegisterMetadata(map[string]*metrics.Metadata{
+		"load.1":  {Type: metrics.TypeGauge, Description: "system load average over 1 minute", TagKeys: tags},
+		"load.5":  {Type: metrics.TypeGauge, Description: "system load average over 5 minutes", TagKeys: tags},
+		"load.15": {Type: metrics.TypeGauge, Description: "system load average over 15 minutes", TagKeys: tags},
+	})
+}
+
 type Collector struct {
 	conf     *collector.Config
 	measures *metrics.Measures
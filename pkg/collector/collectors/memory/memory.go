@@ -15,6 +15,18 @@ const (
 	CollectorName = "memory"
 )
 
+func init() {
+	tags := []string{"collector"}
+	metrics.RegisterMetadata(map[string]*metrics.Metadata{
+		"memory.ram.total":  {Type: metrics.TypeGauge, Unit: "byte", Description: "total memory", TagKeys: tags},
+		"memory.ram.free":   {Type: metrics.TypeGauge, Unit: "byte", Description: "free memory", TagKeys: tags},
+		"memory.ram.shared": {Type: metrics.TypeGauge, Unit: "byte", Description: "shared memory", TagKeys: tags},
+		"memory.ram.buffer": {Type: metrics.TypeGauge, Unit: "byte", Description: "memory used by buffers", TagKeys: tags},
+		"memory.swap.total": {Type: metrics.TypeGauge, Unit: "byte", Description: "total swap", TagKeys: tags},
+		"memory.swap.free":  {Type: metrics.TypeGauge, Unit: "byte", Description: "free swap", TagKeys: tags},
+	})
+}
+
 type Collector struct {
 	conf     *collector.Config
 	measures *metrics.Measures
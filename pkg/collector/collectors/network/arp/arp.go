@@ -33,6 +33,12 @@ IP address       HW type     Flags       HW address            Mask     Device
 192.168.1.134    0x1         0x2         b0:2a:43:1e:62:99     *        br0
 */
 
+func init() {
+	metrics.RegisterMetadata(map[string]*metrics.Metadata{
+		"network.arp": {Type: metrics.TypeGauge, Description: "1 for each entry of the ARP table", TagKeys: []string{"collector", "device", "ip", "lease", "mac", "vendor"}},
+	})
+}
+
 type Collector struct {
 	conf     *collector.Config
 	measures *metrics.Measures
@@ -118,8 +124,8 @@ func (c *Collector) Collect(_ context.Context) error {
 		macAddress = macvendor.NormaliseMacAddress(macAddress)
 		macAddressTag, ipAddressTag, deviceTag := tagger.NewTagUnsafe("mac", macAddress), tagger.NewTagUnsafe("ip", ipAddress), tagger.NewTagUnsafe("device", device)
 		vendorTag := tagger.NewTagUnsafe("vendor", macvendor.GetVendorWithMacOrUnknown(macAddress))
-		c.conf.Tagger.Update(ipAddress, macAddressTag, deviceTag, vendorTag)
-		c.conf.Tagger.Update(macAddress, ipAddressTag, deviceTag, vendorTag)
+		c.conf.Tagger.Update(macAddress, deviceTag, vendorTag)
+		c.conf.Tagger.Relink(macAddressTag, ipAddressTag)
 
 		// we rely on dnsmasq tags collection to make this available
 		tags := append(hostTags, c.conf.Tagger.GetUnstableWithDefault(macAddress, c.leaseTag)...)
@@ -2,6 +2,8 @@ package conntrack
 
 import (
 	"context"
+	"fmt"
+	"os"
 	"strconv"
 	"time"
 
@@ -17,11 +19,29 @@ import (
 const (
 	CollectorName = "network-conntrack"
 
-	optionConntrackFile = "conntrack-file"
+	optionConntrackFile   = "conntrack-file"
+	optionConntrackSource = "conntrack-source"
+
+	// sourceFile reads the conntrack file at each deadline of its records, sourceNetlink dumps the table and follows its events
+	sourceFile    = "file"
+	sourceNetlink = "netlink"
 
 	maxAgeConntrackEntries = time.Hour
+	// netlinkResyncInterval dumps the table again for the destroy events dropped by the kernel
+	netlinkResyncInterval = time.Minute * 10
 )
 
+func init() {
+	metrics.RegisterMetadata(map[string]*metrics.Metadata{
+		"network.conntrack.entries": {
+			Type:        metrics.TypeGauge,
+			Unit:        "connection",
+			Description: "tracked connections",
+			TagKeys:     []string{"collector", "device", "dport", "ip", "lease", "protocol", "state"},
+		},
+	})
+}
+
 type Collector struct {
 	conf     *collector.Config
 	measures *metrics.Measures
@@ -60,7 +80,8 @@ func newConntrack(conf *collector.Config) *Collector {
 
 func (c *Collector) DefaultOptions() map[string]string {
 	return map[string]string{
-		optionConntrackFile: "/proc/net/ip_conntrack",
+		optionConntrackFile:   conntrack.ProcIPConntrack,
+		optionConntrackSource: sourceFile,
 	}
 }
 
@@ -120,7 +141,49 @@ func (c *Collector) aggregationToSamples(now time.Time, aggr *aggregation) *metr
 	}
 }
 
+func (c *Collector) aggregate(aggregations map[string]*aggregation, record *conntrack.Record) {
+	portRange := getPortRange(record.From.Quad.DestinationPort)
+	aKey := record.Protocol + record.From.Quad.Source + portRange
+
+	aggr, ok := aggregations[aKey]
+	if !ok {
+		aggr = &aggregation{
+			protocol:             record.Protocol,
+			destinationPortRange: portRange,
+			sourceIP:             record.From.Quad.Source,
+			state:                record.State,
+		}
+		aggregations[aKey] = aggr
+	}
+	aggr.count++
+}
+
+func (c *Collector) submit(aggregations map[string]*aggregation) {
+	now := time.Now()
+	for _, aggr := range aggregations {
+		_ = c.measures.GaugeDeviation(c.aggregationToSamples(now, aggr), c.conf.CollectInterval*2)
+	}
+	c.measures.Purge()
+}
+
 func (c *Collector) Collect(ctx context.Context) error {
+	source := c.conf.Options[optionConntrackSource]
+	switch source {
+	case sourceNetlink:
+		return c.collectNetlink(ctx)
+	case sourceFile, "":
+		return c.collectFile(ctx)
+	}
+	err := fmt.Errorf("must be %s or %s", sourceFile, sourceNetlink)
+	zap.L().Error("invalid option",
+		zap.String("options", optionConntrackSource),
+		zap.String(optionConntrackSource, source),
+		zap.Error(err),
+	)
+	return err
+}
+
+func (c *Collector) collectFile(ctx context.Context) error {
 	after := time.After(0)
 
 	aggregations := make(map[string]*aggregation)
@@ -132,11 +195,7 @@ func (c *Collector) Collect(ctx context.Context) error {
 			return nil
 
 		case <-ticker.C:
-			now := time.Now()
-			for _, aggr := range aggregations {
-				_ = c.measures.GaugeDeviation(c.aggregationToSamples(now, aggr), c.conf.CollectInterval*2)
-			}
-			c.measures.Purge()
+			c.submit(aggregations)
 			aggregations = make(map[string]*aggregation)
 
 		case <-after:
@@ -147,6 +206,10 @@ func (c *Collector) Collect(ctx context.Context) error {
 			}
 
 			newRecords, closestDeadline, err := conntrack.GetConntrackRecords(conntrackPath)
+			if os.IsNotExist(err) && conntrackPath == conntrack.ProcIPConntrack {
+				// the modern kernels only expose nf_conntrack
+				newRecords, closestDeadline, err = conntrack.GetConntrackRecords(conntrack.ProcNFConntrack)
+			}
 			if err != nil {
 				zap.L().Error("failed to get conntrack records", zap.Error(err))
 				continue
@@ -158,21 +221,81 @@ func (c *Collector) Collect(ctx context.Context) error {
 			after = time.After(closestDeadlineIn)
 
 			for _, newRecord := range newRecords {
-				portRange := getPortRange(newRecord.From.Quad.DestinationPort)
-				aKey := newRecord.Protocol + newRecord.From.Quad.Source + portRange
-
-				aggr, ok := aggregations[aKey]
-				if !ok {
-					aggr = &aggregation{
-						protocol:             newRecord.Protocol,
-						destinationPortRange: portRange,
-						sourceIP:             newRecord.From.Quad.Source,
-						state:                newRecord.State,
-					}
-					aggregations[aKey] = aggr
+				c.aggregate(aggregations, newRecord)
+			}
+		}
+	}
+}
+
+// collectNetlink keeps the conntrack table up to date with the netlink events,
+// the connections destroyed during an interval are still submitted with it
+func (c *Collector) collectNetlink(ctx context.Context) error {
+	records := make(map[uint64]*conntrack.Record)
+	destroyed := make(map[uint64]*conntrack.Record)
+
+	events := make(chan *conntrack.Event)
+	watchErr := make(chan error, 1)
+	watch := func() {
+		watchErr <- conntrack.WatchNetlinkEvents(ctx, events)
+	}
+	go watch()
+	dump := time.After(0)
+	var rewatch <-chan time.Time
+
+	ticker := time.NewTicker(c.conf.CollectInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case <-ticker.C:
+			aggregations := make(map[string]*aggregation)
+			for _, record := range records {
+				c.aggregate(aggregations, record)
+			}
+			for key, record := range destroyed {
+				if _, ok := records[key]; ok {
+					continue
 				}
-				aggr.count++
+				c.aggregate(aggregations, record)
 			}
+			c.submit(aggregations)
+			destroyed = make(map[uint64]*conntrack.Record)
+
+		case <-dump:
+			newRecords, _, err := conntrack.GetNetlinkRecords()
+			if err != nil {
+				zap.L().Error("failed to dump conntrack records", zap.Error(err))
+				dump = time.After(c.conf.CollectInterval)
+				continue
+			}
+			records = make(map[uint64]*conntrack.Record, len(newRecords))
+			for _, newRecord := range newRecords {
+				records[newRecord.Key()] = newRecord
+			}
+			dump = time.After(netlinkResyncInterval)
+
+		case e := <-events:
+			key := e.Record.Key()
+			if e.Type == conntrack.EventDestroy {
+				delete(records, key)
+				destroyed[key] = e.Record
+				continue
+			}
+			records[key] = e.Record
+
+		case err := <-watchErr:
+			if ctx.Err() != nil {
+				return nil
+			}
+			// the events dropped meanwhile are recovered by a new dump
+			zap.L().Error("failed to watch conntrack events", zap.Error(err))
+			rewatch = time.After(c.conf.CollectInterval)
+
+		case <-rewatch:
+			go watch()
+			dump = time.After(0)
 		}
 	}
 }
@@ -34,7 +34,7 @@ type Collector struct {
 func NewStatistics(conf *collector.Config) collector.Collector {
 	return collector.WithDefaults(&Collector{
 		conf:     conf,
-		measures: metrics.NewMeasures(conf.MetricsClient.ChanSeries),
+		measures: metrics.NewMeasures(conf.MetricsClient.ChanSeries).WithBaselines(conf.Baselines, true),
 	})
 }
 
@@ -101,6 +101,8 @@ func (c *Collector) Collect(_ context.Context) error {
 			Host:  c.conf.Host,
 			Time:  now,
 			Tags:  append(hostTags, "device:"+statistic.deviceName),
+			// the tagger tags of the host change across restarts
+			BaselineTags: append([]string{"device:" + statistic.deviceName}, c.conf.Tags...),
 		})
 	}
 	return nil
@@ -38,6 +38,14 @@ Inter-| sta-|   Quality        |   Discarded packets               | Missed | WE
 
 */
 
+func init() {
+	tags := []string{"collector", "device", "mac"}
+	metrics.RegisterMetadata(map[string]*metrics.Metadata{
+		wirelessMetricPrefix + "noise": {Type: metrics.TypeGauge, Description: "noise level in dBm", TagKeys: tags},
+		wirelessDiscardRetryMetric:     {Type: metrics.TypeCount, Unit: "packet", Description: "packets discarded after too many retries", TagKeys: tags},
+	})
+}
+
 type Collector struct {
 	conf     *collector.Config
 	measures *metrics.Measures
@@ -46,7 +54,7 @@ type Collector struct {
 func NewWireless(conf *collector.Config) collector.Collector {
 	return collector.WithDefaults(&Collector{
 		conf:     conf,
-		measures: metrics.NewMeasures(conf.MetricsClient.ChanSeries),
+		measures: metrics.NewMeasures(conf.MetricsClient.ChanSeries).WithBaselines(conf.Baselines, true),
 	})
 }
 
@@ -157,6 +165,8 @@ func (c *Collector) Collect(_ context.Context) error {
 			Time:  now,
 			Host:  c.conf.Host,
 			Tags:  tags,
+			// the tagger tags of the host change across restarts
+			BaselineTags: append([]string{"device:" + device, "mac:" + deviceMacR}, c.conf.Tags...),
 		})
 	}
 	return nil
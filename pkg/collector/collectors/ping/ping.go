@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/JulienBalestra/monitoring/pkg/collector"
+	"github.com/JulienBalestra/monitoring/pkg/datadog"
 	"github.com/JulienBalestra/monitoring/pkg/metrics"
 	"go.uber.org/zap"
 )
@@ -20,8 +21,16 @@ const (
 
 	OptionTarget  = "target"
 	OptionTimeout = "timeout-sec"
+
+	ServiceCheck = "ping.can_connect"
 )
 
+func init() {
+	metrics.RegisterMetadata(map[string]*metrics.Metadata{
+		"latency.icmp": {Type: metrics.TypeGauge, Unit: "millisecond", Description: "ICMP echo round trip time", TagKeys: []string{"collector", "ip", "target"}},
+	})
+}
+
 type Collector struct {
 	conf     *collector.Config
 	measures *metrics.Measures
@@ -115,9 +124,38 @@ func (c *Collector) Collect(ctx context.Context) error {
 
 	dst, err := net.ResolveIPAddr("ip4", target)
 	if err != nil {
+		c.submitServiceCheck(datadog.CheckCritical, err.Error(), "target:"+target)
 		return err
 	}
 
+	f, err := c.ping(ctx, dst, timeout, timeoutDuration)
+	if err != nil {
+		c.submitServiceCheck(datadog.CheckCritical, err.Error(), "ip:"+dst.IP.String(), "target:"+target)
+		return err
+	}
+	c.submitServiceCheck(datadog.CheckOK, "", "ip:"+dst.IP.String(), "target:"+target)
+	tags := append(c.Tags(), "ip:"+dst.IP.String(), "target:"+target)
+	c.measures.GaugeDeviation(&metrics.Sample{
+		Name:  "latency.icmp",
+		Value: f,
+		Time:  time.Now(),
+		Host:  c.conf.Host,
+		Tags:  append(tags, c.conf.Tagger.GetUnstable(target)...),
+	}, c.conf.CollectInterval*3)
+	return nil
+}
+
+func (c *Collector) submitServiceCheck(status datadog.CheckStatus, message string, tags ...string) {
+	c.conf.SubmitServiceCheck(&datadog.ServiceCheck{
+		Check:   ServiceCheck,
+		Status:  status,
+		Message: message,
+		Tags:    tags,
+	})
+}
+
+// ping returns the round trip time in milliseconds
+func (c *Collector) ping(ctx context.Context, dst *net.IPAddr, timeout string, timeoutDuration time.Duration) (float64, error) {
 	ctx, cancel := context.WithTimeout(ctx, timeoutDuration)
 	defer cancel()
 	b, err := exec.CommandContext(ctx, "ping",
@@ -127,7 +165,7 @@ func (c *Collector) Collect(ctx context.Context) error {
 		dst.IP.String(),
 	).CombinedOutput()
 	if err != nil {
-		return fmt.Errorf(
+		return 0, fmt.Errorf(
 			`"ping -w %s -W %s -c 1 %s": %v`,
 			timeout, timeout, dst.IP.String(),
 			err,
@@ -135,25 +173,13 @@ func (c *Collector) Collect(ctx context.Context) error {
 	}
 	i := bytes.Index(b, c.timeStart)
 	if i == -1 {
-		return errors.New("failed to parse ping output")
+		return 0, errors.New("failed to parse ping output")
 	}
 	i += 5
 	b = b[i:]
 	i = bytes.Index(b, c.timeEnd)
 	if i == -1 {
-		return errors.New("failed to parse ping output")
+		return 0, errors.New("failed to parse ping output")
 	}
-	f, err := strconv.ParseFloat(string(b[:i]), 10)
-	if err != nil {
-		return err
-	}
-	tags := append(c.Tags(), "ip:"+dst.IP.String(), "target:"+target)
-	c.measures.GaugeDeviation(&metrics.Sample{
-		Name:  "latency.icmp",
-		Value: f,
-		Time:  time.Now(),
-		Host:  c.conf.Host,
-		Tags:  append(tags, c.conf.Tagger.GetUnstable(target)...),
-	}, c.conf.CollectInterval*3)
-	return nil
+	return strconv.ParseFloat(string(b[:i]), 10)
 }
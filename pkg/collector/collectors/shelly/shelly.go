@@ -21,12 +21,42 @@ const (
 	CollectorName = "shelly"
 
 	optionEndpoint = "endpoint"
+	// optionPowerRollup like "1m:avg,min,max" buffers the power.current samples, empty to disable
+	optionPowerRollup = "power-rollup"
+
+	powerCurrentMetric = "power.current"
 )
 
+func init() {
+	tags := []string{"collector", "ip", "mac", "shelly-model"}
+	metrics.RegisterMetadata(map[string]*metrics.Metadata{
+		"temperature.celsius":       {Type: metrics.TypeGauge, Unit: "degree celsius", Description: "temperature of the sensor", TagKeys: append(tags, "sensor")},
+		"network.wireless.rssi.dbm": {Type: metrics.TypeGauge, Description: "received signal strength indicator in dBm", TagKeys: append(tags, "ssid")},
+		"memory.ram.free":           {Type: metrics.TypeGauge, Unit: "byte", Description: "free memory", TagKeys: tags},
+		"memory.ram.total":          {Type: metrics.TypeGauge, Unit: "byte", Description: "total memory", TagKeys: tags},
+		"filesystem.free":           {Type: metrics.TypeGauge, Unit: "byte", Description: "free space of the filesystem", TagKeys: tags},
+		"filesystem.size":           {Type: metrics.TypeGauge, Unit: "byte", Description: "size of the filesystem", TagKeys: tags},
+		"up.time":                   {Type: metrics.TypeGauge, Unit: "second", Description: "time since the device booted", TagKeys: tags},
+		powerCurrentMetric:          {Type: metrics.TypeGauge, Unit: "watt", Description: "instantaneous power of the meter", TagKeys: append(tags, "meter")},
+		"power.total":               {Type: metrics.TypeCount, Description: "energy consumed by the meter in watt-minute", TagKeys: append(tags, "meter")},
+		"power.on":                  {Type: metrics.TypeGauge, Description: "1 when the relay is on", TagKeys: append(tags, "relay")},
+	})
+	for _, a := range []metrics.Aggregate{metrics.AggregateAvg, metrics.AggregateMin, metrics.AggregateMax, metrics.AggregateLast} {
+		metrics.DefaultRegistry.Register(powerCurrentMetric+"."+string(a), &metrics.Metadata{
+			Type:        metrics.TypeGauge,
+			Unit:        "watt",
+			Description: string(a) + " power of the meter over the rollup window",
+			TagKeys:     append(tags, "meter"),
+		})
+	}
+}
+
 type Collector struct {
 	conf     *collector.Config
 	measures *metrics.Measures
 	client   *http.Client
+
+	powerRollup string
 }
 
 type Status struct {
@@ -54,7 +84,7 @@ type Status struct {
 func NewShelly(conf *collector.Config) collector.Collector {
 	return collector.WithDefaults(&Collector{
 		conf:     conf,
-		measures: metrics.NewMeasures(conf.MetricsClient.ChanSeries),
+		measures: metrics.NewMeasures(conf.MetricsClient.ChanSeries).WithBaselines(conf.Baselines, false),
 		client: &http.Client{
 			Timeout: conf.CollectInterval,
 		},
@@ -77,7 +107,8 @@ func (c *Collector) Tags() []string {
 
 func (c *Collector) DefaultOptions() map[string]string {
 	return map[string]string{
-		optionEndpoint: "http://192.168.1.2",
+		optionEndpoint:    "http://192.168.1.2",
+		optionPowerRollup: "",
 	}
 }
 
@@ -101,12 +132,38 @@ func parseMac(m string) string {
 	return m
 }
 
+func (c *Collector) setPowerRollup() error {
+	powerRollup := c.conf.Options[optionPowerRollup]
+	if powerRollup == c.powerRollup {
+		return nil
+	}
+	if powerRollup == "" {
+		c.measures.SetRollup(powerCurrentMetric, nil)
+		c.powerRollup = powerRollup
+		return nil
+	}
+	r, err := metrics.ParseRollup(powerRollup)
+	if err != nil {
+		zap.L().Error("invalid option", zap.String("options", optionPowerRollup), zap.Error(err))
+		return err
+	}
+	c.measures.SetRollup(powerCurrentMetric, r)
+	c.powerRollup = powerRollup
+	return nil
+}
+
 func (c *Collector) Collect(ctx context.Context) error {
 	shellyEndpoint, ok := c.conf.Options[optionEndpoint]
 	if !ok {
 		zap.L().Error("missing option", zap.String("options", optionEndpoint))
 		return errors.New("missing option " + optionEndpoint)
 	}
+	err := c.setPowerRollup()
+	if err != nil {
+		return err
+	}
+	// the ended windows are emitted even when the device stops answering
+	defer func() { c.measures.FlushRollups(time.Now()) }()
 	ctx, cancel := context.WithTimeout(ctx, c.conf.CollectInterval)
 	defer cancel()
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, shellyEndpoint+"/status", nil)
@@ -196,7 +253,7 @@ func (c *Collector) Collect(ctx context.Context) error {
 	for i, meter := range s.Meters {
 		meterTag := "meter:" + strconv.Itoa(i)
 		c.measures.GaugeDeviation(&metrics.Sample{
-			Name:  "power.current",
+			Name:  powerCurrentMetric,
 			Value: meter.Power,
 			Time:  now,
 			Host:  c.conf.Host,
@@ -208,6 +265,8 @@ func (c *Collector) Collect(ctx context.Context) error {
 			Time:  now,
 			Host:  c.conf.Host,
 			Tags:  append(tags, meterTag),
+			// the tagger tags of the host change across restarts
+			BaselineTags: []string{"mac:" + parseMac(s.Mac), meterTag},
 		})
 	}
 	for i, relay := range s.Relays {
@@ -1,12 +1,58 @@
 package shelly
 
 import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
 	"testing"
+	"time"
 
-	"github.com/magiconair/properties/assert"
+	"github.com/JulienBalestra/monitoring/pkg/collector"
+	"github.com/JulienBalestra/monitoring/pkg/datadog"
+	"github.com/JulienBalestra/monitoring/pkg/tagger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestNewShelly(t *testing.T) {
 	m := parseMac("807D3A021C15")
 	assert.Equal(t, m, "80-7d-3a-02-1c-15")
 }
+
+func TestCollectFlushRollups(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) > 1 {
+			// the device stops answering
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"wifi_sta":{"ssid":"home","ip":"192.168.1.2","rssi":-60},"meters":[{"power":42,"total":100}],"mac":"807D3A021C15"}`))
+	}))
+	defer srv.Close()
+
+	client := datadog.NewClient(&datadog.Config{ChanSize: 1000})
+	c := NewShelly(&collector.Config{
+		MetricsClient:   client,
+		Tagger:          tagger.NewTagger(),
+		Host:            "router",
+		CollectInterval: time.Second,
+		Options: map[string]string{
+			optionEndpoint:    srv.URL,
+			optionPowerRollup: "1s:avg",
+		},
+	})
+	require.NoError(t, c.Collect(context.Background()))
+	time.Sleep(time.Second)
+	require.Error(t, c.Collect(context.Background()))
+
+	var avg []float64
+	for len(client.ChanSeries) > 0 {
+		s := <-client.ChanSeries
+		if s.Metric == powerCurrentMetric+".avg" {
+			avg = append(avg, s.Points[0][1])
+		}
+	}
+	assert.Equal(t, []float64{42}, avg)
+}
@@ -2,16 +2,44 @@ package tagger
 
 import (
 	"context"
+	"strings"
 	"time"
 
 	"github.com/JulienBalestra/monitoring/pkg/collector"
 	"github.com/JulienBalestra/monitoring/pkg/metrics"
+	"github.com/JulienBalestra/monitoring/pkg/tagger"
 )
 
 const (
 	CollectorName = "tagger"
+
+	optionGroupBy       = "group-by"
+	optionGroupSelector = "group-selector"
 )
 
+func init() {
+	tags := []string{"collector"}
+	metrics.RegisterMetadata(map[string]*metrics.Metadata{
+		"tagger.entities": {Type: metrics.TypeGauge, Unit: "item", Description: "entities in the tagger", TagKeys: tags},
+		"tagger.keys":     {Type: metrics.TypeGauge, Unit: "key", Description: "tag keys in the tagger", TagKeys: tags},
+		"tagger.tags":     {Type: metrics.TypeGauge, Unit: "item", Description: "tags in the tagger", TagKeys: tags},
+		"tagger.stale":    {Type: metrics.TypeGauge, Unit: "item", Description: "tags restored from the snapshot not confirmed yet by a collector", TagKeys: tags},
+		"tagger.imported": {Type: metrics.TypeGauge, Unit: "item", Description: "tags imported from the replication peers", TagKeys: tags},
+		"tagger.devices":  {Type: metrics.TypeGauge, Unit: "device", Description: "devices with identifiers linked to them", TagKeys: tags},
+		"tagger.aliases":  {Type: metrics.TypeGauge, Unit: "item", Description: "identifiers linked to a device", TagKeys: tags},
+
+		"tagger.enrichment.rules": {Type: metrics.TypeGauge, Unit: "item", Description: "rules of the enrichment file", TagKeys: tags},
+		"tagger.watchers":         {Type: metrics.TypeGauge, Unit: "item", Description: "watchers of the tagger changes", TagKeys: tags},
+		"tagger.watch.dropped":    {Type: metrics.TypeCount, Unit: "event", Description: "tagger changes dropped because the buffer of the watcher was full", TagKeys: tags},
+		"tagger.group.entities":   {Type: metrics.TypeGauge, Unit: "item", Description: "entities of the group-selector by value of a group-by key", TagKeys: append(tags, "group-by")},
+
+		"tagger.expired.tags":     {Type: metrics.TypeCount, Unit: "item", Description: "tags removed after their TTL", TagKeys: tags},
+		"tagger.expired.entities": {Type: metrics.TypeCount, Unit: "item", Description: "entities removed once all their tags expired", TagKeys: tags},
+		"tagger.expired.links":    {Type: metrics.TypeCount, Unit: "item", Description: "identifiers unlinked from their device after their TTL", TagKeys: tags},
+		"tagger.tag.collisions":   {Type: metrics.TypeCount, Unit: "item", Description: "different tags normalised to the same tag", TagKeys: tags},
+	})
+}
+
 type Collector struct {
 	conf     *collector.Config
 	measures *metrics.Measures
@@ -39,7 +67,10 @@ func (c *Collector) Tags() []string {
 }
 
 func (c *Collector) DefaultOptions() map[string]string {
-	return map[string]string{}
+	return map[string]string{
+		optionGroupBy:       "",
+		optionGroupSelector: "",
+	}
 }
 
 func (c *Collector) DefaultCollectInterval() time.Duration {
@@ -60,27 +91,136 @@ func (c *Collector) Collect(_ context.Context) error {
 	now := time.Now()
 	tags := c.conf.Tagger.GetUnstable(c.conf.Host)
 
-	entities, keys, tagsNumber := c.conf.Tagger.Stats()
+	stats := c.conf.Tagger.Stats()
 	c.measures.GaugeDeviation(&metrics.Sample{
 		Name:  "tagger.entities",
-		Value: entities,
+		Value: stats.Entities,
 		Time:  now,
 		Host:  c.conf.Host,
 		Tags:  tags,
 	}, c.conf.CollectInterval*2)
 	c.measures.GaugeDeviation(&metrics.Sample{
 		Name:  "tagger.keys",
-		Value: keys,
+		Value: stats.Keys,
 		Time:  now,
 		Host:  c.conf.Host,
 		Tags:  tags,
 	}, c.conf.CollectInterval*2)
 	c.measures.GaugeDeviation(&metrics.Sample{
 		Name:  "tagger.tags",
-		Value: tagsNumber,
+		Value: stats.Tags,
+		Time:  now,
+		Host:  c.conf.Host,
+		Tags:  tags,
+	}, c.conf.CollectInterval*2)
+	c.measures.GaugeDeviation(&metrics.Sample{
+		Name:  "tagger.stale",
+		Value: stats.Stale,
+		Time:  now,
+		Host:  c.conf.Host,
+		Tags:  tags,
+	}, c.conf.CollectInterval*2)
+	c.measures.GaugeDeviation(&metrics.Sample{
+		Name:  "tagger.imported",
+		Value: stats.Imported,
+		Time:  now,
+		Host:  c.conf.Host,
+		Tags:  tags,
+	}, c.conf.CollectInterval*2)
+	c.measures.GaugeDeviation(&metrics.Sample{
+		Name:  "tagger.devices",
+		Value: stats.Devices,
+		Time:  now,
+		Host:  c.conf.Host,
+		Tags:  tags,
+	}, c.conf.CollectInterval*2)
+	c.measures.GaugeDeviation(&metrics.Sample{
+		Name:  "tagger.aliases",
+		Value: stats.Aliases,
+		Time:  now,
+		Host:  c.conf.Host,
+		Tags:  tags,
+	}, c.conf.CollectInterval*2)
+	c.measures.GaugeDeviation(&metrics.Sample{
+		Name:  "tagger.enrichment.rules",
+		Value: stats.EnrichmentRules,
+		Time:  now,
+		Host:  c.conf.Host,
+		Tags:  tags,
+	}, c.conf.CollectInterval*2)
+	c.measures.GaugeDeviation(&metrics.Sample{
+		Name:  "tagger.watchers",
+		Value: stats.Watchers,
 		Time:  now,
 		Host:  c.conf.Host,
 		Tags:  tags,
 	}, c.conf.CollectInterval*2)
+	_ = c.measures.Count(&metrics.Sample{
+		Name:  "tagger.watch.dropped",
+		Value: stats.WatchDropped,
+		Time:  now,
+		Host:  c.conf.Host,
+		Tags:  tags,
+	})
+	_ = c.measures.Count(&metrics.Sample{
+		Name:  "tagger.expired.tags",
+		Value: stats.ExpiredTags,
+		Time:  now,
+		Host:  c.conf.Host,
+		Tags:  tags,
+	})
+	_ = c.measures.Count(&metrics.Sample{
+		Name:  "tagger.expired.entities",
+		Value: stats.ExpiredEntities,
+		Time:  now,
+		Host:  c.conf.Host,
+		Tags:  tags,
+	})
+	_ = c.measures.Count(&metrics.Sample{
+		Name:  "tagger.expired.links",
+		Value: stats.ExpiredLinks,
+		Time:  now,
+		Host:  c.conf.Host,
+		Tags:  tags,
+	})
+	_ = c.measures.Count(&metrics.Sample{
+		Name:  "tagger.tag.collisions",
+		Value: stats.TagCollisions,
+		Time:  now,
+		Host:  c.conf.Host,
+		Tags:  tags,
+	})
+	return c.collectGroups(now, tags)
+}
+
+// collectGroups counts the entities by value of the group-by keys, like the devices per vendor
+func (c *Collector) collectGroups(now time.Time, tags []string) error {
+	groupBy := c.conf.Options[optionGroupBy]
+	if groupBy == "" {
+		return nil
+	}
+	var selector *tagger.Selector
+	if s := c.conf.Options[optionGroupSelector]; s != "" {
+		var err error
+		selector, err = tagger.ParseSelector(s)
+		if err != nil {
+			return err
+		}
+	}
+	for _, key := range strings.Split(groupBy, ",") {
+		key = strings.TrimSpace(key)
+		if key == "" {
+			continue
+		}
+		for value, count := range c.conf.Tagger.Count(key, selector) {
+			c.measures.Gauge(&metrics.Sample{
+				Name:  "tagger.group.entities",
+				Value: float64(count),
+				Time:  now,
+				Host:  c.conf.Host,
+				Tags:  append([]string{"group-by:" + key, key + ":" + value}, tags...),
+			})
+		}
+	}
 	return nil
 }
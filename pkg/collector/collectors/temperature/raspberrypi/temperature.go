@@ -20,6 +20,12 @@ const (
 	optionTemperatureFile = "temperature-file"
 )
 
+func init() {
+	metrics.RegisterMetadata(map[string]*metrics.Metadata{
+		"temperature.celsius": {Type: metrics.TypeGauge, Unit: "degree celsius", Description: "temperature of the sensor", TagKeys: []string{"collector", "sensor"}},
+	})
+}
+
 type Collector struct {
 	conf     *collector.Config
 	measures *metrics.Measures
@@ -15,6 +15,12 @@ const (
 	CollectorName = "uptime"
 )
 
+func init() {
+	metrics.RegisterMetadata(map[string]*metrics.Metadata{
+		"up.time": {Type: metrics.TypeGauge, Unit: "second", Description: "time since the host booted", TagKeys: []string{"collector"}},
+	})
+}
+
 type Collector struct {
 	conf     *collector.Config
 	measures *metrics.Measures
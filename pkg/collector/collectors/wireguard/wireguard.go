@@ -11,6 +11,7 @@ import (
 	"time"
 
 	"github.com/JulienBalestra/monitoring/pkg/collector"
+	"github.com/JulienBalestra/monitoring/pkg/datadog"
 	"github.com/JulienBalestra/monitoring/pkg/metrics"
 	"github.com/JulienBalestra/monitoring/pkg/tagger"
 	"golang.zx2c4.com/wireguard/wgctrl"
@@ -23,6 +24,8 @@ const (
 	wireguardMetricPrefix = "wireguard."
 
 	none = "none"
+
+	ServiceCheck = "wireguard.peer.active"
 )
 
 type peerWithSHA struct {
@@ -43,15 +46,30 @@ func withSHA(peer *wgtypes.Peer) *peerWithSHA {
 	}
 }
 
+func init() {
+	tags := []string{"allowed-ips", "collector", "device", "endpoint", "ip", "port", "pub-key-sha1", "pub-key-sha1-7", "wg-active"}
+	metrics.RegisterMetadata(map[string]*metrics.Metadata{
+		wireguardMetricPrefix + "active":            {Type: metrics.TypeGauge, Description: "1 when the peer did a recent handshake", TagKeys: tags},
+		wireguardMetricPrefix + "inactive":          {Type: metrics.TypeGauge, Description: "1 when the peer didn't do a recent handshake", TagKeys: tags},
+		wireguardMetricPrefix + "transfer.received": {Type: metrics.TypeCount, Unit: "byte", Description: "bytes received from the peer", TagKeys: tags},
+		wireguardMetricPrefix + "transfer.sent":     {Type: metrics.TypeCount, Unit: "byte", Description: "bytes sent to the peer", TagKeys: tags},
+		wireguardMetricPrefix + "handshake.age":     {Type: metrics.TypeGauge, Unit: "second", Description: "time since the latest handshake", TagKeys: tags},
+	})
+}
+
 type Collector struct {
 	conf     *collector.Config
 	measures *metrics.Measures
+
+	// latest endpoint of each peer public key
+	endpoints map[string]string
 }
 
 func NewWireguard(conf *collector.Config) collector.Collector {
 	return collector.WithDefaults(&Collector{
-		conf:     conf,
-		measures: metrics.NewMeasures(conf.MetricsClient.ChanSeries),
+		conf:      conf,
+		measures:  metrics.NewMeasures(conf.MetricsClient.ChanSeries).WithBaselines(conf.Baselines, true),
+		endpoints: make(map[string]string),
 	})
 }
 
@@ -131,6 +149,37 @@ func (c *Collector) setStatus(now time.Time, tags []string, isActive bool) {
 	)
 }
 
+// submitServiceCheck is tagged by the stable identity of the peer, the other tags would split the check
+func (c *Collector) submitServiceCheck(peer *peerWithSHA, status datadog.CheckStatus, message string) {
+	c.conf.SubmitServiceCheck(&datadog.ServiceCheck{
+		Check:   ServiceCheck,
+		Status:  status,
+		Message: message,
+		Tags: []string{
+			"pub-key-sha1:" + peer.PublicKeySha1,
+			"pub-key-sha1-7:" + peer.PublicKeyShortSha1,
+			"allowed-ips:" + getAllowedIPsTag(peer.AllowedIPs),
+		},
+	})
+}
+
+// observeEndpoint submits an event when the endpoint of a known peer changed, a roaming peer for example
+func (c *Collector) observeEndpoint(device string, peer *peerWithSHA) {
+	endpoint := peer.Endpoint.String()
+	previous, ok := c.endpoints[peer.PublicKeySha1]
+	c.endpoints[peer.PublicKeySha1] = endpoint
+	if !ok || previous == endpoint {
+		return
+	}
+	c.conf.SubmitEvent(&datadog.Event{
+		Title:          "wireguard peer " + peer.PublicKeyShortSha1 + " endpoint changed",
+		Text:           "endpoint of the peer on " + device + " changed from " + previous + " to " + endpoint,
+		AlertType:      datadog.AlertTypeInfo,
+		AggregationKey: CollectorName + "-endpoint-" + peer.PublicKeySha1,
+		Tags:           c.conf.Tagger.GetUnstable(peer.PublicKey.String()),
+	})
+}
+
 func (c *Collector) Collect(_ context.Context) error {
 	wgc, err := wgctrl.New()
 	if err != nil {
@@ -155,6 +204,8 @@ func (c *Collector) Collect(_ context.Context) error {
 			activeTag := tagger.NewTagUnsafe("wg-active", strconv.FormatBool(active))
 
 			pubKeySha1Tag := tagger.NewTagUnsafe("pub-key-sha1", peerSHA.PublicKeySha1)
+			// the public key and its sha1 share their tags
+			c.conf.Tagger.Link(pubKeySha1Tag, tagger.NewTagUnsafe("pub-key", peerSHA.PublicKey.String()))
 			pubKeySha1TruncTag := tagger.NewTagUnsafe("pub-key-sha1-7", peerSHA.PublicKeyShortSha1)
 			allowedIpsTag := tagger.NewTagUnsafe("allowed-ips", getAllowedIPsTag(peerSHA.AllowedIPs))
 
@@ -163,16 +214,6 @@ func (c *Collector) Collect(_ context.Context) error {
 				ipTag := tagger.NewTagUnsafe("ip", none)
 				portTag := tagger.NewTagUnsafe("port", none)
 				c.conf.Tagger.Update(peerSHA.PublicKey.String(),
-					pubKeySha1Tag,
-					pubKeySha1TruncTag,
-					allowedIpsTag,
-					activeTag,
-					deviceTag,
-					endpointTag,
-					ipTag,
-					portTag,
-				)
-				c.conf.Tagger.Update(peerSHA.PublicKeySha1,
 					pubKeySha1TruncTag,
 					allowedIpsTag,
 					activeTag,
@@ -183,6 +224,7 @@ func (c *Collector) Collect(_ context.Context) error {
 				)
 				tags := append(hostTags, c.conf.Tagger.GetUnstable(peerSHA.PublicKey.String())...)
 				c.setStatus(now, tags, false)
+				c.submitServiceCheck(peerSHA, datadog.CheckUnknown, "the peer has no endpoint")
 				continue
 			}
 
@@ -190,16 +232,6 @@ func (c *Collector) Collect(_ context.Context) error {
 			ipTag := tagger.NewTagUnsafe("ip", peerSHA.Endpoint.IP.String())
 			portTag := tagger.NewTagUnsafe("port", strconv.Itoa(peerSHA.Endpoint.Port))
 			c.conf.Tagger.Update(peerSHA.PublicKey.String(),
-				pubKeySha1Tag,
-				pubKeySha1TruncTag,
-				allowedIpsTag,
-				activeTag,
-				deviceTag,
-				endpointTag,
-				ipTag,
-				portTag,
-			)
-			c.conf.Tagger.Update(peerSHA.PublicKeySha1,
 				pubKeySha1TruncTag,
 				allowedIpsTag,
 				activeTag,
@@ -208,21 +240,31 @@ func (c *Collector) Collect(_ context.Context) error {
 				ipTag,
 				portTag,
 			)
+			c.observeEndpoint(device.Name, peerSHA)
 			tags := append(hostTags, c.conf.Tagger.GetUnstable(peerSHA.PublicKey.String())...)
+			// the tagger tags of the host and of the peer change across restarts
+			baselineTags := append([]string{deviceTag.String(), pubKeySha1Tag.String()}, c.conf.Tags...)
 			c.setStatus(now, tags, active)
+			if active {
+				c.submitServiceCheck(peerSHA, datadog.CheckOK, "")
+			} else {
+				c.submitServiceCheck(peerSHA, datadog.CheckCritical, "latest handshake "+age.Truncate(time.Second).String()+" ago")
+			}
 			_ = c.measures.CountWithNegativeReset(&metrics.Sample{
-				Name:  wireguardMetricPrefix + "transfer.received",
-				Value: float64(peerSHA.ReceiveBytes),
-				Time:  now,
-				Host:  c.conf.Host,
-				Tags:  tags,
+				Name:         wireguardMetricPrefix + "transfer.received",
+				Value:        float64(peerSHA.ReceiveBytes),
+				Time:         now,
+				Host:         c.conf.Host,
+				Tags:         tags,
+				BaselineTags: baselineTags,
 			})
 			_ = c.measures.CountWithNegativeReset(&metrics.Sample{
-				Name:  wireguardMetricPrefix + "transfer.sent",
-				Value: float64(peerSHA.TransmitBytes),
-				Time:  now,
-				Host:  c.conf.Host,
-				Tags:  tags,
+				Name:         wireguardMetricPrefix + "transfer.sent",
+				Value:        float64(peerSHA.TransmitBytes),
+				Time:         now,
+				Host:         c.conf.Host,
+				Tags:         tags,
+				BaselineTags: baselineTags,
 			})
 			c.measures.Gauge(&metrics.Sample{
 				Name:  wireguardMetricPrefix + "handshake.age",
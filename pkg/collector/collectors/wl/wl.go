@@ -28,6 +28,16 @@ const (
 	wirelessMetricPrefix = "network.wireless."
 )
 
+func init() {
+	metrics.RegisterMetadata(map[string]*metrics.Metadata{
+		wirelessMetricPrefix + "rssi.dbm": {
+			Type:        metrics.TypeGauge,
+			Description: "received signal strength indicator in dBm",
+			TagKeys:     []string{"collector", "device", "lease", "mac", "ssid", "vendor"},
+		},
+	})
+}
+
 type Collector struct {
 	conf     *collector.Config
 	measures *metrics.Measures
@@ -247,9 +257,18 @@ func (c *Collector) Collect(ctx context.Context) error {
 				continue
 			}
 			deviceTag := tagger.NewTagUnsafe("device", command.device)
-			ssidTag := tagger.NewTagUnsafe("ssid", command.ssid)
 			vendorTag := tagger.NewTagUnsafe("vendor", vendor)
-			c.conf.Tagger.Update(macAddress, deviceTag, ssidTag, vendorTag)
+			ssidTag, err := tagger.NewTag("ssid", command.ssid)
+			if err != nil {
+				zap.L().Warn("skipping invalid ssid",
+					zap.String("ssid", command.ssid),
+					zap.String("device", command.device),
+					zap.Error(err),
+				)
+				c.conf.Tagger.Update(macAddress, deviceTag, vendorTag)
+			} else {
+				c.conf.Tagger.Update(macAddress, deviceTag, ssidTag, vendorTag)
+			}
 
 			tags := append(hostTags, c.conf.Tagger.GetUnstableWithDefault(macAddress, c.defaultLeaseTag)...)
 			tags = append(tags, "mac:"+macAddress)
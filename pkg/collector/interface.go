@@ -2,6 +2,7 @@ package collector
 
 import (
 	"context"
+	"fmt"
 	"time"
 
 	"github.com/JulienBalestra/monitoring/pkg/datadog"
@@ -12,16 +13,54 @@ import (
 
 const (
 	collectorMetricPrefix = "collector."
+
+	// CollectorServiceCheck reports the result of each collection
+	CollectorServiceCheck = "collector.can_collect"
+
+	// consecutive failed collections after which the collector is considered in a failure loop
+	failureLoopThreshold = 5
 )
 
+func init() {
+	metrics.RegisterMetadata(map[string]*metrics.Metadata{
+		collectorMetricPrefix + "series":      {Type: metrics.TypeCount, Description: "series submitted by the collector", TagKeys: []string{"collector"}},
+		collectorMetricPrefix + "collections": {Type: metrics.TypeCount, Description: "collections run by the collector", TagKeys: []string{"collector", "success"}},
+	})
+}
+
 type Config struct {
 	MetricsClient *datadog.Client
 	Tagger        *tagger.Tagger
+	// Baselines persists the counters across restarts, can be nil
+	Baselines *metrics.BaselineStore
 
 	Host            string
 	CollectInterval time.Duration
 	Options         map[string]string
 	Tags            []string
+
+	// CAFile is the default tlsconfig.OptionCAFile of the collectors
+	CAFile string
+}
+
+// SubmitEvent enriches the event with the host and collector tags like the metrics, then queues it
+func (c *Config) SubmitEvent(e *datadog.Event) {
+	tags := append(c.Tagger.GetUnstable(c.Host), c.Tags...)
+	e.Tags = append(tags, e.Tags...)
+	if e.Host == "" {
+		e.Host = c.Host
+	}
+	c.MetricsClient.SubmitEvent(e)
+}
+
+// SubmitServiceCheck enriches the service check with the host and collector tags like the metrics, then queues it
+func (c *Config) SubmitServiceCheck(sc *datadog.ServiceCheck) {
+	tags := append(c.Tagger.GetUnstable(c.Host), c.Tags...)
+	sc.Tags = append(tags, sc.Tags...)
+	if sc.HostName == "" {
+		sc.HostName = c.Host
+	}
+	c.MetricsClient.SubmitServiceCheck(sc)
 }
 
 type Collector interface {
@@ -104,6 +143,7 @@ func RunCollection(ctx context.Context, c Collector) error {
 	collectorMetrics := time.NewTicker(time.Minute * 5)
 	defer collectorMetrics.Stop()
 	var series, runSuccess, runErr float64
+	failures := 0
 	for {
 		select {
 		case <-ctx.Done():
@@ -143,10 +183,41 @@ func RunCollection(ctx context.Context, c Collector) error {
 			collectionSeries := series - beforeCollection
 			if err != nil {
 				runErr++
+				failures++
 				extCtx.Error("failed collection", zap.Error(err), zap.Float64("series", collectionSeries))
+				status := datadog.CheckWarning
+				if failures >= failureLoopThreshold {
+					status = datadog.CheckCritical
+				}
+				config.SubmitServiceCheck(&datadog.ServiceCheck{
+					Check:   CollectorServiceCheck,
+					Status:  status,
+					Message: fmt.Sprintf("%d consecutive collections failed: %v", failures, err),
+				})
+				if failures == failureLoopThreshold {
+					config.SubmitEvent(&datadog.Event{
+						Title:          "collector " + c.Name() + " is failing",
+						Text:           fmt.Sprintf("%d consecutive collections failed: %v", failures, err),
+						AlertType:      datadog.AlertTypeError,
+						AggregationKey: "collector-failure-" + c.Name(),
+					})
+				}
 				continue
 			}
+			if failures >= failureLoopThreshold {
+				config.SubmitEvent(&datadog.Event{
+					Title:          "collector " + c.Name() + " recovered",
+					Text:           fmt.Sprintf("collection succeeded after %d failures", failures),
+					AlertType:      datadog.AlertTypeSuccess,
+					AggregationKey: "collector-failure-" + c.Name(),
+				})
+			}
+			failures = 0
 			runSuccess++
+			config.SubmitServiceCheck(&datadog.ServiceCheck{
+				Check:  CollectorServiceCheck,
+				Status: datadog.CheckOK,
+			})
 			zctx.Info("ok", zap.Uint64("s", uint64(collectionSeries)))
 		}
 	}
@@ -8,6 +8,7 @@ import (
 	"io"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"go.uber.org/zap"
@@ -16,6 +17,11 @@ import (
 )
 
 const (
+	// ProcIPConntrack is the legacy file of the conntrack records
+	ProcIPConntrack = "/proc/net/ip_conntrack"
+	// ProcNFConntrack is the file of the modern kernels, its records start with the family like ipv4 2
+	ProcNFConntrack = "/proc/net/nf_conntrack"
+
 	ProtocolTCP      = "tcp"
 	StateEstablished = "ESTABLISHED"
 
@@ -23,10 +29,19 @@ const (
 
 	ProtocolICMP = "icmp"
 
+	ProtocolICMPv6  = "icmpv6"
+	ProtocolSCTP    = "sctp"
+	ProtocolDCCP    = "dccp"
+	ProtocolGRE     = "gre"
+	ProtocolUDPLite = "udplite"
+
 	ProtocolUnknown = "unknown"
 
 	StateUnreplied = "UNREPLIED"
 	StateReplied   = "REPLIED"
+
+	FamilyIPv4 = "ipv4"
+	FamilyIPv6 = "ipv6"
 )
 
 var (
@@ -39,6 +54,10 @@ type Quad struct {
 
 	Destination     string
 	DestinationPort int
+
+	// ID is the identifier of the ICMP and ICMPv6 echos, they don't have ports
+	// the GRE keys are the ports
+	ID int
 }
 
 func (q *Quad) Hash() uint64 {
@@ -49,6 +68,8 @@ func (q *Quad) Hash() uint64 {
 
 	h = fnv.AddString(h, q.Destination)
 	h = fnv.Add(h, uint64(q.DestinationPort))
+
+	h = fnv.Add(h, uint64(q.ID))
 	return h
 }
 
@@ -70,6 +91,8 @@ type Record struct {
 	Deadline time.Time
 	Protocol string
 	State    string
+	// Family is ipv4 or ipv6
+	Family string
 }
 
 func (r *Record) Hash() uint64 {
@@ -81,163 +104,141 @@ func (r *Record) Hash() uint64 {
 	return h
 }
 
-func getQuadruplet(srcIPIndex int, line [][]byte) (*Quad, error) {
-	if srcIPIndex+3 >= len(line) ||
-		len(line[srcIPIndex]) <= 4 || len(line[srcIPIndex+1]) <= 4 ||
-		len(line[srcIPIndex+2]) <= 6 || len(line[srcIPIndex+3]) <= 6 {
-		return nil, errors.New("invalid conntrack quadruplet fields")
-	}
+// statefulProtocols have their state after the TTL, the others are REPLIED or UNREPLIED
+var statefulProtocols = map[string]struct{}{
+	ProtocolTCP:  {},
+	ProtocolSCTP: {},
+	ProtocolDCCP: {},
+}
+
+var knownProtocols = map[string]struct{}{
+	ProtocolTCP:     {},
+	ProtocolUDP:     {},
+	ProtocolICMP:    {},
+	ProtocolICMPv6:  {},
+	ProtocolSCTP:    {},
+	ProtocolDCCP:    {},
+	ProtocolGRE:     {},
+	ProtocolUDPLite: {},
+	ProtocolUnknown: {},
+}
+
+// tupleParser fills the track of a direction of the record
+type tupleParser struct {
+	track *Track
+	src   bool
+	dst   bool
+}
+
+func (p *tupleParser) set(key string, value []byte) error {
 	var err error
-	q := &Quad{
-		Source:          string(line[srcIPIndex][4:]),
-		SourcePort:      0,
-		Destination:     string(line[srcIPIndex+1][4:]),
-		DestinationPort: 0,
-	}
-	q.SourcePort, err = strconv.Atoi(string(line[srcIPIndex+2][6:]))
-	if err != nil {
-		return nil, err
+	switch key {
+	case "src":
+		p.track.Quad.Source, p.src = string(value), true
+	case "dst":
+		p.track.Quad.Destination, p.dst = string(value), true
+	case "sport":
+		p.track.Quad.SourcePort, err = strconv.Atoi(string(value))
+	case "dport":
+		p.track.Quad.DestinationPort, err = strconv.Atoi(string(value))
+	case "srckey":
+		p.track.Quad.SourcePort, err = parseGREKey(value)
+	case "dstkey":
+		p.track.Quad.DestinationPort, err = parseGREKey(value)
+	case "id":
+		p.track.Quad.ID, err = strconv.Atoi(string(value))
+	case "packets":
+		p.track.Packets, err = strconv.ParseFloat(string(value), 64)
+	case "bytes":
+		p.track.Bytes, err = strconv.ParseFloat(string(value), 64)
 	}
-	q.DestinationPort, err = strconv.Atoi(string(line[srcIPIndex+3][6:]))
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("invalid conntrack field %s=%s: %v", key, value, err)
 	}
-	return q, nil
+	return nil
 }
 
-func getTraffic(packets, bytes []byte) (float64, float64, error) {
-	if len(packets) <= 8 || len(bytes) <= 6 {
-		return 0, 0, errors.New("invalid conntrack traffic fields")
-	}
-	packetsCount, err := strconv.ParseFloat(string(packets[8:]), 10)
-	if err != nil {
-		return 0, 0, err
-	}
-	bytesCount, err := strconv.ParseFloat(string(bytes[6:]), 10)
-	if err != nil {
-		return 0, 0, err
-	}
-	return packetsCount, bytesCount, nil
+// parseGREKey parses the hexadecimal GRE keys like 0x1f
+func parseGREKey(value []byte) (int, error) {
+	key, err := strconv.ParseUint(string(value), 0, 16)
+	return int(key), err
+}
+
+func (p *tupleParser) complete() bool {
+	return p.src && p.dst
 }
 
+// parseRecordFromLine parses the records of /proc/net/ip_conntrack and of /proc/net/nf_conntrack, starting with the family like ipv6 10
 func parseRecordFromLine(line []byte) (*Record, error) {
 	fields := bytes.Fields(line)
-	ttl, err := strconv.Atoi(string(fields[1]))
-	if err != nil {
-		return nil, err
-	}
 	r := &Record{
-		Deadline: time.Now().Add(time.Duration(ttl) * time.Second),
-		From:     &Track{},
-		To:       &Track{},
+		From: &Track{Quad: &Quad{}},
+		To:   &Track{Quad: &Quad{}},
 	}
-	r.Protocol, fields = string(fields[0]), fields[3:]
-	switch r.Protocol {
-	case ProtocolTCP:
-		r.State = string(fields[0])
-		r.From.Quad, err = getQuadruplet(1, fields)
-		if err != nil {
-			return nil, err
-		}
-		r.From.Packets, r.From.Bytes, err = getTraffic(fields[5], fields[6])
-		if err != nil {
-			return nil, err
+	if len(fields) > 2 {
+		switch family := string(fields[0]); family {
+		case FamilyIPv4, FamilyIPv6:
+			r.Family, fields = family, fields[2:]
 		}
-		if bytes.Equal(fields[7], unRepliedBytes) {
-			r.To.Quad, err = getQuadruplet(8, fields)
-			if err != nil {
-				return nil, err
-			}
-			return r, nil
-		}
-		r.To.Quad, err = getQuadruplet(7, fields)
-		if err != nil {
-			return nil, err
-		}
-		r.To.Packets, r.To.Bytes, err = getTraffic(fields[11], fields[12])
-		if err != nil {
-			return nil, err
-		}
-	case ProtocolUDP:
-		r.From.Quad, err = getQuadruplet(0, fields)
-		if err != nil {
-			return nil, err
-		}
-		r.From.Packets, r.From.Bytes, err = getTraffic(fields[4], fields[5])
-		if err != nil {
-			return nil, err
-		}
-		if bytes.Equal(fields[6], unRepliedBytes) {
-			r.State = StateUnreplied
-			r.To.Quad, err = getQuadruplet(7, fields)
-			if err != nil {
-				return nil, err
-			}
-			return r, nil
-		}
-		r.State = StateReplied
-		r.To.Quad, err = getQuadruplet(6, fields)
-		if err != nil {
-			return nil, err
-		}
-		r.To.Packets, r.To.Bytes, err = getTraffic(fields[10], fields[11])
-		if err != nil {
-			return nil, err
-		}
-	case ProtocolICMP:
-		r.From.Quad = &Quad{
-			Source:      string(fields[0][4:]),
-			Destination: string(fields[1][4:]),
-		}
-		r.From.Packets, r.From.Bytes, err = getTraffic(fields[5], fields[6])
-		if err != nil {
-			return nil, err
-		}
-		if bytes.Equal(fields[7], unRepliedBytes) {
-			r.State = StateUnreplied
-			r.To.Quad = &Quad{
-				Source:      string(fields[8][4:]),
-				Destination: string(fields[9][4:]),
-			}
-			return r, nil
+	}
+	if len(fields) < 5 {
+		return nil, errors.New("invalid conntrack record: too few fields")
+	}
+	r.Protocol = string(fields[0])
+	if _, ok := knownProtocols[r.Protocol]; !ok {
+		return nil, fmt.Errorf("invalid protocol: %q", r.Protocol)
+	}
+	ttl, err := strconv.Atoi(string(fields[2]))
+	if err != nil {
+		return nil, err
+	}
+	r.Deadline = time.Now().Add(time.Duration(ttl) * time.Second)
+	fields = fields[3:]
+	if _, ok := statefulProtocols[r.Protocol]; ok {
+		if bytes.IndexByte(fields[0], '=') != -1 {
+			return nil, fmt.Errorf("missing %s state", r.Protocol)
 		}
-		r.State = StateReplied
-		r.To.Quad = &Quad{
-			Source:      string(fields[7][4:]),
-			Destination: string(fields[8][4:]),
+		r.State, fields = string(fields[0]), fields[1:]
+	}
+
+	unreplied := false
+	from, to := &tupleParser{track: r.From}, &tupleParser{track: r.To}
+	current := from
+	for _, field := range fields {
+		if bytes.Equal(field, unRepliedBytes) {
+			unreplied = true
+			continue
 		}
-		r.To.Packets, r.To.Bytes, err = getTraffic(fields[12], fields[13])
-		if err != nil {
-			return nil, err
+		i := bytes.IndexByte(field, '=')
+		if i <= 0 {
+			// flags like [ASSURED]
+			continue
 		}
-	case ProtocolUnknown:
-		r.From.Quad = &Quad{
-			Source:      string(fields[0][4:]),
-			Destination: string(fields[1][4:]),
+		key := string(field[:i])
+		if key == "src" && current == from && from.complete() {
+			// the reply direction starts with its source
+			current = to
 		}
-		r.From.Packets, r.From.Bytes, err = getTraffic(fields[2], fields[3])
+		err = current.set(key, field[i+1:])
 		if err != nil {
 			return nil, err
 		}
-		if bytes.Equal(fields[4], unRepliedBytes) {
-			r.State = StateUnreplied
-			r.To.Quad = &Quad{
-				Source:      string(fields[5][4:]),
-				Destination: string(fields[6][4:]),
-			}
-			return r, nil
-		}
-		r.State = StateReplied
-		r.To.Quad = &Quad{
-			Source:      string(fields[4][4:]),
-			Destination: string(fields[5][4:]),
-		}
-		r.To.Packets, r.To.Bytes, err = getTraffic(fields[6], fields[7])
-		if err != nil {
-			return nil, err
+	}
+	if !from.complete() || !to.complete() {
+		return nil, errors.New("invalid conntrack quadruplet fields")
+	}
+	if r.Family == "" {
+		r.Family = FamilyIPv4
+		if strings.Contains(r.From.Quad.Source, ":") {
+			r.Family = FamilyIPv6
 		}
-	default:
-		return nil, fmt.Errorf("invalid protocol: %q", r.Protocol)
+	}
+	if r.State != "" {
+		return r, nil
+	}
+	r.State = StateReplied
+	if unreplied {
+		r.State = StateUnreplied
 	}
 	return r, nil
 }
@@ -129,6 +129,7 @@ func TestGetConntrackRecords(t *testing.T) {
 						SourcePort:      0,
 						Destination:     "8.8.8.8",
 						DestinationPort: 0,
+						ID:              3276,
 					},
 					Bytes:   168,
 					Packets: 2,
@@ -139,6 +140,7 @@ func TestGetConntrackRecords(t *testing.T) {
 						SourcePort:      0,
 						Destination:     "78.194.244.189",
 						DestinationPort: 0,
+						ID:              3276,
 					},
 					Bytes:   168,
 					Packets: 2,
@@ -156,6 +158,7 @@ func TestGetConntrackRecords(t *testing.T) {
 						SourcePort:      0,
 						Destination:     "192.168.1.123",
 						DestinationPort: 0,
+						ID:              35924,
 					},
 					Bytes:   48,
 					Packets: 1,
@@ -166,6 +169,7 @@ func TestGetConntrackRecords(t *testing.T) {
 						SourcePort:      0,
 						Destination:     "192.168.1.1",
 						DestinationPort: 0,
+						ID:              35924,
 					},
 					Bytes:   0,
 					Packets: 0,
@@ -248,3 +252,151 @@ func TestGetConntrackRecords2(t *testing.T) {
 	require.NoError(t, err)
 	require.Len(t, r, 153)
 }
+
+func TestParseNFConntrack(t *testing.T) {
+	for name, tc := range map[string]struct {
+		line     string
+		protocol string
+		state    string
+		family   string
+		from, to Track
+	}{
+		"ipv4:tcp": {
+			line:     "ipv4     2 tcp      6 431999 ESTABLISHED src=192.168.1.10 dst=142.250.74.110 sport=50412 dport=443 src=142.250.74.110 dst=192.168.1.1 sport=443 dport=50412 [ASSURED] mark=0 zone=0 use=2",
+			protocol: ProtocolTCP,
+			state:    StateEstablished,
+			family:   FamilyIPv4,
+			from:     Track{Quad: &Quad{Source: "192.168.1.10", SourcePort: 50412, Destination: "142.250.74.110", DestinationPort: 443}},
+			to:       Track{Quad: &Quad{Source: "142.250.74.110", SourcePort: 443, Destination: "192.168.1.1", DestinationPort: 50412}},
+		},
+		"ipv4:tcp:unreplied": {
+			line:     "ipv4     2 tcp      6 118 SYN_SENT src=192.168.1.10 dst=93.184.216.34 sport=50414 dport=80 [UNREPLIED] src=93.184.216.34 dst=192.168.1.1 sport=80 dport=50414 mark=0 zone=0 use=2",
+			protocol: ProtocolTCP,
+			state:    "SYN_SENT",
+			family:   FamilyIPv4,
+			from:     Track{Quad: &Quad{Source: "192.168.1.10", SourcePort: 50414, Destination: "93.184.216.34", DestinationPort: 80}},
+			to:       Track{Quad: &Quad{Source: "93.184.216.34", SourcePort: 80, Destination: "192.168.1.1", DestinationPort: 50414}},
+		},
+		"ipv6:tcp:accounting": {
+			line:     "ipv6     10 tcp      6 299 ESTABLISHED src=2a01:e0a:1:2::10 dst=2a00:1450:4007:80c::200e sport=50413 dport=443 packets=12 bytes=2048 src=2a00:1450:4007:80c::200e dst=2a01:e0a:1:2::10 sport=443 dport=50413 packets=10 bytes=8192 [ASSURED] mark=0 zone=0 use=2",
+			protocol: ProtocolTCP,
+			state:    StateEstablished,
+			family:   FamilyIPv6,
+			from:     Track{Quad: &Quad{Source: "2a01:e0a:1:2::10", SourcePort: 50413, Destination: "2a00:1450:4007:80c::200e", DestinationPort: 443}, Packets: 12, Bytes: 2048},
+			to:       Track{Quad: &Quad{Source: "2a00:1450:4007:80c::200e", SourcePort: 443, Destination: "2a01:e0a:1:2::10", DestinationPort: 50413}, Packets: 10, Bytes: 8192},
+		},
+		"ipv4:udp": {
+			line:     "ipv4     2 udp      17 29 src=192.168.1.10 dst=192.168.1.1 sport=41234 dport=53 src=192.168.1.1 dst=192.168.1.10 sport=53 dport=41234 mark=0 zone=0 use=2",
+			protocol: ProtocolUDP,
+			state:    StateReplied,
+			family:   FamilyIPv4,
+			from:     Track{Quad: &Quad{Source: "192.168.1.10", SourcePort: 41234, Destination: "192.168.1.1", DestinationPort: 53}},
+			to:       Track{Quad: &Quad{Source: "192.168.1.1", SourcePort: 53, Destination: "192.168.1.10", DestinationPort: 41234}},
+		},
+		"ipv6:udp:unreplied": {
+			line:     "ipv6     10 udp      17 25 src=fe80::1 dst=ff02::fb sport=5353 dport=5353 [UNREPLIED] src=ff02::fb dst=fe80::1 sport=5353 dport=5353 mark=0 zone=0 use=2",
+			protocol: ProtocolUDP,
+			state:    StateUnreplied,
+			family:   FamilyIPv6,
+			from:     Track{Quad: &Quad{Source: "fe80::1", SourcePort: 5353, Destination: "ff02::fb", DestinationPort: 5353}},
+			to:       Track{Quad: &Quad{Source: "ff02::fb", SourcePort: 5353, Destination: "fe80::1", DestinationPort: 5353}},
+		},
+		"ipv4:icmp": {
+			line:     "ipv4     2 icmp     1 29 src=192.168.1.10 dst=8.8.8.8 type=8 code=0 id=1 src=8.8.8.8 dst=192.168.1.10 type=0 code=0 id=1 mark=0 zone=0 use=2",
+			protocol: ProtocolICMP,
+			state:    StateReplied,
+			family:   FamilyIPv4,
+			from:     Track{Quad: &Quad{Source: "192.168.1.10", Destination: "8.8.8.8", ID: 1}},
+			to:       Track{Quad: &Quad{Source: "8.8.8.8", Destination: "192.168.1.10", ID: 1}},
+		},
+		"ipv6:icmpv6": {
+			line:     "ipv6     10 icmpv6   58 29 src=2a01:e0a:1:2::10 dst=2001:4860:4860::8888 type=128 code=0 id=7 src=2001:4860:4860::8888 dst=2a01:e0a:1:2::10 type=129 code=0 id=7 mark=0 zone=0 use=2",
+			protocol: ProtocolICMPv6,
+			state:    StateReplied,
+			family:   FamilyIPv6,
+			from:     Track{Quad: &Quad{Source: "2a01:e0a:1:2::10", Destination: "2001:4860:4860::8888", ID: 7}},
+			to:       Track{Quad: &Quad{Source: "2001:4860:4860::8888", Destination: "2a01:e0a:1:2::10", ID: 7}},
+		},
+		"ipv4:sctp": {
+			line:     "ipv4     2 sctp     132 431999 ESTABLISHED src=192.168.1.20 dst=192.168.1.30 sport=36412 dport=38412 src=192.168.1.30 dst=192.168.1.20 sport=38412 dport=36412 [ASSURED] mark=0 zone=0 use=2",
+			protocol: ProtocolSCTP,
+			state:    StateEstablished,
+			family:   FamilyIPv4,
+			from:     Track{Quad: &Quad{Source: "192.168.1.20", SourcePort: 36412, Destination: "192.168.1.30", DestinationPort: 38412}},
+			to:       Track{Quad: &Quad{Source: "192.168.1.30", SourcePort: 38412, Destination: "192.168.1.20", DestinationPort: 36412}},
+		},
+		"ipv4:dccp": {
+			line:     "ipv4     2 dccp     33 431999 OPEN src=192.168.1.21 dst=192.168.1.31 sport=5001 dport=5002 src=192.168.1.31 dst=192.168.1.21 sport=5002 dport=5001 [ASSURED] mark=0 zone=0 use=2",
+			protocol: ProtocolDCCP,
+			state:    "OPEN",
+			family:   FamilyIPv4,
+			from:     Track{Quad: &Quad{Source: "192.168.1.21", SourcePort: 5001, Destination: "192.168.1.31", DestinationPort: 5002}},
+			to:       Track{Quad: &Quad{Source: "192.168.1.31", SourcePort: 5002, Destination: "192.168.1.21", DestinationPort: 5001}},
+		},
+		"ipv4:gre": {
+			line:     "ipv4     2 gre      47 178 timeout=180, stream_timeout=18000 src=192.168.1.40 dst=203.0.113.5 srckey=0x0 dstkey=0x0 src=203.0.113.5 dst=192.168.1.40 srckey=0x0 dstkey=0x0 [ASSURED] mark=0 zone=0 use=2",
+			protocol: ProtocolGRE,
+			state:    StateReplied,
+			family:   FamilyIPv4,
+			from:     Track{Quad: &Quad{Source: "192.168.1.40", Destination: "203.0.113.5"}},
+			to:       Track{Quad: &Quad{Source: "203.0.113.5", Destination: "192.168.1.40"}},
+		},
+		"ipv4:gre:keys": {
+			line:     "ipv4     2 gre      47 178 timeout=180, stream_timeout=18000 src=192.168.1.40 dst=203.0.113.5 srckey=0x1f dstkey=0x2a src=203.0.113.5 dst=192.168.1.40 srckey=0x2a dstkey=0x1f [ASSURED] mark=0 zone=0 use=2",
+			protocol: ProtocolGRE,
+			state:    StateReplied,
+			family:   FamilyIPv4,
+			from:     Track{Quad: &Quad{Source: "192.168.1.40", SourcePort: 31, Destination: "203.0.113.5", DestinationPort: 42}},
+			to:       Track{Quad: &Quad{Source: "203.0.113.5", SourcePort: 42, Destination: "192.168.1.40", DestinationPort: 31}},
+		},
+		"ipv4:udplite:unreplied": {
+			line:     "ipv4     2 udplite  136 29 src=192.168.1.50 dst=192.168.1.60 sport=7000 dport=7001 [UNREPLIED] src=192.168.1.60 dst=192.168.1.50 sport=7001 dport=7000 mark=0 zone=0 use=2",
+			protocol: ProtocolUDPLite,
+			state:    StateUnreplied,
+			family:   FamilyIPv4,
+			from:     Track{Quad: &Quad{Source: "192.168.1.50", SourcePort: 7000, Destination: "192.168.1.60", DestinationPort: 7001}},
+			to:       Track{Quad: &Quad{Source: "192.168.1.60", SourcePort: 7001, Destination: "192.168.1.50", DestinationPort: 7000}},
+		},
+		"ipv4:unknown": {
+			line:     "ipv4     2 unknown  2 579 src=192.168.1.1 dst=224.0.0.1 [UNREPLIED] src=224.0.0.1 dst=192.168.1.1 mark=0 zone=0 use=2",
+			protocol: ProtocolUnknown,
+			state:    StateUnreplied,
+			family:   FamilyIPv4,
+			from:     Track{Quad: &Quad{Source: "192.168.1.1", Destination: "224.0.0.1"}},
+			to:       Track{Quad: &Quad{Source: "224.0.0.1", Destination: "192.168.1.1"}},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			r, err := parseRecordFromLine([]byte(tc.line))
+			require.NoError(t, err)
+			assert.Equal(t, tc.protocol, r.Protocol)
+			assert.Equal(t, tc.state, r.State)
+			assert.Equal(t, tc.family, r.Family)
+			assert.Equal(t, tc.from, *r.From)
+			assert.Equal(t, tc.to, *r.To)
+		})
+	}
+}
+
+func TestParseConntrackInvalid(t *testing.T) {
+	for name, line := range map[string]string{
+		"empty":           "",
+		"protocol":        "ipv4     2 ospf     89 29 src=192.168.1.1 dst=224.0.0.5 src=224.0.0.5 dst=192.168.1.1 mark=0 use=2",
+		"ttl":             "udp      17 soon src=127.0.0.1 dst=127.0.0.1 sport=51251 dport=53 src=127.0.0.1 dst=127.0.0.1 sport=53 dport=51251 mark=0 use=2",
+		"missing state":   "tcp      6 3554 src=192.168.1.147 dst=64.233.167.188 sport=43338 dport=5228 src=64.233.167.188 dst=78.194.244.189 sport=5228 dport=43338 mark=0 use=2",
+		"missing reply":   "ipv4     2 udp      17 29 src=192.168.1.10 dst=192.168.1.1 sport=41234 dport=53 mark=0 zone=0 use=2",
+		"invalid port":    "ipv4     2 udp      17 29 src=192.168.1.10 dst=192.168.1.1 sport=dns dport=53 src=192.168.1.1 dst=192.168.1.10 sport=53 dport=41234 mark=0 zone=0 use=2",
+		"invalid packets": "udp      17 15 src=127.0.0.1 dst=127.0.0.1 sport=51251 dport=53 packets=one bytes=60 src=127.0.0.1 dst=127.0.0.1 sport=53 dport=51251 packets=1 bytes=74 mark=0 use=2",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseRecordFromLine([]byte(line))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestGetNFConntrackRecords(t *testing.T) {
+	r, _, err := GetConntrackRecords("fixtures/nf_conntrack.txt")
+	require.NoError(t, err)
+	require.Len(t, r, 12)
+}
@@ -0,0 +1,407 @@
+package conntrack
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/netip"
+	"time"
+
+	"github.com/JulienBalestra/dry/pkg/fnv"
+	"github.com/mdlayher/netlink"
+	"go.uber.org/zap"
+)
+
+// the ctnetlink values of linux/netfilter/nfnetlink.h and linux/netfilter/nfnetlink_conntrack.h
+const (
+	netlinkNetfilter = 12
+
+	nfnlSubsysCTNetlink = 1
+	nfnetlinkV0         = 0
+
+	ipctnlMsgCTNew    = 0
+	ipctnlMsgCTGet    = 1
+	ipctnlMsgCTDelete = 2
+
+	nfnlGroupConntrackNew     = 1
+	nfnlGroupConntrackUpdate  = 2
+	nfnlGroupConntrackDestroy = 3
+
+	ctaTupleOrig     = 1
+	ctaTupleReply    = 2
+	ctaStatus        = 3
+	ctaProtoInfo     = 4
+	ctaTimeout       = 7
+	ctaCountersOrig  = 9
+	ctaCountersReply = 10
+
+	ctaTupleIP    = 1
+	ctaTupleProto = 2
+
+	ctaIPv4Src = 1
+	ctaIPv4Dst = 2
+	ctaIPv6Src = 3
+	ctaIPv6Dst = 4
+
+	ctaProtoNum     = 1
+	ctaProtoSrcPort = 2
+	ctaProtoDstPort = 3
+	// the GRE keys are in the port attributes
+	ctaProtoICMPID   = 4
+	ctaProtoICMPv6ID = 7
+
+	ctaCountersPackets = 1
+	ctaCountersBytes   = 2
+
+	ctaProtoInfoTCP  = 1
+	ctaProtoInfoDCCP = 2
+	ctaProtoInfoSCTP = 3
+	// the state is the first attribute of the TCP, DCCP and SCTP protocol info
+	ctaProtoInfoState = 1
+
+	ipsSeenReply = 1 << 1
+
+	afInet  = 2
+	afInet6 = 10
+
+	nfgenmsgLen = 4
+)
+
+var (
+	protocolNumbers = map[uint8]string{
+		1:   ProtocolICMP,
+		6:   ProtocolTCP,
+		17:  ProtocolUDP,
+		33:  ProtocolDCCP,
+		47:  ProtocolGRE,
+		58:  ProtocolICMPv6,
+		132: ProtocolSCTP,
+		136: ProtocolUDPLite,
+	}
+
+	// the states are named like in /proc/net/nf_conntrack
+	tcpStates = []string{
+		"NONE", "SYN_SENT", "SYN_RECV", StateEstablished, "FIN_WAIT", "CLOSE_WAIT", "LAST_ACK", "TIME_WAIT", "CLOSE", "SYN_SENT2",
+	}
+	dccpStates = []string{
+		"NONE", "REQUEST", "RESPOND", "PARTOPEN", "OPEN", "CLOSEREQ", "CLOSING", "TIMEWAIT", "IGNORE", "INVALID",
+	}
+	sctpStates = []string{
+		"NONE", "CLOSED", "COOKIE_WAIT", "COOKIE_ECHOED", StateEstablished, "SHUTDOWN_SENT", "SHUTDOWN_RECD", "SHUTDOWN_ACK_SENT", "HEARTBEAT_SENT", "HEARTBEAT_ACKED",
+	}
+)
+
+type EventType string
+
+const (
+	EventNew     EventType = "new"
+	EventUpdate  EventType = "update"
+	EventDestroy EventType = "destroy"
+)
+
+// Event is a change of the conntrack table streamed by netlink
+type Event struct {
+	Type   EventType
+	Record *Record
+}
+
+// Key identifies the connection of the record across its state changes
+func (r *Record) Key() uint64 {
+	h := fnv.NewHash()
+	h = fnv.AddString(h, r.Protocol)
+	h = fnv.Add(h, r.From.Hash())
+	return h
+}
+
+func stateName(states []string, state uint8) string {
+	if int(state) < len(states) {
+		return states[state]
+	}
+	return ProtocolUnknown
+}
+
+// decodeTuple decodes a CTA_TUPLE_ORIG or CTA_TUPLE_REPLY attribute
+func decodeTuple(ad *netlink.AttributeDecoder, track *Track, protocol *string) error {
+	var src, dst bool
+	for ad.Next() {
+		switch ad.Type() {
+		case ctaTupleIP:
+			ad.Nested(func(nad *netlink.AttributeDecoder) error {
+				for nad.Next() {
+					addr, ok := netip.AddrFromSlice(nad.Bytes())
+					if !ok {
+						return fmt.Errorf("invalid conntrack address of length %d", len(nad.Bytes()))
+					}
+					switch nad.Type() {
+					case ctaIPv4Src, ctaIPv6Src:
+						track.Quad.Source, src = addr.String(), true
+					case ctaIPv4Dst, ctaIPv6Dst:
+						track.Quad.Destination, dst = addr.String(), true
+					}
+				}
+				return nil
+			})
+		case ctaTupleProto:
+			ad.Nested(func(nad *netlink.AttributeDecoder) error {
+				nad.ByteOrder = binary.BigEndian
+				for nad.Next() {
+					switch nad.Type() {
+					case ctaProtoNum:
+						p, ok := protocolNumbers[nad.Uint8()]
+						if !ok {
+							p = ProtocolUnknown
+						}
+						*protocol = p
+					case ctaProtoSrcPort:
+						track.Quad.SourcePort = int(nad.Uint16())
+					case ctaProtoDstPort:
+						track.Quad.DestinationPort = int(nad.Uint16())
+					case ctaProtoICMPID, ctaProtoICMPv6ID:
+						track.Quad.ID = int(nad.Uint16())
+					}
+				}
+				return nil
+			})
+		}
+	}
+	if err := ad.Err(); err != nil {
+		return err
+	}
+	if !src || !dst {
+		return errors.New("invalid conntrack tuple: missing address")
+	}
+	return nil
+}
+
+func decodeCounters(ad *netlink.AttributeDecoder, track *Track) error {
+	ad.ByteOrder = binary.BigEndian
+	for ad.Next() {
+		switch ad.Type() {
+		case ctaCountersPackets:
+			track.Packets = float64(ad.Uint64())
+		case ctaCountersBytes:
+			track.Bytes = float64(ad.Uint64())
+		}
+	}
+	return ad.Err()
+}
+
+func decodeProtoInfo(ad *netlink.AttributeDecoder, state *string) error {
+	for ad.Next() {
+		var states []string
+		switch ad.Type() {
+		case ctaProtoInfoTCP:
+			states = tcpStates
+		case ctaProtoInfoDCCP:
+			states = dccpStates
+		case ctaProtoInfoSCTP:
+			states = sctpStates
+		default:
+			continue
+		}
+		ad.Nested(func(nad *netlink.AttributeDecoder) error {
+			for nad.Next() {
+				if nad.Type() == ctaProtoInfoState {
+					*state = stateName(states, nad.Uint8())
+				}
+			}
+			return nil
+		})
+	}
+	return ad.Err()
+}
+
+// parseRecordFromMessage decodes the data of a ctnetlink message: the nfgenmsg header followed by the CTA attributes
+func parseRecordFromMessage(data []byte, now time.Time) (*Record, error) {
+	if len(data) < nfgenmsgLen {
+		return nil, errors.New("invalid conntrack message: too short")
+	}
+	r := &Record{
+		From:     &Track{Quad: &Quad{}},
+		To:       &Track{Quad: &Quad{}},
+		Deadline: now,
+	}
+	switch data[0] {
+	case afInet:
+		r.Family = FamilyIPv4
+	case afInet6:
+		r.Family = FamilyIPv6
+	default:
+		return nil, fmt.Errorf("invalid conntrack family: %d", data[0])
+	}
+	ad, err := netlink.NewAttributeDecoder(data[nfgenmsgLen:])
+	if err != nil {
+		return nil, err
+	}
+	ad.ByteOrder = binary.BigEndian
+
+	var from, to bool
+	var replyProtocol string
+	status := uint32(0)
+	for ad.Next() {
+		switch ad.Type() {
+		case ctaTupleOrig:
+			ad.Nested(func(nad *netlink.AttributeDecoder) error {
+				return decodeTuple(nad, r.From, &r.Protocol)
+			})
+			from = true
+		case ctaTupleReply:
+			ad.Nested(func(nad *netlink.AttributeDecoder) error {
+				return decodeTuple(nad, r.To, &replyProtocol)
+			})
+			to = true
+		case ctaStatus:
+			status = ad.Uint32()
+		case ctaTimeout:
+			r.Deadline = now.Add(time.Duration(ad.Uint32()) * time.Second)
+		case ctaCountersOrig:
+			ad.Nested(func(nad *netlink.AttributeDecoder) error {
+				return decodeCounters(nad, r.From)
+			})
+		case ctaCountersReply:
+			ad.Nested(func(nad *netlink.AttributeDecoder) error {
+				return decodeCounters(nad, r.To)
+			})
+		case ctaProtoInfo:
+			ad.Nested(func(nad *netlink.AttributeDecoder) error {
+				return decodeProtoInfo(nad, &r.State)
+			})
+		}
+	}
+	if err := ad.Err(); err != nil {
+		return nil, err
+	}
+	if !from || !to {
+		return nil, errors.New("invalid conntrack message: missing tuple")
+	}
+	if r.Protocol == "" {
+		return nil, errors.New("invalid conntrack message: missing protocol")
+	}
+	if r.State != "" {
+		return r, nil
+	}
+	// the stateless protocols and the destroy events without protocol info
+	r.State = StateUnreplied
+	if status&ipsSeenReply != 0 {
+		r.State = StateReplied
+	}
+	return r, nil
+}
+
+// parseEventFromMessage decodes a ctnetlink event, the new conntracks are created with NLM_F_CREATE and NLM_F_EXCL
+func parseEventFromMessage(m netlink.Message, now time.Time) (*Event, error) {
+	if uint16(m.Header.Type)>>8 != nfnlSubsysCTNetlink {
+		return nil, fmt.Errorf("invalid conntrack message type: %d", m.Header.Type)
+	}
+	e := &Event{}
+	switch uint16(m.Header.Type) & 0xff {
+	case ipctnlMsgCTNew:
+		e.Type = EventUpdate
+		if m.Header.Flags&(netlink.Create|netlink.Excl) != 0 {
+			e.Type = EventNew
+		}
+	case ipctnlMsgCTDelete:
+		e.Type = EventDestroy
+	default:
+		return nil, fmt.Errorf("invalid conntrack message type: %d", m.Header.Type)
+	}
+	r, err := parseRecordFromMessage(m.Data, now)
+	if err != nil {
+		return nil, err
+	}
+	e.Record = r
+	return e, nil
+}
+
+func dialNetlink(groups ...uint32) (*netlink.Conn, error) {
+	conn, err := netlink.Dial(netlinkNetfilter, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range groups {
+		err = conn.JoinGroup(group)
+		if err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// GetNetlinkRecords dumps the conntrack table with ctnetlink, like GetConntrackRecords reads it from the proc file
+func GetNetlinkRecords() (map[uint64]*Record, time.Time, error) {
+	now := time.Now()
+	closestDeadline := now.Add(time.Hour * 48)
+	records := make(map[uint64]*Record)
+
+	conn, err := dialNetlink()
+	if err != nil {
+		return records, closestDeadline, err
+	}
+	defer conn.Close()
+
+	messages, err := conn.Execute(netlink.Message{
+		Header: netlink.Header{
+			Type:  netlink.HeaderType(nfnlSubsysCTNetlink<<8 | ipctnlMsgCTGet),
+			Flags: netlink.Request | netlink.Dump,
+		},
+		// AF_UNSPEC dumps both families
+		Data: []byte{0, nfnetlinkV0, 0, 0},
+	})
+	if err != nil {
+		return records, closestDeadline, err
+	}
+	for _, m := range messages {
+		record, err := parseRecordFromMessage(m.Data, now)
+		if err != nil {
+			zap.L().Error("failed to parse conntrack message", zap.Binary("data", m.Data), zap.Error(err))
+			continue
+		}
+		records[record.Hash()] = record
+		if record.Deadline.Before(closestDeadline) {
+			closestDeadline = record.Deadline
+		}
+	}
+	return records, closestDeadline, nil
+}
+
+// WatchNetlinkEvents sends the NEW, UPDATE and DESTROY conntrack events until the context is done or the socket fails
+// the kernel drops the events of a full socket buffer, the caller must dump the table again after an error
+func WatchNetlinkEvents(ctx context.Context, events chan<- *Event) error {
+	conn, err := dialNetlink(nfnlGroupConntrackNew, nfnlGroupConntrackUpdate, nfnlGroupConntrackDestroy)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		// unblocks the receive
+		_ = conn.SetReadDeadline(time.Now())
+	}()
+	for {
+		messages, err := conn.Receive()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		now := time.Now()
+		for _, m := range messages {
+			e, err := parseEventFromMessage(m, now)
+			if err != nil {
+				zap.L().Error("failed to parse conntrack event", zap.Binary("data", m.Data), zap.Error(err))
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return nil
+			case events <- e:
+			}
+		}
+	}
+}
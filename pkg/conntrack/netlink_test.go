@@ -0,0 +1,217 @@
+package conntrack
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mdlayher/netlink"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readNetlinkFixture(t *testing.T, name string) netlink.Message {
+	b, err := os.ReadFile(filepath.Join("fixtures", "ctnetlink", name+".bin"))
+	require.NoError(t, err)
+	m := netlink.Message{}
+	require.NoError(t, m.UnmarshalBinary(b))
+	return m
+}
+
+func TestParseRecordFromMessage(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	for name, tc := range map[string]struct {
+		fixture string
+		record  *Record
+	}{
+		"tcp with accounting": {
+			fixture: "dump-ipv4-tcp",
+			record: &Record{
+				From:     &Track{Quad: &Quad{Source: "192.168.1.10", SourcePort: 50412, Destination: "142.250.74.110", DestinationPort: 443}, Packets: 12, Bytes: 2048},
+				To:       &Track{Quad: &Quad{Source: "142.250.74.110", SourcePort: 443, Destination: "192.168.1.1", DestinationPort: 50412}, Packets: 10, Bytes: 8192},
+				Deadline: now.Add(time.Second * 431999),
+				Protocol: ProtocolTCP,
+				State:    StateEstablished,
+				Family:   FamilyIPv4,
+			},
+		},
+		"sctp": {
+			fixture: "dump-ipv4-sctp",
+			record: &Record{
+				From:     &Track{Quad: &Quad{Source: "192.168.1.20", SourcePort: 36412, Destination: "192.168.1.30", DestinationPort: 38412}},
+				To:       &Track{Quad: &Quad{Source: "192.168.1.30", SourcePort: 38412, Destination: "192.168.1.20", DestinationPort: 36412}},
+				Deadline: now.Add(time.Second * 431999),
+				Protocol: ProtocolSCTP,
+				State:    StateEstablished,
+				Family:   FamilyIPv4,
+			},
+		},
+		"dccp": {
+			fixture: "dump-ipv4-dccp",
+			record: &Record{
+				From:     &Track{Quad: &Quad{Source: "192.168.1.21", SourcePort: 5001, Destination: "192.168.1.31", DestinationPort: 5002}},
+				To:       &Track{Quad: &Quad{Source: "192.168.1.31", SourcePort: 5002, Destination: "192.168.1.21", DestinationPort: 5001}},
+				Deadline: now.Add(time.Second * 431999),
+				Protocol: ProtocolDCCP,
+				State:    "OPEN",
+				Family:   FamilyIPv4,
+			},
+		},
+		"udplite": {
+			fixture: "dump-ipv4-udplite",
+			record: &Record{
+				From:     &Track{Quad: &Quad{Source: "192.168.1.50", SourcePort: 7000, Destination: "192.168.1.60", DestinationPort: 7001}},
+				To:       &Track{Quad: &Quad{Source: "192.168.1.60", SourcePort: 7001, Destination: "192.168.1.50", DestinationPort: 7000}},
+				Deadline: now.Add(time.Second * 29),
+				Protocol: ProtocolUDPLite,
+				State:    StateUnreplied,
+				Family:   FamilyIPv4,
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m := readNetlinkFixture(t, tc.fixture)
+			r, err := parseRecordFromMessage(m.Data, now)
+			require.NoError(t, err)
+			assert.Equal(t, tc.record, r)
+		})
+	}
+}
+
+func TestParseEventFromMessage(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	for name, tc := range map[string]struct {
+		fixture string
+		event   *Event
+	}{
+		"new udp ipv6": {
+			fixture: "event-new-ipv6-udp",
+			event: &Event{
+				Type: EventNew,
+				Record: &Record{
+					From:     &Track{Quad: &Quad{Source: "fe80::1", SourcePort: 5353, Destination: "ff02::fb", DestinationPort: 5353}},
+					To:       &Track{Quad: &Quad{Source: "ff02::fb", SourcePort: 5353, Destination: "fe80::1", DestinationPort: 5353}},
+					Deadline: now.Add(time.Second * 30),
+					Protocol: ProtocolUDP,
+					State:    StateUnreplied,
+					Family:   FamilyIPv6,
+				},
+			},
+		},
+		"new gre": {
+			fixture: "event-new-ipv4-gre",
+			event: &Event{
+				Type: EventNew,
+				Record: &Record{
+					From:     &Track{Quad: &Quad{Source: "192.168.1.40", Destination: "203.0.113.5"}},
+					To:       &Track{Quad: &Quad{Source: "203.0.113.5", Destination: "192.168.1.40"}},
+					Deadline: now.Add(time.Second * 30),
+					Protocol: ProtocolGRE,
+					State:    StateUnreplied,
+					Family:   FamilyIPv4,
+				},
+			},
+		},
+		"update icmp": {
+			fixture: "event-update-ipv4-icmp",
+			event: &Event{
+				Type: EventUpdate,
+				Record: &Record{
+					From:     &Track{Quad: &Quad{Source: "192.168.1.10", Destination: "8.8.8.8", ID: 1}},
+					To:       &Track{Quad: &Quad{Source: "8.8.8.8", Destination: "192.168.1.10", ID: 1}},
+					Deadline: now.Add(time.Second * 30),
+					Protocol: ProtocolICMP,
+					State:    StateReplied,
+					Family:   FamilyIPv4,
+				},
+			},
+		},
+		"update tcp ipv6": {
+			fixture: "event-update-ipv6-tcp",
+			event: &Event{
+				Type: EventUpdate,
+				Record: &Record{
+					From:     &Track{Quad: &Quad{Source: "2a01:e0a:1:2::10", SourcePort: 50413, Destination: "2a00:1450:4007:80c::200e", DestinationPort: 443}},
+					To:       &Track{Quad: &Quad{Source: "2a00:1450:4007:80c::200e", SourcePort: 443, Destination: "2a01:e0a:1:2::10", DestinationPort: 50413}},
+					Deadline: now.Add(time.Second * 120),
+					Protocol: ProtocolTCP,
+					State:    "FIN_WAIT",
+					Family:   FamilyIPv6,
+				},
+			},
+		},
+		"destroy tcp ipv6": {
+			fixture: "event-destroy-ipv6-tcp",
+			event: &Event{
+				Type: EventDestroy,
+				Record: &Record{
+					From:     &Track{Quad: &Quad{Source: "2a01:e0a:1:2::10", SourcePort: 50413, Destination: "2a00:1450:4007:80c::200e", DestinationPort: 443}, Packets: 24, Bytes: 4096},
+					To:       &Track{Quad: &Quad{Source: "2a00:1450:4007:80c::200e", SourcePort: 443, Destination: "2a01:e0a:1:2::10", DestinationPort: 50413}, Packets: 20, Bytes: 16384},
+					Deadline: now,
+					Protocol: ProtocolTCP,
+					State:    StateReplied,
+					Family:   FamilyIPv6,
+				},
+			},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			m := readNetlinkFixture(t, tc.fixture)
+			e, err := parseEventFromMessage(m, now)
+			require.NoError(t, err)
+			assert.Equal(t, tc.event, e)
+		})
+	}
+
+	// the update and the destroy events of a connection share its key
+	update, destroy := readNetlinkFixture(t, "event-update-ipv6-tcp"), readNetlinkFixture(t, "event-destroy-ipv6-tcp")
+	u, err := parseEventFromMessage(update, now)
+	require.NoError(t, err)
+	d, err := parseEventFromMessage(destroy, now)
+	require.NoError(t, err)
+	assert.Equal(t, u.Record.Key(), d.Record.Key())
+	assert.NotEqual(t, u.Record.Hash(), d.Record.Hash())
+}
+
+func TestRecordKey(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	m := readNetlinkFixture(t, "event-update-ipv4-icmp")
+	e, err := parseEventFromMessage(m, now)
+	require.NoError(t, err)
+
+	// the concurrent pings to the same destination are different connections
+	other, err := parseEventFromMessage(m, now)
+	require.NoError(t, err)
+	other.Record.From.Quad.ID, other.Record.To.Quad.ID = 2, 2
+	assert.NotEqual(t, e.Record.Key(), other.Record.Key())
+
+	// like the GRE tunnels with different keys
+	m = readNetlinkFixture(t, "event-new-ipv4-gre")
+	e, err = parseEventFromMessage(m, now)
+	require.NoError(t, err)
+	other, err = parseEventFromMessage(m, now)
+	require.NoError(t, err)
+	other.Record.From.Quad.SourcePort, other.Record.To.Quad.DestinationPort = 31, 31
+	assert.NotEqual(t, e.Record.Key(), other.Record.Key())
+}
+
+func TestParseMessageInvalid(t *testing.T) {
+	now := time.Now()
+	m := readNetlinkFixture(t, "dump-ipv4-tcp")
+	for name, data := range map[string][]byte{
+		"empty":     {},
+		"family":    append([]byte{7}, m.Data[1:]...),
+		"truncated": m.Data[:len(m.Data)-3],
+		"no tuple":  m.Data[:4],
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := parseRecordFromMessage(data, now)
+			assert.Error(t, err)
+		})
+	}
+
+	m.Header.Type = netlink.HeaderType(nfnlSubsysCTNetlink<<8 | ipctnlMsgCTGet)
+	_, err := parseEventFromMessage(m, now)
+	assert.Error(t, err)
+}
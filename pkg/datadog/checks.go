@@ -0,0 +1,165 @@
+package datadog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/JulienBalestra/monitoring/pkg/tagger"
+	"go.uber.org/zap"
+)
+
+type CheckStatus int
+
+const (
+	CheckOK CheckStatus = iota
+	CheckWarning
+	CheckCritical
+	CheckUnknown
+)
+
+const (
+	serviceChecksPath = "/api/v1/check_run"
+
+	serviceChecksChanSize = 200
+	// the oldest pending service checks are dropped once reached
+	maxPendingServiceChecks = 1000
+
+	maxServiceCheckMessageLen = 500
+)
+
+func (s CheckStatus) String() string {
+	switch s {
+	case CheckOK:
+		return "ok"
+	case CheckWarning:
+		return "warning"
+	case CheckCritical:
+		return "critical"
+	}
+	return "unknown"
+}
+
+// ServiceCheck is the payload of https://docs.datadoghq.com/api/latest/service-checks/
+type ServiceCheck struct {
+	Check     string      `json:"check"`
+	HostName  string      `json:"host_name"`
+	Status    CheckStatus `json:"status"`
+	Timestamp int64       `json:"timestamp,omitempty"`
+	Message   string      `json:"message,omitempty"`
+	Tags      []string    `json:"tags"`
+}
+
+// SubmitServiceCheck queues the service check, it's sent along with the next series
+// the service check is dropped when the queue is full
+func (c *Client) SubmitServiceCheck(sc *ServiceCheck) {
+	if sc.Timestamp == 0 {
+		sc.Timestamp = time.Now().Unix()
+	}
+	if sc.HostName == "" {
+		sc.HostName = c.conf.Host
+	}
+	// the tags follow the Datadog rules like the ones of the series
+	sc.Tags = tagger.NormaliseTags(sc.Tags)
+	if sc.Tags == nil {
+		sc.Tags = []string{}
+	}
+	sc.Message = truncate(sc.Message, maxServiceCheckMessageLen)
+	select {
+	case c.ChanServiceChecks <- sc:
+	default:
+		c.Stats.Lock()
+		c.Stats.DroppedServiceChecks++
+		c.Stats.Unlock()
+	}
+}
+
+// SendServiceChecks posts the service checks in a single request
+func (c *Client) SendServiceChecks(ctx context.Context, checks []*ServiceCheck) error {
+	if len(checks) == 0 {
+		return nil
+	}
+	var buff bytes.Buffer
+	err := json.NewEncoder(&buff).Encode(checks)
+	if err != nil {
+		return err
+	}
+	creds := c.credentials()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.serviceChecksURL, &buff)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(contentType, typeApplicationJson)
+	req.Header.Set("DD-API-KEY", creds.apiKey)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 300 {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return resp.Body.Close()
+	}
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	apiKey, err := hideKey(creds.apiKey)
+	if err != nil {
+		return &APIError{
+			StatusCode: resp.StatusCode,
+			Message:    fmt.Sprintf("failed to send service checks status code: %d: %v %s", resp.StatusCode, err, string(bodyBytes)),
+		}
+	}
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    fmt.Sprintf("failed to send service checks status code: %d API=%q %s", resp.StatusCode, apiKey, string(bodyBytes)),
+	}
+}
+
+// queueServiceCheck appends the service check to the pending ones, dropping the oldest when full
+func (c *Client) queueServiceCheck(pending []*ServiceCheck, sc *ServiceCheck) []*ServiceCheck {
+	pending = append(pending, sc)
+	if len(pending) <= maxPendingServiceChecks {
+		return pending
+	}
+	c.Stats.Lock()
+	c.Stats.DroppedServiceChecks++
+	c.Stats.Unlock()
+	return pending[1:]
+}
+
+// flushServiceChecks sends the pending service checks and returns them if they can be retried
+func (c *Client) flushServiceChecks(ctx context.Context, pending []*ServiceCheck) []*ServiceCheck {
+	if len(pending) == 0 {
+		return pending
+	}
+	zctx := zap.L().With(zap.Int("serviceChecks", len(pending)))
+	err := c.SendServiceChecks(ctx, pending)
+	if err == nil {
+		c.Stats.Lock()
+		c.Stats.SentServiceChecks += float64(len(pending))
+		c.Stats.Unlock()
+		zctx.Debug("successfully sent service checks")
+		return nil
+	}
+	c.Stats.Lock()
+	c.Stats.SentServiceChecksErrors++
+	c.Stats.Unlock()
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && !apiErr.Retryable() {
+		c.Stats.Lock()
+		c.Stats.DroppedServiceChecks += float64(len(pending))
+		c.Stats.Unlock()
+		zctx.Error("dropping rejected service checks", zap.Error(err))
+		return nil
+	}
+	zctx.Error("failed to send service checks", zap.Error(err))
+	return pending
+}
@@ -0,0 +1,81 @@
+package datadog
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeServiceChecksAPI struct {
+	mu       sync.Mutex
+	checks   []ServiceCheck
+	statuses []int
+}
+
+func (f *fakeServiceChecksAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.statuses) > 0 {
+		status := f.statuses[0]
+		f.statuses = f.statuses[1:]
+		w.WriteHeader(status)
+		return
+	}
+	var checks []ServiceCheck
+	err := json.NewDecoder(r.Body).Decode(&checks)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.checks = append(f.checks, checks...)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func TestSubmitServiceCheck(t *testing.T) {
+	c := NewClient(&Config{Host: "router"})
+	c.SubmitServiceCheck(&ServiceCheck{Check: "http.can_connect", Status: CheckCritical})
+	sc := <-c.ChanServiceChecks
+	assert.Equal(t, "router", sc.HostName)
+	assert.NotZero(t, sc.Timestamp)
+	assert.Equal(t, []string{}, sc.Tags)
+	assert.Equal(t, "critical", sc.Status.String())
+
+	c.SubmitServiceCheck(&ServiceCheck{Check: "http.can_connect", Tags: []string{"host-target:Router.LAN"}})
+	sc = <-c.ChanServiceChecks
+	assert.Equal(t, []string{"host-target:router.lan"}, sc.Tags)
+}
+
+func TestFlushServiceChecks(t *testing.T) {
+	api := &fakeServiceChecksAPI{
+		statuses: []int{http.StatusInternalServerError, http.StatusForbidden},
+	}
+	srv := httptest.NewServer(api)
+	defer srv.Close()
+	c := NewClient(&Config{Host: "router", DatadogAPIKey: "api-key-12345678"})
+	c.serviceChecksURL = srv.URL + serviceChecksPath
+
+	pending := []*ServiceCheck{
+		{Check: "ping.can_connect", Status: CheckOK, HostName: "router", Tags: []string{"target:1.1.1.1"}},
+		{Check: "collector.can_collect", Status: CheckWarning, HostName: "router", Message: "failed", Tags: []string{"collector:http"}},
+	}
+	ctx := context.Background()
+
+	// retried
+	pending = c.flushServiceChecks(ctx, pending)
+	require.Len(t, pending, 2)
+
+	// rejected
+	assert.Len(t, c.flushServiceChecks(ctx, pending), 0)
+	assert.Equal(t, float64(2), c.Stats.DroppedServiceChecks)
+	assert.Equal(t, float64(2), c.Stats.SentServiceChecksErrors)
+
+	assert.Len(t, c.flushServiceChecks(ctx, pending), 0)
+	assert.Equal(t, float64(2), c.Stats.SentServiceChecks)
+	assert.Equal(t, []ServiceCheck{*pending[0], *pending[1]}, api.checks)
+}
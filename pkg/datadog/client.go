@@ -2,18 +2,21 @@ package datadog
 
 import (
 	"bytes"
-	"compress/zlib"
 	"context"
 	"crypto/tls"
-	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/JulienBalestra/monitoring/pkg/metrics"
+	"github.com/JulienBalestra/monitoring/pkg/tagger"
+	"github.com/JulienBalestra/monitoring/pkg/tlsconfig"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
@@ -41,6 +44,11 @@ const (
 
 	MinimalSendInterval = time.Second * 5
 	DefaultSendInterval = time.Second * 60
+
+	DefaultAPIURL  = "https://api.datadoghq.com"
+	DefaultLogsURL = "https://http-intake.logs.datadoghq.com"
+
+	logsPath = "/api/v2/logs"
 )
 
 type Config struct {
@@ -48,12 +56,38 @@ type Config struct {
 	HostTags []string
 	ChanSize int
 
+	// DatadogAPIKey and DatadogAPPKey are the initial keys, see Client.SetCredentials
 	DatadogAPIKey string
 	DatadogAPPKey string
 
 	SendInterval  time.Duration
 	ClientMetrics *ClientMetrics
 	Logger        *zap.Config
+
+	// SeriesAPI is one of SeriesAPIV1, SeriesAPIV2 or SeriesAPIV2Protobuf, defaults to SeriesAPIV1
+	SeriesAPI string
+	// Origin is only sent with the v2 series API
+	Origin *Origin
+	// Metadata provides the units of the v2 series, defaults to metrics.DefaultRegistry
+	Metadata *metrics.Registry
+	// CompressionLevel of the series payloads from zlib.BestSpeed to zlib.BestCompression, defaults to DefaultCompressionLevel
+	CompressionLevel int
+	// APIURL is the base URL of the series, metadata, host tags, events and service checks APIs, defaults to DefaultAPIURL
+	APIURL string
+	// LogsURL is the base URL of the logs intake, defaults to DefaultLogsURL
+	LogsURL string
+	// Destinations are additional orgs receiving the series
+	Destinations []*Destination
+	// Archive keeps a copy of every series aggregated by the client, optional
+	Archive SeriesArchive
+	// TLSConfig of the requests, defaults to the authorities of tlsconfig.RootCAs
+	TLSConfig *tls.Config
+}
+
+// SeriesArchive receives the series aggregated since the previous flush, before they are sent
+// each series is archived once, even when its send fails and is retried
+type SeriesArchive interface {
+	Archive(series SeriesIterator) error
 }
 
 type ClientMetrics struct {
@@ -61,146 +95,176 @@ type ClientMetrics struct {
 
 	SentLogsBytes  float64
 	SentLogsErrors float64
-
-	SentSeriesBytes  float64
-	SentSeries       float64
-	SentSeriesErrors float64
+	SentLogs       float64
+	DroppedLogs    float64
+
+	SentEvents       float64
+	SentEventsErrors float64
+	DroppedEvents    float64
+
+	SentServiceChecks       float64
+	SentServiceChecksErrors float64
+	DroppedServiceChecks    float64
+
+	HostTagsUpdates float64
+	HostTagsErrors  float64
+	// HostTagsSynced is 1 when the last reconciliation succeeded
+	HostTagsSynced float64
+
+	SentSeriesBytes             float64
+	SentSeriesUncompressedBytes float64
+	SentSeries                  float64
+	SentSeriesErrors            float64
+	SentPoints                  float64
+	// LastFlushPoints are the points of the last successful flush
+	LastFlushPoints float64
+	// LastSuccessfulFlush is the time of the last successful flush, the creation of the client until the first one
+	LastSuccessfulFlush time.Time
+	// PendingSeries are the series of the aggregation store waiting for the next flush
+	PendingSeries float64
+	// DroppedSeries is incremented by the destinations when their queue is full
+	DroppedSeries float64
+	ArchiveErrors float64
+
+	KeyRotations float64
 
 	StoreAggregations float64
+
+	// Endpoints are the HTTP telemetry of each endpoint like EndpointSeries
+	Endpoints map[string]*EndpointMetrics
 }
 
 type Client struct {
 	conf *Config
 
-	httpClient                      *http.Client
-	seriesURL, hostTagsURL, logsURL string
-
-	ChanSeries chan metrics.Series
-	Stats      *ClientMetrics
+	httpClient                  *http.Client
+	hostTagsURL, logsURL        string
+	eventsURL, serviceChecksURL string
+	seriesEncoder               seriesEncoder
+	compressor                  *compressor
+	creds                       *atomic.Pointer[credentials]
+	destinations                []*destination
+
+	ChanSeries        chan metrics.Series
+	ChanEvents        chan *Event
+	ChanServiceChecks chan *ServiceCheck
+	Stats             *ClientMetrics
 }
 
 func NewClient(conf *Config) *Client {
+	clientMetrics := conf.ClientMetrics
+	if conf.ClientMetrics == nil {
+		clientMetrics = &ClientMetrics{}
+	}
+	clientMetrics.Lock()
+	if clientMetrics.LastSuccessfulFlush.IsZero() {
+		clientMetrics.LastSuccessfulFlush = time.Now()
+	}
+	clientMetrics.Unlock()
+	if conf.TLSConfig == nil {
+		conf.TLSConfig = &tls.Config{RootCAs: tlsconfig.RootCAs()}
+	}
 	httpClient := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: true, // TODO this is needed on dd-wrt, load certificate authority there
+		Transport: &telemetryTransport{
+			next: &http.Transport{
+				TLSClientConfig: conf.TLSConfig,
 			},
+			stats: clientMetrics,
 		},
 		Timeout: time.Second * 15,
 	}
-	clientMetrics := conf.ClientMetrics
-	if conf.ClientMetrics == nil {
-		clientMetrics = &ClientMetrics{}
-	}
 	if conf.SendInterval <= MinimalSendInterval {
 		conf.SendInterval = DefaultSendInterval
 	}
-	return &Client{
-		httpClient: httpClient,
-		conf:       conf,
-
-		seriesURL:   "https://api.datadoghq.com/api/v1/series?api_key=" + conf.DatadogAPIKey,
-		hostTagsURL: "https://api.datadoghq.com/api/v1/tags/hosts/" + conf.Host,
-		logsURL: "https://http-intake.logs.datadoghq.com/v1/input/" + conf.DatadogAPIKey +
-			"?hostname=" + conf.Host,
-		ChanSeries: make(chan metrics.Series, conf.ChanSize),
-
-		Stats: clientMetrics,
-	}
-}
-
-type Payload struct {
-	Series []metrics.Series `json:"series"`
-}
-
-type HostTags struct {
-	Host string   `json:"host"`
-	Tags []string `json:"tags"`
-}
-
-func (c *Client) UpdateHostTags(ctx context.Context, tags []string) error {
-	if len(tags) == 0 {
-		return nil
+	if ValidSeriesAPI(conf.SeriesAPI) != nil {
+		conf.SeriesAPI = SeriesAPIV1
 	}
-
-	// TODO find a good logger/workflow to debug this
-	zap.L().Debug("sending host tags", zap.Strings("tags", tags))
-
-	var buff bytes.Buffer
-	err := json.NewEncoder(&buff).Encode(&HostTags{
-		Host: c.conf.Host,
-		Tags: tags,
-	})
-	if err != nil {
-		return err
+	if conf.Metadata == nil {
+		conf.Metadata = metrics.DefaultRegistry
 	}
-	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.hostTagsURL, &buff)
-	if err != nil {
-		return err
+	if conf.APIURL == "" {
+		conf.APIURL = DefaultAPIURL
 	}
-	req.Header.Set(contentType, typeApplicationJson)
-	req.Header.Set("DD-API-KEY", c.conf.DatadogAPIKey)
-	req.Header.Set("DD-APPLICATION-KEY", c.conf.DatadogAPPKey)
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return err
+	conf.APIURL = strings.TrimSuffix(conf.APIURL, "/")
+	if conf.LogsURL == "" {
+		conf.LogsURL = DefaultLogsURL
 	}
+	conf.LogsURL = strings.TrimSuffix(conf.LogsURL, "/")
+	c := &Client{
+		httpClient: httpClient,
+		conf:       conf,
 
-	if resp.StatusCode < 300 {
-		// From https://golang.org/pkg/net/http/#Response:
-		// The default HTTP client's Transport may not reuse HTTP/1.x "keep-alive"
-		// TCP connections if the Body is not read to completion and closed.
-		_, _ = io.Copy(ioutil.Discard, resp.Body)
-		return resp.Body.Close()
-	}
-	bodyBytes, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
-	}
-	_ = resp.Body.Close()
-	apiKey, err := hideKey(c.conf.DatadogAPIKey)
-	if err != nil {
-		return fmt.Errorf("failed to send logs status code: %d: %v %s %q", resp.StatusCode, err, string(bodyBytes), tags)
+		seriesEncoder:     newSeriesEncoder(conf.SeriesAPI, conf.Origin, conf.Metadata),
+		compressor:        newCompressor(conf.CompressionLevel),
+		creds:             &atomic.Pointer[credentials]{},
+		hostTagsURL:       conf.APIURL + hostTagsPath + url.PathEscape(conf.Host) + "?source=" + hostTagsSource,
+		logsURL:           conf.LogsURL + logsPath,
+		eventsURL:         conf.APIURL + eventsPath,
+		serviceChecksURL:  conf.APIURL + serviceChecksPath,
+		ChanSeries:        make(chan metrics.Series, conf.ChanSize),
+		ChanEvents:        make(chan *Event, eventsChanSize),
+		ChanServiceChecks: make(chan *ServiceCheck, serviceChecksChanSize),
+
+		Stats: clientMetrics,
 	}
-	appKey, err := hideKey(c.conf.DatadogAPPKey)
-	if err != nil {
-		return fmt.Errorf("failed to send logs status code: %d: %v %s %q", resp.StatusCode, err, string(bodyBytes), tags)
+	c.creds.Store(c.newCredentials(conf.DatadogAPIKey, conf.DatadogAPPKey))
+	for _, d := range conf.Destinations {
+		c.destinations = append(c.destinations, newDestination(conf, d))
 	}
-	return fmt.Errorf("failed to send logs status code: %d APP=%q API=%q %s %q", resp.StatusCode, appKey, apiKey, string(bodyBytes), tags)
+	return c
 }
 
 func (c *Client) Run(ctx context.Context) {
 	const timeout = 5 * time.Second
 
 	store := metrics.NewAggregationStore()
+	// the series are archived once, with the flush following their aggregation, whatever the result of the sends
+	var unarchived []metrics.Series
+	var events []*Event
+	var serviceChecks []*ServiceCheck
 
 	seriesTicker := time.NewTicker(c.conf.SendInterval)
 	defer seriesTicker.Stop()
 
-	// TODO fix this
-	//hostTicker := time.NewTicker(time.Hour)
-	//defer hostTicker.Stop()
+	// the destinations are stopped once the main org is flushed
+	stopDestinations := c.runDestinations()
+	defer stopDestinations()
 
 	zap.L().Info("sending metrics periodically", zap.Duration("sendInterval", c.conf.SendInterval))
 
 	for {
 		select {
-		/*
-			case <-hostTicker.C:
-				zctx := zap.L().With(
-					zap.Strings("hostTags", c.conf.HostTags),
-				)
-				ctxTimeout, cancel := context.WithTimeout(ctx, timeout)
-				err := c.UpdateHostTags(ctxTimeout, c.conf.HostTags)
+		case <-ctx.Done():
+			for len(c.ChanSeries) > 0 {
+				s := <-c.ChanSeries
+				s.Tags = tagger.NormaliseTags(s.Tags)
+				store.Aggregate(&s)
+				unarchived = c.queueArchive(unarchived, s)
+				c.fanOut(&s)
+			}
+			unarchived = c.archive(unarchived)
+			for len(c.ChanEvents) > 0 {
+				events = c.queueEvent(events, <-c.ChanEvents)
+			}
+			if len(events) > 0 {
+				ctxTimeout, cancel := context.WithTimeout(context.TODO(), timeout)
+				events = c.flushEvents(ctxTimeout, events)
 				cancel()
-				if err != nil {
-					zctx.Error("failed to update host tags", zap.Error(err))
-					continue
+				if len(events) > 0 {
+					zap.L().Error("end of datadog client with pending events", zap.Int("events", len(events)))
 				}
-				zctx.Info("successfully updated host tags")
-		*/
-
-		case <-ctx.Done():
+			}
+			for len(c.ChanServiceChecks) > 0 {
+				serviceChecks = c.queueServiceCheck(serviceChecks, <-c.ChanServiceChecks)
+			}
+			if len(serviceChecks) > 0 {
+				ctxTimeout, cancel := context.WithTimeout(context.TODO(), timeout)
+				serviceChecks = c.flushServiceChecks(ctxTimeout, serviceChecks)
+				cancel()
+				if len(serviceChecks) > 0 {
+					zap.L().Error("end of datadog client with pending service checks", zap.Int("serviceChecks", len(serviceChecks)))
+				}
+			}
 			storeLen := store.Len()
 			if storeLen > 0 {
 				zctx := zap.L().With(
@@ -210,7 +274,7 @@ func (c *Client) Run(ctx context.Context) {
 				// TODO find something better
 				zctx.Info("sending pending series")
 				ctxTimeout, cancel := context.WithTimeout(context.TODO(), timeout)
-				err := c.SendSeries(ctxTimeout, store.Series())
+				err := c.sendSeries(ctxTimeout, storeLen, store.Each)
 				cancel()
 				if err != nil {
 					zctx.Error("end of datadog client with pending series", zap.Error(err))
@@ -221,12 +285,35 @@ func (c *Client) Run(ctx context.Context) {
 			return
 
 		case s := <-c.ChanSeries:
+			// the tags of untrusted sources like the DHCP hostnames follow the Datadog rules before the aggregation
+			s.Tags = tagger.NormaliseTags(s.Tags)
 			aggregateCount := store.Aggregate(&s)
 			c.Stats.Lock()
 			c.Stats.StoreAggregations += float64(aggregateCount)
+			c.Stats.PendingSeries = float64(store.Len())
 			c.Stats.Unlock()
+			unarchived = c.queueArchive(unarchived, s)
+			c.fanOut(&s)
+
+		case e := <-c.ChanEvents:
+			events = c.queueEvent(events, e)
+
+		case sc := <-c.ChanServiceChecks:
+			serviceChecks = c.queueServiceCheck(serviceChecks, sc)
 
 		case <-seriesTicker.C:
+			if len(events) > 0 {
+				// the events not sent in time are retried with the next series
+				ctxTimeout, cancel := context.WithTimeout(ctx, timeout)
+				events = c.flushEvents(ctxTimeout, events)
+				cancel()
+			}
+			if len(serviceChecks) > 0 {
+				ctxTimeout, cancel := context.WithTimeout(ctx, timeout)
+				serviceChecks = c.flushServiceChecks(ctxTimeout, serviceChecks)
+				cancel()
+			}
+			unarchived = c.archive(unarchived)
 			storeLen := store.Len()
 			zctx := zap.L().With(
 				zap.Int("storeLen", storeLen),
@@ -236,18 +323,22 @@ func (c *Client) Run(ctx context.Context) {
 				continue
 			}
 			ctxTimeout, cancel := context.WithTimeout(ctx, c.conf.SendInterval)
-			err := c.SendSeries(ctxTimeout, store.Series())
+			err := c.sendSeries(ctxTimeout, storeLen, store.Each)
 			cancel()
 			if err == nil {
 				zctx.Info("successfully sent series")
 				store.Reset()
+				c.Stats.Lock()
+				c.Stats.PendingSeries = 0
+				c.Stats.Unlock()
 				continue
 			}
+			gcThreshold := metrics.DatadogMetricsMaxAge()
+			gc := store.GarbageCollect(gcThreshold)
 			c.Stats.Lock()
 			c.Stats.SentSeriesErrors++
+			c.Stats.PendingSeries = float64(store.Len())
 			c.Stats.Unlock()
-			gcThreshold := metrics.DatadogMetricsMaxAge()
-			gc := store.GarbageCollect(gcThreshold)
 			zctx.Error("failed to send series",
 				zap.Error(err),
 				zap.Int("garbageCollected", gc),
@@ -257,43 +348,75 @@ func (c *Client) Run(ctx context.Context) {
 	}
 }
 
+// queueArchive keeps the aggregated series until the next flush archives them
+func (c *Client) queueArchive(unarchived []metrics.Series, s metrics.Series) []metrics.Series {
+	if c.conf.Archive == nil {
+		return unarchived
+	}
+	return append(unarchived, s)
+}
+
+// archive keeps a copy of the series aggregated since the last flush, before sending them
+// so the series dropped during an outage are kept, it returns the emptied slice
+func (c *Client) archive(unarchived []metrics.Series) []metrics.Series {
+	if len(unarchived) == 0 || c.conf.Archive == nil {
+		return unarchived
+	}
+	err := c.conf.Archive.Archive(SliceIterator(unarchived))
+	if err != nil {
+		c.Stats.Lock()
+		c.Stats.ArchiveErrors++
+		c.Stats.Unlock()
+		zap.L().Error("failed to archive series", zap.Error(err))
+	}
+	return unarchived[:0]
+}
+
+// hideKey only reveals the last 4 characters like the Datadog UI
 func hideKey(key string) (string, error) {
-	const end = "***"
+	const start = "***"
 	if key == "" {
 		return "", errors.New("invalid empty API/APP Key")
 	}
 	if len(key) < 8 {
 		return "", errors.New("invalid API/APP Key")
 	}
-	return key[:8] + end, nil
+	return start + key[len(key)-4:], nil
 }
 
 func (c *Client) SendSeries(ctx context.Context, series []metrics.Series) error {
-	if len(series) == 0 {
+	return c.sendSeries(ctx, len(series), SliceIterator(series))
+}
+
+// sendSeries streams the series from the iterator into the compressor
+func (c *Client) sendSeries(ctx context.Context, seriesLen int, series SeriesIterator) error {
+	if seriesLen == 0 {
 		return nil
 	}
 
-	var zb bytes.Buffer
-	w, err := zlib.NewWriterLevel(&zb, zlib.BestCompression)
-	if err != nil {
-		return err
+	points := 0
+	counted := func(fn func(*metrics.Series)) {
+		series(func(s *metrics.Series) {
+			points += len(s.Points)
+			fn(s)
+		})
 	}
-	err = json.NewEncoder(w).Encode(Payload{Series: series})
+	body, uncompressedLen, err := c.compressor.compress(c.seriesEncoder, counted)
 	if err != nil {
 		return err
 	}
-	err = w.Close()
-	if err != nil {
-		return err
-	}
-	bodyLen := float64(zb.Len())
+	bodyLen := float64(body.Len())
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.seriesURL, &zb)
+	creds := c.credentials()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, creds.seriesURL, body)
 	if err != nil {
+		_ = body.Close()
 		return err
 	}
-	req.Header.Set(contentType, typeApplicationJson)
+	req.ContentLength = int64(body.Len())
+	req.Header.Set(contentType, c.seriesEncoder.ContentType())
 	req.Header.Set(contentEncoding, encodingDeflate)
+	req.Header.Set("DD-API-KEY", creds.apiKey)
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return err
@@ -302,7 +425,11 @@ func (c *Client) SendSeries(ctx context.Context, series []metrics.Series) error
 		// internal self metrics/counters
 		c.Stats.Lock()
 		c.Stats.SentSeriesBytes += bodyLen
-		c.Stats.SentSeries += float64(len(series))
+		c.Stats.SentSeriesUncompressedBytes += float64(uncompressedLen)
+		c.Stats.SentSeries += float64(seriesLen)
+		c.Stats.SentPoints += float64(points)
+		c.Stats.LastFlushPoints = float64(points)
+		c.Stats.LastSuccessfulFlush = time.Now()
 		c.Stats.Unlock()
 
 		// From https://golang.org/pkg/net/http/#Response:
@@ -316,13 +443,32 @@ func (c *Client) SendSeries(ctx context.Context, series []metrics.Series) error
 		return err
 	}
 	_ = resp.Body.Close()
-	apiKey, err := hideKey(c.conf.DatadogAPIKey)
+	apiKey, err := hideKey(creds.apiKey)
 	if err != nil {
 		return fmt.Errorf("failed to send series status code: %d: %v %s", resp.StatusCode, err, string(bodyBytes))
 	}
 	return fmt.Errorf("failed to send series status code: %d API=%q %s", resp.StatusCode, apiKey, string(bodyBytes))
 }
 
+// APIError is returned when the Datadog API rejects a request
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return e.Message
+}
+
+// Retryable reports if the same request can succeed later
+func (e *APIError) Retryable() bool {
+	return e.StatusCode == http.StatusRequestTimeout ||
+		e.StatusCode == http.StatusTooManyRequests ||
+		e.StatusCode >= 500
+}
+
+// SendLogs sends a deflate compressed JSON array of log entries
+// see https://docs.datadoghq.com/api/latest/logs/#send-logs
 func (c *Client) SendLogs(ctx context.Context, buffer *bytes.Buffer) error {
 	bufferLen := buffer.Len()
 	if bufferLen == 0 {
@@ -330,13 +476,15 @@ func (c *Client) SendLogs(ctx context.Context, buffer *bytes.Buffer) error {
 	}
 
 	logsBytes := float64(bufferLen)
+	creds := c.credentials()
 	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.logsURL, buffer)
 	if err != nil {
 		return err
 	}
 
-	req.Header.Set(contentType, "text/plain")
+	req.Header.Set(contentType, typeApplicationJson)
 	req.Header.Set(contentEncoding, encodingDeflate)
+	req.Header.Set("DD-API-KEY", creds.apiKey)
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		c.Stats.Lock()
@@ -365,11 +513,17 @@ func (c *Client) SendLogs(ctx context.Context, buffer *bytes.Buffer) error {
 		return err
 	}
 	_ = resp.Body.Close()
-	apiKey, err := hideKey(c.conf.DatadogAPIKey)
+	apiKey, err := hideKey(creds.apiKey)
 	if err != nil {
-		return fmt.Errorf("failed to send logs status code: %d: %v %s", resp.StatusCode, err, string(bodyBytes))
+		return &APIError{
+			StatusCode: resp.StatusCode,
+			Message:    fmt.Sprintf("failed to send logs status code: %d: %v %s", resp.StatusCode, err, string(bodyBytes)),
+		}
+	}
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    fmt.Sprintf("failed to send logs status code: %d API=%q %s", resp.StatusCode, apiKey, string(bodyBytes)),
 	}
-	return fmt.Errorf("failed to send logs status code: %d API=%q %s", resp.StatusCode, apiKey, string(bodyBytes))
 }
 
 func (c *Client) MetricClientUp(host string, tags ...string) {
@@ -388,7 +542,7 @@ func (c *Client) MetricClientUp(host string, tags ...string) {
 }
 
 func (c *Client) MetricClientShutdown(ctx context.Context, host string, tags ...string) error {
-	return c.SendSeries(ctx, []metrics.Series{
+	series := []metrics.Series{
 		{
 			Metric: "client.shutdown",
 			Type:   metrics.TypeGauge,
@@ -401,5 +555,8 @@ func (c *Client) MetricClientShutdown(ctx context.Context, host string, tags ...
 			Host: host,
 			Tags: tags,
 		},
-	})
+	}
+	// sent without the aggregation store
+	c.archive(series)
+	return c.SendSeries(ctx, series)
 }
@@ -0,0 +1,185 @@
+package datadog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultKeyFilesCheckInterval = time.Second * 30
+
+	validatePath = "/api/v1/validate"
+)
+
+// credentials are swapped atomically, the requests load them once
+type credentials struct {
+	apiKey    string
+	appKey    string
+	seriesURL string
+}
+
+func (c *Client) newCredentials(apiKey, appKey string) *credentials {
+	seriesURL := c.conf.APIURL + seriesPath(c.conf.SeriesAPI)
+	if c.conf.SeriesAPI == SeriesAPIV1 {
+		seriesURL += "?api_key=" + apiKey
+	}
+	return &credentials{
+		apiKey:    apiKey,
+		appKey:    appKey,
+		seriesURL: seriesURL,
+	}
+}
+
+func (c *Client) credentials() *credentials {
+	return c.creds.Load()
+}
+
+// SetCredentials replaces the keys of the next series, logs, events, service checks, host tags and metadata requests
+// an empty key keeps the current one
+func (c *Client) SetCredentials(apiKey, appKey string) {
+	current := c.credentials()
+	if apiKey == "" {
+		apiKey = current.apiKey
+	}
+	if appKey == "" {
+		appKey = current.appKey
+	}
+	c.creds.Store(c.newCredentials(apiKey, appKey))
+}
+
+// ValidateAPIKey checks the API key with https://docs.datadoghq.com/api/latest/authentication/#validate-api-key
+func (c *Client) ValidateAPIKey(ctx context.Context) error {
+	creds := c.credentials()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.conf.APIURL+validatePath, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("DD-API-KEY", creds.apiKey)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	apiKey, err := hideKey(creds.apiKey)
+	if err != nil {
+		apiKey = err.Error()
+	}
+	if resp.StatusCode >= 300 {
+		return &APIError{
+			StatusCode: resp.StatusCode,
+			Message:    fmt.Sprintf("failed to validate API key status code: %d API=%q %s", resp.StatusCode, apiKey, string(bodyBytes)),
+		}
+	}
+	valid := struct {
+		Valid bool `json:"valid"`
+	}{}
+	err = json.Unmarshal(bodyBytes, &valid)
+	if err != nil {
+		return err
+	}
+	if !valid.Valid {
+		return &APIError{
+			StatusCode: http.StatusForbidden,
+			Message:    fmt.Sprintf("invalid API key API=%q", apiKey),
+		}
+	}
+	return nil
+}
+
+// ValidateKeys checks the API key and the APP key when set, the APP key is checked by reading the host tags
+func (c *Client) ValidateKeys(ctx context.Context) error {
+	err := c.ValidateAPIKey(ctx)
+	if err != nil {
+		return err
+	}
+	if c.credentials().appKey == "" {
+		return nil
+	}
+	_, err = c.GetHostTags(ctx)
+	return err
+}
+
+// ReadKeyFile returns the trimmed content of the file
+func ReadKeyFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	key := strings.TrimSpace(string(b))
+	if key == "" {
+		return "", fmt.Errorf("empty key file %s", path)
+	}
+	return key, nil
+}
+
+// reloadKeyFiles swaps the credentials when the content of a key file changed
+func (c *Client) reloadKeyFiles(apiKeyFile, appKeyFile string) (bool, error) {
+	current := c.credentials()
+	apiKey, appKey := current.apiKey, current.appKey
+	var err error
+	if apiKeyFile != "" {
+		apiKey, err = ReadKeyFile(apiKeyFile)
+		if err != nil {
+			return false, err
+		}
+	}
+	if appKeyFile != "" {
+		appKey, err = ReadKeyFile(appKeyFile)
+		if err != nil {
+			return false, err
+		}
+	}
+	if apiKey == current.apiKey && appKey == current.appKey {
+		return false, nil
+	}
+	c.SetCredentials(apiKey, appKey)
+	c.Stats.Lock()
+	c.Stats.KeyRotations++
+	c.Stats.Unlock()
+	return true, nil
+}
+
+// RunKeyFilesWatch re-reads the key files every interval until the context is done
+// the current keys are kept when a file can't be read
+func (c *Client) RunKeyFilesWatch(ctx context.Context, apiKeyFile, appKeyFile string, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultKeyFilesCheckInterval
+	}
+	zctx := zap.L().With(
+		zap.String("apiKeyFile", apiKeyFile),
+		zap.String("appKeyFile", appKeyFile),
+		zap.Duration("keyFilesCheckInterval", interval),
+	)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			rotated, err := c.reloadKeyFiles(apiKeyFile, appKeyFile)
+			if err != nil {
+				zctx.Error("failed to read key files, keeping the current keys", zap.Error(err))
+				continue
+			}
+			if !rotated {
+				continue
+			}
+			creds := c.credentials()
+			apiKey, _ := hideKey(creds.apiKey)
+			appKey, _ := hideKey(creds.appKey)
+			zctx.Info("rotated keys", zap.String("apiKey", apiKey), zap.String("appKey", appKey))
+		}
+	}
+}
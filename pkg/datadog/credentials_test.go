@@ -0,0 +1,111 @@
+package datadog
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateKeys(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case validatePath:
+			if r.Header.Get("DD-API-KEY") != "valid-api-key" {
+				w.WriteHeader(http.StatusForbidden)
+				_, _ = w.Write([]byte(`{"errors":["Forbidden"]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"valid":true}`))
+		default:
+			if r.Header.Get("DD-APPLICATION-KEY") != "valid-app-key" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			_, _ = w.Write([]byte(`{"tags":[]}`))
+		}
+	}))
+	defer srv.Close()
+
+	for name, tc := range map[string]struct {
+		apiKey, appKey string
+		statusCode     int
+	}{
+		"valid": {
+			apiKey: "valid-api-key",
+			appKey: "valid-app-key",
+		},
+		"valid without APP key": {
+			apiKey: "valid-api-key",
+		},
+		"invalid API key": {
+			apiKey:     "invalid-api-key",
+			appKey:     "valid-app-key",
+			statusCode: http.StatusForbidden,
+		},
+		"invalid APP key": {
+			apiKey:     "valid-api-key",
+			appKey:     "invalid-app-key",
+			statusCode: http.StatusForbidden,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c := NewClient(&Config{
+				DatadogAPIKey: tc.apiKey,
+				DatadogAPPKey: tc.appKey,
+				Host:          "router",
+				APIURL:        srv.URL,
+			})
+			err := c.ValidateKeys(context.Background())
+			if tc.statusCode == 0 {
+				require.NoError(t, err)
+				return
+			}
+			apiErr := &APIError{}
+			require.True(t, errors.As(err, &apiErr), err)
+			assert.Equal(t, tc.statusCode, apiErr.StatusCode)
+			assert.False(t, apiErr.Retryable())
+			assert.NotContains(t, apiErr.Error(), tc.apiKey)
+		})
+	}
+}
+
+func TestReloadKeyFiles(t *testing.T) {
+	dir := t.TempDir()
+	apiKeyFile, appKeyFile := filepath.Join(dir, "api-key"), filepath.Join(dir, "app-key")
+	require.NoError(t, os.WriteFile(apiKeyFile, []byte("api-key-1\n"), 0600))
+	require.NoError(t, os.WriteFile(appKeyFile, []byte("app-key-1\n"), 0600))
+
+	c := NewClient(&Config{
+		DatadogAPIKey: "api-key-1",
+		DatadogAPPKey: "app-key-1",
+		SeriesAPI:     SeriesAPIV1,
+	})
+	rotated, err := c.reloadKeyFiles(apiKeyFile, appKeyFile)
+	require.NoError(t, err)
+	assert.False(t, rotated)
+
+	require.NoError(t, os.WriteFile(apiKeyFile, []byte("api-key-2\n"), 0600))
+	rotated, err = c.reloadKeyFiles(apiKeyFile, appKeyFile)
+	require.NoError(t, err)
+	assert.True(t, rotated)
+	creds := c.credentials()
+	assert.Equal(t, "api-key-2", creds.apiKey)
+	assert.Equal(t, "app-key-1", creds.appKey)
+	assert.Equal(t, DefaultAPIURL+seriesPath(SeriesAPIV1)+"?api_key=api-key-2", creds.seriesURL)
+	assert.Equal(t, 1.0, c.Stats.KeyRotations)
+
+	// the current keys are kept
+	require.NoError(t, os.WriteFile(apiKeyFile, []byte("\n"), 0600))
+	_, err = c.reloadKeyFiles(apiKeyFile, appKeyFile)
+	assert.Error(t, err)
+	_, err = c.reloadKeyFiles(filepath.Join(dir, "missing"), "")
+	assert.Error(t, err)
+	assert.Equal(t, "api-key-2", c.credentials().apiKey)
+}
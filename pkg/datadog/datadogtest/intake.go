@@ -0,0 +1,501 @@
+// Package datadogtest provides an in-process fake of the Datadog intake to test the datadog.Client end to end.
+package datadogtest
+
+import (
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JulienBalestra/monitoring/pkg/datadog"
+	"github.com/JulienBalestra/monitoring/pkg/metrics"
+)
+
+const (
+	SeriesV1Path      = "/api/v1/series"
+	SeriesV2Path      = "/api/v2/series"
+	LogsPath          = "/api/v2/logs"
+	HostTagsPath      = "/api/v1/tags/hosts/"
+	MetricsPath       = "/api/v1/metrics/"
+	EventsPath        = "/api/v1/events"
+	ServiceChecksPath = "/api/v1/check_run"
+	ValidatePath      = "/api/v1/validate"
+)
+
+// Request is a request received by the Intake, its body is decompressed
+type Request struct {
+	Method string
+	Path   string
+	Query  url.Values
+	Header http.Header
+	Body   []byte
+}
+
+type failure struct {
+	status int
+	times  int
+}
+
+// Intake records the requests of a datadog.Client and serves them like the Datadog APIs
+// the series, logs, host tags, metric metadata, events, service checks and key validation endpoints are implemented
+type Intake struct {
+	mu *sync.RWMutex
+
+	server *httptest.Server
+
+	requests      []*Request
+	series        []metrics.Series
+	logs          []map[string]interface{}
+	hostTags      map[string][]string
+	metadata      map[string]*datadog.MetricMetadata
+	events        []*datadog.Event
+	serviceChecks []*datadog.ServiceCheck
+
+	failures map[string]*failure
+	latency  time.Duration
+
+	apiKey, appKey string
+}
+
+// NewIntake starts an Intake, it must be closed
+func NewIntake() *Intake {
+	i := &Intake{
+		mu:       &sync.RWMutex{},
+		hostTags: make(map[string][]string),
+		metadata: make(map[string]*datadog.MetricMetadata),
+		failures: make(map[string]*failure),
+	}
+	i.server = httptest.NewServer(http.HandlerFunc(i.serveHTTP))
+	return i
+}
+
+func (i *Intake) Close() {
+	i.server.Close()
+}
+
+func (i *Intake) URL() string {
+	return i.server.URL
+}
+
+// Configure points the API and logs URLs of the client config to the Intake
+func (i *Intake) Configure(conf *datadog.Config) *datadog.Config {
+	conf.APIURL = i.server.URL
+	conf.LogsURL = i.server.URL
+	return conf
+}
+
+// Fail replies the status code to the next requests of the path, the path prefix is matched
+// a negative times fails every request
+func (i *Intake) Fail(path string, status, times int) {
+	i.mu.Lock()
+	i.failures[path] = &failure{status: status, times: times}
+	i.mu.Unlock()
+}
+
+// SetKeys restricts the accepted API and APP keys, an empty key accepts any non-empty key
+func (i *Intake) SetKeys(apiKey, appKey string) {
+	i.mu.Lock()
+	i.apiKey, i.appKey = apiKey, appKey
+	i.mu.Unlock()
+}
+
+func (i *Intake) validKey(key, expected string) bool {
+	if key == "" {
+		return false
+	}
+	return expected == "" || key == expected
+}
+
+// SetLatency delays every response
+func (i *Intake) SetLatency(latency time.Duration) {
+	i.mu.Lock()
+	i.latency = latency
+	i.mu.Unlock()
+}
+
+func (i *Intake) failure(path string) int {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for prefix, f := range i.failures {
+		if !strings.HasPrefix(path, prefix) || f.times == 0 {
+			continue
+		}
+		f.times--
+		return f.status
+	}
+	return 0
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	var body io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "deflate":
+		zr, err := zlib.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		body = zr
+	case "":
+	default:
+		return nil, fmt.Errorf("unsupported encoding %q", r.Header.Get("Content-Encoding"))
+	}
+	return ioutil.ReadAll(body)
+}
+
+func (i *Intake) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	i.mu.RLock()
+	latency, apiKey, appKey := i.latency, i.apiKey, i.appKey
+	i.mu.RUnlock()
+	if latency > 0 {
+		select {
+		case <-time.After(latency):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req := &Request{
+		Method: r.Method,
+		Path:   r.URL.Path,
+		Query:  r.URL.Query(),
+		Header: r.Header.Clone(),
+		Body:   body,
+	}
+	i.mu.Lock()
+	i.requests = append(i.requests, req)
+	i.mu.Unlock()
+
+	key := r.Header.Get("DD-API-KEY")
+	if key == "" {
+		key = r.URL.Query().Get("api_key")
+	}
+	needsAPPKey := strings.HasPrefix(r.URL.Path, HostTagsPath) || strings.HasPrefix(r.URL.Path, MetricsPath)
+	if !i.validKey(key, apiKey) || (needsAPPKey && !i.validKey(r.Header.Get("DD-APPLICATION-KEY"), appKey)) {
+		http.Error(w, `{"errors":["Forbidden"]}`, http.StatusForbidden)
+		return
+	}
+	if status := i.failure(r.URL.Path); status != 0 {
+		http.Error(w, `{"errors":["injected failure"]}`, status)
+		return
+	}
+
+	switch {
+	case r.URL.Path == ValidatePath:
+		_, _ = w.Write([]byte(`{"valid":true}`))
+		return
+	case r.URL.Path == SeriesV1Path || r.URL.Path == SeriesV2Path:
+		err = i.handleSeries(r, body)
+	case r.URL.Path == LogsPath:
+		err = i.handleLogs(body)
+	case r.URL.Path == EventsPath:
+		err = i.handleEvent(body)
+	case r.URL.Path == ServiceChecksPath:
+		err = i.handleServiceChecks(body)
+	case strings.HasPrefix(r.URL.Path, HostTagsPath):
+		// replies itself
+		err = i.handleHostTags(w, r, strings.TrimPrefix(r.URL.Path, HostTagsPath), body)
+		if err == nil {
+			return
+		}
+	case strings.HasPrefix(r.URL.Path, MetricsPath):
+		// replies itself
+		err = i.handleMetadata(w, r, strings.TrimPrefix(r.URL.Path, MetricsPath), body)
+		if err == nil {
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (i *Intake) handleSeries(r *http.Request, body []byte) error {
+	var series []metrics.Series
+	var err error
+	switch {
+	case r.URL.Path == SeriesV1Path:
+		payload := struct {
+			Series []metrics.Series `json:"series"`
+		}{}
+		err = json.Unmarshal(body, &payload)
+		series = payload.Series
+	case r.Header.Get("Content-Type") == "application/x-protobuf":
+		series, err = decodeProtobufSeries(body)
+	default:
+		series, err = decodeV2Series(body)
+	}
+	if err != nil {
+		return err
+	}
+	i.mu.Lock()
+	i.series = append(i.series, series...)
+	i.mu.Unlock()
+	return nil
+}
+
+func (i *Intake) handleLogs(body []byte) error {
+	var logs []map[string]interface{}
+	err := json.Unmarshal(body, &logs)
+	if err != nil {
+		return err
+	}
+	i.mu.Lock()
+	i.logs = append(i.logs, logs...)
+	i.mu.Unlock()
+	return nil
+}
+
+func (i *Intake) handleEvent(body []byte) error {
+	e := &datadog.Event{}
+	err := json.Unmarshal(body, e)
+	if err != nil {
+		return err
+	}
+	if e.Title == "" {
+		return fmt.Errorf("missing event title")
+	}
+	i.mu.Lock()
+	i.events = append(i.events, e)
+	i.mu.Unlock()
+	return nil
+}
+
+func (i *Intake) handleServiceChecks(body []byte) error {
+	var checks []*datadog.ServiceCheck
+	err := json.Unmarshal(body, &checks)
+	if err != nil {
+		return err
+	}
+	i.mu.Lock()
+	i.serviceChecks = append(i.serviceChecks, checks...)
+	i.mu.Unlock()
+	return nil
+}
+
+func (i *Intake) handleHostTags(w http.ResponseWriter, r *http.Request, host string, body []byte) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		tags, ok := i.hostTags[host]
+		if !ok {
+			http.NotFound(w, r)
+			return nil
+		}
+		return json.NewEncoder(w).Encode(&datadog.HostTags{Host: host, Tags: tags})
+	case http.MethodPut, http.MethodPost:
+		h := &datadog.HostTags{}
+		err := json.Unmarshal(body, h)
+		if err != nil {
+			return err
+		}
+		i.hostTags[host] = h.Tags
+		w.WriteHeader(http.StatusCreated)
+		return json.NewEncoder(w).Encode(h)
+	case http.MethodDelete:
+		delete(i.hostTags, host)
+		w.WriteHeader(http.StatusNoContent)
+		return nil
+	}
+	return fmt.Errorf("unsupported method %s", r.Method)
+}
+
+func (i *Intake) handleMetadata(w http.ResponseWriter, r *http.Request, name string, body []byte) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	switch r.Method {
+	case http.MethodGet:
+		m, ok := i.metadata[name]
+		if !ok {
+			http.NotFound(w, r)
+			return nil
+		}
+		return json.NewEncoder(w).Encode(m)
+	case http.MethodPut:
+		m := &datadog.MetricMetadata{}
+		err := json.Unmarshal(body, m)
+		if err != nil {
+			return err
+		}
+		i.metadata[name] = m
+		return json.NewEncoder(w).Encode(m)
+	}
+	return fmt.Errorf("unsupported method %s", r.Method)
+}
+
+// Requests returns the received requests whose path has the prefix
+func (i *Intake) Requests(prefix string) []*Request {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	var requests []*Request
+	for _, r := range i.requests {
+		if strings.HasPrefix(r.Path, prefix) {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+func hasTags(tags []string, expected ...string) bool {
+	set := make(map[string]struct{}, len(tags))
+	for _, t := range tags {
+		set[t] = struct{}{}
+	}
+	for _, t := range expected {
+		if _, ok := set[t]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Series returns the received series of the metric having all the tags
+// an empty metric matches all the series
+func (i *Intake) Series(metric string, tags ...string) []metrics.Series {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	var series []metrics.Series
+	for _, s := range i.series {
+		if metric != "" && s.Metric != metric {
+			continue
+		}
+		if hasTags(s.Tags, tags...) {
+			series = append(series, s)
+		}
+	}
+	return series
+}
+
+// Logs returns the received log entries
+func (i *Intake) Logs() []map[string]interface{} {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return append([]map[string]interface{}(nil), i.logs...)
+}
+
+// HostTags returns the tags of the host, nil if none
+func (i *Intake) HostTags(host string) []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return append([]string(nil), i.hostTags[host]...)
+}
+
+// SetHostTags sets the current tags of the host, like tags already applied by a previous run
+func (i *Intake) SetHostTags(host string, tags ...string) {
+	i.mu.Lock()
+	i.hostTags[host] = tags
+	i.mu.Unlock()
+}
+
+// MetricMetadata returns the metadata of the metric, nil if none
+func (i *Intake) MetricMetadata(name string) *datadog.MetricMetadata {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	return i.metadata[name]
+}
+
+// SetMetricMetadata marks the metric as known with the given metadata
+func (i *Intake) SetMetricMetadata(name string, m *datadog.MetricMetadata) {
+	i.mu.Lock()
+	i.metadata[name] = m
+	i.mu.Unlock()
+}
+
+// Events returns the received events having all the tags
+func (i *Intake) Events(tags ...string) []*datadog.Event {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	var events []*datadog.Event
+	for _, e := range i.events {
+		if hasTags(e.Tags, tags...) {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// ServiceChecks returns the received service checks named check having all the tags
+func (i *Intake) ServiceChecks(check string, tags ...string) []*datadog.ServiceCheck {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	var checks []*datadog.ServiceCheck
+	for _, sc := range i.serviceChecks {
+		if sc.Check == check && hasTags(sc.Tags, tags...) {
+			checks = append(checks, sc)
+		}
+	}
+	return checks
+}
+
+// AssertMetric fails the test if no series of the metric with all the tags was received
+func (i *Intake) AssertMetric(t testing.TB, metric string, tags ...string) bool {
+	t.Helper()
+	if len(i.Series(metric, tags...)) > 0 {
+		return true
+	}
+	t.Errorf("no series %q with tags %q received, got metrics %q", metric, tags, i.metricNames())
+	return false
+}
+
+// AssertServiceCheck fails the test if the latest status of the check with all the tags isn't the expected one
+func (i *Intake) AssertServiceCheck(t testing.TB, check string, status datadog.CheckStatus, tags ...string) bool {
+	t.Helper()
+	checks := i.ServiceChecks(check, tags...)
+	if len(checks) == 0 {
+		t.Errorf("no service check %q with tags %q received", check, tags)
+		return false
+	}
+	if latest := checks[len(checks)-1].Status; latest != status {
+		t.Errorf("service check %q with tags %q is %s, expected %s", check, tags, latest, status)
+		return false
+	}
+	return true
+}
+
+// WaitFor polls the condition until true or the timeout
+func (i *Intake) WaitFor(t testing.TB, timeout time.Duration, condition func() bool) bool {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Errorf("condition not met after %s", timeout)
+			return false
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	return true
+}
+
+func (i *Intake) metricNames() []string {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+	seen := make(map[string]struct{})
+	var names []string
+	for _, s := range i.series {
+		if _, ok := seen[s.Metric]; ok {
+			continue
+		}
+		seen[s.Metric] = struct{}{}
+		names = append(names, s.Metric)
+	}
+	return names
+}
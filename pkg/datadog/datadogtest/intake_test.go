@@ -0,0 +1,242 @@
+package datadogtest
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/JulienBalestra/monitoring/pkg/datadog"
+	"github.com/JulienBalestra/monitoring/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newClient(intake *Intake, seriesAPI string) *datadog.Client {
+	return datadog.NewClient(intake.Configure(&datadog.Config{
+		Host:          "router",
+		DatadogAPIKey: "api-key-12345678",
+		DatadogAPPKey: "app-key-12345678",
+		SeriesAPI:     seriesAPI,
+		ClientMetrics: &datadog.ClientMetrics{},
+	}))
+}
+
+func TestIntakeSeries(t *testing.T) {
+	for _, api := range []string{datadog.SeriesAPIV1, datadog.SeriesAPIV2, datadog.SeriesAPIV2Protobuf} {
+		t.Run(api, func(t *testing.T) {
+			intake := NewIntake()
+			defer intake.Close()
+
+			c := newClient(intake, api)
+			err := c.SendSeries(context.Background(), []metrics.Series{
+				{
+					Metric: "network.arp",
+					Points: [][]float64{{1600000000, 1.5}},
+					Type:   metrics.TypeGauge,
+					Host:   "router",
+					Device: "eth0",
+					Tags:   []string{"collector:network-arp", "mac:aa:bb:cc:dd:ee:ff"},
+				},
+				{
+					Metric:   "network.bytes",
+					Points:   [][]float64{{1600000000, 42}},
+					Type:     metrics.TypeCount,
+					Interval: 15,
+					Host:     "router",
+					Tags:     []string{"collector:network"},
+				},
+			})
+			require.NoError(t, err)
+
+			intake.AssertMetric(t, "network.arp", "mac:aa:bb:cc:dd:ee:ff")
+			series := intake.Series("network.arp")
+			require.Len(t, series, 1)
+			assert.Equal(t, "router", series[0].Host)
+			assert.Equal(t, "eth0", series[0].Device)
+			assert.Equal(t, metrics.TypeGauge, series[0].Type)
+			assert.Equal(t, [][]float64{{1600000000, 1.5}}, series[0].Points)
+
+			series = intake.Series("network.bytes")
+			require.Len(t, series, 1)
+			assert.Equal(t, metrics.TypeCount, series[0].Type)
+			assert.Equal(t, float64(15), series[0].Interval)
+			assert.Len(t, intake.Series(""), 2)
+			assert.Len(t, intake.Series("network.arp", "mac:00:00:00:00:00:00"), 0)
+		})
+	}
+}
+
+func TestIntakeFailure(t *testing.T) {
+	intake := NewIntake()
+	defer intake.Close()
+
+	c := newClient(intake, datadog.SeriesAPIV2)
+	series := []metrics.Series{{Metric: "up", Points: [][]float64{{1600000000, 1}}, Type: metrics.TypeGauge}}
+
+	intake.Fail(SeriesV2Path, http.StatusServiceUnavailable, 1)
+	assert.Error(t, c.SendSeries(context.Background(), series))
+	assert.Len(t, intake.Series("up"), 0)
+	assert.NoError(t, c.SendSeries(context.Background(), series))
+	intake.AssertMetric(t, "up")
+	assert.Len(t, intake.Requests(SeriesV2Path), 2)
+
+	intake.SetLatency(time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*50)
+	defer cancel()
+	assert.Error(t, c.SendSeries(ctx, series))
+}
+
+func TestIntakeForbidden(t *testing.T) {
+	intake := NewIntake()
+	defer intake.Close()
+
+	c := datadog.NewClient(intake.Configure(&datadog.Config{Host: "router", SeriesAPI: datadog.SeriesAPIV2}))
+	err := c.SendEvent(context.Background(), &datadog.Event{Title: "forbidden"})
+	apiErr := &datadog.APIError{}
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+	assert.Len(t, intake.Events(), 0)
+}
+
+func TestIntakeLogs(t *testing.T) {
+	intake := NewIntake()
+	defer intake.Close()
+
+	b := &bytes.Buffer{}
+	zw := zlib.NewWriter(b)
+	_, err := zw.Write([]byte(`[{"message":"started","service":"monitoring"}]`))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	c := newClient(intake, datadog.SeriesAPIV1)
+	require.NoError(t, c.SendLogs(context.Background(), b))
+	logs := intake.Logs()
+	require.Len(t, logs, 1)
+	assert.Equal(t, "started", logs[0]["message"])
+}
+
+func TestIntakeHostTags(t *testing.T) {
+	intake := NewIntake()
+	defer intake.Close()
+
+	c := newClient(intake, datadog.SeriesAPIV1)
+	ctx := context.Background()
+	intake.SetHostTags("router", "role:old")
+
+	updated, err := c.ReconcileHostTags(ctx, []string{"role:router"})
+	require.NoError(t, err)
+	assert.True(t, updated)
+	assert.Equal(t, []string{"role:router"}, intake.HostTags("router"))
+
+	updated, err = c.ReconcileHostTags(ctx, []string{"role:router"})
+	require.NoError(t, err)
+	assert.False(t, updated)
+
+	_, err = c.ReconcileHostTags(ctx, nil)
+	require.NoError(t, err)
+	assert.Nil(t, intake.HostTags("router"))
+}
+
+func TestIntakeMetadata(t *testing.T) {
+	intake := NewIntake()
+	defer intake.Close()
+
+	c := newClient(intake, datadog.SeriesAPIV1)
+	ctx := context.Background()
+	require.NoError(t, c.UpdateMetricMetadata(ctx, "network.arp", &datadog.MetricMetadata{Type: metrics.TypeGauge, Description: "arp entries"}))
+	m, err := c.GetMetricMetadata(ctx, "network.arp")
+	require.NoError(t, err)
+	assert.Equal(t, "arp entries", m.Description)
+	assert.Equal(t, m, intake.MetricMetadata("network.arp"))
+}
+
+func TestIntakeEventsAndServiceChecks(t *testing.T) {
+	intake := NewIntake()
+	defer intake.Close()
+
+	c := newClient(intake, datadog.SeriesAPIV1)
+	ctx := context.Background()
+	require.NoError(t, c.SendEvent(ctx, &datadog.Event{Title: "new lease", Tags: []string{"collector:dnsmasq"}}))
+	assert.Len(t, intake.Events("collector:dnsmasq"), 1)
+	assert.Len(t, intake.Events("collector:wireguard"), 0)
+
+	require.NoError(t, c.SendServiceChecks(ctx, []*datadog.ServiceCheck{
+		{Check: "ping.can_connect", Status: datadog.CheckCritical, HostName: "router", Tags: []string{"target:1.1.1.1"}},
+		{Check: "ping.can_connect", Status: datadog.CheckOK, HostName: "router", Tags: []string{"target:1.1.1.1"}},
+	}))
+	intake.AssertServiceCheck(t, "ping.can_connect", datadog.CheckOK, "target:1.1.1.1")
+	assert.Len(t, intake.ServiceChecks("ping.can_connect"), 2)
+}
+
+func TestIntakeDestinations(t *testing.T) {
+	main, team, down := NewIntake(), NewIntake(), NewIntake()
+	defer main.Close()
+	defer team.Close()
+	defer down.Close()
+	down.Fail(SeriesV2Path, http.StatusServiceUnavailable, -1)
+
+	c := datadog.NewClient(main.Configure(&datadog.Config{
+		Host:          "router",
+		DatadogAPIKey: "main-12345678",
+		SeriesAPI:     datadog.SeriesAPIV2,
+		Destinations: []*datadog.Destination{
+			{Name: "team", APIKey: "team-12345678", APIURL: team.URL(), Metrics: []string{"network.*"}},
+			{Name: "down", APIKey: "down-12345678", APIURL: down.URL()},
+		},
+	}))
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.Run(ctx)
+		close(done)
+	}()
+	c.ChanSeries <- metrics.Series{Metric: "network.arp", Points: [][]float64{{1600000000, 1}}, Type: metrics.TypeGauge}
+	c.ChanSeries <- metrics.Series{Metric: "temperature.celsius", Points: [][]float64{{1600000000, 66.9}}, Type: metrics.TypeGauge}
+	cancel()
+	<-done
+
+	main.AssertMetric(t, "network.arp")
+	main.AssertMetric(t, "temperature.celsius")
+	team.AssertMetric(t, "network.arp")
+	assert.Len(t, team.Series("temperature.celsius"), 0)
+	assert.Len(t, down.Series(""), 0)
+	assert.Equal(t, "team-12345678", team.Requests(SeriesV2Path)[0].Header.Get("DD-API-KEY"))
+
+	destinations := c.Destinations()
+	assert.Equal(t, float64(1), destinations[0].ClientMetrics.SentSeries)
+	assert.Equal(t, float64(0), destinations[1].ClientMetrics.SentSeries)
+	assert.Equal(t, float64(2), c.Stats.SentSeries)
+}
+
+func TestIntakeKeyRotation(t *testing.T) {
+	intake := NewIntake()
+	defer intake.Close()
+
+	c := newClient(intake, datadog.SeriesAPIV1)
+	ctx := context.Background()
+	require.NoError(t, c.ValidateKeys(ctx))
+
+	intake.SetKeys("api-key-rotated1", "app-key-rotated1")
+	apiErr := &datadog.APIError{}
+	require.True(t, errors.As(c.ValidateKeys(ctx), &apiErr))
+	assert.Equal(t, http.StatusForbidden, apiErr.StatusCode)
+
+	c.SetCredentials("api-key-rotated1", "")
+	require.True(t, errors.As(c.ValidateKeys(ctx), &apiErr))
+
+	c.SetCredentials("", "app-key-rotated1")
+	require.NoError(t, c.ValidateKeys(ctx))
+	require.NoError(t, c.SendSeries(ctx, []metrics.Series{
+		{
+			Metric: "temperature.celsius",
+			Points: [][]float64{{1600000000, 42}},
+			Type:   metrics.TypeGauge,
+			Host:   "router",
+		},
+	}))
+	intake.AssertMetric(t, "temperature.celsius")
+}
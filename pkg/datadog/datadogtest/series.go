@@ -0,0 +1,195 @@
+package datadogtest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/JulienBalestra/monitoring/pkg/metrics"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// metric types and protobuf fields of the v2 series API, see the MetricPayload of the datadog-agent
+const (
+	metricTypeCount = 1
+	metricTypeRate  = 2
+	metricTypeGauge = 3
+
+	fieldPayloadSeries = 1
+
+	fieldSeriesResources = 1
+	fieldSeriesMetric    = 2
+	fieldSeriesTags      = 3
+	fieldSeriesPoints    = 4
+	fieldSeriesType      = 5
+	fieldSeriesUnit      = 6
+	fieldSeriesInterval  = 8
+
+	fieldResourceType = 1
+	fieldResourceName = 2
+
+	fieldPointValue     = 1
+	fieldPointTimestamp = 2
+
+	resourceTypeHost   = "host"
+	resourceTypeDevice = "device"
+)
+
+func metricType(t int64) string {
+	switch t {
+	case metricTypeCount:
+		return metrics.TypeCount
+	case metricTypeRate:
+		return metrics.TypeRate
+	case metricTypeGauge:
+		return metrics.TypeGauge
+	}
+	return ""
+}
+
+func setResource(s *metrics.Series, typ, name string) {
+	switch typ {
+	case resourceTypeHost:
+		s.Host = name
+	case resourceTypeDevice:
+		s.Device = name
+	}
+}
+
+func decodeV2Series(body []byte) ([]metrics.Series, error) {
+	payload := struct {
+		Series []struct {
+			Metric string `json:"metric"`
+			Type   int64  `json:"type"`
+			Points []struct {
+				Timestamp int64   `json:"timestamp"`
+				Value     float64 `json:"value"`
+			} `json:"points"`
+			Interval  int64  `json:"interval"`
+			Unit      string `json:"unit"`
+			Resources []struct {
+				Name string `json:"name"`
+				Type string `json:"type"`
+			} `json:"resources"`
+			Tags []string `json:"tags"`
+		} `json:"series"`
+	}{}
+	err := json.Unmarshal(body, &payload)
+	if err != nil {
+		return nil, err
+	}
+	series := make([]metrics.Series, 0, len(payload.Series))
+	for _, p := range payload.Series {
+		s := metrics.Series{
+			Metric:   p.Metric,
+			Type:     metricType(p.Type),
+			Interval: float64(p.Interval),
+			Unit:     p.Unit,
+			Tags:     p.Tags,
+		}
+		for _, point := range p.Points {
+			s.Points = append(s.Points, []float64{float64(point.Timestamp), point.Value})
+		}
+		for _, r := range p.Resources {
+			setResource(&s, r.Type, r.Name)
+		}
+		series = append(series, s)
+	}
+	return series, nil
+}
+
+// consumeFields decodes one level of a protobuf message, the varint and fixed64 values are re-encoded
+func consumeFields(b []byte) (map[protowire.Number][][]byte, error) {
+	fields := make(map[protowire.Number][][]byte)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		var v []byte
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			var u uint64
+			u, n = protowire.ConsumeVarint(b)
+			v = protowire.AppendVarint(nil, u)
+		case protowire.Fixed64Type:
+			var u uint64
+			u, n = protowire.ConsumeFixed64(b)
+			v = protowire.AppendFixed64(nil, u)
+		default:
+			return nil, fmt.Errorf("unexpected wire type %d", typ)
+		}
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		fields[num] = append(fields[num], v)
+	}
+	return fields, nil
+}
+
+func firstVarint(fields map[protowire.Number][][]byte, num protowire.Number) int64 {
+	if len(fields[num]) == 0 {
+		return 0
+	}
+	v, _ := protowire.ConsumeVarint(fields[num][0])
+	return int64(v)
+}
+
+func firstString(fields map[protowire.Number][][]byte, num protowire.Number) string {
+	if len(fields[num]) == 0 {
+		return ""
+	}
+	return string(fields[num][0])
+}
+
+func decodeProtobufSeries(body []byte) ([]metrics.Series, error) {
+	payload, err := consumeFields(body)
+	if err != nil {
+		return nil, err
+	}
+	series := make([]metrics.Series, 0, len(payload[fieldPayloadSeries]))
+	for _, b := range payload[fieldPayloadSeries] {
+		fields, err := consumeFields(b)
+		if err != nil {
+			return nil, err
+		}
+		s := metrics.Series{
+			Metric:   firstString(fields, fieldSeriesMetric),
+			Type:     metricType(firstVarint(fields, fieldSeriesType)),
+			Interval: float64(firstVarint(fields, fieldSeriesInterval)),
+			Unit:     firstString(fields, fieldSeriesUnit),
+		}
+		if s.Metric == "" {
+			return nil, errors.New("missing metric name")
+		}
+		for _, tag := range fields[fieldSeriesTags] {
+			s.Tags = append(s.Tags, string(tag))
+		}
+		for _, r := range fields[fieldSeriesResources] {
+			resource, err := consumeFields(r)
+			if err != nil {
+				return nil, err
+			}
+			setResource(&s, firstString(resource, fieldResourceType), firstString(resource, fieldResourceName))
+		}
+		for _, p := range fields[fieldSeriesPoints] {
+			point, err := consumeFields(p)
+			if err != nil {
+				return nil, err
+			}
+			var value float64
+			if len(point[fieldPointValue]) > 0 {
+				bits, _ := protowire.ConsumeFixed64(point[fieldPointValue][0])
+				value = math.Float64frombits(bits)
+			}
+			s.Points = append(s.Points, []float64{float64(firstVarint(point, fieldPointTimestamp)), value})
+		}
+		series = append(series, s)
+	}
+	return series, nil
+}
@@ -0,0 +1,194 @@
+package datadog
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/JulienBalestra/monitoring/pkg/metrics"
+)
+
+const (
+	// destinationChanSize buffers the series of a destination, they are dropped when full
+	destinationChanSize = 1000
+)
+
+// Destination is an additional Datadog org receiving a copy of the series
+// each destination has its own queue, retries and ClientMetrics, the events, service checks, logs and host tags
+// are only sent to the main org
+type Destination struct {
+	Name   string
+	APIKey string
+	// APIURL defaults to DefaultAPIURL
+	APIURL string
+	// Metrics is the allow-list of path.Match patterns like "network.*", empty forwards every metric
+	Metrics []string
+
+	ClientMetrics *ClientMetrics
+
+	client *Client
+}
+
+// ParseDestination parses the comma separated key=value of a destination like:
+// name=team,site=datadoghq.eu,api-key-env=TEAM_DATADOG_API_KEY,metrics=network.*|temperature.*
+// the keys are name, api-key, api-key-env, site, api-url and metrics, the metrics are separated by a pipe
+func ParseDestination(s string) (*Destination, error) {
+	d := &Destination{}
+	for _, kv := range strings.Split(s, ",") {
+		i := strings.Index(kv, "=")
+		if i == -1 {
+			return nil, fmt.Errorf("invalid destination field %q, must be key=value", kv)
+		}
+		k, v := strings.TrimSpace(kv[:i]), strings.TrimSpace(kv[i+1:])
+		switch k {
+		case "name":
+			d.Name = v
+		case "api-key":
+			d.APIKey = v
+		case "api-key-env":
+			d.APIKey = os.Getenv(v)
+			if d.APIKey == "" {
+				return nil, fmt.Errorf("empty environment variable %s for the API key of destination %q", v, d.Name)
+			}
+		case "site":
+			d.APIURL = "https://api." + v
+		case "api-url":
+			d.APIURL = v
+		case "metrics":
+			d.Metrics = strings.Split(v, "|")
+		default:
+			return nil, fmt.Errorf("unknown destination field %q", k)
+		}
+	}
+	err := d.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func (d *Destination) Validate() error {
+	if d.Name == "" {
+		return fmt.Errorf("missing destination name")
+	}
+	if d.APIKey == "" {
+		return fmt.Errorf("missing API key of destination %q", d.Name)
+	}
+	for _, pattern := range d.Metrics {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf("invalid metric pattern %q of destination %q: %v", pattern, d.Name, err)
+		}
+	}
+	return nil
+}
+
+// ValidDestinations checks every destination and the uniqueness of their names
+func ValidDestinations(destinations []*Destination) error {
+	names := make(map[string]struct{}, len(destinations))
+	for _, d := range destinations {
+		err := d.Validate()
+		if err != nil {
+			return err
+		}
+		if _, ok := names[d.Name]; ok {
+			return fmt.Errorf("duplicated destination %q", d.Name)
+		}
+		names[d.Name] = struct{}{}
+	}
+	return nil
+}
+
+func (d *Destination) allows(metric string) bool {
+	if len(d.Metrics) == 0 {
+		return true
+	}
+	for _, pattern := range d.Metrics {
+		ok, _ := path.Match(pattern, metric)
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+type destination struct {
+	conf   *Destination
+	client *Client
+}
+
+func newDestination(conf *Config, d *Destination) *destination {
+	if d.ClientMetrics == nil {
+		d.ClientMetrics = &ClientMetrics{}
+	}
+	destConf := *conf
+	destConf.DatadogAPIKey = d.APIKey
+	// the APP key belongs to the main org
+	destConf.DatadogAPPKey = ""
+	destConf.APIURL = d.APIURL
+	destConf.ChanSize = destinationChanSize
+	destConf.ClientMetrics = d.ClientMetrics
+	destConf.Destinations = nil
+	destConf.Archive = nil
+	d.client = NewClient(&destConf)
+	return &destination{
+		conf:   d,
+		client: d.client,
+	}
+}
+
+// QueueDepth returns the queue depths of the destination, see Client.QueueDepth
+func (d *Destination) QueueDepth() map[string]float64 {
+	if d.client == nil {
+		return nil
+	}
+	return d.client.QueueDepth()
+}
+
+// Destinations returns the additional orgs receiving the series
+func (c *Client) Destinations() []*Destination {
+	destinations := make([]*Destination, 0, len(c.destinations))
+	for _, d := range c.destinations {
+		destinations = append(destinations, d.conf)
+	}
+	return destinations
+}
+
+// fanOut copies the series to the queue of the destinations allowing it, it never blocks
+func (c *Client) fanOut(s *metrics.Series) {
+	for _, d := range c.destinations {
+		if !d.conf.allows(s.Metric) {
+			continue
+		}
+		copied := *s
+		// the points are appended by the aggregation stores
+		copied.Points = append([][]float64(nil), s.Points...)
+		select {
+		case d.client.ChanSeries <- copied:
+		default:
+			d.client.Stats.Lock()
+			d.client.Stats.DroppedSeries++
+			d.client.Stats.Unlock()
+		}
+	}
+}
+
+// runDestinations starts the clients of the destinations, the returned function stops them after their last flush
+func (c *Client) runDestinations() func() {
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := &sync.WaitGroup{}
+	for _, d := range c.destinations {
+		wg.Add(1)
+		go func(d *destination) {
+			d.client.Run(ctx)
+			wg.Done()
+		}(d)
+	}
+	return func() {
+		cancel()
+		wg.Wait()
+	}
+}
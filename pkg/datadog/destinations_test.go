@@ -0,0 +1,110 @@
+package datadog
+
+import (
+	"os"
+	"testing"
+
+	"github.com/JulienBalestra/monitoring/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDestination(t *testing.T) {
+	require.NoError(t, os.Setenv("TEST_DESTINATION_API_KEY", "env-key-12345678"))
+	defer os.Unsetenv("TEST_DESTINATION_API_KEY")
+
+	for name, tc := range map[string]struct {
+		s        string
+		expected *Destination
+		err      bool
+	}{
+		"full": {
+			s: "name=team,site=datadoghq.eu,api-key=key-12345678,metrics=network.*|temperature.celsius",
+			expected: &Destination{
+				Name:    "team",
+				APIKey:  "key-12345678",
+				APIURL:  "https://api.datadoghq.eu",
+				Metrics: []string{"network.*", "temperature.celsius"},
+			},
+		},
+		"env": {
+			s: "name=personal, api-key-env=TEST_DESTINATION_API_KEY, api-url=http://127.0.0.1:8080",
+			expected: &Destination{
+				Name:   "personal",
+				APIKey: "env-key-12345678",
+				APIURL: "http://127.0.0.1:8080",
+			},
+		},
+		"empty env": {
+			s:   "name=personal,api-key-env=TEST_DESTINATION_UNSET",
+			err: true,
+		},
+		"missing name": {
+			s:   "api-key=key-12345678",
+			err: true,
+		},
+		"missing key": {
+			s:   "name=team",
+			err: true,
+		},
+		"unknown field": {
+			s:   "name=team,api-key=key-12345678,org=team",
+			err: true,
+		},
+		"invalid pattern": {
+			s:   "name=team,api-key=key-12345678,metrics=network.[",
+			err: true,
+		},
+		"not key value": {
+			s:   "team",
+			err: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			d, err := ParseDestination(tc.s)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, d)
+		})
+	}
+}
+
+func TestValidDestinations(t *testing.T) {
+	assert.NoError(t, ValidDestinations(nil))
+	assert.NoError(t, ValidDestinations([]*Destination{{Name: "a", APIKey: "k"}, {Name: "b", APIKey: "k"}}))
+	assert.Error(t, ValidDestinations([]*Destination{{Name: "a", APIKey: "k"}, {Name: "a", APIKey: "k"}}))
+}
+
+func TestFanOut(t *testing.T) {
+	c := NewClient(&Config{
+		Host: "router",
+		Destinations: []*Destination{
+			{Name: "all", APIKey: "all-12345678"},
+			{Name: "network", APIKey: "network-12345678", Metrics: []string{"network.*"}},
+		},
+	})
+	destinations := c.Destinations()
+	require.Len(t, destinations, 2)
+	assert.NotNil(t, destinations[0].ClientMetrics)
+
+	s := &metrics.Series{Metric: "network.arp", Points: [][]float64{{1, 1}}}
+	c.fanOut(s)
+	c.fanOut(&metrics.Series{Metric: "temperature.celsius", Points: [][]float64{{1, 66}}})
+	assert.Len(t, c.destinations[0].client.ChanSeries, 2)
+	require.Len(t, c.destinations[1].client.ChanSeries, 1)
+
+	copied := <-c.destinations[1].client.ChanSeries
+	assert.Equal(t, *s, copied)
+	copied.Points[0] = []float64{2, 2}
+	assert.Equal(t, [][]float64{{1, 1}}, s.Points)
+
+	for i := 0; i < destinationChanSize; i++ {
+		c.fanOut(s)
+	}
+	assert.Equal(t, float64(2), c.destinations[0].client.Stats.DroppedSeries)
+	assert.Equal(t, float64(0), c.destinations[1].client.Stats.DroppedSeries)
+	assert.Equal(t, float64(0), c.Stats.DroppedSeries)
+}
@@ -0,0 +1,192 @@
+package datadog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+	"unicode/utf8"
+
+	"github.com/JulienBalestra/monitoring/pkg/tagger"
+	"go.uber.org/zap"
+)
+
+const (
+	AlertTypeError   = "error"
+	AlertTypeWarning = "warning"
+	AlertTypeInfo    = "info"
+	AlertTypeSuccess = "success"
+
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+
+	eventsPath = "/api/v1/events"
+
+	eventsChanSize = 100
+	// the oldest pending events are dropped once reached
+	maxPendingEvents = 200
+
+	// limits of https://docs.datadoghq.com/api/latest/events/#post-an-event
+	maxEventTitleLen          = 100
+	maxEventTextLen           = 4000
+	maxEventAggregationKeyLen = 100
+)
+
+// Event is the payload of https://docs.datadoghq.com/api/latest/events/#post-an-event
+// the events sharing an AggregationKey are grouped in the event stream
+type Event struct {
+	Title          string   `json:"title"`
+	Text           string   `json:"text"`
+	DateHappened   int64    `json:"date_happened,omitempty"`
+	Host           string   `json:"host,omitempty"`
+	Tags           []string `json:"tags,omitempty"`
+	AlertType      string   `json:"alert_type,omitempty"`
+	Priority       string   `json:"priority,omitempty"`
+	AggregationKey string   `json:"aggregation_key,omitempty"`
+	SourceTypeName string   `json:"source_type_name,omitempty"`
+}
+
+// truncate cuts the string to max bytes at a rune boundary, ending with ...
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	i := max - 3
+	for i > 0 && !utf8.RuneStart(s[i]) {
+		i--
+	}
+	return s[:i] + "..."
+}
+
+func (e *Event) truncate() {
+	e.Title = truncate(e.Title, maxEventTitleLen)
+	e.Text = truncate(e.Text, maxEventTextLen)
+	e.AggregationKey = truncate(e.AggregationKey, maxEventAggregationKeyLen)
+}
+
+// SubmitEvent queues the event, it's sent along with the next series
+// the event is dropped when the queue is full
+func (c *Client) SubmitEvent(e *Event) {
+	if e.DateHappened == 0 {
+		e.DateHappened = time.Now().Unix()
+	}
+	if e.Host == "" {
+		e.Host = c.conf.Host
+	}
+	// the tags follow the Datadog rules like the ones of the series
+	e.Tags = tagger.NormaliseTags(e.Tags)
+	e.truncate()
+	select {
+	case c.ChanEvents <- e:
+	default:
+		c.Stats.Lock()
+		c.Stats.DroppedEvents++
+		c.Stats.Unlock()
+	}
+}
+
+// SendEvent posts a single event
+func (c *Client) SendEvent(ctx context.Context, e *Event) error {
+	var buff bytes.Buffer
+	err := json.NewEncoder(&buff).Encode(e)
+	if err != nil {
+		return err
+	}
+	creds := c.credentials()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.eventsURL, &buff)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(contentType, typeApplicationJson)
+	req.Header.Set("DD-API-KEY", creds.apiKey)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 300 {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return resp.Body.Close()
+	}
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	apiKey, err := hideKey(creds.apiKey)
+	if err != nil {
+		return &APIError{
+			StatusCode: resp.StatusCode,
+			Message:    fmt.Sprintf("failed to send event status code: %d: %v %s", resp.StatusCode, err, string(bodyBytes)),
+		}
+	}
+	return &APIError{
+		StatusCode: resp.StatusCode,
+		Message:    fmt.Sprintf("failed to send event status code: %d API=%q %s", resp.StatusCode, apiKey, string(bodyBytes)),
+	}
+}
+
+// sendEvents returns the events to retry
+// the events rejected by the API are dropped
+func (c *Client) sendEvents(ctx context.Context, events []*Event) ([]*Event, error) {
+	var retry []*Event
+	var lastErr error
+	sent, dropped := 0., 0.
+	for i, e := range events {
+		if ctx.Err() != nil {
+			retry = append(retry, events[i:]...)
+			break
+		}
+		err := c.SendEvent(ctx, e)
+		if err == nil {
+			sent++
+			continue
+		}
+		lastErr = err
+		var apiErr *APIError
+		if errors.As(err, &apiErr) && !apiErr.Retryable() {
+			dropped++
+			continue
+		}
+		retry = append(retry, e)
+	}
+	c.Stats.Lock()
+	c.Stats.SentEvents += sent
+	c.Stats.DroppedEvents += dropped
+	if lastErr != nil {
+		c.Stats.SentEventsErrors++
+	}
+	c.Stats.Unlock()
+	return retry, lastErr
+}
+
+// queueEvent appends the event to the pending ones, dropping the oldest when full
+func (c *Client) queueEvent(pending []*Event, e *Event) []*Event {
+	pending = append(pending, e)
+	if len(pending) <= maxPendingEvents {
+		return pending
+	}
+	c.Stats.Lock()
+	c.Stats.DroppedEvents++
+	c.Stats.Unlock()
+	return pending[1:]
+}
+
+// flushEvents sends the pending events and returns the ones to retry
+func (c *Client) flushEvents(ctx context.Context, pending []*Event) []*Event {
+	if len(pending) == 0 {
+		return pending
+	}
+	zctx := zap.L().With(zap.Int("events", len(pending)))
+	retry, err := c.sendEvents(ctx, pending)
+	if err != nil {
+		zctx.Error("failed to send events", zap.Error(err), zap.Int("retry", len(retry)))
+		return retry
+	}
+	zctx.Debug("successfully sent events")
+	return retry
+}
@@ -0,0 +1,148 @@
+package datadog
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeEventsAPI struct {
+	mu       sync.Mutex
+	events   []Event
+	statuses []int
+}
+
+func (f *fakeEventsAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.statuses) > 0 {
+		status := f.statuses[0]
+		f.statuses = f.statuses[1:]
+		if status >= 300 {
+			w.WriteHeader(status)
+			return
+		}
+	}
+	e := Event{}
+	err := json.NewDecoder(r.Body).Decode(&e)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.events = append(f.events, e)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func newEventsClient(t *testing.T, api *fakeEventsAPI) *Client {
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	c := NewClient(&Config{
+		Host:          "router",
+		DatadogAPIKey: "api-key-12345678",
+	})
+	c.eventsURL = srv.URL + eventsPath
+	return c
+}
+
+func TestSubmitEvent(t *testing.T) {
+	c := NewClient(&Config{Host: "router"})
+	c.SubmitEvent(&Event{
+		Title: strings.Repeat("t", 200),
+		Tags:  []string{"collector:wireguard", "lease:John's iPhone"},
+	})
+	e := <-c.ChanEvents
+	assert.Equal(t, "router", e.Host)
+	assert.Equal(t, []string{"collector:wireguard", "lease:john_s_iphone"}, e.Tags)
+	assert.NotZero(t, e.DateHappened)
+	assert.Len(t, e.Title, maxEventTitleLen)
+	assert.True(t, strings.HasSuffix(e.Title, "..."))
+
+	for i := 0; i < eventsChanSize+5; i++ {
+		c.SubmitEvent(&Event{Title: "full"})
+	}
+	assert.Equal(t, float64(5), c.Stats.DroppedEvents)
+}
+
+func TestTruncate(t *testing.T) {
+	for name, tc := range map[string]struct {
+		s   string
+		max int
+		exp string
+	}{
+		"short": {
+			s:   "wg0",
+			max: 10,
+			exp: "wg0",
+		},
+		"ascii": {
+			s:   "handshake timeout",
+			max: 10,
+			exp: "handsha...",
+		},
+		"multi-byte rune": {
+			// the second é starts at the cut
+			s:   "réseau éteint",
+			max: 11,
+			exp: "réseau ...",
+		},
+		"split rune": {
+			// the é spans the bytes 5 and 6, cutting at 6 steps back to 5
+			s:   "aaaaaé€€€",
+			max: 9,
+			exp: "aaaaa...",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			out := truncate(tc.s, tc.max)
+			assert.Equal(t, tc.exp, out)
+			assert.True(t, utf8.ValidString(out))
+			assert.LessOrEqual(t, len(out), tc.max)
+		})
+	}
+}
+
+func TestSendEvents(t *testing.T) {
+	api := &fakeEventsAPI{
+		statuses: []int{http.StatusServiceUnavailable, http.StatusBadRequest},
+	}
+	c := newEventsClient(t, api)
+	events := []*Event{
+		{Title: "retried", AlertType: AlertTypeError, AggregationKey: "key"},
+		{Title: "rejected"},
+		{Title: "sent", AlertType: AlertTypeSuccess, AggregationKey: "key"},
+	}
+
+	retry, err := c.sendEvents(context.Background(), events)
+	assert.Error(t, err)
+	require.Len(t, retry, 1)
+	assert.Equal(t, "retried", retry[0].Title)
+	assert.Equal(t, float64(1), c.Stats.SentEvents)
+	assert.Equal(t, float64(1), c.Stats.DroppedEvents)
+	assert.Equal(t, float64(1), c.Stats.SentEventsErrors)
+
+	retry, err = c.sendEvents(context.Background(), retry)
+	require.NoError(t, err)
+	assert.Len(t, retry, 0)
+	assert.Equal(t, []Event{
+		{Title: "sent", AlertType: AlertTypeSuccess, AggregationKey: "key"},
+		{Title: "retried", AlertType: AlertTypeError, AggregationKey: "key"},
+	}, api.events)
+}
+
+func TestQueueEvent(t *testing.T) {
+	c := NewClient(&Config{})
+	var pending []*Event
+	for i := 0; i < maxPendingEvents+1; i++ {
+		pending = c.queueEvent(pending, &Event{Title: "event"})
+	}
+	assert.Len(t, pending, maxPendingEvents)
+	assert.Equal(t, float64(1), c.Stats.DroppedEvents)
+}
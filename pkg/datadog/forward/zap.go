@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"compress/zlib"
 	"context"
+	"encoding/json"
+	"errors"
 	"net/url"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,83 +16,334 @@ import (
 )
 
 const (
-	DatadogZapScheme  = "datadog"
-	DatadogZapOutput  = DatadogZapScheme + "://zap"
-	bufferSize        = 1000000
-	bufferSyncTrigger = bufferSize + (bufferSize * 0.15)
+	DatadogZapScheme = "datadog"
+	DatadogZapOutput = DatadogZapScheme + "://zap"
+
+	DefaultService          = "monitoring"
+	DefaultSource           = "monitoring"
+	DefaultFlushInterval    = time.Second * 10
+	DefaultFlushBytes       = 1 << 20
+	DefaultMaxBufferedBytes = 4 << 20
+
+	// limits of https://docs.datadoghq.com/api/latest/logs/#send-logs
+	maxBatchEntries = 1000
+	maxBatchBytes   = 4 << 20
+	maxEntryBytes   = 1 << 20
+
+	sendTimeout      = time.Second * 15
+	maxSendAttempts  = 3
+	retryBackoff     = time.Second
+	shutdownTimeout  = time.Second * 15
+	entryOverheadLen = 256
 )
 
+// LogsSender is implemented by the datadog.Client
+type LogsSender interface {
+	SendLogs(ctx context.Context, buffer *bytes.Buffer) error
+}
+
+type Config struct {
+	Host    string
+	Service string
+	Source  string
+	Tags    []string
+
+	// FlushInterval is the maximum time a log is buffered
+	FlushInterval time.Duration
+	// FlushBytes triggers a flush once reached by the buffered logs
+	FlushBytes int
+	// MaxBufferedBytes bounds the memory of the logs waiting to be sent, the new logs are dropped once reached
+	MaxBufferedBytes int
+}
+
+// Forwarder is a zap.Sink shipping the JSON logs to the Datadog logs intake
+// Write only buffers the logs, a background shipper sends them by batch
 type Forwarder struct {
-	mu     *sync.Mutex
-	buffer *bytes.Buffer
+	conf   *Config
+	sender LogsSender
+	stats  *datadog.ClientMetrics
+
+	mu            *sync.Mutex
+	entries       [][]byte
+	pendingBytes  int
+	inflightBytes int
+
+	// attributes appended to each entry
+	attributes []byte
+	zw         *zlib.Writer
+
+	trigger   chan struct{}
+	closing   chan struct{}
+	done      chan struct{}
+	closeOnce *sync.Once
+}
+
+func newForwarder(ctx context.Context, sender LogsSender, stats *datadog.ClientMetrics, conf *Config) *Forwarder {
+	if conf.Service == "" {
+		conf.Service = DefaultService
+	}
+	if conf.Source == "" {
+		conf.Source = DefaultSource
+	}
+	if conf.FlushInterval <= 0 {
+		conf.FlushInterval = DefaultFlushInterval
+	}
+	if conf.FlushBytes <= 0 {
+		conf.FlushBytes = DefaultFlushBytes
+	}
+	if conf.MaxBufferedBytes <= 0 {
+		conf.MaxBufferedBytes = DefaultMaxBufferedBytes
+	}
+	f := &Forwarder{
+		conf:       conf,
+		sender:     sender,
+		stats:      stats,
+		mu:         &sync.Mutex{},
+		attributes: newAttributes(conf),
+		trigger:    make(chan struct{}, 1),
+		closing:    make(chan struct{}),
+		done:       make(chan struct{}),
+		closeOnce:  &sync.Once{},
+	}
+	go f.run(ctx)
+	return f
+}
 
-	c *datadog.Client
+// NewDatadogForwarder returns the zap.Sink factory to register with zap.RegisterSink
+func NewDatadogForwarder(ctx context.Context, c *datadog.Client, conf *Config) func(*url.URL) (zap.Sink, error) {
+	return func(_ *url.URL) (zap.Sink, error) {
+		return newForwarder(ctx, c, c.Stats, conf), nil
+	}
+}
 
-	lastSync time.Time
-	ctx      context.Context
+func newAttributes(conf *Config) []byte {
+	var b []byte
+	for _, kv := range [][2]string{
+		{"service", conf.Service},
+		{"ddsource", conf.Source},
+		{"ddtags", strings.Join(conf.Tags, ",")},
+		{"hostname", conf.Host},
+	} {
+		if kv[1] == "" {
+			continue
+		}
+		v, _ := json.Marshal(kv[1])
+		b = append(b, ',', '"')
+		b = append(b, kv[0]...)
+		b = append(b, '"', ':')
+		b = append(b, v...)
+	}
+	return b
+}
+
+func (f *Forwarder) drop(entries int) {
+	f.stats.Lock()
+	f.stats.DroppedLogs += float64(entries)
+	f.stats.Unlock()
 }
 
-func (f *Forwarder) Write(p []byte) (n int, err error) {
+// Write buffers a copy of the log entry, it never blocks on the network
+func (f *Forwarder) Write(p []byte) (int, error) {
+	if len(p) > maxEntryBytes {
+		f.drop(1)
+		return len(p), nil
+	}
 	f.mu.Lock()
-	since := time.Since(f.lastSync)
-	nn, err := f.buffer.Write(p)
-	bufferLen := f.buffer.Len()
-	f.mu.Unlock()
-	if err != nil {
-		return nn, err
+	if f.pendingBytes+f.inflightBytes+len(p) > f.conf.MaxBufferedBytes {
+		f.mu.Unlock()
+		f.drop(1)
+		return len(p), nil
 	}
-	if since > time.Minute || bufferLen > bufferSyncTrigger {
-		//	return nn, f.Sync()
+	entry := make([]byte, len(p))
+	copy(entry, p)
+	f.entries = append(f.entries, entry)
+	f.pendingBytes += len(entry)
+	full := f.pendingBytes >= f.conf.FlushBytes || len(f.entries) >= maxBatchEntries
+	f.mu.Unlock()
+
+	if full {
+		select {
+		case f.trigger <- struct{}{}:
+		default:
+		}
 	}
-	return nn, nil
+	return len(p), nil
 }
 
-func (f *Forwarder) Sync() error {
-	ctx, cancel := context.WithTimeout(f.ctx, time.Second*45)
-	defer cancel()
-	var zb bytes.Buffer
-	w, err := zlib.NewWriterLevel(&zb, zlib.BestCompression)
-	if err != nil {
-		return err
+func (f *Forwarder) run(ctx context.Context) {
+	ticker := time.NewTicker(f.conf.FlushInterval)
+	defer ticker.Stop()
+	defer close(f.done)
+
+	for {
+		select {
+		case <-ctx.Done():
+			f.shutdown()
+			return
+
+		case <-f.closing:
+			f.shutdown()
+			return
+
+		case <-ticker.C:
+			_ = f.flush(ctx)
+
+		case <-f.trigger:
+			_ = f.flush(ctx)
+		}
 	}
+}
 
+func (f *Forwarder) shutdown() {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	_ = f.flush(ctx)
+	cancel()
+}
+
+// flush sends the buffered entries by batch, the entries of failed batches are dropped
+func (f *Forwarder) flush(ctx context.Context) error {
 	f.mu.Lock()
-	data := make([]byte, f.buffer.Len())
-	copy(data, f.buffer.Bytes())
+	entries := f.entries
+	f.entries = nil
+	f.inflightBytes += f.pendingBytes
+	f.pendingBytes = 0
 	f.mu.Unlock()
 
-	_, err = w.Write(data)
-	if err != nil {
-		return err
+	var lastErr error
+	for len(entries) > 0 {
+		n, size := 0, 0
+		for n < len(entries) && n < maxBatchEntries {
+			if n > 0 && size+len(entries[n])+entryOverheadLen > maxBatchBytes {
+				break
+			}
+			size += len(entries[n]) + entryOverheadLen
+			n++
+		}
+		batch := entries[:n]
+		entries = entries[n:]
+
+		batchBytes := 0
+		for _, e := range batch {
+			batchBytes += len(e)
+		}
+		err := f.send(ctx, batch)
+		f.mu.Lock()
+		f.inflightBytes -= batchBytes
+		f.mu.Unlock()
+		if err != nil {
+			f.drop(len(batch))
+			lastErr = err
+			continue
+		}
+		f.stats.Lock()
+		f.stats.SentLogs += float64(len(batch))
+		f.stats.Unlock()
+	}
+	return lastErr
+}
+
+// send retries the batch until accepted, rejected or the attempts are exhausted
+func (f *Forwarder) send(ctx context.Context, batch [][]byte) error {
+	var err error
+	for attempt := 0; attempt < maxSendAttempts; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(retryBackoff << (attempt - 1))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return err
+			case <-timer.C:
+			}
+		}
+		var body *bytes.Buffer
+		body, err = f.encode(batch)
+		if err != nil {
+			return err
+		}
+		sendCtx, cancel := context.WithTimeout(ctx, sendTimeout)
+		err = f.sender.SendLogs(sendCtx, body)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		var apiErr *datadog.APIError
+		if errors.As(err, &apiErr) && !apiErr.Retryable() {
+			return err
+		}
 	}
+	return err
+}
 
-	err = w.Close()
+// encode compresses the JSON array of the entries
+func (f *Forwarder) encode(batch [][]byte) (*bytes.Buffer, error) {
+	body := &bytes.Buffer{}
+	if f.zw == nil {
+		zw, err := zlib.NewWriterLevel(body, zlib.BestCompression)
+		if err != nil {
+			return nil, err
+		}
+		f.zw = zw
+	} else {
+		f.zw.Reset(body)
+	}
+	b := make([]byte, 0, 4096)
+	b = append(b, '[')
+	for i, entry := range batch {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = f.appendEntry(b, entry)
+		if len(b) > 32<<10 {
+			_, err := f.zw.Write(b)
+			if err != nil {
+				return nil, err
+			}
+			b = b[:0]
+		}
+	}
+	b = append(b, ']')
+	_, err := f.zw.Write(b)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = f.c.SendLogs(ctx, &zb)
+	err = f.zw.Close()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	f.mu.Lock()
-	f.buffer.Reset()
-	f.lastSync = time.Now()
-	f.mu.Unlock()
-	return nil
+	return body, nil
 }
 
-func (f *Forwarder) Close() error {
-	return f.Sync()
+// appendEntry merges the attributes into the JSON object of the zap entry
+// an entry not encoded as a JSON object is sent as the message
+func (f *Forwarder) appendEntry(b, entry []byte) []byte {
+	entry = bytes.TrimSpace(entry)
+	if len(entry) > 2 && entry[0] == '{' && json.Valid(entry) {
+		b = append(b, entry[:len(entry)-1]...)
+		b = append(b, f.attributes...)
+		return append(b, '}')
+	}
+	message, _ := json.Marshal(string(entry))
+	b = append(b, `{"message":`...)
+	b = append(b, message...)
+	b = append(b, f.attributes...)
+	return append(b, '}')
 }
 
-func NewDatadogForwarder(ctx context.Context, c *datadog.Client) func(*url.URL) (zap.Sink, error) {
-	return func(_ *url.URL) (zap.Sink, error) {
-		return &Forwarder{
-			mu:       &sync.Mutex{},
-			buffer:   bytes.NewBuffer(make([]byte, 0, bufferSize)),
-			c:        c,
-			lastSync: time.Now(),
-			ctx:      ctx,
-		}, nil
+// Sync triggers a flush without waiting for it: zap calls it with the writes locked, so it must not wait on the intake
+// Close waits for the buffered logs to be sent
+func (f *Forwarder) Sync() error {
+	select {
+	case f.trigger <- struct{}{}:
+	default:
 	}
+	return nil
+}
+
+// Close sends the buffered logs and stops the shipper
+func (f *Forwarder) Close() error {
+	f.closeOnce.Do(func() {
+		close(f.closing)
+	})
+	<-f.done
+	return nil
 }
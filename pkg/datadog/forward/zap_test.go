@@ -0,0 +1,233 @@
+package forward
+
+import (
+	"bytes"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/JulienBalestra/monitoring/pkg/datadog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+type fakeSender struct {
+	mu      sync.Mutex
+	block   chan struct{}
+	errs    []error
+	calls   int
+	entries []map[string]interface{}
+}
+
+func (s *fakeSender) SendLogs(ctx context.Context, buffer *bytes.Buffer) error {
+	if s.block != nil {
+		select {
+		case <-s.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		return err
+	}
+	r, err := zlib.NewReader(buffer)
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	var entries []map[string]interface{}
+	err = json.Unmarshal(b, &entries)
+	if err != nil {
+		return err
+	}
+	s.entries = append(s.entries, entries...)
+	return nil
+}
+
+func (s *fakeSender) received() []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries
+}
+
+func newTestForwarder(t *testing.T, s *fakeSender, conf *Config) (*Forwarder, *datadog.ClientMetrics) {
+	stats := &datadog.ClientMetrics{}
+	f := newForwarder(context.Background(), s, stats, conf)
+	t.Cleanup(func() { _ = f.Close() })
+	return f, stats
+}
+
+func TestForwarderEntries(t *testing.T) {
+	s := &fakeSender{}
+	f, stats := newTestForwarder(t, s, &Config{
+		Host: "router",
+		Tags: []string{"env:home", "arch:arm"},
+	})
+	_, err := f.Write([]byte(`{"l":"info","m":"starting monitoring","pid":1}` + "\n"))
+	require.NoError(t, err)
+	_, err = f.Write([]byte("plain text\n"))
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	assert.Equal(t, []map[string]interface{}{
+		{
+			"l":        "info",
+			"m":        "starting monitoring",
+			"pid":      float64(1),
+			"service":  "monitoring",
+			"ddsource": "monitoring",
+			"ddtags":   "env:home,arch:arm",
+			"hostname": "router",
+		},
+		{
+			"message":  "plain text",
+			"service":  "monitoring",
+			"ddsource": "monitoring",
+			"ddtags":   "env:home,arch:arm",
+			"hostname": "router",
+		},
+	}, s.received())
+	assert.Equal(t, float64(2), stats.SentLogs)
+	assert.Equal(t, float64(0), stats.DroppedLogs)
+}
+
+func TestForwarderFlushTriggers(t *testing.T) {
+	t.Run("size", func(t *testing.T) {
+		s := &fakeSender{}
+		f, _ := newTestForwarder(t, s, &Config{FlushInterval: time.Hour, FlushBytes: 10})
+		_, err := f.Write([]byte(`{"m":"more than ten bytes"}`))
+		require.NoError(t, err)
+		assert.Eventually(t, func() bool { return len(s.received()) == 1 }, time.Second*5, time.Millisecond*10)
+	})
+	t.Run("interval", func(t *testing.T) {
+		s := &fakeSender{}
+		f, _ := newTestForwarder(t, s, &Config{FlushInterval: time.Millisecond * 50})
+		_, err := f.Write([]byte(`{"m":"a"}`))
+		require.NoError(t, err)
+		assert.Eventually(t, func() bool { return len(s.received()) == 1 }, time.Second*5, time.Millisecond*10)
+	})
+	t.Run("close", func(t *testing.T) {
+		s := &fakeSender{}
+		f, _ := newTestForwarder(t, s, &Config{FlushInterval: time.Hour})
+		_, err := f.Write([]byte(`{"m":"a"}`))
+		require.NoError(t, err)
+		require.NoError(t, f.Close())
+		assert.Len(t, s.received(), 1)
+	})
+}
+
+func TestForwarderRetries(t *testing.T) {
+	for name, tc := range map[string]struct {
+		errs    []error
+		calls   int
+		sent    float64
+		dropped float64
+	}{
+		"server error": {
+			errs:  []error{&datadog.APIError{StatusCode: http.StatusServiceUnavailable}},
+			calls: 2,
+			sent:  1,
+		},
+		"rejected": {
+			errs:    []error{&datadog.APIError{StatusCode: http.StatusBadRequest}},
+			calls:   1,
+			dropped: 1,
+		},
+		"exhausted": {
+			errs: []error{
+				&datadog.APIError{StatusCode: http.StatusTooManyRequests},
+				&datadog.APIError{StatusCode: http.StatusTooManyRequests},
+				&datadog.APIError{StatusCode: http.StatusTooManyRequests},
+			},
+			calls:   maxSendAttempts,
+			dropped: 1,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s := &fakeSender{errs: tc.errs}
+			f, stats := newTestForwarder(t, s, &Config{FlushInterval: time.Hour})
+			_, err := f.Write([]byte(`{"m":"a"}`))
+			require.NoError(t, err)
+			require.NoError(t, f.Close())
+			assert.Equal(t, tc.calls, s.calls)
+			assert.Equal(t, tc.sent, stats.SentLogs)
+			assert.Equal(t, tc.dropped, stats.DroppedLogs)
+		})
+	}
+}
+
+func TestForwarderWriteNeverBlocks(t *testing.T) {
+	s := &fakeSender{block: make(chan struct{})}
+	f, stats := newTestForwarder(t, s, &Config{FlushBytes: 1, MaxBufferedBytes: 100})
+
+	entry := []byte(`{"m":"twenty bytes"}`)
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 100; i++ {
+			_, _ = f.Write(entry)
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Write blocked by a stuck intake")
+	}
+	stats.RLock()
+	dropped := stats.DroppedLogs
+	stats.RUnlock()
+	assert.GreaterOrEqual(t, dropped, float64(95))
+	close(s.block)
+}
+
+func TestForwarderSyncNeverBlocks(t *testing.T) {
+	s := &fakeSender{block: make(chan struct{})}
+	f, _ := newTestForwarder(t, s, &Config{FlushInterval: time.Hour})
+	// zap locks the writes and the syncs together, and syncs on its own after the error entries
+	logger := zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), f, zapcore.InfoLevel))
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			logger.Error("the intake hangs")
+			_, _ = f.Write([]byte(`{"m":"a"}`))
+			_ = f.Sync()
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("Sync blocked by a stuck intake")
+	}
+	close(s.block)
+	require.NoError(t, f.Close())
+	assert.Len(t, s.received(), 20)
+}
+
+func TestForwarderBatches(t *testing.T) {
+	s := &fakeSender{}
+	f, stats := newTestForwarder(t, s, &Config{FlushInterval: time.Hour, FlushBytes: 1 << 30, MaxBufferedBytes: 1 << 30})
+	for i := 0; i < maxBatchEntries+10; i++ {
+		_, err := f.Write([]byte(`{"m":"a"}`))
+		require.NoError(t, err)
+	}
+	require.NoError(t, f.Close())
+	assert.GreaterOrEqual(t, s.calls, 2)
+	assert.Equal(t, float64(maxBatchEntries+10), stats.SentLogs)
+}
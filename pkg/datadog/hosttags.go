@@ -0,0 +1,237 @@
+package datadog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/JulienBalestra/monitoring/pkg/tagger"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultHostTagsSyncInterval = time.Minute * 10
+
+	hostTagsPath = "/api/v1/tags/hosts/"
+	// the tags applied by this client, the ones of the other sources like the datadog-agent aren't managed
+	hostTagsSource = "users"
+
+	hostTagsMinBackoff = time.Second * 10
+)
+
+// HostTags is the payload of https://docs.datadoghq.com/api/latest/tags/
+type HostTags struct {
+	Host string   `json:"host,omitempty"`
+	Tags []string `json:"tags"`
+}
+
+func (c *Client) doHostTagsRequest(ctx context.Context, method string, body io.Reader) (*HostTags, error) {
+	creds := c.credentials()
+	req, err := http.NewRequestWithContext(ctx, method, c.hostTagsURL, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(contentType, typeApplicationJson)
+	req.Header.Set("DD-API-KEY", creds.apiKey)
+	req.Header.Set("DD-APPLICATION-KEY", creds.appKey)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && method == http.MethodGet {
+		// the host doesn't have any tag of the source yet
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return &HostTags{}, nil
+	}
+	if resp.StatusCode >= 300 {
+		bodyBytes, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		apiKey, err := hideKey(creds.apiKey)
+		if err != nil {
+			return nil, &APIError{
+				StatusCode: resp.StatusCode,
+				Message:    fmt.Sprintf("failed to %s host tags status code: %d: %v %s", method, resp.StatusCode, err, string(bodyBytes)),
+			}
+		}
+		appKey, err := hideKey(creds.appKey)
+		if err != nil {
+			return nil, &APIError{
+				StatusCode: resp.StatusCode,
+				Message:    fmt.Sprintf("failed to %s host tags status code: %d: %v %s", method, resp.StatusCode, err, string(bodyBytes)),
+			}
+		}
+		return nil, &APIError{
+			StatusCode: resp.StatusCode,
+			Message:    fmt.Sprintf("failed to %s host tags status code: %d APP=%q API=%q %s", method, resp.StatusCode, appKey, apiKey, string(bodyBytes)),
+		}
+	}
+	if method == http.MethodDelete {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil, nil
+	}
+	h := &HostTags{}
+	err = json.NewDecoder(resp.Body).Decode(h)
+	if err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// GetHostTags requires the APP key
+func (c *Client) GetHostTags(ctx context.Context) ([]string, error) {
+	h, err := c.doHostTagsRequest(ctx, http.MethodGet, nil)
+	if err != nil {
+		return nil, err
+	}
+	return h.Tags, nil
+}
+
+// UpdateHostTags replaces the host tags, an empty list removes them
+// it requires the APP key
+func (c *Client) UpdateHostTags(ctx context.Context, tags []string) error {
+	zap.L().Debug("sending host tags", zap.Strings("tags", tags))
+	if len(tags) == 0 {
+		_, err := c.doHostTagsRequest(ctx, http.MethodDelete, nil)
+		return err
+	}
+
+	var buff bytes.Buffer
+	err := json.NewEncoder(&buff).Encode(&HostTags{
+		Host: c.conf.Host,
+		Tags: tags,
+	})
+	if err != nil {
+		return err
+	}
+	_, err = c.doHostTagsRequest(ctx, http.MethodPut, &buff)
+	return err
+}
+
+// normalizeHostTags returns the sorted and deduplicated tags following the Datadog rules, like the tags returned by Datadog
+func normalizeHostTags(tags []string) []string {
+	set := make(map[string]struct{}, len(tags))
+	normalized := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = tagger.NormaliseTag(tag)
+		if _, ok := set[tag]; ok || tag == "" {
+			continue
+		}
+		set[tag] = struct{}{}
+		normalized = append(normalized, tag)
+	}
+	sort.Strings(normalized)
+	return normalized
+}
+
+func equalHostTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// ReconcileHostTags updates the host tags only when they differ from the given ones
+// it returns true if the tags were updated
+func (c *Client) ReconcileHostTags(ctx context.Context, tags []string) (bool, error) {
+	current, err := c.GetHostTags(ctx)
+	if err != nil {
+		return false, err
+	}
+	desired := normalizeHostTags(tags)
+	if equalHostTags(normalizeHostTags(current), desired) {
+		return false, nil
+	}
+	err = c.UpdateHostTags(ctx, desired)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RunHostTagsSync periodically reconciles the host tags returned by the tags function until the context is done
+// the failed reconciliations are retried with an exponential backoff bounded by the interval
+// the APP key is checked at each interval, it can be added later by the rotation of the key files
+func (c *Client) RunHostTagsSync(ctx context.Context, tags func() []string, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultHostTagsSyncInterval
+	}
+	zctx := zap.L().With(
+		zap.Duration("hostTagsSyncInterval", interval),
+	)
+	backoff := hostTagsMinBackoff
+	skipped := false
+	for {
+		if c.credentials().appKey == "" {
+			if !skipped {
+				zctx.Info("no APP key, skipping host tags sync")
+				skipped = true
+			}
+			timer := time.NewTimer(interval)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			continue
+		}
+		skipped = false
+		desired := tags()
+		ctxTimeout, cancel := context.WithTimeout(ctx, time.Second*15)
+		updated, err := c.ReconcileHostTags(ctxTimeout, desired)
+		cancel()
+
+		wait := interval
+		c.Stats.Lock()
+		if err != nil {
+			c.Stats.HostTagsErrors++
+			c.Stats.HostTagsSynced = 0
+		} else {
+			c.Stats.HostTagsSynced = 1
+			if updated {
+				c.Stats.HostTagsUpdates++
+			}
+		}
+		c.Stats.Unlock()
+
+		switch {
+		case err != nil && ctx.Err() == nil:
+			wait = backoff
+			if backoff < interval {
+				backoff *= 2
+			}
+			if wait > interval {
+				wait = interval
+			}
+			zctx.Error("failed to sync host tags", zap.Error(err), zap.Duration("retryIn", wait))
+		case err == nil && updated:
+			backoff = hostTagsMinBackoff
+			zctx.Info("successfully updated host tags", zap.Strings("hostTags", desired))
+		case err == nil:
+			backoff = hostTagsMinBackoff
+			zctx.Debug("host tags in sync")
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
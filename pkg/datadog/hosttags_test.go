@@ -0,0 +1,162 @@
+package datadog
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeHostTagsAPI struct {
+	mu      sync.Mutex
+	tags    []string
+	methods []string
+	fail    int
+}
+
+func (f *fakeHostTagsAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.methods = append(f.methods, r.Method)
+	if r.URL.Query().Get("source") != hostTagsSource || r.Header.Get("DD-APPLICATION-KEY") == "" {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	if f.fail > 0 {
+		f.fail--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		if f.tags == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(&HostTags{Tags: f.tags})
+	case http.MethodPut:
+		h := &HostTags{}
+		_ = json.NewDecoder(r.Body).Decode(h)
+		f.tags = h.Tags
+		w.WriteHeader(http.StatusCreated)
+		_ = json.NewEncoder(w).Encode(h)
+	case http.MethodDelete:
+		f.tags = nil
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+func (f *fakeHostTagsAPI) requests() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	methods := f.methods
+	f.methods = nil
+	return methods
+}
+
+func newHostTagsClient(t *testing.T, api *fakeHostTagsAPI) *Client {
+	return newHostTagsClientWithAPPKey(t, api, "app-key-12345678")
+}
+
+func newHostTagsClientWithAPPKey(t *testing.T, api *fakeHostTagsAPI, appKey string) *Client {
+	srv := httptest.NewServer(api)
+	t.Cleanup(srv.Close)
+	c := NewClient(&Config{
+		Host:          "router",
+		DatadogAPIKey: "api-key-12345678",
+		DatadogAPPKey: appKey,
+	})
+	c.hostTagsURL = srv.URL + hostTagsPath + "router?source=" + hostTagsSource
+	return c
+}
+
+func TestReconcileHostTags(t *testing.T) {
+	api := &fakeHostTagsAPI{}
+	c := newHostTagsClient(t, api)
+	ctx := context.Background()
+
+	updated, err := c.ReconcileHostTags(ctx, []string{"role:router", "env:home", "role:router"})
+	require.NoError(t, err)
+	assert.True(t, updated)
+	assert.Equal(t, []string{http.MethodGet, http.MethodPut}, api.requests())
+	assert.Equal(t, []string{"env:home", "role:router"}, api.tags)
+
+	// unordered but identical
+	updated, err = c.ReconcileHostTags(ctx, []string{"role:router", "env:home"})
+	require.NoError(t, err)
+	assert.False(t, updated)
+	assert.Equal(t, []string{http.MethodGet}, api.requests())
+
+	updated, err = c.ReconcileHostTags(ctx, nil)
+	require.NoError(t, err)
+	assert.True(t, updated)
+	assert.Equal(t, []string{http.MethodGet, http.MethodDelete}, api.requests())
+	assert.Nil(t, api.tags)
+
+	// Datadog returns the lowercased tags
+	updated, err = c.ReconcileHostTags(ctx, []string{"Env:Prod", "role:router"})
+	require.NoError(t, err)
+	assert.True(t, updated)
+	assert.Equal(t, []string{"env:prod", "role:router"}, api.tags)
+	api.requests()
+	updated, err = c.ReconcileHostTags(ctx, []string{"role:router", "Env:Prod"})
+	require.NoError(t, err)
+	assert.False(t, updated)
+	assert.Equal(t, []string{http.MethodGet}, api.requests())
+
+	api.fail = 1
+	_, err = c.ReconcileHostTags(ctx, []string{"env:home"})
+	assert.Error(t, err)
+}
+
+func TestRunHostTagsSync(t *testing.T) {
+	api := &fakeHostTagsAPI{}
+	c := newHostTagsClient(t, api)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.RunHostTagsSync(ctx, func() []string { return []string{"env:home"} }, time.Hour)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool {
+		c.Stats.RLock()
+		defer c.Stats.RUnlock()
+		return c.Stats.HostTagsSynced == 1
+	}, time.Second*5, time.Millisecond*10)
+	cancel()
+	<-done
+	assert.Equal(t, float64(1), c.Stats.HostTagsUpdates)
+	assert.Equal(t, float64(0), c.Stats.HostTagsErrors)
+}
+
+func TestRunHostTagsSyncLateAPPKey(t *testing.T) {
+	api := &fakeHostTagsAPI{}
+	c := newHostTagsClientWithAPPKey(t, api, "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		c.RunHostTagsSync(ctx, func() []string { return []string{"env:home"} }, time.Millisecond*20)
+		close(done)
+	}()
+	time.Sleep(time.Millisecond * 50)
+	assert.Empty(t, api.requests())
+
+	// added by the rotation of the key files
+	c.SetCredentials("api-key-12345678", "app-key-12345678")
+	assert.Eventually(t, func() bool {
+		c.Stats.RLock()
+		defer c.Stats.RUnlock()
+		return c.Stats.HostTagsSynced == 1
+	}, time.Second*5, time.Millisecond*10)
+	cancel()
+	<-done
+	assert.Equal(t, float64(0), c.Stats.HostTagsErrors)
+}
@@ -0,0 +1,47 @@
+package datadog
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func marshalNoHTMLEscape(t *testing.T, v interface{}) string {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	require.NoError(t, enc.Encode(v))
+	return string(bytes.TrimSuffix(b.Bytes(), []byte("\n")))
+}
+
+func TestAppendJSONString(t *testing.T) {
+	for _, s := range []string{
+		"",
+		"lease:my-phone",
+		`quote:"`,
+		`backslash:\`,
+		"ssid:café",
+		"ctrl:\x01\x1f\n\r\t",
+		"invalid:\xff\xfe",
+		"html:<a&b>",
+	} {
+		t.Run(s, func(t *testing.T) {
+			assert.Equal(t, marshalNoHTMLEscape(t, s), string(appendJSONString(nil, s)))
+		})
+	}
+}
+
+func TestAppendJSONFloat(t *testing.T) {
+	for _, f := range []float64{
+		0, 1, -1, 12.5, 1600000000, 1e20, 1e21, 1.5e-7, -2.25e-9, 0.000001, math.MaxFloat64,
+	} {
+		assert.Equal(t, marshalNoHTMLEscape(t, f), string(appendJSONFloat(nil, f)))
+	}
+	assert.False(t, validJSONFloat(math.NaN()))
+	assert.False(t, validJSONFloat(math.Inf(-1)))
+	assert.True(t, validJSONFloat(0))
+}
@@ -0,0 +1,179 @@
+package datadog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/JulienBalestra/monitoring/pkg/metrics"
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultMetadataSyncInterval = time.Hour * 6
+
+	metricMetadataPath = "/api/v1/metrics/"
+)
+
+// MetricMetadata is the payload of https://docs.datadoghq.com/api/latest/metrics/#get-metric-metadata
+type MetricMetadata struct {
+	Type        string `json:"type,omitempty"`
+	Unit        string `json:"unit,omitempty"`
+	PerUnit     string `json:"per_unit,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// matches compares the fields set in the registry, the ones it leaves empty are omitted from the updates
+// so the fields only set in Datadog, like a description from the UI, are kept
+func (m *MetricMetadata) matches(md *metrics.Metadata) bool {
+	return matchesField(m.Type, md.Type) &&
+		matchesField(m.Unit, md.Unit) &&
+		matchesField(m.PerUnit, md.PerUnit) &&
+		matchesField(m.Description, md.Description)
+}
+
+func matchesField(current, expected string) bool {
+	return expected == "" || current == expected
+}
+
+func (c *Client) metricMetadataURL(name string) string {
+	return c.conf.APIURL + metricMetadataPath + url.PathEscape(name)
+}
+
+func (c *Client) doMetadataRequest(ctx context.Context, method, name string, body io.Reader) (*MetricMetadata, error) {
+	creds := c.credentials()
+	req, err := http.NewRequestWithContext(ctx, method, c.metricMetadataURL(name), body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(contentType, typeApplicationJson)
+	req.Header.Set("DD-API-KEY", creds.apiKey)
+	req.Header.Set("DD-APPLICATION-KEY", creds.appKey)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound && method == http.MethodGet {
+		// the metric wasn't submitted yet, its metadata can't be edited
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil, nil
+	}
+	if resp.StatusCode >= 300 {
+		bodyBytes, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to %s metric metadata %q status code: %d %s", method, name, resp.StatusCode, string(bodyBytes))
+	}
+	m := &MetricMetadata{}
+	err = json.NewDecoder(resp.Body).Decode(m)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// GetMetricMetadata requires the APP key
+// it returns nil metadata when the metric is unknown to Datadog
+func (c *Client) GetMetricMetadata(ctx context.Context, name string) (*MetricMetadata, error) {
+	return c.doMetadataRequest(ctx, http.MethodGet, name, nil)
+}
+
+// UpdateMetricMetadata requires the APP key
+func (c *Client) UpdateMetricMetadata(ctx context.Context, name string, m *MetricMetadata) error {
+	var buff bytes.Buffer
+	err := json.NewEncoder(&buff).Encode(m)
+	if err != nil {
+		return err
+	}
+	_, err = c.doMetadataRequest(ctx, http.MethodPut, name, &buff)
+	return err
+}
+
+// ReconcileMetadata updates the Datadog metric metadata differing from the registry
+// a failing metric doesn't stop the others, it returns the number of updated metrics and the joined errors
+func (c *Client) ReconcileMetadata(ctx context.Context, registry *metrics.Registry) (int, error) {
+	updated := 0
+	var errs []error
+	for _, name := range registry.Names() {
+		if ctx.Err() != nil {
+			errs = append(errs, ctx.Err())
+			break
+		}
+		md, ok := registry.Get(name)
+		if !ok {
+			continue
+		}
+		ok, err := c.reconcileMetricMetadata(ctx, name, &md)
+		if err != nil {
+			zap.L().Warn("failed to sync metric metadata", zap.String("metric", name), zap.Error(err))
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			updated++
+		}
+	}
+	return updated, errors.Join(errs...)
+}
+
+// reconcileMetricMetadata returns true when the Datadog metadata of the metric was updated
+func (c *Client) reconcileMetricMetadata(ctx context.Context, name string, md *metrics.Metadata) (bool, error) {
+	current, err := c.GetMetricMetadata(ctx, name)
+	if err != nil {
+		return false, err
+	}
+	if current == nil || current.matches(md) {
+		return false, nil
+	}
+	zap.L().Debug("updating metric metadata", zap.String("metric", name), zap.Any("metadata", md))
+	err = c.UpdateMetricMetadata(ctx, name, &MetricMetadata{
+		Type:        md.Type,
+		Unit:        md.Unit,
+		PerUnit:     md.PerUnit,
+		Description: md.Description,
+	})
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RunMetadataSync periodically reconciles the registry with Datadog until the context is done
+func (c *Client) RunMetadataSync(ctx context.Context, registry *metrics.Registry, interval time.Duration) {
+	if c.credentials().appKey == "" {
+		zap.L().Info("no APP key, skipping metric metadata sync")
+		return
+	}
+	if interval <= 0 {
+		interval = DefaultMetadataSyncInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	zctx := zap.L().With(
+		zap.Duration("metadataSyncInterval", interval),
+		zap.Int("metrics", registry.Len()),
+	)
+	for {
+		updated, err := c.ReconcileMetadata(ctx, registry)
+		if err != nil && ctx.Err() == nil {
+			zctx.Error("failed to sync metric metadata", zap.Error(err), zap.Int("updated", updated))
+		} else if err == nil {
+			zctx.Info("successfully synced metric metadata", zap.Int("updated", updated))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
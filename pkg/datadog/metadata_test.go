@@ -0,0 +1,121 @@
+package datadog
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/JulienBalestra/monitoring/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReconcileMetadata(t *testing.T) {
+	mu := &sync.Mutex{}
+	puts := make(map[string]*MetricMetadata)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		name := strings.TrimPrefix(r.URL.Path, metricMetadataPath)
+		switch r.Method {
+		case http.MethodGet:
+			if name == "unknown" {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			_ = json.NewEncoder(w).Encode(&MetricMetadata{Type: metrics.TypeGauge})
+		case http.MethodPut:
+			m := &MetricMetadata{}
+			_ = json.NewDecoder(r.Body).Decode(m)
+			if m.Unit == "invalid" {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(`{"errors":["Invalid unit"]}`))
+				return
+			}
+			puts[name] = m
+			_ = json.NewEncoder(w).Encode(m)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewClient(&Config{
+		Host:          "router",
+		DatadogAPIKey: "api-key-12345678",
+		DatadogAPPKey: "app-key-12345678",
+		APIURL:        srv.URL,
+	})
+	registry := metrics.NewRegistry()
+	registry.Register("a.rejected", &metrics.Metadata{Type: metrics.TypeGauge, Unit: "invalid"})
+	registry.Register("b.synced", &metrics.Metadata{Type: metrics.TypeGauge})
+	registry.Register("c.updated", &metrics.Metadata{Type: metrics.TypeGauge, Unit: "byte"})
+	registry.Register("unknown", &metrics.Metadata{Type: metrics.TypeGauge, Unit: "byte"})
+
+	// the rejected metric doesn't stop the others
+	updated, err := c.ReconcileMetadata(context.Background(), registry)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "a.rejected")
+	assert.Equal(t, 1, updated)
+	assert.Equal(t, map[string]*MetricMetadata{
+		"c.updated": {Type: metrics.TypeGauge, Unit: "byte"},
+	}, puts)
+}
+
+func TestReconcileMetadataIdempotent(t *testing.T) {
+	mu := &sync.Mutex{}
+	current := map[string]*MetricMetadata{
+		// the description was set in the UI
+		"a.described": {Type: metrics.TypeGauge, Unit: "byte", Description: "set in the UI"},
+		"b.outdated":  {Type: metrics.TypeGauge, Unit: "bit"},
+	}
+	puts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		name := strings.TrimPrefix(r.URL.Path, metricMetadataPath)
+		m, ok := current[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if r.Method == http.MethodPut {
+			puts++
+			// like Datadog, only the fields of the payload are updated
+			update := &MetricMetadata{}
+			_ = json.NewDecoder(r.Body).Decode(update)
+			if update.Unit != "" {
+				m.Unit = update.Unit
+			}
+			if update.Description != "" {
+				m.Description = update.Description
+			}
+		}
+		_ = json.NewEncoder(w).Encode(m)
+	}))
+	defer srv.Close()
+
+	c := NewClient(&Config{
+		Host:          "router",
+		DatadogAPIKey: "api-key-12345678",
+		DatadogAPPKey: "app-key-12345678",
+		APIURL:        srv.URL,
+	})
+	registry := metrics.NewRegistry()
+	registry.Register("a.described", &metrics.Metadata{Type: metrics.TypeGauge, Unit: "byte"})
+	registry.Register("b.outdated", &metrics.Metadata{Type: metrics.TypeGauge, Unit: "byte"})
+
+	updated, err := c.ReconcileMetadata(context.Background(), registry)
+	require.NoError(t, err)
+	assert.Equal(t, 1, updated)
+	assert.Equal(t, 1, puts)
+
+	// the second reconciliation has nothing to update
+	updated, err = c.ReconcileMetadata(context.Background(), registry)
+	require.NoError(t, err)
+	assert.Equal(t, 0, updated)
+	assert.Equal(t, 1, puts)
+	assert.Equal(t, "set in the UI", current["a.described"].Description)
+}
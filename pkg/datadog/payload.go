@@ -0,0 +1,131 @@
+package datadog
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"sync"
+)
+
+const (
+	// DefaultCompressionLevel is the zlib default, the payloads are ~5% larger than with zlib.BestCompression
+	// for a third of its CPU time
+	DefaultCompressionLevel = 6
+
+	// buffers growing larger than this aren't kept between flushes
+	maxPooledBufferSize = 4 << 20
+)
+
+func ValidCompressionLevel(level int) error {
+	if level < zlib.BestSpeed || level > zlib.BestCompression {
+		return fmt.Errorf("invalid compression level %d, must be between %d and %d", level, zlib.BestSpeed, zlib.BestCompression)
+	}
+	return nil
+}
+
+// compressor reuses the zlib writers, the payload buffers and the encoding scratch buffers between flushes
+type compressor struct {
+	level int
+
+	writers  *sync.Pool
+	buffers  *sync.Pool
+	scratchs *sync.Pool
+}
+
+func newCompressor(level int) *compressor {
+	if ValidCompressionLevel(level) != nil {
+		level = DefaultCompressionLevel
+	}
+	return &compressor{
+		level:   level,
+		writers: &sync.Pool{},
+		buffers: &sync.Pool{
+			New: func() interface{} {
+				return &bytes.Buffer{}
+			},
+		},
+		scratchs: &sync.Pool{
+			New: func() interface{} {
+				b := make([]byte, 0, 4096)
+				return &b
+			},
+		},
+	}
+}
+
+func (c *compressor) putBuffer(buffer *bytes.Buffer) {
+	if buffer.Cap() > maxPooledBufferSize {
+		return
+	}
+	buffer.Reset()
+	c.buffers.Put(buffer)
+}
+
+// compress returns the compressed payload of the encoder along with its uncompressed size
+// the payload must be closed to release its buffer
+func (c *compressor) compress(encoder seriesEncoder, series SeriesIterator) (*payload, int, error) {
+	buffer := c.buffers.Get().(*bytes.Buffer)
+
+	var w *zlib.Writer
+	pooled := c.writers.Get()
+	if pooled != nil {
+		w = pooled.(*zlib.Writer)
+		w.Reset(buffer)
+	} else {
+		var err error
+		w, err = zlib.NewWriterLevel(buffer, c.level)
+		if err != nil {
+			c.putBuffer(buffer)
+			return nil, 0, err
+		}
+	}
+	counter := &countingWriter{w: w}
+
+	scratch := c.scratchs.Get().(*[]byte)
+	b, err := encoder.Encode(counter, series, (*scratch)[:0])
+	if cap(b) <= maxPooledBufferSize {
+		*scratch = b
+		c.scratchs.Put(scratch)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	c.writers.Put(w)
+	if err != nil {
+		c.putBuffer(buffer)
+		return nil, 0, err
+	}
+	return &payload{
+		Reader:     bytes.NewReader(buffer.Bytes()),
+		buffer:     buffer,
+		compressor: c,
+	}, counter.n, nil
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+// payload is a request body releasing its buffer once closed by the http.Transport
+type payload struct {
+	*bytes.Reader
+
+	once       sync.Once
+	buffer     *bytes.Buffer
+	compressor *compressor
+}
+
+func (p *payload) Close() error {
+	p.once.Do(func() {
+		p.compressor.putBuffer(p.buffer)
+	})
+	return nil
+}
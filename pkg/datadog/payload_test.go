@@ -0,0 +1,117 @@
+package datadog
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/JulienBalestra/monitoring/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newBenchmarkStore(n int) *metrics.AggregationStore {
+	store := metrics.NewAggregationStore()
+	now := float64(time.Now().Unix())
+	for i := 0; i < n; i++ {
+		store.Aggregate(&metrics.Series{
+			Metric: "network.conntrack.entries",
+			Points: [][]float64{
+				{now - 30, float64(i)},
+				{now, float64(i + 1)},
+			},
+			Type: metrics.TypeGauge,
+			Host: "router",
+			Tags: []string{
+				"collector:network-conntrack",
+				fmt.Sprintf("ip:192.168.1.%d", i%255),
+				fmt.Sprintf("dport:%d", i),
+				"lease:unknown",
+				"protocol:tcp",
+				"state:ESTABLISHED",
+			},
+		})
+	}
+	return store
+}
+
+func TestCompressorRoundTrip(t *testing.T) {
+	store := newBenchmarkStore(100)
+	c := newCompressor(DefaultCompressionLevel)
+	for i := 0; i < 2; i++ {
+		body, uncompressed, err := c.compress(&v1JSONEncoder{}, store.Each)
+		require.NoError(t, err)
+		r, err := zlib.NewReader(body)
+		require.NoError(t, err)
+		b, err := ioutil.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, body.Close())
+		assert.Equal(t, len(b), uncompressed)
+
+		payload := struct {
+			Series []metrics.Series `json:"series"`
+		}{}
+		require.NoError(t, json.Unmarshal(b, &payload))
+		assert.Len(t, payload.Series, 100)
+	}
+}
+
+func TestNewCompressorInvalidLevel(t *testing.T) {
+	assert.Equal(t, DefaultCompressionLevel, newCompressor(0).level)
+	assert.Equal(t, zlib.BestSpeed, newCompressor(zlib.BestSpeed).level)
+	assert.Error(t, ValidCompressionLevel(10))
+}
+
+// BenchmarkLegacyPayload is the encoding done before the streaming encoder, kept as a reference
+func BenchmarkLegacyPayload(b *testing.B) {
+	store := newBenchmarkStore(2000)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		var zb bytes.Buffer
+		w, err := zlib.NewWriterLevel(&zb, zlib.BestCompression)
+		require.NoError(b, err)
+		err = json.NewEncoder(w).Encode(struct {
+			Series []metrics.Series `json:"series"`
+		}{Series: store.Series()})
+		require.NoError(b, err)
+		require.NoError(b, w.Close())
+	}
+}
+
+func benchmarkCompressor(b *testing.B, encoder seriesEncoder, level int) {
+	store := newBenchmarkStore(2000)
+	c := newCompressor(level)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		body, _, err := c.compress(encoder, store.Each)
+		require.NoError(b, err)
+		_ = body.Close()
+	}
+}
+
+func BenchmarkV1Payload(b *testing.B) {
+	benchmarkCompressor(b, &v1JSONEncoder{}, DefaultCompressionLevel)
+}
+
+// BenchmarkV1PayloadBestCompression compares to BenchmarkLegacyPayload at the same level
+func BenchmarkV1PayloadBestCompression(b *testing.B) {
+	benchmarkCompressor(b, &v1JSONEncoder{}, zlib.BestCompression)
+}
+
+func BenchmarkV1PayloadBestSpeed(b *testing.B) {
+	benchmarkCompressor(b, &v1JSONEncoder{}, zlib.BestSpeed)
+}
+
+func BenchmarkV2Payload(b *testing.B) {
+	benchmarkCompressor(b, &v2JSONEncoder{}, DefaultCompressionLevel)
+}
+
+func BenchmarkV2ProtobufPayload(b *testing.B) {
+	benchmarkCompressor(b, &v2ProtobufEncoder{}, DefaultCompressionLevel)
+}
@@ -0,0 +1,413 @@
+package datadog
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/JulienBalestra/monitoring/pkg/metrics"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+const (
+	// SeriesAPIV1 is the historical https://docs.datadoghq.com/api/latest/metrics/#submit-metrics JSON endpoint
+	SeriesAPIV1 = "v1"
+	// SeriesAPIV2 is the /api/v2/series JSON endpoint
+	SeriesAPIV2 = "v2"
+	// SeriesAPIV2Protobuf is the /api/v2/series endpoint with the agent protobuf payload
+	SeriesAPIV2Protobuf = "v2-protobuf"
+
+	typeApplicationProtobuf = "application/x-protobuf"
+
+	resourceTypeHost   = "host"
+	resourceTypeDevice = "device"
+)
+
+// v2 MetricType enum
+const (
+	metricTypeUnspecified = 0
+	metricTypeCount       = 1
+	metricTypeRate        = 2
+	metricTypeGauge       = 3
+)
+
+// Origin is the metadata attached to every v2 series
+// https://github.com/DataDog/agent-payload/blob/master/proto/metrics/agent_payload.proto
+type Origin struct {
+	Product  uint32 `json:"origin_product,omitempty"`
+	Category uint32 `json:"origin_category,omitempty"`
+	Service  uint32 `json:"origin_service,omitempty"`
+}
+
+// SeriesIterator calls fn for every series to send
+type SeriesIterator func(fn func(*metrics.Series))
+
+// SliceIterator allows to send a slice of series
+func SliceIterator(series []metrics.Series) SeriesIterator {
+	return func(fn func(*metrics.Series)) {
+		for i := range series {
+			fn(&series[i])
+		}
+	}
+}
+
+type seriesEncoder interface {
+	ContentType() string
+	// Encode streams the payload into w, b is a scratch buffer returned to be reused
+	Encode(w io.Writer, series SeriesIterator, b []byte) ([]byte, error)
+}
+
+func ValidSeriesAPI(api string) error {
+	switch api {
+	case SeriesAPIV1, SeriesAPIV2, SeriesAPIV2Protobuf:
+		return nil
+	}
+	return fmt.Errorf("invalid series API %q, must be one of %s, %s, %s", api, SeriesAPIV1, SeriesAPIV2, SeriesAPIV2Protobuf)
+}
+
+func newSeriesEncoder(api string, origin *Origin, registry *metrics.Registry) seriesEncoder {
+	switch api {
+	case SeriesAPIV2:
+		return &v2JSONEncoder{origin: origin, registry: registry}
+	case SeriesAPIV2Protobuf:
+		return &v2ProtobufEncoder{origin: origin, registry: registry}
+	}
+	return &v1JSONEncoder{}
+}
+
+// unit of the series, falling back to the registered metadata
+func unit(registry *metrics.Registry, s *metrics.Series) string {
+	if s.Unit != "" || registry == nil {
+		return s.Unit
+	}
+	return registry.Unit(s.Metric)
+}
+
+func seriesPath(api string) string {
+	if api == SeriesAPIV2 || api == SeriesAPIV2Protobuf {
+		return "/api/v2/series"
+	}
+	return "/api/v1/series"
+}
+
+func metricTypeV2(t string) int32 {
+	switch t {
+	case metrics.TypeCount:
+		return metricTypeCount
+	case metrics.TypeRate:
+		return metricTypeRate
+	case metrics.TypeGauge, "":
+		// gauge is the default type
+		return metricTypeGauge
+	}
+	return metricTypeUnspecified
+}
+
+type v1JSONEncoder struct{}
+
+func (e *v1JSONEncoder) ContentType() string {
+	return typeApplicationJson
+}
+
+// Encode streams {"series":[...]} one series at a time
+func (e *v1JSONEncoder) Encode(w io.Writer, series SeriesIterator, b []byte) ([]byte, error) {
+	return encodeJSONSeries(w, series, b, func(b []byte, s *metrics.Series) []byte {
+		b = append(b, `{"metric":`...)
+		b = appendJSONString(b, s.Metric)
+		b = append(b, `,"points":[`...)
+		first := true
+		for _, p := range s.Points {
+			if !validJSONFloat(p[0]) || !validJSONFloat(p[1]) {
+				continue
+			}
+			if !first {
+				b = append(b, ',')
+			}
+			first = false
+			b = append(b, '[')
+			b = appendJSONFloat(b, p[0])
+			b = append(b, ',')
+			b = appendJSONFloat(b, p[1])
+			b = append(b, ']')
+		}
+		b = append(b, ']')
+		if s.Type != "" {
+			b = append(b, `,"type":`...)
+			b = appendJSONString(b, s.Type)
+		}
+		if s.Interval != 0 {
+			b = append(b, `,"interval":`...)
+			b = appendJSONFloat(b, s.Interval)
+		}
+		b = append(b, `,"host":`...)
+		b = appendJSONString(b, s.Host)
+		if s.Device != "" {
+			b = append(b, `,"device":`...)
+			b = appendJSONString(b, s.Device)
+		}
+		if len(s.Tags) > 0 {
+			b = append(b, `,"tags":`...)
+			b = appendJSONStrings(b, s.Tags)
+		}
+		return append(b, '}')
+	})
+}
+
+type v2JSONEncoder struct {
+	origin   *Origin
+	registry *metrics.Registry
+}
+
+func (e *v2JSONEncoder) ContentType() string {
+	return typeApplicationJson
+}
+
+func (e *v2JSONEncoder) appendMetadata(b []byte) []byte {
+	b = append(b, `,"metadata":{"origin":{`...)
+	first := true
+	for _, field := range []struct {
+		name  string
+		value uint32
+	}{
+		{"origin_product", e.origin.Product},
+		{"origin_category", e.origin.Category},
+		{"origin_service", e.origin.Service},
+	} {
+		if field.value == 0 {
+			continue
+		}
+		if !first {
+			b = append(b, ',')
+		}
+		first = false
+		b = appendJSONString(b, field.name)
+		b = append(b, ':')
+		b = strconv.AppendUint(b, uint64(field.value), 10)
+	}
+	return append(b, "}}"...)
+}
+
+func appendResourceV2(b []byte, name, resourceType string) []byte {
+	b = append(b, `{"name":`...)
+	b = appendJSONString(b, name)
+	b = append(b, `,"type":`...)
+	b = appendJSONString(b, resourceType)
+	return append(b, '}')
+}
+
+func (e *v2JSONEncoder) Encode(w io.Writer, series SeriesIterator, b []byte) ([]byte, error) {
+	return encodeJSONSeries(w, series, b, func(b []byte, s *metrics.Series) []byte {
+		b = append(b, `{"metric":`...)
+		b = appendJSONString(b, s.Metric)
+		b = append(b, `,"type":`...)
+		b = strconv.AppendInt(b, int64(metricTypeV2(s.Type)), 10)
+		b = append(b, `,"points":[`...)
+		first := true
+		for _, p := range s.Points {
+			if !validJSONFloat(p[0]) || !validJSONFloat(p[1]) {
+				continue
+			}
+			if !first {
+				b = append(b, ',')
+			}
+			first = false
+			b = append(b, `{"timestamp":`...)
+			b = strconv.AppendInt(b, int64(p[0]), 10)
+			b = append(b, `,"value":`...)
+			b = appendJSONFloat(b, p[1])
+			b = append(b, '}')
+		}
+		b = append(b, ']')
+		if s.Interval != 0 {
+			b = append(b, `,"interval":`...)
+			b = strconv.AppendInt(b, int64(s.Interval), 10)
+		}
+		u := unit(e.registry, s)
+		if u != "" {
+			b = append(b, `,"unit":`...)
+			b = appendJSONString(b, u)
+		}
+		if s.Host != "" || s.Device != "" {
+			b = append(b, `,"resources":[`...)
+			if s.Host != "" {
+				b = appendResourceV2(b, s.Host, resourceTypeHost)
+			}
+			if s.Device != "" {
+				if s.Host != "" {
+					b = append(b, ',')
+				}
+				b = appendResourceV2(b, s.Device, resourceTypeDevice)
+			}
+			b = append(b, ']')
+		}
+		if len(s.Tags) > 0 {
+			b = append(b, `,"tags":`...)
+			b = appendJSONStrings(b, s.Tags)
+		}
+		if e.origin != nil {
+			b = e.appendMetadata(b)
+		}
+		return append(b, '}')
+	})
+}
+
+// encodeJSONSeries appends each series in the scratch buffer b before writing it to w
+func encodeJSONSeries(w io.Writer, series SeriesIterator, b []byte, appendSeries func([]byte, *metrics.Series) []byte) ([]byte, error) {
+	b = append(b[:0], `{"series":[`...)
+	var err error
+	first := true
+	series(func(s *metrics.Series) {
+		if err != nil {
+			return
+		}
+		if !first {
+			b = append(b, ',')
+		}
+		first = false
+		b = appendSeries(b, s)
+		_, err = w.Write(b)
+		b = b[:0]
+	})
+	if err != nil {
+		return b, err
+	}
+	b = append(b, "]}"...)
+	_, err = w.Write(b)
+	return b[:0], err
+}
+
+// MetricPayload field numbers
+const (
+	fieldPayloadSeries = 1
+
+	fieldSeriesResources = 1
+	fieldSeriesMetric    = 2
+	fieldSeriesTags      = 3
+	fieldSeriesPoints    = 4
+	fieldSeriesType      = 5
+	fieldSeriesUnit      = 6
+	fieldSeriesInterval  = 8
+	fieldSeriesMetadata  = 9
+
+	fieldResourceType = 1
+	fieldResourceName = 2
+
+	fieldPointValue     = 1
+	fieldPointTimestamp = 2
+
+	fieldMetadataOrigin = 1
+
+	fieldOriginProduct  = 4
+	fieldOriginCategory = 5
+	fieldOriginService  = 6
+)
+
+type v2ProtobufEncoder struct {
+	origin   *Origin
+	registry *metrics.Registry
+}
+
+func (e *v2ProtobufEncoder) ContentType() string {
+	return typeApplicationProtobuf
+}
+
+// Encode writes every MetricSeries as a length delimited repeated field of the MetricPayload
+// the top level message doesn't need any framing so each series is written as soon as it's encoded
+func (e *v2ProtobufEncoder) Encode(w io.Writer, series SeriesIterator, b []byte) ([]byte, error) {
+	var err error
+	var message []byte
+	series(func(s *metrics.Series) {
+		if err != nil {
+			return
+		}
+		// the tag and length are written apart to not copy the series in another buffer
+		b = e.appendSeries(b[:0], s)
+		message = protowire.AppendTag(message[:0], fieldPayloadSeries, protowire.BytesType)
+		message = protowire.AppendVarint(message, uint64(len(b)))
+		_, err = w.Write(message)
+		if err != nil {
+			return
+		}
+		_, err = w.Write(b)
+	})
+	return b[:0], err
+}
+
+func appendResource(b []byte, resourceType, name string) []byte {
+	size := protowire.SizeTag(fieldResourceType) + protowire.SizeBytes(len(resourceType)) +
+		protowire.SizeTag(fieldResourceName) + protowire.SizeBytes(len(name))
+	b = protowire.AppendTag(b, fieldSeriesResources, protowire.BytesType)
+	b = protowire.AppendVarint(b, uint64(size))
+	b = protowire.AppendTag(b, fieldResourceType, protowire.BytesType)
+	b = protowire.AppendString(b, resourceType)
+	b = protowire.AppendTag(b, fieldResourceName, protowire.BytesType)
+	return protowire.AppendString(b, name)
+}
+
+func sizeUint32Field(num protowire.Number, v uint32) int {
+	if v == 0 {
+		return 0
+	}
+	return protowire.SizeTag(num) + protowire.SizeVarint(uint64(v))
+}
+
+// the sub messages are small enough to compute their size before appending them, avoiding any temporary buffer
+func (e *v2ProtobufEncoder) appendSeries(b []byte, s *metrics.Series) []byte {
+	if s.Host != "" {
+		b = appendResource(b, resourceTypeHost, s.Host)
+	}
+	if s.Device != "" {
+		b = appendResource(b, resourceTypeDevice, s.Device)
+	}
+	b = protowire.AppendTag(b, fieldSeriesMetric, protowire.BytesType)
+	b = protowire.AppendString(b, s.Metric)
+	for _, tag := range s.Tags {
+		b = protowire.AppendTag(b, fieldSeriesTags, protowire.BytesType)
+		b = protowire.AppendString(b, tag)
+	}
+	for _, p := range s.Points {
+		ts := uint64(int64(p[0]))
+		size := protowire.SizeTag(fieldPointValue) + protowire.SizeFixed64() +
+			protowire.SizeTag(fieldPointTimestamp) + protowire.SizeVarint(ts)
+		b = protowire.AppendTag(b, fieldSeriesPoints, protowire.BytesType)
+		b = protowire.AppendVarint(b, uint64(size))
+		b = protowire.AppendTag(b, fieldPointValue, protowire.Fixed64Type)
+		b = protowire.AppendFixed64(b, math.Float64bits(p[1]))
+		b = protowire.AppendTag(b, fieldPointTimestamp, protowire.VarintType)
+		b = protowire.AppendVarint(b, ts)
+	}
+	b = protowire.AppendTag(b, fieldSeriesType, protowire.VarintType)
+	b = protowire.AppendVarint(b, uint64(metricTypeV2(s.Type)))
+	u := unit(e.registry, s)
+	if u != "" {
+		b = protowire.AppendTag(b, fieldSeriesUnit, protowire.BytesType)
+		b = protowire.AppendString(b, u)
+	}
+	if s.Interval > 0 {
+		b = protowire.AppendTag(b, fieldSeriesInterval, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(int64(s.Interval)))
+	}
+	if e.origin != nil {
+		originSize := sizeUint32Field(fieldOriginProduct, e.origin.Product) +
+			sizeUint32Field(fieldOriginCategory, e.origin.Category) +
+			sizeUint32Field(fieldOriginService, e.origin.Service)
+		metadataSize := protowire.SizeTag(fieldMetadataOrigin) + protowire.SizeBytes(originSize)
+		b = protowire.AppendTag(b, fieldSeriesMetadata, protowire.BytesType)
+		b = protowire.AppendVarint(b, uint64(metadataSize))
+		b = protowire.AppendTag(b, fieldMetadataOrigin, protowire.BytesType)
+		b = protowire.AppendVarint(b, uint64(originSize))
+		b = appendUint32Field(b, fieldOriginProduct, e.origin.Product)
+		b = appendUint32Field(b, fieldOriginCategory, e.origin.Category)
+		b = appendUint32Field(b, fieldOriginService, e.origin.Service)
+	}
+	return b
+}
+
+func appendUint32Field(b []byte, num protowire.Number, v uint32) []byte {
+	if v == 0 {
+		return b
+	}
+	b = protowire.AppendTag(b, num, protowire.VarintType)
+	return protowire.AppendVarint(b, uint64(v))
+}
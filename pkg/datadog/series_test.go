@@ -0,0 +1,194 @@
+package datadog
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"testing"
+
+	"github.com/JulienBalestra/monitoring/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+type pointV2 struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+type resourceV2 struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+type metadataV2 struct {
+	Origin *Origin `json:"origin,omitempty"`
+}
+
+type seriesV2 struct {
+	Metric    string       `json:"metric"`
+	Type      int32        `json:"type"`
+	Points    []pointV2    `json:"points"`
+	Interval  int64        `json:"interval,omitempty"`
+	Unit      string       `json:"unit,omitempty"`
+	Resources []resourceV2 `json:"resources,omitempty"`
+	Tags      []string     `json:"tags,omitempty"`
+	Metadata  *metadataV2  `json:"metadata,omitempty"`
+}
+
+func encode(t *testing.T, encoder seriesEncoder, series []metrics.Series) *bytes.Buffer {
+	var b bytes.Buffer
+	_, err := encoder.Encode(&b, SliceIterator(series), nil)
+	require.NoError(t, err)
+	return &b
+}
+
+var testSeries = []metrics.Series{
+	{
+		Metric: "power.current",
+		Points: [][]float64{
+			{1600000000, 12.5},
+			{1600000005, 13},
+		},
+		Type:   metrics.TypeGauge,
+		Host:   "host",
+		Device: "eth0",
+		Tags:   []string{"meter:0", "ip:192.168.1.2"},
+		Unit:   "watt",
+	},
+	{
+		Metric:   "power.total",
+		Points:   [][]float64{{1600000010, 3}},
+		Type:     metrics.TypeCount,
+		Interval: 10,
+		Host:     "host",
+	},
+}
+
+func TestV1JSONEncoder(t *testing.T) {
+	b := encode(t, newSeriesEncoder(SeriesAPIV1, nil, nil), testSeries)
+
+	payload := struct {
+		Series []metrics.Series `json:"series"`
+	}{}
+	require.NoError(t, json.Unmarshal(b.Bytes(), &payload))
+	expected := make([]metrics.Series, len(testSeries))
+	copy(expected, testSeries)
+	// v1 doesn't support units
+	expected[0].Unit = ""
+	assert.Equal(t, expected, payload.Series)
+}
+
+func TestV1JSONEncoderEmpty(t *testing.T) {
+	b := encode(t, newSeriesEncoder(SeriesAPIV1, nil, nil), nil)
+	assert.Equal(t, `{"series":[]}`, b.String())
+}
+
+func TestV2JSONEncoder(t *testing.T) {
+	origin := &Origin{Product: 10, Service: 3}
+	registry := metrics.NewRegistry()
+	registry.Register("power.total", &metrics.Metadata{Unit: "watt"})
+	b := encode(t, newSeriesEncoder(SeriesAPIV2, origin, registry), testSeries)
+
+	payload := struct {
+		Series []seriesV2 `json:"series"`
+	}{}
+	require.NoError(t, json.Unmarshal(b.Bytes(), &payload))
+	assert.Equal(t, []seriesV2{
+		{
+			Metric: "power.current",
+			Type:   metricTypeGauge,
+			Points: []pointV2{
+				{Timestamp: 1600000000, Value: 12.5},
+				{Timestamp: 1600000005, Value: 13},
+			},
+			Unit: "watt",
+			Resources: []resourceV2{
+				{Name: "host", Type: resourceTypeHost},
+				{Name: "eth0", Type: resourceTypeDevice},
+			},
+			Tags:     []string{"meter:0", "ip:192.168.1.2"},
+			Metadata: &metadataV2{Origin: origin},
+		},
+		{
+			Metric:   "power.total",
+			Type:     metricTypeCount,
+			Points:   []pointV2{{Timestamp: 1600000010, Value: 3}},
+			Interval: 10,
+			Unit:     "watt",
+			Resources: []resourceV2{
+				{Name: "host", Type: resourceTypeHost},
+			},
+			Metadata: &metadataV2{Origin: origin},
+		},
+	}, payload.Series)
+}
+
+// consumeFields decodes one level of a protobuf message into its fields
+func consumeFields(t *testing.T, b []byte) map[protowire.Number][][]byte {
+	fields := make(map[protowire.Number][][]byte)
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		require.True(t, n > 0)
+		b = b[n:]
+		var v []byte
+		switch typ {
+		case protowire.BytesType:
+			v, n = protowire.ConsumeBytes(b)
+		case protowire.VarintType:
+			var u uint64
+			u, n = protowire.ConsumeVarint(b)
+			v = protowire.AppendVarint(nil, u)
+		case protowire.Fixed64Type:
+			var u uint64
+			u, n = protowire.ConsumeFixed64(b)
+			v = protowire.AppendFixed64(nil, u)
+		default:
+			t.Fatalf("unexpected wire type %d", typ)
+		}
+		require.True(t, n > 0)
+		b = b[n:]
+		fields[num] = append(fields[num], v)
+	}
+	return fields
+}
+
+func varint(t *testing.T, b []byte) uint64 {
+	v, n := protowire.ConsumeVarint(b)
+	require.True(t, n > 0)
+	return v
+}
+
+func TestV2ProtobufEncoder(t *testing.T) {
+	b := encode(t, newSeriesEncoder(SeriesAPIV2Protobuf, &Origin{Product: 10}, nil), testSeries)
+
+	payload := consumeFields(t, b.Bytes())
+	require.Len(t, payload[fieldPayloadSeries], 2)
+
+	first := consumeFields(t, payload[fieldPayloadSeries][0])
+	assert.Equal(t, "power.current", string(first[fieldSeriesMetric][0]))
+	assert.Equal(t, "watt", string(first[fieldSeriesUnit][0]))
+	assert.Equal(t, uint64(metricTypeGauge), varint(t, first[fieldSeriesType][0]))
+	require.Len(t, first[fieldSeriesTags], 2)
+	assert.Equal(t, "meter:0", string(first[fieldSeriesTags][0]))
+	require.Len(t, first[fieldSeriesResources], 2)
+	device := consumeFields(t, first[fieldSeriesResources][1])
+	assert.Equal(t, resourceTypeDevice, string(device[fieldResourceType][0]))
+	assert.Equal(t, "eth0", string(device[fieldResourceName][0]))
+	require.Len(t, first[fieldSeriesPoints], 2)
+	point := consumeFields(t, first[fieldSeriesPoints][1])
+	value, _ := protowire.ConsumeFixed64(point[fieldPointValue][0])
+	assert.Equal(t, 13., math.Float64frombits(value))
+	assert.Equal(t, uint64(1600000005), varint(t, point[fieldPointTimestamp][0]))
+	metadata := consumeFields(t, first[fieldSeriesMetadata][0])
+	origin := consumeFields(t, metadata[fieldMetadataOrigin][0])
+	assert.Equal(t, uint64(10), varint(t, origin[fieldOriginProduct][0]))
+	assert.Empty(t, origin[fieldOriginService])
+
+	second := consumeFields(t, payload[fieldPayloadSeries][1])
+	assert.Equal(t, "power.total", string(second[fieldSeriesMetric][0]))
+	assert.Equal(t, uint64(metricTypeCount), varint(t, second[fieldSeriesType][0]))
+	assert.Equal(t, uint64(10), varint(t, second[fieldSeriesInterval][0]))
+	assert.Empty(t, second[fieldSeriesUnit])
+}
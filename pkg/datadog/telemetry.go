@@ -0,0 +1,118 @@
+package datadog
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	EndpointSeries        = "series"
+	EndpointLogs          = "logs"
+	EndpointHostTags      = "host_tags"
+	EndpointMetadata      = "metadata"
+	EndpointEvents        = "events"
+	EndpointServiceChecks = "service_checks"
+	EndpointValidate      = "validate"
+	EndpointOther         = "other"
+)
+
+const (
+	QueueSeries        = "series"
+	QueueEvents        = "events"
+	QueueServiceChecks = "service_checks"
+	// QueueStore are the aggregated series waiting for the next flush
+	QueueStore = "store"
+)
+
+// LatencyBuckets are the upper bounds in seconds of the request latency histograms
+var LatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// EndpointMetrics are the HTTP telemetry of an endpoint like EndpointSeries
+type EndpointMetrics struct {
+	Requests float64
+	// StatusCodes counts the responses by status code, the transport errors like timeouts use the status code 0
+	StatusCodes map[int]float64
+	// LatencyBuckets is the cumulative count of the requests lower or equal to the LatencyBuckets of the same index
+	// the additional last bucket counts every request
+	LatencyBuckets []float64
+	LatencySeconds float64
+}
+
+func endpointOf(path string) string {
+	switch {
+	case strings.HasSuffix(path, "/series"):
+		return EndpointSeries
+	case path == logsPath:
+		return EndpointLogs
+	case strings.HasPrefix(path, hostTagsPath):
+		return EndpointHostTags
+	case strings.HasPrefix(path, metricMetadataPath):
+		return EndpointMetadata
+	case path == eventsPath:
+		return EndpointEvents
+	case path == serviceChecksPath:
+		return EndpointServiceChecks
+	case path == validatePath:
+		return EndpointValidate
+	}
+	return EndpointOther
+}
+
+// observe records a request, statusCode is 0 when the request failed without response
+func (m *ClientMetrics) observe(endpoint string, statusCode int, latency time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+	if m.Endpoints == nil {
+		m.Endpoints = make(map[string]*EndpointMetrics)
+	}
+	e, ok := m.Endpoints[endpoint]
+	if !ok {
+		e = &EndpointMetrics{
+			StatusCodes:    make(map[int]float64),
+			LatencyBuckets: make([]float64, len(LatencyBuckets)+1),
+		}
+		m.Endpoints[endpoint] = e
+	}
+	seconds := latency.Seconds()
+	e.Requests++
+	e.StatusCodes[statusCode]++
+	e.LatencySeconds += seconds
+	for i, le := range LatencyBuckets {
+		if seconds <= le {
+			e.LatencyBuckets[i]++
+		}
+	}
+	e.LatencyBuckets[len(LatencyBuckets)]++
+}
+
+// telemetryTransport records the latency and the status code of every request
+// the latency is measured until the headers of the response
+type telemetryTransport struct {
+	next  http.RoundTripper
+	stats *ClientMetrics
+}
+
+func (t *telemetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	statusCode := 0
+	if err == nil {
+		statusCode = resp.StatusCode
+	}
+	t.stats.observe(endpointOf(req.URL.Path), statusCode, time.Since(start))
+	return resp, err
+}
+
+// QueueDepth returns the number of items waiting in each queue like QueueSeries
+func (c *Client) QueueDepth() map[string]float64 {
+	c.Stats.RLock()
+	pendingSeries := c.Stats.PendingSeries
+	c.Stats.RUnlock()
+	return map[string]float64{
+		QueueSeries:        float64(len(c.ChanSeries)),
+		QueueEvents:        float64(len(c.ChanEvents)),
+		QueueServiceChecks: float64(len(c.ChanServiceChecks)),
+		QueueStore:         pendingSeries,
+	}
+}
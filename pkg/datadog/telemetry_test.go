@@ -0,0 +1,87 @@
+package datadog
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/JulienBalestra/monitoring/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpointOf(t *testing.T) {
+	for path, endpoint := range map[string]string{
+		"/api/v1/series":               EndpointSeries,
+		"/api/v2/series":               EndpointSeries,
+		"/api/v2/logs":                 EndpointLogs,
+		"/api/v1/tags/hosts/router":    EndpointHostTags,
+		"/api/v1/metrics/network.arp":  EndpointMetadata,
+		"/api/v1/events":               EndpointEvents,
+		"/api/v1/check_run":            EndpointServiceChecks,
+		"/api/v1/validate":             EndpointValidate,
+		"/api/v1/query":                EndpointOther,
+		"/api/v1/tags/hosts-not-quite": EndpointOther,
+	} {
+		t.Run(path, func(t *testing.T) {
+			assert.Equal(t, endpoint, endpointOf(path))
+		})
+	}
+}
+
+func TestSeriesTelemetry(t *testing.T) {
+	status := http.StatusAccepted
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer srv.Close()
+
+	c := NewClient(&Config{
+		DatadogAPIKey: "api-key-12345678",
+		APIURL:        srv.URL,
+		SeriesAPI:     SeriesAPIV2,
+	})
+	started := c.Stats.LastSuccessfulFlush
+	require.False(t, started.IsZero())
+
+	series := []metrics.Series{
+		{Metric: "network.arp", Points: [][]float64{{1600000000, 1}, {1600000015, 2}}, Type: metrics.TypeGauge, Host: "router"},
+		{Metric: "network.bytes", Points: [][]float64{{1600000000, 42}}, Type: metrics.TypeCount, Host: "router"},
+	}
+	ctx := context.Background()
+	require.NoError(t, c.SendSeries(ctx, series))
+	status = http.StatusInternalServerError
+	require.Error(t, c.SendSeries(ctx, series))
+
+	c.Stats.RLock()
+	defer c.Stats.RUnlock()
+	assert.Equal(t, 3.0, c.Stats.SentPoints)
+	assert.Equal(t, 3.0, c.Stats.LastFlushPoints)
+	assert.Equal(t, 2.0, c.Stats.SentSeries)
+	assert.Greater(t, c.Stats.SentSeriesUncompressedBytes, 0.0)
+	assert.Greater(t, c.Stats.SentSeriesBytes, 0.0)
+	assert.False(t, c.Stats.LastSuccessfulFlush.Before(started))
+
+	e := c.Stats.Endpoints[EndpointSeries]
+	require.NotNil(t, e)
+	assert.Equal(t, 2.0, e.Requests)
+	assert.Equal(t, map[int]float64{http.StatusAccepted: 1, http.StatusInternalServerError: 1}, e.StatusCodes)
+	require.Len(t, e.LatencyBuckets, len(LatencyBuckets)+1)
+	assert.Equal(t, 2.0, e.LatencyBuckets[len(LatencyBuckets)])
+	for i := 1; i < len(e.LatencyBuckets); i++ {
+		assert.GreaterOrEqual(t, e.LatencyBuckets[i], e.LatencyBuckets[i-1])
+	}
+}
+
+func TestQueueDepth(t *testing.T) {
+	c := NewClient(&Config{ChanSize: 10})
+	c.ChanSeries <- metrics.Series{Metric: "network.arp"}
+	c.SubmitEvent(&Event{Title: "started"})
+	assert.Equal(t, map[string]float64{
+		QueueSeries:        1,
+		QueueEvents:        1,
+		QueueServiceChecks: 0,
+		QueueStore:         0,
+	}, c.QueueDepth())
+}
@@ -0,0 +1,177 @@
+package metrics
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/JulienBalestra/monitoring/pkg/state"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultBaselineMaxAge is the age after which a persisted baseline isn't used to compute a delta
+	DefaultBaselineMaxAge = time.Minute * 15
+
+	bootIDPath = "/proc/sys/kernel/random/boot_id"
+)
+
+// Baseline is the latest known value of a counter
+type Baseline struct {
+	Value float64   `json:"value"`
+	Time  time.Time `json:"time"`
+	// BootID of the host when the value was read
+	BootID string `json:"boot_id,omitempty"`
+}
+
+// BaselineStore persists the counter baselines of the Measures in a state file
+// so deltas can be computed across daemon restarts
+type BaselineStore struct {
+	mu *sync.RWMutex
+
+	path   string
+	maxAge time.Duration
+	bootID string
+
+	baselines map[uint64]*Baseline
+}
+
+// ReadBootID returns the boot ID of the host, empty if unavailable
+func ReadBootID() string {
+	b, err := ioutil.ReadFile(bootIDPath)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// NewBaselineStore loads the baselines of the state file if any
+func NewBaselineStore(path string, maxAge time.Duration) (*BaselineStore, error) {
+	if maxAge <= 0 {
+		maxAge = DefaultBaselineMaxAge
+	}
+	b := &BaselineStore{
+		mu:        &sync.RWMutex{},
+		path:      path,
+		maxAge:    maxAge,
+		bootID:    ReadBootID(),
+		baselines: make(map[uint64]*Baseline),
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return b, nil
+		}
+		return nil, err
+	}
+	persisted := make(map[string]*Baseline)
+	err = json.Unmarshal(data, &persisted)
+	if err != nil {
+		// a corrupted state must not prevent the daemon to start
+		zap.L().Warn("ignoring invalid counter baselines", zap.String("path", path), zap.Error(err))
+		return b, nil
+	}
+	for k, v := range persisted {
+		h, err := strconv.ParseUint(k, 10, 64)
+		if err != nil {
+			continue
+		}
+		b.baselines[h] = v
+	}
+	return b, nil
+}
+
+// Load returns the baseline of the counter, see Sample.BaselineTags, when it can be safely used at the given time
+// the baseline is rejected when too old, in the future or, if checkBootID, when the host rebooted since
+func (b *BaselineStore) Load(h uint64, now time.Time, checkBootID bool) (*Baseline, bool) {
+	b.mu.RLock()
+	baseline, ok := b.baselines[h]
+	b.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	age := now.Sub(baseline.Time)
+	if age <= 0 || age > b.maxAge {
+		return nil, false
+	}
+	if checkBootID && (baseline.BootID == "" || baseline.BootID != b.bootID) {
+		return nil, false
+	}
+	return baseline, true
+}
+
+func (b *BaselineStore) Store(h uint64, s *Sample) {
+	b.mu.Lock()
+	b.baselines[h] = &Baseline{
+		Value:  s.Value,
+		Time:   s.Time,
+		BootID: b.bootID,
+	}
+	b.mu.Unlock()
+}
+
+// Delete removes the baseline of the counter, like when its series isn't collected anymore
+func (b *BaselineStore) Delete(h uint64) {
+	b.mu.Lock()
+	delete(b.baselines, h)
+	b.mu.Unlock()
+}
+
+func (b *BaselineStore) Len() int {
+	b.mu.RLock()
+	l := len(b.baselines)
+	b.mu.RUnlock()
+	return l
+}
+
+// Save atomically writes the baselines still usable to the state file
+func (b *BaselineStore) Save() error {
+	persisted := make(map[string]*Baseline)
+	now := time.Now()
+	b.mu.Lock()
+	for h, baseline := range b.baselines {
+		if now.Sub(baseline.Time) > b.maxAge {
+			delete(b.baselines, h)
+			continue
+		}
+		persisted[strconv.FormatUint(h, 10)] = baseline
+	}
+	b.mu.Unlock()
+
+	data, err := json.Marshal(persisted)
+	if err != nil {
+		return err
+	}
+	return state.WriteFileAtomic(b.path, data, 0644)
+}
+
+// Run periodically saves the baselines until the context is done, then saves them a last time
+func (b *BaselineStore) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	zctx := zap.L().With(zap.String("path", b.path))
+	for {
+		select {
+		case <-ctx.Done():
+			err := b.Save()
+			if err != nil {
+				zctx.Error("failed to save counter baselines", zap.Error(err))
+				return
+			}
+			zctx.Info("saved counter baselines", zap.Int("baselines", b.Len()))
+			return
+
+		case <-ticker.C:
+			err := b.Save()
+			if err != nil {
+				zctx.Error("failed to save counter baselines", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newBaselineStore(t *testing.T, path string) *BaselineStore {
+	b, err := NewBaselineStore(path, time.Minute*15)
+	require.NoError(t, err)
+	// stable across hosts
+	b.bootID = "boot-1"
+	return b
+}
+
+func TestBaselineStoreRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counters.json")
+	now := time.Now()
+	sample := func(value float64, ts time.Time) *Sample {
+		return &Sample{
+			Name:  "power.total",
+			Value: value,
+			Time:  ts,
+			Host:  "host",
+			Tags:  []string{"meter:0"},
+		}
+	}
+
+	ch := make(chan Series, 10)
+	defer close(ch)
+	b := newBaselineStore(t, path)
+	m := NewMeasures(ch).WithBaselines(b, false)
+	require.NoError(t, m.Count(sample(10, now.Add(-time.Minute*2))))
+	require.NoError(t, m.Count(sample(15, now.Add(-time.Minute))))
+	require.Len(t, ch, 1)
+	<-ch
+	require.NoError(t, b.Save())
+
+	// restart
+	b = newBaselineStore(t, path)
+	assert.Equal(t, 1, b.Len())
+	m = NewMeasures(ch).WithBaselines(b, false)
+	require.NoError(t, m.Count(sample(18, now)))
+	require.Len(t, ch, 1)
+	assert.Equal(t, Series{
+		Metric:   "power.total",
+		Points:   [][]float64{{float64(now.Unix()), 3}},
+		Type:     TypeCount,
+		Interval: 60,
+		Host:     "host",
+		Tags:     []string{"meter:0"},
+	}, <-ch)
+
+	// restart after a reset of the source
+	require.NoError(t, b.Save())
+	b = newBaselineStore(t, path)
+	m = NewMeasures(ch).WithBaselines(b, false)
+	require.NoError(t, m.Count(sample(1, now.Add(time.Minute))))
+	assert.Len(t, ch, 0)
+	require.NoError(t, m.Count(sample(2, now.Add(time.Minute*2))))
+	assert.Len(t, ch, 1)
+}
+
+func TestBaselineTags(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counters.json")
+	now := time.Now()
+	sample := func(value float64, ts time.Time, lease string) *Sample {
+		return &Sample{
+			Name:         "power.total",
+			Value:        value,
+			Time:         ts,
+			Host:         "host",
+			Tags:         []string{"meter:0", "lease:" + lease},
+			BaselineTags: []string{"meter:0"},
+		}
+	}
+
+	ch := make(chan Series, 10)
+	defer close(ch)
+	b := newBaselineStore(t, path)
+	m := NewMeasures(ch).WithBaselines(b, false)
+	require.NoError(t, m.Count(sample(10, now.Add(-time.Minute), "unknown")))
+	require.NoError(t, b.Save())
+
+	// the tagger tags changed across the restart
+	b = newBaselineStore(t, path)
+	m = NewMeasures(ch).WithBaselines(b, false)
+	require.NoError(t, m.Count(sample(15, now, "laptop")))
+	require.Len(t, ch, 1)
+	assert.Equal(t, []float64{float64(now.Unix()), 5}, (<-ch).Points[0])
+
+	// the deleted series don't keep their baseline
+	m.Delete(sample(15, now, "laptop"))
+	assert.Equal(t, 0, b.Len())
+}
+
+func TestBaselineStoreLoad(t *testing.T) {
+	now := time.Now()
+	b := newBaselineStore(t, filepath.Join(t.TempDir(), "counters.json"))
+	b.Store(1, &Sample{Value: 1, Time: now.Add(-time.Minute)})
+	b.Store(2, &Sample{Value: 1, Time: now.Add(-time.Hour)})
+
+	_, ok := b.Load(1, now, true)
+	assert.True(t, ok)
+	_, ok = b.Load(3, now, true)
+	assert.False(t, ok)
+
+	// stale
+	_, ok = b.Load(2, now, false)
+	assert.False(t, ok)
+
+	// in the future
+	_, ok = b.Load(1, now.Add(-time.Hour), false)
+	assert.False(t, ok)
+
+	// rebooted
+	b.bootID = "boot-2"
+	_, ok = b.Load(1, now, true)
+	assert.False(t, ok)
+	_, ok = b.Load(1, now, false)
+	assert.True(t, ok)
+
+	// stale baselines aren't persisted
+	require.NoError(t, b.Save())
+	assert.Equal(t, 1, b.Len())
+}
+
+func TestBaselineStoreCorrupted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "counters.json")
+	require.NoError(t, ioutil.WriteFile(path, []byte("{"), 0644))
+	b := newBaselineStore(t, path)
+	assert.Equal(t, 0, b.Len())
+}
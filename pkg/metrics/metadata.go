@@ -0,0 +1,134 @@
+package metrics
+
+import (
+	"sort"
+	"sync"
+)
+
+// Metadata describes a metric as documented in Datadog
+// https://docs.datadoghq.com/api/latest/metrics/#edit-metric-metadata
+type Metadata struct {
+	Type        string `json:"type,omitempty" yaml:"type,omitempty"`
+	Unit        string `json:"unit,omitempty" yaml:"unit,omitempty"`
+	PerUnit     string `json:"per_unit,omitempty" yaml:"per_unit,omitempty"`
+	Description string `json:"description,omitempty" yaml:"description,omitempty"`
+
+	// TagKeys are the keys of the tags emitted with the metric, they are not synced to Datadog
+	TagKeys []string `json:"-" yaml:"tag_keys,omitempty"`
+}
+
+// Registry is a concurrent safe store of metric metadata indexed by metric name
+type Registry struct {
+	mu       *sync.RWMutex
+	metadata map[string]*Metadata
+}
+
+// DefaultRegistry is filled by the collectors at init time
+var DefaultRegistry = NewRegistry()
+
+func NewRegistry() *Registry {
+	return &Registry{
+		mu:       &sync.RWMutex{},
+		metadata: make(map[string]*Metadata),
+	}
+}
+
+// Register the metadata of a metric
+// when a metric is already registered, the empty fields are completed and the tag keys merged
+// this allows different collectors to emit the same metric with their own tags
+func (r *Registry) Register(name string, m *Metadata) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	existing, ok := r.metadata[name]
+	if !ok {
+		existing = &Metadata{}
+		r.metadata[name] = existing
+	}
+	if existing.Type == "" {
+		existing.Type = m.Type
+	}
+	if existing.Unit == "" {
+		existing.Unit = m.Unit
+	}
+	if existing.PerUnit == "" {
+		existing.PerUnit = m.PerUnit
+	}
+	if existing.Description == "" {
+		existing.Description = m.Description
+	}
+	for _, k := range m.TagKeys {
+		i := sort.SearchStrings(existing.TagKeys, k)
+		if i < len(existing.TagKeys) && existing.TagKeys[i] == k {
+			continue
+		}
+		existing.TagKeys = append(existing.TagKeys, "")
+		copy(existing.TagKeys[i+1:], existing.TagKeys[i:])
+		existing.TagKeys[i] = k
+	}
+}
+
+// Get returns a copy of the metadata of the metric
+func (r *Registry) Get(name string) (Metadata, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m, ok := r.metadata[name]
+	if !ok {
+		return Metadata{}, false
+	}
+	c := *m
+	c.TagKeys = append([]string(nil), m.TagKeys...)
+	return c, true
+}
+
+// Unit returns the registered unit of the metric, empty if unknown
+func (r *Registry) Unit(name string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m, ok := r.metadata[name]
+	if !ok {
+		return ""
+	}
+	return m.Unit
+}
+
+// Names returns the sorted registered metric names
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.metadata))
+	for name := range r.metadata {
+		names = append(names, name)
+	}
+	r.mu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// All returns a copy of every registered metadata
+func (r *Registry) All() map[string]Metadata {
+	all := make(map[string]Metadata)
+	for _, name := range r.Names() {
+		m, ok := r.Get(name)
+		if !ok {
+			continue
+		}
+		all[name] = m
+	}
+	return all
+}
+
+func (r *Registry) Len() int {
+	r.mu.RLock()
+	l := len(r.metadata)
+	r.mu.RUnlock()
+	return l
+}
+
+// RegisterMetadata registers the metadata of the metrics in the DefaultRegistry
+func RegisterMetadata(metadata map[string]*Metadata) {
+	for name, m := range metadata {
+		DefaultRegistry.Register(name, m)
+	}
+}
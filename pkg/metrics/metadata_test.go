@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistryRegister(t *testing.T) {
+	r := NewRegistry()
+	r.Register("network.wireless.rssi.dbm", &Metadata{
+		Type:    TypeGauge,
+		TagKeys: []string{"ssid", "mac"},
+	})
+	r.Register("network.wireless.rssi.dbm", &Metadata{
+		Type:        TypeCount,
+		Description: "rssi",
+		TagKeys:     []string{"mac", "device"},
+	})
+	r.Register("power.current", &Metadata{Type: TypeGauge, Unit: "watt"})
+
+	m, ok := r.Get("network.wireless.rssi.dbm")
+	assert.True(t, ok)
+	assert.Equal(t, Metadata{
+		// the first registration wins
+		Type:        TypeGauge,
+		Description: "rssi",
+		TagKeys:     []string{"device", "mac", "ssid"},
+	}, m)
+
+	// Get returns a copy
+	m.TagKeys[0] = "changed"
+	m, _ = r.Get("network.wireless.rssi.dbm")
+	assert.Equal(t, "device", m.TagKeys[0])
+
+	assert.Equal(t, "watt", r.Unit("power.current"))
+	assert.Equal(t, "", r.Unit("unknown"))
+	_, ok = r.Get("unknown")
+	assert.False(t, ok)
+
+	assert.Equal(t, []string{"network.wireless.rssi.dbm", "power.current"}, r.Names())
+	assert.Len(t, r.All(), 2)
+	assert.Equal(t, 2, r.Len())
+}
@@ -13,6 +13,7 @@ import (
 const (
 	TypeCount = "count"
 	TypeGauge = "gauge"
+	TypeRate  = "rate"
 
 	DefaultMeasureMaxAgeSample = time.Hour * 12
 )
@@ -28,7 +29,11 @@ type Series struct {
 	Type     string      `json:"type,omitempty"`
 	Interval float64     `json:"interval,omitempty"`
 	Host     string      `json:"host"`
+	Device   string      `json:"device,omitempty"`
 	Tags     []string    `json:"tags,omitempty"`
+
+	// Unit is only part of the v2 payloads, v1 relies on the metric metadata
+	Unit string `json:"-"`
 }
 
 type Sample struct {
@@ -38,16 +43,24 @@ type Sample struct {
 	Host  string
 
 	Tags []string
+	// BaselineTags identify the counter in the BaselineStore, like the tags set by the collector
+	// without the ones of the tagger changing across restarts, the Tags are used when empty
+	BaselineTags []string
 }
 
 type Measures struct {
 	counter   map[uint64]*Sample
 	deviation map[uint64]*Sample
+	rollups   map[string]*Rollup
+	windows   map[uint64]*rollupWindow
 	ch        chan Series
 
 	purge           time.Time
 	maxAge          time.Duration
 	submittedSeries float64
+
+	baselines   *BaselineStore
+	checkBootID bool
 }
 
 func (s *Sample) Count(newMetric *Sample) (*Series, error) {
@@ -92,6 +105,21 @@ func (s *Sample) Hash() uint64 {
 	return h
 }
 
+// baselineHash identifies the counter in the BaselineStore
+func (s *Sample) baselineHash() uint64 {
+	if len(s.BaselineTags) == 0 {
+		return s.Hash()
+	}
+	h := fnv.NewHash()
+	h = fnv.AddString(h, s.Name)
+	h = fnv.AddString(h, s.Host)
+	sort.Strings(s.BaselineTags)
+	for _, tag := range s.BaselineTags {
+		h = fnv.AddString(h, tag)
+	}
+	return h
+}
+
 func NewMeasures(ch chan Series) *Measures {
 	return NewMeasuresWithMaxAge(ch, DefaultMeasureMaxAgeSample)
 }
@@ -100,12 +128,22 @@ func NewMeasuresWithMaxAge(ch chan Series, maxAge time.Duration) *Measures {
 	return &Measures{
 		counter:   make(map[uint64]*Sample),
 		deviation: make(map[uint64]*Sample),
+		rollups:   make(map[string]*Rollup),
+		windows:   make(map[uint64]*rollupWindow),
 		ch:        ch,
 		purge:     time.Now(),
 		maxAge:    maxAge,
 	}
 }
 
+// WithBaselines persists the counter baselines in the store, a nil store is a no-op
+// checkBootID rejects the baselines read before a reboot of the host, for counters maintained by the kernel
+func (m *Measures) WithBaselines(b *BaselineStore, checkBootID bool) *Measures {
+	m.baselines = b
+	m.checkBootID = checkBootID
+	return m
+}
+
 func (m *Measures) GetTotalSubmittedSeries() float64 {
 	return m.submittedSeries
 }
@@ -113,12 +151,14 @@ func (m *Measures) GetTotalSubmittedSeries() float64 {
 func (m *Measures) Purge() (float64, float64) {
 	counts := 0.
 	deviations := 0.
+	m.FlushRollups(time.Now())
 	if time.Since(m.purge) < m.maxAge {
 		return counts, deviations
 	}
 	for key, sample := range m.counter {
 		if time.Since(sample.Time) > m.maxAge {
 			delete(m.counter, key)
+			m.deleteBaseline(sample)
 			counts++
 		}
 	}
@@ -136,9 +176,16 @@ func (m *Measures) Delete(sample *Sample) {
 	h := sample.Hash()
 	delete(m.deviation, h)
 	delete(m.counter, h)
+	delete(m.windows, h)
+	m.deleteBaseline(sample)
 }
 
 func (m *Measures) Gauge(newSample *Sample) {
+	r, ok := m.rollups[newSample.Name]
+	if ok {
+		m.rollup(r, newSample)
+		return
+	}
 	m.submittedSeries++
 	m.ch <- Series{
 		Metric: newSample.Name,
@@ -152,6 +199,12 @@ func (m *Measures) Gauge(newSample *Sample) {
 }
 
 func (m *Measures) GaugeDeviation(newSample *Sample, maxAge time.Duration) bool {
+	r, ok := m.rollups[newSample.Name]
+	if ok {
+		// every sample counts in the aggregates
+		m.rollup(r, newSample)
+		return true
+	}
 	h := newSample.Hash()
 	oldSample, ok := m.deviation[h]
 	if ok && newSample.Value == oldSample.Value && time.Since(oldSample.Time) < maxAge {
@@ -197,22 +250,62 @@ func (m *Measures) CountWithNegativeReset(newSample *Sample) error {
 	return m.count(newSample, true)
 }
 
+// loadBaseline returns the persisted counter sample as if it was previously observed
+func (m *Measures) loadBaseline(newSample *Sample) (*Sample, bool) {
+	if m.baselines == nil {
+		return nil, false
+	}
+	baseline, ok := m.baselines.Load(newSample.baselineHash(), newSample.Time, m.checkBootID)
+	if !ok {
+		return nil, false
+	}
+	return &Sample{
+		Name:  newSample.Name,
+		Value: baseline.Value,
+		Time:  baseline.Time,
+		Host:  newSample.Host,
+		Tags:  newSample.Tags,
+	}, true
+}
+
+func (m *Measures) setCounter(h uint64, newSample *Sample) {
+	m.counter[h] = newSample
+	if m.baselines != nil {
+		m.baselines.Store(newSample.baselineHash(), newSample)
+	}
+}
+
+func (m *Measures) deleteBaseline(sample *Sample) {
+	if m.baselines != nil {
+		m.baselines.Delete(sample.baselineHash())
+	}
+}
+
 func (m *Measures) count(newSample *Sample, resetNegative bool) error {
 	h := newSample.Hash()
 	oldSample, ok := m.counter[h]
+	fromBaseline := false
 	if !ok {
-		m.counter[h] = newSample
-		return nil
+		oldSample, fromBaseline = m.loadBaseline(newSample)
+		if !fromBaseline {
+			m.setCounter(h, newSample)
+			return nil
+		}
 	}
 	s, err := oldSample.Count(newSample)
 	if err == nil {
-		m.counter[h] = newSample
+		m.setCounter(h, newSample)
 		m.ch <- *s
 		m.submittedSeries++
 		return nil
 	}
 	if IsCountZero(err) {
-		m.counter[h] = newSample
+		m.setCounter(h, newSample)
+		return nil
+	}
+	if fromBaseline {
+		// the source has been reset since the baseline was persisted
+		m.setCounter(h, newSample)
 		return nil
 	}
 	if !resetNegative {
@@ -221,7 +314,7 @@ func (m *Measures) count(newSample *Sample, resetNegative bool) error {
 	if !IsCountNegative(err) {
 		return err
 	}
-	m.counter[h] = newSample
+	m.setCounter(h, newSample)
 	return nil
 }
 
@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+type Aggregate string
+
+const (
+	AggregateAvg   Aggregate = "avg"
+	AggregateMin   Aggregate = "min"
+	AggregateMax   Aggregate = "max"
+	AggregateLast  Aggregate = "last"
+	AggregateCount Aggregate = "count"
+)
+
+// Rollup buffers the gauge samples of a metric over a window
+// each aggregate is emitted as a gauge named "<metric>.<aggregate>" timestamped at the start of the window
+type Rollup struct {
+	Window     time.Duration
+	Aggregates []Aggregate
+}
+
+func (r *Rollup) String() string {
+	aggregates := make([]string, 0, len(r.Aggregates))
+	for _, a := range r.Aggregates {
+		aggregates = append(aggregates, string(a))
+	}
+	return r.Window.String() + ":" + strings.Join(aggregates, ",")
+}
+
+// ParseRollup parses a rollup like "1m:avg,min,max"
+func ParseRollup(s string) (*Rollup, error) {
+	i := strings.Index(s, ":")
+	if i == -1 {
+		return nil, fmt.Errorf("invalid rollup %q, must be <window>:<aggregate>,<aggregate>", s)
+	}
+	window, err := time.ParseDuration(s[:i])
+	if err != nil {
+		return nil, fmt.Errorf("invalid rollup window %q: %v", s, err)
+	}
+	if window < time.Second {
+		return nil, fmt.Errorf("invalid rollup window %q: must be greater or equal to 1s", s)
+	}
+	r := &Rollup{Window: window}
+	seen := make(map[Aggregate]struct{})
+	for _, a := range strings.Split(s[i+1:], ",") {
+		aggregate := Aggregate(a)
+		switch aggregate {
+		case AggregateAvg, AggregateMin, AggregateMax, AggregateLast, AggregateCount:
+		default:
+			return nil, fmt.Errorf("invalid rollup aggregate %q in %q", a, s)
+		}
+		// a repeated aggregate would emit the same series twice
+		if _, ok := seen[aggregate]; ok {
+			return nil, fmt.Errorf("duplicated rollup aggregate %q in %q", a, s)
+		}
+		seen[aggregate] = struct{}{}
+		r.Aggregates = append(r.Aggregates, aggregate)
+	}
+	return r, nil
+}
+
+type rollupWindow struct {
+	rollup *Rollup
+	start  time.Time
+
+	name string
+	host string
+	tags []string
+
+	count, sum, min, max, last float64
+}
+
+func (w *rollupWindow) add(s *Sample) {
+	if w.count == 0 {
+		w.min, w.max = s.Value, s.Value
+	}
+	w.count++
+	w.sum += s.Value
+	w.min = math.Min(w.min, s.Value)
+	w.max = math.Max(w.max, s.Value)
+	w.last = s.Value
+	w.tags = s.Tags
+}
+
+func (w *rollupWindow) value(a Aggregate) float64 {
+	switch a {
+	case AggregateAvg:
+		return w.sum / w.count
+	case AggregateMin:
+		return w.min
+	case AggregateMax:
+		return w.max
+	case AggregateCount:
+		return w.count
+	}
+	return w.last
+}
+
+// SetRollup enables the rollup mode of the metric, the Gauge and GaugeDeviation samples are then buffered
+// a nil rollup disables the mode
+func (m *Measures) SetRollup(metric string, r *Rollup) {
+	if r == nil {
+		delete(m.rollups, metric)
+		return
+	}
+	m.rollups[metric] = r
+}
+
+func (m *Measures) rollup(r *Rollup, newSample *Sample) {
+	// flush any window the new sample has passed, including the ones of series not sampled anymore
+	m.FlushRollups(newSample.Time)
+
+	h := newSample.Hash()
+	w, ok := m.windows[h]
+	if !ok {
+		w = &rollupWindow{
+			rollup: r,
+			start:  newSample.Time.Truncate(r.Window),
+			name:   newSample.Name,
+			host:   newSample.Host,
+		}
+		m.windows[h] = w
+	}
+	w.add(newSample)
+}
+
+// FlushRollups emits the aggregates of the windows ended at the given time
+func (m *Measures) FlushRollups(now time.Time) {
+	for h, w := range m.windows {
+		if now.Before(w.start.Add(w.rollup.Window)) {
+			continue
+		}
+		delete(m.windows, h)
+		ts := float64(w.start.Unix())
+		for _, a := range w.rollup.Aggregates {
+			m.submittedSeries++
+			m.ch <- Series{
+				Metric: w.name + "." + string(a),
+				Points: [][]float64{
+					{ts, w.value(a)},
+				},
+				Type: TypeGauge,
+				Host: w.host,
+				Tags: w.tags,
+			}
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRollup(t *testing.T) {
+	for input, tc := range map[string]struct {
+		rollup *Rollup
+		err    bool
+	}{
+		"1m:avg,min,max": {
+			&Rollup{Window: time.Minute, Aggregates: []Aggregate{AggregateAvg, AggregateMin, AggregateMax}},
+			false,
+		},
+		"30s:last,count": {
+			&Rollup{Window: time.Second * 30, Aggregates: []Aggregate{AggregateLast, AggregateCount}},
+			false,
+		},
+		"1m":         {nil, true},
+		"1m:":        {nil, true},
+		"1m:median":  {nil, true},
+		"1m:avg,avg": {nil, true},
+		"100ms:avg":  {nil, true},
+		"minute:avg": {nil, true},
+	} {
+		t.Run(input, func(t *testing.T) {
+			r, err := ParseRollup(input)
+			if tc.err {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.rollup, r)
+			again, err := ParseRollup(r.String())
+			require.NoError(t, err)
+			assert.Equal(t, r, again)
+		})
+	}
+}
+
+func TestRollup(t *testing.T) {
+	start := time.Unix(1600000020, 0)
+	ch := make(chan Series, 10)
+	defer close(ch)
+	m := NewMeasures(ch)
+	m.SetRollup("power.current", &Rollup{
+		Window:     time.Minute,
+		Aggregates: []Aggregate{AggregateAvg, AggregateMin, AggregateMax, AggregateLast, AggregateCount},
+	})
+
+	for i, v := range []float64{10, 2000, 10, 12} {
+		assert.True(t, m.GaugeDeviation(&Sample{
+			Name:  "power.current",
+			Value: v,
+			Time:  start.Add(time.Second * 5 * time.Duration(i)),
+			Host:  "host",
+			Tags:  []string{"meter:0"},
+		}, time.Minute))
+	}
+	// not rolled up
+	m.Gauge(&Sample{
+		Name:  "power.on",
+		Value: 1,
+		Time:  start,
+		Host:  "host",
+	})
+	assert.Len(t, ch, 1)
+	assert.Equal(t, "power.on", (<-ch).Metric)
+
+	// the next window flushes the previous one
+	m.Gauge(&Sample{
+		Name:  "power.current",
+		Value: 5,
+		Time:  start.Add(time.Minute),
+		Host:  "host",
+		Tags:  []string{"meter:0"},
+	})
+	require.Len(t, ch, 5)
+	windowStart := float64(start.Truncate(time.Minute).Unix())
+	for _, exp := range []struct {
+		metric string
+		value  float64
+	}{
+		{"power.current.avg", 508},
+		{"power.current.min", 10},
+		{"power.current.max", 2000},
+		{"power.current.last", 12},
+		{"power.current.count", 4},
+	} {
+		s := <-ch
+		assert.Equal(t, Series{
+			Metric: exp.metric,
+			Points: [][]float64{{windowStart, exp.value}},
+			Type:   TypeGauge,
+			Host:   "host",
+			Tags:   []string{"meter:0"},
+		}, s)
+	}
+	assert.Equal(t, 6., m.GetTotalSubmittedSeries())
+
+	m.FlushRollups(start.Add(time.Minute))
+	assert.Len(t, ch, 0)
+	m.FlushRollups(start.Add(time.Minute * 2))
+	assert.Len(t, ch, 5)
+}
@@ -76,6 +76,16 @@ func (st *AggregationStore) Series() []Series {
 	return series
 }
 
+// Each calls fn for every aggregated series without copying them
+// fn must not keep a reference to the series once it returns
+func (st *AggregationStore) Each(fn func(*Series)) {
+	st.mu.RLock()
+	for _, s := range st.store {
+		fn(s)
+	}
+	st.mu.RUnlock()
+}
+
 func (st *AggregationStore) Aggregate(series ...*Series) int {
 	matchingSeries := 0
 	st.mu.Lock()
@@ -83,6 +93,7 @@ func (st *AggregationStore) Aggregate(series ...*Series) int {
 		h := fnv.NewHash()
 		h = fnv.AddString(h, s.Metric)
 		h = fnv.AddString(h, s.Host)
+		h = fnv.AddString(h, s.Device)
 		h = fnv.AddString(h, s.Type)
 		h = fnv.AddString(h, strconv.FormatInt(int64(s.Interval), 10))
 
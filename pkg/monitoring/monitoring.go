@@ -2,27 +2,51 @@ package monitoring
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/JulienBalestra/dry/pkg/version"
 	"github.com/JulienBalestra/dry/pkg/zapconfig"
+	"github.com/JulienBalestra/monitoring/pkg/archive"
 	"github.com/JulienBalestra/monitoring/pkg/collector"
 	"github.com/JulienBalestra/monitoring/pkg/collector/catalog"
 	"github.com/JulienBalestra/monitoring/pkg/datadog"
 	"github.com/JulienBalestra/monitoring/pkg/datadog/forward"
+	"github.com/JulienBalestra/monitoring/pkg/metrics"
 	"github.com/JulienBalestra/monitoring/pkg/tagger"
+	"github.com/JulienBalestra/monitoring/pkg/tagger/replication"
+	"github.com/JulienBalestra/monitoring/pkg/tlsconfig"
 	"go.uber.org/zap"
 )
 
+const (
+	countersStateFile = "counters.json"
+	taggerStateFile   = "tagger.json"
+	stateSaveInterval = time.Minute
+
+	// KeysValidationFatal stops the start when Datadog rejects a key, the network errors are only logged
+	KeysValidationFatal = "fatal"
+	// KeysValidationWarn logs the rejected keys
+	KeysValidationWarn = "warn"
+	KeysValidationOff  = "off"
+
+	keysValidationTimeout = time.Second * 15
+)
+
 func NewDefaultConfig() *Config {
 	return &Config{
 		DatadogClientConfig: &datadog.Config{
 			ClientMetrics: &datadog.ClientMetrics{},
 		},
 		ZapConfig: zapconfig.NewZapConfig(),
+		Archive:   &archive.Config{},
 	}
 }
 
@@ -33,6 +57,45 @@ type Config struct {
 	ZapConfig  *zap.Config
 	ZapLevel   string
 
+	// MetadataSyncInterval of the metric metadata with Datadog, 0 disables the sync
+	MetadataSyncInterval time.Duration
+	// HostTagsSyncInterval of the host tags with Datadog, 0 disables the sync
+	HostTagsSyncInterval time.Duration
+
+	// StateDirectory keeps the state across restarts, empty disables the persistence
+	StateDirectory string
+	// TaggerSnapshotMaxAge is the age after which the tags of the snapshot aren't restored
+	TaggerSnapshotMaxAge time.Duration
+	// TaggerTTL is the default TTL of the tags, TaggerKeyTTLs overrides it for some keys, 0 never expires them
+	TaggerTTL     time.Duration
+	TaggerKeyTTLs map[string]time.Duration
+	// TaggerEnrichmentFile adds static tags to the entities, reloaded on change, empty disables it
+	TaggerEnrichmentFile string
+	// TaggerReplicationAddress exports the tagger to the peers, empty disables it
+	TaggerReplicationAddress string
+	// TaggerReplicationCertFile and TaggerReplicationKeyFile serve the replication over TLS
+	TaggerReplicationCertFile string
+	TaggerReplicationKeyFile  string
+	// TaggerReplicationPeers are imported in the tagger
+	TaggerReplicationPeers []*replication.Peer
+	// TaggerReplicationTokenFile is the token shared by the replication server and its peers
+	TaggerReplicationTokenFile string
+
+	// Archive of the flushed series, an empty directory disables it
+	Archive *archive.Config
+
+	// DatadogAPIKeyFile and DatadogAPPKeyFile are re-read every KeyFilesCheckInterval to rotate the keys
+	DatadogAPIKeyFile     string
+	DatadogAPPKeyFile     string
+	KeyFilesCheckInterval time.Duration
+	// CAFile replaces the default authorities of the Datadog client and the collectors, see tlsconfig.RootCAs
+	CAFile string
+	// DatadogInsecureSkipVerify accepts any certificate from the Datadog APIs
+	DatadogInsecureSkipVerify bool
+
+	// KeysValidation is one of KeysValidationFatal, KeysValidationWarn or KeysValidationOff, defaults to KeysValidationWarn
+	KeysValidation string
+
 	DatadogClientConfig *datadog.Config
 }
 
@@ -41,6 +104,13 @@ type Monitoring struct {
 
 	datadogClient *datadog.Client
 	catalogConfig *catalog.ConfigFile
+	baselines     *metrics.BaselineStore
+	archive       *archive.Writer
+	// logForwarders are closed at the end to ship the buffered logs, their Sync doesn't wait
+	logForwarders []zap.Sink
+
+	replicationToken      string
+	replicationHTTPClient *http.Client
 
 	Tagger *tagger.Tagger
 }
@@ -49,27 +119,118 @@ func NewMonitoring(conf *Config) (*Monitoring, error) {
 	if conf.Hostname == "" {
 		return nil, fmt.Errorf("empty hostname")
 	}
+	switch conf.KeysValidation {
+	case "":
+		conf.KeysValidation = KeysValidationWarn
+	case KeysValidationFatal, KeysValidationWarn, KeysValidationOff:
+	default:
+		return nil, fmt.Errorf("invalid keys validation %q, must be one of %s, %s, %s", conf.KeysValidation, KeysValidationFatal, KeysValidationWarn, KeysValidationOff)
+	}
+	if conf.DatadogAPIKeyFile != "" {
+		key, err := datadog.ReadKeyFile(conf.DatadogAPIKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.DatadogClientConfig.DatadogAPIKey = key
+	}
+	if conf.DatadogAPPKeyFile != "" {
+		key, err := datadog.ReadKeyFile(conf.DatadogAPPKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		conf.DatadogClientConfig.DatadogAPPKey = key
+	}
 	if conf.DatadogClientConfig.SendInterval <= datadog.MinimalSendInterval {
 		return nil, fmt.Errorf("SendInterval must be greater or equal to %s", datadog.MinimalSendInterval)
 	}
-	_, err := tagger.CreateTags(conf.HostTags...)
+	if conf.DatadogClientConfig.SeriesAPI != "" {
+		err := datadog.ValidSeriesAPI(conf.DatadogClientConfig.SeriesAPI)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if conf.DatadogClientConfig.CompressionLevel != 0 {
+		err := datadog.ValidCompressionLevel(conf.DatadogClientConfig.CompressionLevel)
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := datadog.ValidDestinations(conf.DatadogClientConfig.Destinations)
 	if err != nil {
 		return nil, err
 	}
+	hostTags, err := tagger.CreateTags(conf.HostTags...)
+	if err != nil {
+		return nil, err
+	}
+	// the host tags are compared with the ones normalised by Datadog
+	conf.HostTags = make([]string, 0, len(hostTags))
+	for _, tag := range hostTags {
+		conf.HostTags = append(conf.HostTags, tag.String())
+	}
 
 	catalogConfig, err := catalog.ParseConfigFile(conf.ConfigFile)
 	if err != nil {
 		return nil, err
 	}
 
+	var baselines *metrics.BaselineStore
+	if conf.StateDirectory != "" {
+		err = os.MkdirAll(conf.StateDirectory, 0755)
+		if err != nil {
+			return nil, err
+		}
+		baselines, err = metrics.NewBaselineStore(filepath.Join(conf.StateDirectory, countersStateFile), metrics.DefaultBaselineMaxAge)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var archiveWriter *archive.Writer
+	if conf.Archive != nil && conf.Archive.Directory != "" {
+		archiveWriter, err = archive.NewWriter(conf.Archive)
+		if err != nil {
+			return nil, err
+		}
+		conf.DatadogClientConfig.Archive = archiveWriter
+	}
+
+	if conf.DatadogClientConfig.Host == "" {
+		conf.DatadogClientConfig.Host = conf.Hostname
+	}
+	if conf.DatadogClientConfig.TLSConfig == nil {
+		tlsConf := &tlsconfig.Config{
+			CAFile:             conf.CAFile,
+			InsecureSkipVerify: conf.DatadogInsecureSkipVerify,
+		}
+		conf.DatadogClientConfig.TLSConfig, err = tlsConf.TLSConfig()
+		if err != nil {
+			return nil, err
+		}
+	}
 	datadogClient := datadog.NewClient(conf.DatadogClientConfig)
 	err = conf.ZapConfig.Level.UnmarshalText([]byte(conf.ZapLevel))
 	if err != nil {
 		return nil, err
 	}
-	err = zap.RegisterSink(forward.DatadogZapScheme, forward.NewDatadogForwarder(context.Background(), datadogClient))
-	if err != nil {
-		return nil, err
+	// the sinks are global to zap, only register the forwarder when used
+	var logForwarders []zap.Sink
+	if usesDatadogSink(conf.ZapConfig) {
+		newForwarder := forward.NewDatadogForwarder(context.Background(), datadogClient, &forward.Config{
+			Host: conf.Hostname,
+			Tags: conf.HostTags,
+		})
+		err = zap.RegisterSink(forward.DatadogZapScheme, func(u *url.URL) (zap.Sink, error) {
+			sink, err := newForwarder(u)
+			if err != nil {
+				return nil, err
+			}
+			logForwarders = append(logForwarders, sink)
+			return sink, nil
+		})
+		if err != nil {
+			return nil, err
+		}
 	}
 	logger, err := conf.ZapConfig.Build()
 	if err != nil {
@@ -78,16 +239,105 @@ func NewMonitoring(conf *Config) (*Monitoring, error) {
 	logger = logger.With(zap.String("host", conf.Hostname))
 	zap.ReplaceGlobals(logger)
 	zap.RedirectStdLog(logger)
-	return &Monitoring{
+	tags := tagger.NewTagger()
+	tags.SetDefaultTTL(conf.TaggerTTL)
+	for key, ttl := range conf.TaggerKeyTTLs {
+		tags.SetKeyTTL(key, ttl)
+	}
+	if conf.TaggerEnrichmentFile != "" {
+		enrichment, err := tagger.LoadEnrichmentFile(conf.TaggerEnrichmentFile)
+		if err != nil {
+			return nil, err
+		}
+		tags.SetEnrichment(enrichment)
+	}
+	if conf.StateDirectory != "" {
+		_, err = tags.RestoreSnapshot(filepath.Join(conf.StateDirectory, taggerStateFile), conf.TaggerSnapshotMaxAge)
+		if err != nil {
+			return nil, err
+		}
+	}
+	m := &Monitoring{
 		conf:          conf,
 		datadogClient: datadogClient,
 		catalogConfig: catalogConfig,
-		Tagger:        tagger.NewTagger(),
-	}, nil
+		baselines:     baselines,
+		archive:       archiveWriter,
+		logForwarders: logForwarders,
+		Tagger:        tags,
+	}
+	if conf.TaggerReplicationAddress == "" && len(conf.TaggerReplicationPeers) == 0 {
+		return m, nil
+	}
+	if conf.TaggerReplicationTokenFile == "" {
+		return nil, fmt.Errorf("the tagger replication requires a token file")
+	}
+	if (conf.TaggerReplicationCertFile == "") != (conf.TaggerReplicationKeyFile == "") {
+		return nil, fmt.Errorf("the tagger replication requires both a certificate and its key")
+	}
+	m.replicationToken, err = replication.ReadTokenFile(conf.TaggerReplicationTokenFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, peer := range conf.TaggerReplicationPeers {
+		err = peer.Validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+	m.replicationHTTPClient, err = tlsconfig.NewHTTPClient(map[string]string{}, conf.CAFile)
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func usesDatadogSink(conf *zap.Config) bool {
+	for _, paths := range [][]string{conf.OutputPaths, conf.ErrorOutputPaths} {
+		for _, p := range paths {
+			if strings.HasPrefix(p, forward.DatadogZapScheme+":") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hostTags are the normalised host tags along with the ones of the host entity in the tagger
+func (m *Monitoring) hostTags() []string {
+	tags := make([]string, 0, len(m.conf.HostTags))
+	tags = append(tags, m.conf.HostTags...)
+	return append(tags, m.Tagger.GetUnstable(m.conf.Hostname)...)
+}
+
+// validateKeys only fails when the keys are rejected with KeysValidationFatal
+func (m *Monitoring) validateKeys(ctx context.Context) error {
+	if m.conf.KeysValidation == KeysValidationOff {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, keysValidationTimeout)
+	defer cancel()
+	err := m.datadogClient.ValidateKeys(ctx)
+	if err == nil {
+		zap.L().Info("valid datadog keys")
+		return nil
+	}
+	apiErr := &datadog.APIError{}
+	rejected := errors.As(err, &apiErr) && !apiErr.Retryable()
+	if rejected && m.conf.KeysValidation == KeysValidationFatal {
+		zap.L().Error("invalid datadog keys", zap.Error(err))
+		return err
+	}
+	zap.L().Warn("failed to validate datadog keys", zap.Bool("rejected", rejected), zap.Error(err))
+	return nil
 }
 
 func (m *Monitoring) Start(ctx context.Context) error {
 	zap.L().With(zap.Int("pid", os.Getpid())).Info("starting monitoring")
+	err := m.validateKeys(ctx)
+	if err != nil {
+		return err
+	}
 	runCtx, runCancel := context.WithCancel(ctx)
 
 	datadogClientContext, datadogClientCancel := context.WithCancel(context.TODO())
@@ -99,6 +349,35 @@ func (m *Monitoring) Start(ctx context.Context) error {
 		datadogClientWaitGroup.Done()
 	}()
 
+	// the state is saved a last time once the collectors are stopped
+	stateContext, stateCancel := context.WithCancel(context.TODO())
+	stateWaitGroup := &sync.WaitGroup{}
+	if m.baselines != nil {
+		stateWaitGroup.Add(1)
+		go func() {
+			m.baselines.Run(stateContext, stateSaveInterval)
+			stateWaitGroup.Done()
+		}()
+	}
+	if m.conf.StateDirectory != "" {
+		stateWaitGroup.Add(1)
+		go func() {
+			m.Tagger.RunSnapshots(stateContext, filepath.Join(m.conf.StateDirectory, taggerStateFile), stateSaveInterval)
+			stateWaitGroup.Done()
+		}()
+	}
+
+	// backgroundWaitGroup tracks the goroutines stopped with the run context along with the collectors:
+	// the metric metadata and host tags syncs, the key files watch, the tagger sweep, enrichment and replication
+	backgroundWaitGroup := &sync.WaitGroup{}
+	if m.conf.MetadataSyncInterval > 0 {
+		backgroundWaitGroup.Add(1)
+		go func() {
+			m.datadogClient.RunMetadataSync(runCtx, metrics.DefaultRegistry, m.conf.MetadataSyncInterval)
+			backgroundWaitGroup.Done()
+		}()
+	}
+
 	errorsChan := make(chan error, len(m.catalogConfig.Collectors))
 	collectorWaitGroup := &sync.WaitGroup{}
 	for name, newFn := range catalog.CollectorCatalog() {
@@ -118,7 +397,9 @@ func (m *Monitoring) Start(ctx context.Context) error {
 				config := &collector.Config{
 					MetricsClient:   m.datadogClient,
 					Tagger:          m.Tagger,
+					Baselines:       m.baselines,
 					Host:            m.conf.Hostname,
+					CAFile:          m.conf.CAFile,
 					CollectInterval: collectorToStart.Interval,
 					Options:         collectorToStart.Options,
 				}
@@ -140,9 +421,58 @@ func (m *Monitoring) Start(ctx context.Context) error {
 		"commit:"+version.Commit[:min(8, len(version.Commit))],
 	)
 	m.datadogClient.MetricClientUp(m.conf.Hostname, tags...)
-	// TODO: make it works
-	//_ = m.datadogClient.UpdateHostTags(runCtx, m.conf.HostTags)
-	var err error
+	m.datadogClient.SubmitEvent(&datadog.Event{
+		Title:          "monitoring started on " + m.conf.Hostname,
+		Text:           fmt.Sprintf("pid %d, commit %s", os.Getpid(), version.Commit),
+		Tags:           tags,
+		AlertType:      datadog.AlertTypeInfo,
+		Priority:       datadog.PriorityLow,
+		AggregationKey: "monitoring-" + m.conf.Hostname,
+	})
+	if m.conf.DatadogAPIKeyFile != "" || m.conf.DatadogAPPKeyFile != "" {
+		backgroundWaitGroup.Add(1)
+		go func() {
+			m.datadogClient.RunKeyFilesWatch(runCtx, m.conf.DatadogAPIKeyFile, m.conf.DatadogAPPKeyFile, m.conf.KeyFilesCheckInterval)
+			backgroundWaitGroup.Done()
+		}()
+	}
+	backgroundWaitGroup.Add(1)
+	go func() {
+		m.Tagger.RunSweep(runCtx, tagger.DefaultSweepInterval)
+		backgroundWaitGroup.Done()
+	}()
+	if m.conf.TaggerReplicationAddress != "" {
+		backgroundWaitGroup.Add(1)
+		go func() {
+			handler := replication.NewHandler(m.Tagger, m.replicationToken)
+			err := replication.ListenAndServe(runCtx, m.conf.TaggerReplicationAddress, handler, m.conf.TaggerReplicationCertFile, m.conf.TaggerReplicationKeyFile)
+			if err != nil {
+				zap.L().Error("failed to serve the tagger replication", zap.Error(err))
+			}
+			backgroundWaitGroup.Done()
+		}()
+	}
+	for _, peer := range m.conf.TaggerReplicationPeers {
+		backgroundWaitGroup.Add(1)
+		go func(c *replication.Client) {
+			c.Run(runCtx)
+			backgroundWaitGroup.Done()
+		}(replication.NewClient(m.Tagger, peer, m.replicationToken, m.replicationHTTPClient))
+	}
+	if m.conf.TaggerEnrichmentFile != "" {
+		backgroundWaitGroup.Add(1)
+		go func() {
+			m.Tagger.RunEnrichmentFileWatch(runCtx, m.conf.TaggerEnrichmentFile, tagger.DefaultEnrichmentCheckInterval)
+			backgroundWaitGroup.Done()
+		}()
+	}
+	if m.conf.HostTagsSyncInterval > 0 {
+		backgroundWaitGroup.Add(1)
+		go func() {
+			m.datadogClient.RunHostTagsSync(runCtx, m.hostTags, m.conf.HostTagsSyncInterval)
+			backgroundWaitGroup.Done()
+		}()
+	}
 	select {
 	case <-runCtx.Done():
 	case err = <-errorsChan:
@@ -150,14 +480,44 @@ func (m *Monitoring) Start(ctx context.Context) error {
 	}
 	runCancel()
 
+	stopEvent := &datadog.Event{
+		Title:          "monitoring stopped on " + m.conf.Hostname,
+		Text:           fmt.Sprintf("pid %d", os.Getpid()),
+		Tags:           tags,
+		AlertType:      datadog.AlertTypeInfo,
+		Priority:       datadog.PriorityLow,
+		AggregationKey: "monitoring-" + m.conf.Hostname,
+	}
+	if err != nil {
+		stopEvent.Text += fmt.Sprintf(", failed to run collection: %v", err)
+		stopEvent.AlertType = datadog.AlertTypeError
+	}
+	// sent by the datadog client before its end
+	m.datadogClient.SubmitEvent(stopEvent)
+
 	ctxShutdown, shutdownCancel := context.WithTimeout(context.Background(), time.Second*5)
 	_ = m.datadogClient.MetricClientShutdown(ctxShutdown, m.conf.Hostname, tags...)
 	shutdownCancel()
+	// the tagger and the baselines are saved once nothing updates them anymore, the client sends the series last
 	collectorWaitGroup.Wait()
+	backgroundWaitGroup.Wait()
+	stateCancel()
+	stateWaitGroup.Wait()
 	close(errorsChan)
 	datadogClientCancel()
 
 	datadogClientWaitGroup.Wait()
+	if m.archive != nil {
+		archiveErr := m.archive.Close()
+		if archiveErr != nil {
+			zap.L().Error("failed to close the archive", zap.Error(archiveErr))
+		}
+	}
 	zap.L().Info("end of monitoring")
+	// ship the buffered logs
+	_ = zap.L().Sync()
+	for _, f := range m.logForwarders {
+		_ = f.Close()
+	}
 	return err
 }
@@ -0,0 +1,123 @@
+package monitoring
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/JulienBalestra/monitoring/pkg/archive"
+	"github.com/JulienBalestra/monitoring/pkg/collector"
+	"github.com/JulienBalestra/monitoring/pkg/datadog"
+	"github.com/JulienBalestra/monitoring/pkg/datadog/datadogtest"
+	"github.com/JulienBalestra/monitoring/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T) string {
+	fixtures, err := filepath.Abs("fixtures")
+	require.NoError(t, err)
+	configFile := filepath.Join(t.TempDir(), "config.yaml")
+	err = os.WriteFile(configFile, []byte(fmt.Sprintf(`collectors:
+- name: dnsmasq-dhcp
+  interval: 50ms
+  options:
+    leases-file: %s
+- name: network-arp
+  interval: 50ms
+  options:
+    arp-file: %s
+- name: temperature-dd-wrt
+  interval: 50ms
+  options:
+    temperature-file: %s
+`,
+		filepath.Join(fixtures, "dnsmasq.leases"),
+		filepath.Join(fixtures, "arp"),
+		filepath.Join(fixtures, "temperature"),
+	)), 0644)
+	require.NoError(t, err)
+	return configFile
+}
+
+func TestMonitoringStart(t *testing.T) {
+	intake := datadogtest.NewIntake()
+	defer intake.Close()
+	intake.SetHostTags("router", "role:old")
+
+	conf := NewDefaultConfig()
+	conf.Hostname = "router"
+	// the host tags are synced normalised like Datadog stores them
+	conf.HostTags = []string{"role:Router"}
+	conf.ConfigFile = writeConfigFile(t)
+	conf.ZapLevel = "error"
+	conf.ZapConfig.OutputPaths = []string{"stdout"}
+	conf.HostTagsSyncInterval = time.Minute
+	conf.DatadogClientConfig.DatadogAPIKey = "api-key-12345678"
+	conf.DatadogClientConfig.DatadogAPPKey = "app-key-12345678"
+	conf.DatadogClientConfig.SeriesAPI = datadog.SeriesAPIV2Protobuf
+	// the series are sent at the end of the run
+	conf.DatadogClientConfig.SendInterval = time.Minute
+	intake.Configure(conf.DatadogClientConfig)
+	conf.Archive.Directory = t.TempDir()
+
+	m, err := NewMonitoring(conf)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"role:router"}, m.hostTags())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- m.Start(ctx)
+	}()
+
+	intake.WaitFor(t, time.Second*10, func() bool {
+		return len(m.Tagger.Get("192.168.1.149")) > 0 &&
+			len(m.Tagger.Get("b8-8a-ec-fa-76-59")) > 0 &&
+			len(intake.HostTags("router")) == 1 && intake.HostTags("router")[0] == "role:router"
+	})
+	// let every collector run a few times
+	time.Sleep(time.Millisecond * 200)
+	cancel()
+	select {
+	case err = <-errs:
+		assert.NoError(t, err)
+	case <-time.After(time.Second * 15):
+		t.Fatal("monitoring did not stop")
+	}
+
+	intake.AssertMetric(t, "dnsmasq.dhcp.lease", "lease:android-f1703c3606a2892d", "ip:192.168.1.149")
+	intake.AssertMetric(t, "network.arp", "ip:192.168.1.101", "device:br0")
+	intake.AssertMetric(t, "temperature.celsius", "sensor:cpu")
+	for _, s := range intake.Series("temperature.celsius") {
+		assert.Equal(t, "router", s.Host)
+		assert.Equal(t, 66.9, s.Points[len(s.Points)-1][1])
+	}
+
+	intake.AssertServiceCheck(t, collector.CollectorServiceCheck, datadog.CheckOK, "collector:dnsmasq-dhcp")
+	intake.AssertServiceCheck(t, collector.CollectorServiceCheck, datadog.CheckOK, "collector:network-arp")
+	intake.AssertServiceCheck(t, collector.CollectorServiceCheck, datadog.CheckOK, "collector:temperature-dd-wrt")
+
+	events := intake.Events()
+	require.Len(t, events, 2)
+	assert.Equal(t, "monitoring started on router", events[0].Title)
+	assert.Equal(t, "monitoring stopped on router", events[1].Title)
+	assert.Equal(t, datadog.AlertTypeInfo, events[1].AlertType)
+
+	assert.Equal(t, []string{"role:router"}, intake.HostTags("router"))
+
+	// the series are archived as aggregated, each point once
+	archived, sent := 0, 0
+	require.NoError(t, archive.Read(conf.Archive.Directory, time.Now().Add(-time.Minute), time.Now(), func(s *metrics.Series) error {
+		archived += len(s.Points)
+		return nil
+	}))
+	for _, s := range intake.Series("") {
+		sent += len(s.Points)
+	}
+	assert.Equal(t, sent, archived)
+}
@@ -0,0 +1,295 @@
+package tagger
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/JulienBalestra/monitoring/pkg/macvendor"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// DefaultEnrichmentCheckInterval is the interval to check the changes of the enrichment file
+	DefaultEnrichmentCheckInterval = time.Minute
+
+	enrichmentMACKey   = "mac"
+	enrichmentIPKey    = "ip"
+	enrichmentLeaseKey = "lease"
+	// the wireguard peers are entities without any tag holding their public key
+	enrichmentWireGuardKey = "wireguard"
+)
+
+// EnrichmentRule adds the Tags to the entities matching exactly one of MAC, IP, Lease or WireGuard
+// a rule matches the entity itself or the value of its tag with the same key: a MAC rule matches the IP of its lease
+// the patterns accept wildcards, like f0:18:98:* for a MAC OUI or *phone* for a lease
+type EnrichmentRule struct {
+	MAC       string   `yaml:"mac,omitempty"`
+	IP        string   `yaml:"ip,omitempty"`
+	Lease     string   `yaml:"lease,omitempty"`
+	WireGuard string   `yaml:"wireguard,omitempty"`
+	Tags      []string `yaml:"tags"`
+}
+
+type enrichmentFile struct {
+	Rules []EnrichmentRule `yaml:"rules"`
+}
+
+type enrichmentRule struct {
+	// key of the entity tags matched by the pattern, empty to only match the entity
+	key     tagKey
+	pattern string
+	glob    bool
+	tags    []*Tag
+}
+
+// Enrichment holds the static tags added to the discovered ones
+type Enrichment struct {
+	// the exact rules are evaluated before the wildcard ones, then in the order of the file
+	rules []*enrichmentRule
+	// keys set by the rules
+	tagKeys map[tagKey]struct{}
+}
+
+// NewEnrichment validates the rules
+func NewEnrichment(rules []EnrichmentRule) (*Enrichment, error) {
+	e := &Enrichment{tagKeys: make(map[tagKey]struct{})}
+	for i, r := range rules {
+		rule := &enrichmentRule{}
+		matches := 0
+		for _, m := range []struct {
+			key, pattern string
+		}{
+			{enrichmentMACKey, r.MAC},
+			{enrichmentIPKey, r.IP},
+			{enrichmentLeaseKey, r.Lease},
+			{enrichmentWireGuardKey, r.WireGuard},
+		} {
+			if m.pattern == "" {
+				continue
+			}
+			matches++
+			rule.key, rule.pattern = tagKey(m.key), m.pattern
+		}
+		if matches != 1 {
+			return nil, fmt.Errorf("invalid enrichment rule %d: requires one of %s, %s, %s or %s", i, enrichmentMACKey, enrichmentIPKey, enrichmentLeaseKey, enrichmentWireGuardKey)
+		}
+		if rule.key == enrichmentMACKey {
+			rule.pattern = macvendor.NormaliseMacAddress(rule.pattern)
+		}
+		if rule.key == enrichmentLeaseKey {
+			// the lease names are normalised in lowercase
+			rule.pattern = strings.ToLower(rule.pattern)
+		}
+		if rule.key == enrichmentWireGuardKey {
+			rule.key = ""
+		}
+		rule.glob = strings.ContainsAny(rule.pattern, "*?[")
+		if rule.glob {
+			_, err := path.Match(rule.pattern, "")
+			if err != nil {
+				return nil, fmt.Errorf("invalid enrichment rule %d: %q: %v", i, rule.pattern, err)
+			}
+		}
+		if len(r.Tags) == 0 {
+			return nil, fmt.Errorf("invalid enrichment rule %d: %q: no tags", i, rule.pattern)
+		}
+		tags, err := CreateTags(r.Tags...)
+		if err != nil {
+			return nil, fmt.Errorf("invalid enrichment rule %d: %q: %v", i, rule.pattern, err)
+		}
+		rule.tags = tags
+		for _, tag := range tags {
+			e.tagKeys[tag.key] = struct{}{}
+		}
+		e.rules = append(e.rules, rule)
+	}
+	sort.SliceStable(e.rules, func(i, j int) bool { return !e.rules[i].glob && e.rules[j].glob })
+	return e, nil
+}
+
+// LoadEnrichmentFile reads the rules of a .csv file or else of a YAML file:
+//
+//	rules:
+//	  - mac: "aa:bb:cc:dd:ee:ff"
+//	    tags: ["owner:alice", "room:kitchen"]
+//
+// a CSV record is the key of the match, the pattern and the tags: mac,f0:18:98:*,device-type:apple
+func LoadEnrichmentFile(file string) (*Enrichment, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if strings.EqualFold(filepath.Ext(file), ".csv") {
+		rules, err := parseEnrichmentCSV(string(b))
+		if err != nil {
+			return nil, fmt.Errorf("invalid enrichment file %s: %v", file, err)
+		}
+		return NewEnrichment(rules)
+	}
+	f := &enrichmentFile{}
+	err = yaml.UnmarshalStrict(b, f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid enrichment file %s: %v", file, err)
+	}
+	return NewEnrichment(f.Rules)
+}
+
+func parseEnrichmentCSV(s string) ([]EnrichmentRule, error) {
+	r := csv.NewReader(strings.NewReader(s))
+	r.Comment = '#'
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var rules []EnrichmentRule
+	for i, record := range records {
+		if len(record) < 3 {
+			return nil, fmt.Errorf("record %d: requires a key, a pattern and tags", i+1)
+		}
+		rule := EnrichmentRule{Tags: record[2:]}
+		switch record[0] {
+		case enrichmentMACKey:
+			rule.MAC = record[1]
+		case enrichmentIPKey:
+			rule.IP = record[1]
+		case enrichmentLeaseKey:
+			rule.Lease = record[1]
+		case enrichmentWireGuardKey:
+			rule.WireGuard = record[1]
+		default:
+			return nil, fmt.Errorf("record %d: unknown key %q", i+1, record[0])
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// Len is the number of rules
+func (e *Enrichment) Len() int {
+	if e == nil {
+		return 0
+	}
+	return len(e.rules)
+}
+
+func (e *Enrichment) keys() map[tagKey]struct{} {
+	if e == nil {
+		return nil
+	}
+	return e.tagKeys
+}
+
+func (r *enrichmentRule) match(s string) bool {
+	if r.key == enrichmentMACKey {
+		s = macvendor.NormaliseMacAddress(s)
+	}
+	if !r.glob {
+		return s == r.pattern
+	}
+	ok, _ := path.Match(r.pattern, s)
+	return ok
+}
+
+// SetEnrichment replaces the static tags of the entities, nil removes them
+func (t *Tagger) SetEnrichment(e *Enrichment) {
+	t.mu.Lock()
+	t.enrichment = e
+	t.mu.Unlock()
+}
+
+// enrich must be called with the lock, it returns the tags of the rules matching the entity or the tags of its view
+// the keys set by a collector take precedence over the rules, the first matching rule sets a key
+func (t *Tagger) enrich(entity string, view []viewTag) []*Tag {
+	if t.enrichment == nil {
+		return nil
+	}
+	var tags []*Tag
+	seen := make(map[tagKey]struct{}, len(view))
+	for _, tag := range view {
+		seen[tag.key] = struct{}{}
+	}
+	for _, rule := range t.enrichment.rules {
+		if !matchRule(rule, entity, view) {
+			continue
+		}
+		added := make(map[tagKey]struct{})
+		for _, tag := range rule.tags {
+			if _, ok := seen[tag.key]; ok {
+				continue
+			}
+			added[tag.key] = struct{}{}
+			tags = append(tags, tag)
+		}
+		for key := range added {
+			seen[key] = struct{}{}
+		}
+	}
+	return tags
+}
+
+func matchRule(rule *enrichmentRule, entity string, view []viewTag) bool {
+	if rule.match(entity) {
+		return true
+	}
+	if rule.key == "" {
+		return false
+	}
+	for _, tag := range view {
+		if tag.key == rule.key && rule.match(string(tag.value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// RunEnrichmentFileWatch reloads the enrichment file when it changes until the context is done
+// an invalid file is logged and the current rules are kept
+func (t *Tagger) RunEnrichmentFileWatch(ctx context.Context, file string, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultEnrichmentCheckInterval
+	}
+	zctx := zap.L().With(zap.String("enrichmentFile", file))
+	var modTime time.Time
+	var size int64
+	fi, err := os.Stat(file)
+	if err == nil {
+		modTime, size = fi.ModTime(), fi.Size()
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			fi, err := os.Stat(file)
+			if err != nil {
+				zctx.Error("failed to stat enrichment file, keeping the current rules", zap.Error(err))
+				continue
+			}
+			if fi.ModTime().Equal(modTime) && fi.Size() == size {
+				continue
+			}
+			// an invalid file is reported once per change
+			modTime, size = fi.ModTime(), fi.Size()
+			e, err := LoadEnrichmentFile(file)
+			if err != nil {
+				zctx.Error("failed to reload enrichment file, keeping the current rules", zap.Error(err))
+				continue
+			}
+			t.SetEnrichment(e)
+			zctx.Info("reloaded enrichment file", zap.Int("rules", e.Len()))
+		}
+	}
+}
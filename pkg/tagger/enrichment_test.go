@@ -0,0 +1,123 @@
+package tagger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnrichment(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tagger := NewTagger()
+	tagger.now = func() time.Time { return now }
+	e, err := NewEnrichment([]EnrichmentRule{
+		{MAC: "F0:18:98:*", Tags: []string{"device-type:apple", "owner:family"}},
+		{MAC: "f0:18:98:aa:bb:cc", Tags: []string{"owner:alice", "room:kitchen"}},
+		{Lease: "*phone*", Tags: []string{"device-type:phone"}},
+		{IP: "192.168.1.254", Tags: []string{"role:printer"}},
+		{WireGuard: "hJYrKcMyKYQCsFXvJrLPuXAt2O9tVGvUbDR6XcMaG1Y=", Tags: []string{"owner:bob"}},
+	})
+	require.NoError(t, err)
+	tagger.SetEnrichment(e)
+
+	tagger.Update("f0-18-98-aa-bb-cc", NewTagUnsafe("ip", "192.168.1.10"), NewTagUnsafe("lease", "alice-phone"))
+	tagger.Update("192.168.1.10", NewTagUnsafe("mac", "f0-18-98-aa-bb-cc"), NewTagUnsafe("lease", "alice-phone"))
+	tagger.Update("192.168.1.11", NewTagUnsafe("mac", "f0-18-98-00-00-01"), NewTagUnsafe("device-type", "laptop"))
+	tagger.UpdateWithTTL("192.168.1.12", time.Minute, NewTagUnsafe("mac", "f0-18-98-00-00-02"))
+
+	// the exact rule is evaluated before the prefix and the discovered keys take precedence
+	assert.Equal(t, []string{"device-type:apple", "ip:192.168.1.10", "lease:alice-phone", "owner:alice", "room:kitchen"}, tagger.Get("f0-18-98-aa-bb-cc"))
+	assert.Equal(t, []string{"device-type:apple", "lease:alice-phone", "mac:f0-18-98-aa-bb-cc", "owner:alice", "room:kitchen"}, tagger.Get("192.168.1.10"))
+	assert.Equal(t, []string{"device-type:laptop", "mac:f0-18-98-00-00-01", "owner:family"}, tagger.Get("192.168.1.11"))
+	assert.Equal(t, []string{"device-type:phone"}, tagger.Get("bob-phone"))
+	assert.Equal(t, []string{"role:printer"}, tagger.Get("192.168.1.254"))
+	assert.Equal(t, []string{"owner:bob"}, tagger.Get("hJYrKcMyKYQCsFXvJrLPuXAt2O9tVGvUbDR6XcMaG1Y="))
+	assert.Equal(t, []string{"lease:unknown", "role:printer"}, tagger.GetWithDefault("192.168.1.254", NewTagUnsafe("lease", MissingTagValue), NewTagUnsafe("role", MissingTagValue)))
+	assert.Equal(t, map[string]struct{}{"owner:bob": {}}, tagger.GetIndexed("hJYrKcMyKYQCsFXvJrLPuXAt2O9tVGvUbDR6XcMaG1Y="))
+	// the enrichment isn't set by a collector
+	assert.Equal(t, []string{"device-type:laptop", "mac:f0-18-98-00-00-01"}, tagger.GetFresh("192.168.1.11", time.Hour))
+
+	// the expired MAC doesn't match anymore
+	now = now.Add(time.Minute * 2)
+	assert.Equal(t, []string{}, tagger.Get("192.168.1.12"))
+
+	assert.Equal(t, 5.0, tagger.Stats().EnrichmentRules)
+	tagger.SetEnrichment(nil)
+	assert.Equal(t, []string{}, tagger.Get("bob-phone"))
+	assert.Equal(t, 0.0, tagger.Stats().EnrichmentRules)
+}
+
+func TestLoadEnrichmentFile(t *testing.T) {
+	dir := t.TempDir()
+	for name, tc := range map[string]struct {
+		file    string
+		content string
+		rules   int
+		err     bool
+	}{
+		"yaml": {
+			file: "enrichment.yaml",
+			content: `rules:
+  - mac: "aa:bb:cc:dd:ee:ff"
+    tags: ["owner:alice", "room:kitchen"]
+  - lease: "*phone*"
+    tags: ["device-type:phone"]
+`,
+			rules: 2,
+		},
+		"csv": {
+			file: "enrichment.csv",
+			content: `# key,pattern,tags
+mac,aa:bb:cc:*,device-type:apple
+wireguard,hJYrKcMyKYQCsFXvJrLPuXAt2O9tVGvUbDR6XcMaG1Y=,owner:bob,room:office
+`,
+			rules: 2,
+		},
+		"unknown yaml field": {
+			file:    "enrichment.yaml",
+			content: "rules:\n  - hostname: router\n    tags: [\"role:router\"]\n",
+			err:     true,
+		},
+		"two matches": {
+			file:    "enrichment.yaml",
+			content: "rules:\n  - mac: aa:bb:cc:dd:ee:ff\n    ip: 192.168.1.1\n    tags: [\"role:router\"]\n",
+			err:     true,
+		},
+		"no tags": {
+			file:    "enrichment.yaml",
+			content: "rules:\n  - ip: 192.168.1.1\n",
+			err:     true,
+		},
+		"invalid tag": {
+			file:    "enrichment.csv",
+			content: "ip,192.168.1.1,router\n",
+			err:     true,
+		},
+		"invalid pattern": {
+			file:    "enrichment.csv",
+			content: "lease,[phone,device-type:phone\n",
+			err:     true,
+		},
+		"unknown csv key": {
+			file:    "enrichment.csv",
+			content: "hostname,router,role:router\n",
+			err:     true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(dir, name+"-"+tc.file)
+			require.NoError(t, os.WriteFile(file, []byte(tc.content), 0644))
+			e, err := LoadEnrichmentFile(file)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.rules, e.Len())
+		})
+	}
+}
@@ -0,0 +1,392 @@
+package tagger
+
+import (
+	"sort"
+	"time"
+)
+
+// identityLink makes an alias, like an IP or a lease name, an identifier of a device, like its MAC
+type identityLink struct {
+	alias   *Tag
+	device  string
+	updated time.Time
+}
+
+// identityDevice groups the identifiers of a device, the aliases are the entities of its links
+type identityDevice struct {
+	tag     *Tag
+	aliases map[string]struct{}
+}
+
+// identity is an identifier of the device of an entity
+type identity struct {
+	tag     *Tag
+	updated time.Time
+}
+
+// viewTag is a tag visible from an entity: its own tags, the identifiers of its device and the tags inherited from them
+type viewTag struct {
+	key      tagKey
+	value    tagValue
+	keyValue string
+	updated  time.Time
+	ttl      time.Duration
+	source   string
+}
+
+// Link makes the aliases identifiers of the device, like the IP and the lease name of a MAC address
+// the tags of the device and of its aliases are visible from all of them, along with the identifiers as tags like ip:192.168.1.10
+// an alias linked to another device moves, an alias being a device brings its own aliases
+// a link expires after the TTL of the key of its alias, like the tags
+func (t *Tagger) Link(device *Tag, aliases ...*Tag) {
+	t.link(device, aliases, false)
+}
+
+// Relink links the aliases and unlinks the previous aliases of the device with the same keys, like the previous IP of a renewed lease
+func (t *Tagger) Relink(device *Tag, aliases ...*Tag) {
+	t.link(device, aliases, true)
+}
+
+// Unlink removes the links of the identifiers, a device loses all its aliases
+func (t *Tagger) Unlink(identifiers ...string) {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var affected []string
+	var before map[string]map[string]struct{}
+	watched := t.hasWatchers()
+	if watched {
+		for _, identifier := range identifiers {
+			affected = append(affected, t.groupLocked(identifier, now)...)
+		}
+		before = t.viewsLocked(affected, now)
+	}
+	for _, identifier := range identifiers {
+		if d, ok := t.devices[identifier]; ok {
+			for alias := range d.aliases {
+				t.unlinkAliasLocked(alias)
+			}
+			continue
+		}
+		t.unlinkAliasLocked(identifier)
+	}
+	if watched {
+		t.notifyViews("", before, affected, now)
+	}
+}
+
+func (t *Tagger) link(device *Tag, aliases []*Tag, replace bool) {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.registerOriginals(aliases)
+	deviceName := string(device.value)
+	var affected []string
+	var before map[string]map[string]struct{}
+	watched := t.hasWatchers()
+	if watched {
+		affected = t.groupLocked(deviceName, now)
+		for _, alias := range aliases {
+			affected = append(affected, t.groupLocked(string(alias.value), now)...)
+		}
+		before = t.viewsLocked(affected, now)
+	}
+
+	// the device was the alias of another one
+	t.unlinkAliasLocked(deviceName)
+	if replace {
+		linked := make(map[tagKey]map[string]struct{}, len(aliases))
+		for _, alias := range aliases {
+			if linked[alias.key] == nil {
+				linked[alias.key] = make(map[string]struct{}, 1)
+			}
+			linked[alias.key][string(alias.value)] = struct{}{}
+		}
+		if d, ok := t.devices[deviceName]; ok {
+			for name := range d.aliases {
+				values, ok := linked[t.links[name].alias.key]
+				if !ok {
+					continue
+				}
+				if _, ok := values[name]; !ok {
+					t.unlinkAliasLocked(name)
+				}
+			}
+		}
+	}
+	for _, alias := range aliases {
+		t.linkAliasLocked(device, alias, now)
+	}
+	if watched {
+		affected = append(affected, t.groupLocked(deviceName, now)...)
+		t.notifyViews("", before, affected, now)
+	}
+}
+
+// linkAliasLocked must be called with the lock
+func (t *Tagger) linkAliasLocked(device, alias *Tag, updated time.Time) {
+	deviceName, name := string(device.value), string(alias.value)
+	if name == deviceName {
+		return
+	}
+	// unlinking the last alias of the device removes it
+	t.unlinkAliasLocked(name)
+	d, ok := t.devices[deviceName]
+	if !ok {
+		d = &identityDevice{tag: device, aliases: make(map[string]struct{}, 1)}
+		t.devices[deviceName] = d
+	}
+	// a device linked as an alias brings its own aliases
+	if other, ok := t.devices[name]; ok {
+		for a := range other.aliases {
+			t.links[a].device = deviceName
+			d.aliases[a] = struct{}{}
+		}
+		delete(t.devices, name)
+	}
+	t.links[name] = &identityLink{alias: alias, device: deviceName, updated: updated}
+	d.aliases[name] = struct{}{}
+}
+
+// unlinkAliasLocked must be called with the lock
+func (t *Tagger) unlinkAliasLocked(name string) {
+	l, ok := t.links[name]
+	if !ok {
+		return
+	}
+	delete(t.links, name)
+	d, ok := t.devices[l.device]
+	if !ok {
+		return
+	}
+	delete(d.aliases, name)
+	if len(d.aliases) == 0 {
+		delete(t.devices, l.device)
+	}
+}
+
+// linkExpired must be called with the lock
+func (t *Tagger) linkExpired(l *identityLink, now time.Time) bool {
+	ttl := t.keyTTLs[l.alias.key]
+	if ttl <= 0 {
+		ttl = t.defaultTTL
+	}
+	return ttl > 0 && now.Sub(l.updated) > ttl
+}
+
+// identitiesLocked must be called with the lock, it returns the other live identifiers of the device of the entity, the device first
+func (t *Tagger) identitiesLocked(entity string, now time.Time) []identity {
+	deviceName := entity
+	l, isAlias := t.links[entity]
+	if isAlias {
+		if t.linkExpired(l, now) {
+			return nil
+		}
+		deviceName = l.device
+	}
+	d, ok := t.devices[deviceName]
+	if !ok {
+		return nil
+	}
+	var identities []identity
+	if isAlias {
+		identities = append(identities, identity{tag: d.tag, updated: l.updated})
+	}
+	for name := range d.aliases {
+		if name == entity {
+			continue
+		}
+		sibling := t.links[name]
+		if t.linkExpired(sibling, now) {
+			continue
+		}
+		identities = append(identities, identity{tag: sibling.alias, updated: sibling.updated})
+	}
+	return identities
+}
+
+// groupLocked must be called with the lock, it returns the entity and the other identifiers of its device
+func (t *Tagger) groupLocked(entity string, now time.Time) []string {
+	group := []string{entity}
+	for _, id := range t.identitiesLocked(entity, now) {
+		group = append(group, string(id.tag.value))
+	}
+	return group
+}
+
+// identifiersLocked must be called with the lock, it returns the entities known only by their links
+func (t *Tagger) identifiersLocked() []string {
+	var identifiers []string
+	for name := range t.links {
+		if _, ok := t.store[name]; !ok {
+			identifiers = append(identifiers, name)
+		}
+	}
+	for name := range t.devices {
+		if _, ok := t.store[name]; !ok {
+			identifiers = append(identifiers, name)
+		}
+	}
+	return identifiers
+}
+
+// view must be called with the lock, it returns the live tags visible from the entity without the enrichment
+// the own tags of the entity take precedence, then the identifiers of its device, then the tags inherited from them
+// the restored tags not confirmed yet come last, maxAge > 0 only returns the own tags set by a collector in the last maxAge
+func (t *Tagger) view(entity string, maxAge time.Duration, now time.Time) []viewTag {
+	tags := make([]viewTag, 0)
+	entityTags := t.store[entity]
+	if maxAge > 0 {
+		for key, values := range entityTags {
+			for value, e := range values {
+				if e.stale || now.Sub(e.updated) > maxAge || t.expired(key, e, now) {
+					continue
+				}
+				tags = append(tags, newViewTag(key, value, e))
+			}
+		}
+		return tags
+	}
+
+	var stale []viewTag
+	for key, values := range entityTags {
+		for value, e := range values {
+			if t.expired(key, e, now) {
+				continue
+			}
+			if e.stale {
+				stale = append(stale, newViewTag(key, value, e))
+				continue
+			}
+			tags = append(tags, newViewTag(key, value, e))
+		}
+	}
+	identities := t.identitiesLocked(entity, now)
+	if len(identities) == 0 {
+		return append(tags, stale...)
+	}
+
+	present := make(map[tagKey]struct{}, len(tags))
+	seen := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		present[tag.key] = struct{}{}
+		seen[tag.keyValue] = struct{}{}
+	}
+	// the keys of a pass can have several values, the next passes only add the missing keys
+	pass := func(candidates []viewTag) {
+		added := make(map[tagKey]struct{})
+		for _, tag := range candidates {
+			if _, ok := present[tag.key]; ok {
+				continue
+			}
+			if _, ok := seen[tag.keyValue]; ok {
+				continue
+			}
+			seen[tag.keyValue] = struct{}{}
+			added[tag.key] = struct{}{}
+			tags = append(tags, tag)
+		}
+		for key := range added {
+			present[key] = struct{}{}
+		}
+	}
+
+	identityTags := make([]viewTag, 0, len(identities))
+	for _, id := range identities {
+		identityTags = append(identityTags, viewTag{
+			key:      id.tag.key,
+			value:    id.tag.value,
+			keyValue: id.tag.keyValue,
+			updated:  id.updated,
+		})
+	}
+	pass(identityTags)
+	// the device comes first, its tags take precedence over the ones of the other aliases
+	var inheritedStale []viewTag
+	for _, id := range identities {
+		var inherited []viewTag
+		for key, values := range t.store[string(id.tag.value)] {
+			for value, e := range values {
+				// the tags referring to the entity itself, like the ip tag of its MAC
+				if string(value) == entity || t.expired(key, e, now) {
+					continue
+				}
+				if e.stale {
+					inheritedStale = append(inheritedStale, newViewTag(key, value, e))
+					continue
+				}
+				inherited = append(inherited, newViewTag(key, value, e))
+			}
+		}
+		pass(inherited)
+	}
+	pass(stale)
+	pass(inheritedStale)
+	return tags
+}
+
+func newViewTag(key tagKey, value tagValue, e *tagEntry) viewTag {
+	return viewTag{
+		key:      key,
+		value:    value,
+		keyValue: e.keyValue,
+		updated:  e.updated,
+		ttl:      e.ttl,
+		source:   e.source,
+	}
+}
+
+// viewSet must be called with the lock
+func (t *Tagger) viewSet(entity string, now time.Time) map[string]struct{} {
+	tags := t.view(entity, 0, now)
+	set := make(map[string]struct{}, len(tags))
+	for _, tag := range tags {
+		set[tag.keyValue] = struct{}{}
+	}
+	return set
+}
+
+// viewsLocked must be called with the lock, it captures the views of the entities before a change
+func (t *Tagger) viewsLocked(entities []string, now time.Time) map[string]map[string]struct{} {
+	views := make(map[string]map[string]struct{}, len(entities))
+	for _, entity := range entities {
+		views[entity] = t.viewSet(entity, now)
+	}
+	return views
+}
+
+// sweepLinks must be called with the lock, it removes the expired links and returns their number
+func (t *Tagger) sweepLinks(now time.Time) int {
+	expired := 0
+	watched := t.hasWatchers()
+	for name, l := range t.links {
+		if !t.linkExpired(l, now) {
+			continue
+		}
+		deviceTag := t.devices[l.device].tag
+		t.unlinkAliasLocked(name)
+		expired++
+		if watched {
+			t.notify(EventUpdate, "", name, nil, []string{deviceTag.keyValue}, now)
+			t.notify(EventUpdate, "", l.device, nil, []string{l.alias.keyValue}, now)
+		}
+	}
+	t.expiredLinks += float64(expired)
+	return expired
+}
+
+// Aliases returns the sorted live identifiers of the device of the entity, like the IP and the lease name of a MAC address
+func (t *Tagger) Aliases(entity string) []string {
+	now := t.now()
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	aliases := make([]string, 0)
+	for _, id := range t.identitiesLocked(entity, now) {
+		aliases = append(aliases, id.tag.keyValue)
+	}
+	sort.Strings(aliases)
+	return aliases
+}
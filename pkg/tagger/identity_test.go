@@ -0,0 +1,175 @@
+package tagger
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLink(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tagger := NewTagger()
+	tagger.now = func() time.Time { return now }
+
+	mac, ip, lease := NewTagUnsafe("mac", "aa-bb-cc-dd-ee-ff"), NewTagUnsafe("ip", "192.168.1.10"), NewTagUnsafe("lease", "phone")
+	tagger.Update("aa-bb-cc-dd-ee-ff", NewTagUnsafe("vendor", "apple"), NewTagUnsafe("device", "br0"))
+	tagger.Update("192.168.1.10", NewTagUnsafe("device", "wlan0"))
+	tagger.Relink(mac, ip, lease)
+
+	for name, tc := range map[string]struct {
+		entity string
+		exp    []string
+	}{
+		"device": {
+			entity: "aa-bb-cc-dd-ee-ff",
+			exp:    []string{"device:br0", "ip:192.168.1.10", "lease:phone", "vendor:apple"},
+		},
+		"alias with its own device": {
+			entity: "192.168.1.10",
+			exp:    []string{"device:wlan0", "lease:phone", "mac:aa-bb-cc-dd-ee-ff", "vendor:apple"},
+		},
+		"alias without tags": {
+			entity: "phone",
+			exp:    []string{"device:br0", "ip:192.168.1.10", "mac:aa-bb-cc-dd-ee-ff", "vendor:apple"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.exp, tagger.Get(tc.entity))
+		})
+	}
+	assert.Equal(t, []string{"device:wlan0", "lease:phone", "mac:aa-bb-cc-dd-ee-ff", "vendor:apple"}, tagger.GetWithDefault("192.168.1.10", NewTagUnsafe("lease", MissingTagValue)))
+	assert.Equal(t, []string{"ip:192.168.1.10", "mac:aa-bb-cc-dd-ee-ff"}, tagger.Aliases("phone"))
+	// the identities aren't set by a collector
+	assert.Equal(t, []string{}, tagger.GetFresh("phone", time.Minute))
+
+	// the renewed lease has a new IP
+	tagger.Relink(mac, NewTagUnsafe("ip", "192.168.1.11"), lease)
+	assert.Equal(t, []string{"device:wlan0"}, tagger.Get("192.168.1.10"))
+	assert.Equal(t, []string{"device:br0", "lease:phone", "mac:aa-bb-cc-dd-ee-ff", "vendor:apple"}, tagger.Get("192.168.1.11"))
+
+	// the IP is reused by another device
+	tagger.Link(NewTagUnsafe("mac", "00-11-22-33-44-55"), NewTagUnsafe("ip", "192.168.1.11"))
+	assert.Equal(t, []string{"mac:00-11-22-33-44-55"}, tagger.Get("192.168.1.11"))
+	assert.Equal(t, []string{"device:br0", "lease:phone", "vendor:apple"}, tagger.Get("aa-bb-cc-dd-ee-ff"))
+
+	// linked again by every collection
+	tagger.Relink(NewTagUnsafe("mac", "00-11-22-33-44-55"), NewTagUnsafe("ip", "192.168.1.11"))
+	tagger.Relink(NewTagUnsafe("mac", "00-11-22-33-44-55"), NewTagUnsafe("ip", "192.168.1.11"))
+	assert.Equal(t, []string{"mac:00-11-22-33-44-55"}, tagger.Get("192.168.1.11"))
+
+	tagger.Unlink("aa-bb-cc-dd-ee-ff")
+	assert.Equal(t, []string{}, tagger.Get("phone"))
+	stats := tagger.Stats()
+	assert.Equal(t, 1.0, stats.Devices)
+	assert.Equal(t, 1.0, stats.Aliases)
+}
+
+func TestLinkMerge(t *testing.T) {
+	tagger := NewTagger()
+	tagger.Update("pub-key-sha1", NewTagUnsafe("wg-active", "true"))
+	tagger.Link(NewTagUnsafe("pub-key", "key"), NewTagUnsafe("ip", "10.0.0.2"))
+	// the device becomes an alias with its own aliases
+	tagger.Link(NewTagUnsafe("pub-key-sha1", "pub-key-sha1"), NewTagUnsafe("pub-key", "key"))
+
+	assert.Equal(t, []string{"pub-key-sha1:pub-key-sha1", "pub-key:key", "wg-active:true"}, tagger.Get("10.0.0.2"))
+	assert.Equal(t, 1.0, tagger.Stats().Devices)
+	assert.Equal(t, 2.0, tagger.Stats().Aliases)
+}
+
+func TestLinkExpiry(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tagger := NewTagger()
+	tagger.now = func() time.Time { return now }
+	tagger.SetKeyTTL("lease", time.Hour)
+	tagger.SetKeyTTL("ip", time.Minute)
+
+	mac := NewTagUnsafe("mac", "aa-bb-cc-dd-ee-ff")
+	tagger.Update("aa-bb-cc-dd-ee-ff", NewTagUnsafe("vendor", "apple"))
+	tagger.Link(mac, NewTagUnsafe("ip", "192.168.1.10"), NewTagUnsafe("lease", "phone"))
+
+	now = now.Add(time.Minute * 2)
+	assert.Equal(t, []string{}, tagger.Get("192.168.1.10"))
+	assert.Equal(t, []string{"mac:aa-bb-cc-dd-ee-ff", "vendor:apple"}, tagger.Get("phone"))
+
+	w := tagger.Watch(nil)
+	defer w.Close()
+	tagger.Sweep()
+	assert.Equal(t, 1.0, tagger.Stats().ExpiredLinks)
+	assert.Equal(t, 1.0, tagger.Stats().Aliases)
+	assert.Equal(t, &Event{Type: EventUpdate, Entity: "192.168.1.10", Removed: []string{"mac:aa-bb-cc-dd-ee-ff"}, Time: now}, receive(t, w))
+	assert.Equal(t, &Event{Type: EventUpdate, Entity: "aa-bb-cc-dd-ee-ff", Removed: []string{"ip:192.168.1.10"}, Time: now}, receive(t, w))
+}
+
+func TestLinkWatch(t *testing.T) {
+	tagger := NewTagger()
+	tagger.Update("aa-bb-cc-dd-ee-ff", NewTagUnsafe("vendor", "apple"))
+	w := tagger.Watch(&WatchFilter{Keys: []string{"vendor"}})
+	defer w.Close()
+
+	tagger.Link(NewTagUnsafe("mac", "aa-bb-cc-dd-ee-ff"), NewTagUnsafe("ip", "192.168.1.10"))
+	e := receive(t, w)
+	assert.Equal(t, EventAdd, e.Type)
+	assert.Equal(t, "192.168.1.10", e.Entity)
+	assert.Equal(t, []string{"vendor:apple"}, e.Added)
+
+	// the change of the device is visible from its aliases
+	tagger.Update("aa-bb-cc-dd-ee-ff", NewTagUnsafe("vendor", "samsung"))
+	assert.Equal(t, "192.168.1.10", receive(t, w).Entity)
+	assert.Equal(t, "aa-bb-cc-dd-ee-ff", receive(t, w).Entity)
+	assert.Empty(t, w.C)
+}
+
+func TestLinkSelect(t *testing.T) {
+	tagger := NewTagger()
+	tagger.Update("aa-bb-cc-dd-ee-ff", NewTagUnsafe("vendor", "apple"))
+	tagger.Update("00-11-22-33-44-55", NewTagUnsafe("vendor", "samsung"))
+	tagger.Link(NewTagUnsafe("mac", "aa-bb-cc-dd-ee-ff"), NewTagUnsafe("ip", "192.168.1.10"), NewTagUnsafe("lease", "phone"))
+
+	for name, tc := range map[string]struct {
+		selector string
+		exp      []string
+	}{
+		"inherited tag": {
+			selector: "vendor:apple AND ip:*",
+			exp:      []string{"aa-bb-cc-dd-ee-ff", "phone"},
+		},
+		"identity": {
+			selector: "mac:aa-bb-cc-dd-ee-ff",
+			exp:      []string{"192.168.1.10", "phone"},
+		},
+		"identity glob": {
+			selector: "lease:ph*",
+			exp:      []string{"192.168.1.10", "aa-bb-cc-dd-ee-ff"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s, err := ParseSelector(tc.selector)
+			require.NoError(t, err)
+			assert.Equal(t, tc.exp, tagger.Select(s))
+		})
+	}
+	assert.Equal(t, map[string]int{"apple": 3, "samsung": 1}, tagger.Count("vendor", nil))
+}
+
+func TestLinkExportAndSnapshot(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "tagger.json")
+	saved := NewTagger()
+	saved.now = func() time.Time { return now }
+	saved.Update("aa-bb-cc-dd-ee-ff", NewTagUnsafe("vendor", "apple"))
+	saved.Link(NewTagUnsafe("mac", "aa-bb-cc-dd-ee-ff"), NewTagUnsafe("lease", "phone"))
+
+	assert.Equal(t, map[string][]Entry{
+		"phone": {{Tag: "mac:aa-bb-cc-dd-ee-ff", Updated: now}},
+	}, saved.Export([]string{"mac"}))
+	require.NoError(t, saved.SaveSnapshot(path))
+
+	restored := NewTagger()
+	restored.now = func() time.Time { return now.Add(time.Minute) }
+	_, err := restored.RestoreSnapshot(path, time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"mac:aa-bb-cc-dd-ee-ff", "vendor:apple"}, restored.Get("phone"))
+}
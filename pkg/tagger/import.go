@@ -0,0 +1,246 @@
+package tagger
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// LocalWins keeps the current tags of a key over the imported ones
+	LocalWins ConflictPolicy = "local-wins"
+	// RemoteWins replaces the current tags of a key by the imported ones
+	RemoteWins ConflictPolicy = "remote-wins"
+	// NewestWins keeps the most recently updated tags of a key
+	NewestWins ConflictPolicy = "newest-wins"
+)
+
+// ConflictPolicy resolves an imported key already set by a collector or another peer
+type ConflictPolicy string
+
+func ParseConflictPolicy(s string) (ConflictPolicy, error) {
+	switch p := ConflictPolicy(s); p {
+	case LocalWins, RemoteWins, NewestWins:
+		return p, nil
+	case "":
+		return LocalWins, nil
+	}
+	return "", fmt.Errorf("invalid conflict policy %q, must be one of %s, %s, %s", s, LocalWins, RemoteWins, NewestWins)
+}
+
+// exportable must be called with the lock
+func exportable(tag *viewTag, keys map[tagKey]struct{}) bool {
+	if tag.source != "" {
+		return false
+	}
+	if len(keys) == 0 {
+		return true
+	}
+	_, ok := keys[tag.key]
+	return ok
+}
+
+// exportEntity must be called with the lock, the identities and the tags inherited from them are exported like the own tags
+func (t *Tagger) exportEntity(entity string, keys map[tagKey]struct{}, now time.Time) []Entry {
+	var entries []Entry
+	for _, tag := range t.view(entity, 0, now) {
+		if !exportable(&tag, keys) {
+			continue
+		}
+		entries = append(entries, Entry{Tag: tag.keyValue, Updated: tag.updated, TTL: tag.ttl})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Tag < entries[j].Tag })
+	return entries
+}
+
+func keySet(keys []string) map[tagKey]struct{} {
+	set := make(map[tagKey]struct{}, len(keys))
+	for _, key := range keys {
+		set[tagKey(key)] = struct{}{}
+	}
+	return set
+}
+
+// Export returns the local tags of the keys, all of them when empty, the imported tags aren't exported again
+// the identifiers of the devices are exported with the tags visible from them
+func (t *Tagger) Export(keys []string) map[string][]Entry {
+	now := t.now()
+	set := keySet(keys)
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	entities := make(map[string][]Entry)
+	export := func(entity string) {
+		entries := t.exportEntity(entity, set, now)
+		if len(entries) > 0 {
+			entities[entity] = entries
+		}
+	}
+	for entity := range t.store {
+		export(entity)
+	}
+	for _, identifier := range t.identifiersLocked() {
+		export(identifier)
+	}
+	return entities
+}
+
+// ExportEntity returns the local tags of the keys of the entity
+func (t *Tagger) ExportEntity(entity string, keys []string) []Entry {
+	now := t.now()
+	set := keySet(keys)
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.exportEntity(entity, set, now)
+}
+
+// acceptImport must be called with the lock, it resolves the conflicts with the current tags of the key
+func (t *Tagger) acceptImport(source string, key tagKey, values map[tagValue]*tagEntry, imported []*tagEntry, policy ConflictPolicy, now time.Time) bool {
+	conflict := false
+	var current time.Time
+	for _, e := range values {
+		if e.source == source || t.expired(key, e, now) {
+			continue
+		}
+		conflict = true
+		if e.updated.After(current) {
+			current = e.updated
+		}
+	}
+	if !conflict {
+		return true
+	}
+	switch policy {
+	case RemoteWins:
+		return true
+	case NewestWins:
+		for _, e := range imported {
+			if e.updated.After(current) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// importLocked must be called with the lock
+func (t *Tagger) importLocked(source, entity string, entries []Entry, policy ConflictPolicy, now time.Time) int {
+	entityTags, hasEntity := t.store[entity]
+	if !hasEntity {
+		entityTags = make(entityStore, len(entries))
+	}
+	var before map[string]map[string]struct{}
+	watched := t.hasWatchers()
+	if watched {
+		before = t.viewsLocked(t.groupLocked(entity, now), now)
+	}
+
+	byKey := make(map[tagKey][]*tagEntry, len(entries))
+	exported := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		i := strings.Index(entry.Tag, keyValueJoin)
+		if i <= 0 || i+1 >= len(entry.Tag) {
+			continue
+		}
+		e := &tagEntry{
+			keyValue: entry.Tag,
+			updated:  entry.Updated,
+			ttl:      entry.TTL,
+			source:   source,
+		}
+		key := tagKey(entry.Tag[:i])
+		if t.expired(key, e, now) {
+			continue
+		}
+		exported[entry.Tag] = struct{}{}
+		byKey[key] = append(byKey[key], e)
+	}
+
+	// the tags the source doesn't export anymore
+	for key, values := range entityTags {
+		for value, e := range values {
+			if e.source != source {
+				continue
+			}
+			if _, ok := exported[e.keyValue]; ok {
+				continue
+			}
+			delete(values, value)
+			t.indexRemove(entity, key, value)
+		}
+		if len(values) == 0 {
+			delete(entityTags, key)
+		}
+	}
+
+	imported := 0
+	for key, importedEntries := range byKey {
+		values := entityTags[key]
+		if !t.acceptImport(source, key, values, importedEntries, policy, now) {
+			continue
+		}
+		for value := range values {
+			t.indexRemove(entity, key, value)
+		}
+		values = make(map[tagValue]*tagEntry, len(importedEntries))
+		for _, e := range importedEntries {
+			value := tagValue(e.keyValue[len(key)+len(keyValueJoin):])
+			values[value] = e
+			t.indexAdd(entity, key, value)
+			imported++
+		}
+		entityTags[key] = values
+	}
+
+	switch {
+	case len(entityTags) > 0:
+		t.store[entity] = entityTags
+	case hasEntity:
+		delete(t.store, entity)
+	}
+	if watched {
+		t.notifyViews(source, before, t.groupLocked(entity, now), now)
+	}
+	return imported
+}
+
+// ImportEntity sets the tags of the entity exported by the source and removes the ones it doesn't export anymore
+// the policy resolves the keys already set by a collector or another peer, it returns the number of imported tags
+func (t *Tagger) ImportEntity(source, entity string, entries []Entry, policy ConflictPolicy) int {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.importLocked(source, entity, entries, policy, now)
+}
+
+// ImportSnapshot imports the entities exported by the source and removes the tags of the source missing from them
+func (t *Tagger) ImportSnapshot(source string, entities map[string][]Entry, policy ConflictPolicy) int {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var missing []string
+	for entity, entityTags := range t.store {
+		if _, ok := entities[entity]; ok {
+			continue
+		}
+	lookup:
+		for _, values := range entityTags {
+			for _, e := range values {
+				if e.source == source {
+					missing = append(missing, entity)
+					break lookup
+				}
+			}
+		}
+	}
+	for _, entity := range missing {
+		t.importLocked(source, entity, nil, policy, now)
+	}
+	imported := 0
+	for entity, entries := range entities {
+		imported += t.importLocked(source, entity, entries, policy, now)
+	}
+	return imported
+}
@@ -0,0 +1,94 @@
+package tagger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImportEntity(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	older, newer := now.Add(-time.Hour), now.Add(time.Hour)
+
+	for name, tc := range map[string]struct {
+		policy  ConflictPolicy
+		updated time.Time
+		exp     []string
+	}{
+		"local wins": {
+			policy:  LocalWins,
+			updated: newer,
+			exp:     []string{"lease:local-phone", "vendor:apple"},
+		},
+		"remote wins": {
+			policy:  RemoteWins,
+			updated: older,
+			exp:     []string{"lease:remote-phone", "vendor:apple"},
+		},
+		"newest wins with a newer remote": {
+			policy:  NewestWins,
+			updated: newer,
+			exp:     []string{"lease:remote-phone", "vendor:apple"},
+		},
+		"newest wins with an older remote": {
+			policy:  NewestWins,
+			updated: older,
+			exp:     []string{"lease:local-phone", "vendor:apple"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tagger := NewTagger()
+			tagger.now = func() time.Time { return now }
+			tagger.Update("192.168.1.10", NewTagUnsafe("lease", "local-phone"))
+
+			tagger.ImportEntity("router", "192.168.1.10", []Entry{
+				{Tag: "lease:remote-phone", Updated: tc.updated},
+				{Tag: "vendor:apple", Updated: tc.updated},
+			}, tc.policy)
+			assert.Equal(t, tc.exp, tagger.Get("192.168.1.10"))
+		})
+	}
+}
+
+func TestImportSnapshot(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tagger := NewTagger()
+	tagger.now = func() time.Time { return now }
+	tagger.Update("192.168.1.11", NewTagUnsafe("device", "wlan0"))
+	w := tagger.Watch(nil)
+	defer w.Close()
+
+	n := tagger.ImportSnapshot("router", map[string][]Entry{
+		"192.168.1.10": {{Tag: "lease:phone", Updated: now}},
+		"192.168.1.11": {{Tag: "lease:laptop", Updated: now}},
+		// expired on the router
+		"192.168.1.12": {{Tag: "lease:tv", Updated: now.Add(-time.Hour), TTL: time.Minute}},
+	}, LocalWins)
+	assert.Equal(t, 2, n)
+	assert.Equal(t, []string{"lease:phone"}, tagger.Get("192.168.1.10"))
+	assert.Equal(t, []string{"device:wlan0", "lease:laptop"}, tagger.Get("192.168.1.11"))
+	assert.Equal(t, []string{}, tagger.Get("192.168.1.12"))
+	assert.Equal(t, 2.0, tagger.Stats().Imported)
+	assert.Equal(t, "router", (<-w.C).Source)
+	assert.Equal(t, "router", (<-w.C).Source)
+
+	// the imported tags aren't exported again
+	assert.Equal(t, map[string][]Entry{
+		"192.168.1.11": {{Tag: "device:wlan0", Updated: now}},
+	}, tagger.Export(nil))
+	assert.Empty(t, tagger.Export([]string{"lease"}))
+
+	// the tags missing from the next snapshot are removed, the local ones are kept
+	tagger.ImportSnapshot("router", map[string][]Entry{
+		"192.168.1.10": {{Tag: "lease:phone", Updated: now}},
+	}, LocalWins)
+	assert.Equal(t, []string{"device:wlan0"}, tagger.Get("192.168.1.11"))
+	assert.Equal(t, []string{"lease:phone"}, tagger.Get("192.168.1.10"))
+
+	// a collector takes over the imported key
+	tagger.Update("192.168.1.10", NewTagUnsafe("lease", "phone"))
+	tagger.ImportEntity("router", "192.168.1.10", nil, LocalWins)
+	assert.Equal(t, []string{"lease:phone"}, tagger.Get("192.168.1.10"))
+	assert.Equal(t, 0.0, tagger.Stats().Imported)
+}
@@ -0,0 +1,179 @@
+package tagger
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// MaxTagLength is the number of characters Datadog keeps of a tag
+	MaxTagLength = 200
+)
+
+// normalisedValue is a value of the index with the first original tag normalised to it
+type normalisedValue struct {
+	original   string
+	collisions map[string]struct{}
+}
+
+func validTagRune(r rune) bool {
+	switch {
+	case unicode.IsLetter(r), unicode.IsDigit(r):
+		return true
+	}
+	switch r {
+	case '_', '-', ':', '.', '/':
+		return true
+	}
+	return false
+}
+
+// isNormalised is the fast path of the tags already following the rules
+func isNormalised(s string, leadingLetter bool) bool {
+	if s == "" || len(s) > MaxTagLength {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c >= 'a' && c <= 'z':
+		case c >= '0' && c <= '9', c == '-', c == ':', c == '.', c == '/':
+			if i == 0 && leadingLetter {
+				return false
+			}
+		case c == '_':
+			if i == 0 && leadingLetter || i == len(s)-1 || s[i+1] == '_' {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// normalise applies the Datadog rules: lowercase, the characters other than letters, digits, _ - : . / become _,
+// the consecutive _ are merged and the trailing ones removed, the leading characters other than letters are removed
+// with leadingLetter, the result is truncated to maxLength characters
+func normalise(s string, leadingLetter bool, maxLength int) string {
+	if isNormalised(s, leadingLetter) && len(s) <= maxLength {
+		return s
+	}
+	b := strings.Builder{}
+	b.Grow(len(s))
+	length := 0
+	underscore := false
+	for _, r := range s {
+		if length >= maxLength {
+			break
+		}
+		if leadingLetter && length == 0 && !unicode.IsLetter(r) {
+			continue
+		}
+		r = unicode.ToLower(r)
+		if !validTagRune(r) {
+			r = '_'
+		}
+		if r == '_' {
+			if underscore {
+				continue
+			}
+			underscore = true
+		} else {
+			underscore = false
+		}
+		b.WriteRune(r)
+		length++
+	}
+	return strings.TrimRight(b.String(), "_")
+}
+
+// NormaliseTag returns the tag following the Datadog rules, like the tags of the series
+func NormaliseTag(tag string) string {
+	return normalise(tag, true, MaxTagLength)
+}
+
+// NormaliseTags returns the tags following the Datadog rules, the slice is only copied when a tag changes
+func NormaliseTags(tags []string) []string {
+	for i, tag := range tags {
+		if isNormalised(tag, true) {
+			continue
+		}
+		normalised := make([]string, i, len(tags))
+		copy(normalised, tags[:i])
+		for _, tag := range tags[i:] {
+			tag = NormaliseTag(tag)
+			if tag == "" {
+				continue
+			}
+			normalised = append(normalised, tag)
+		}
+		return normalised
+	}
+	return tags
+}
+
+// normaliseTag returns the tag following the Datadog rules, keeping the original one when it changes
+func normaliseTag(key, value string) (*Tag, bool) {
+	k := normalise(key, true, MaxTagLength-2)
+	if k == "" {
+		return nil, false
+	}
+	v := normalise(value, false, MaxTagLength-utf8.RuneCountInString(k)-len(keyValueJoin))
+	if v == "" {
+		return nil, false
+	}
+	tag := NewTagUnsafe(k, v)
+	if k != key || v != value {
+		tag.original = key + keyValueJoin + value
+	}
+	return tag, true
+}
+
+// registerOriginals must be called with the lock, it reports the different tags normalised to the same indexed value
+func (t *Tagger) registerOriginals(tags []*Tag) {
+	for _, tag := range tags {
+		values, ok := t.originals[tag.key]
+		if !ok {
+			values = make(map[tagValue]*normalisedValue)
+			t.originals[tag.key] = values
+		}
+		original := tag.Original()
+		v, ok := values[tag.value]
+		if !ok {
+			values[tag.value] = &normalisedValue{original: original}
+			continue
+		}
+		if v.original == original {
+			continue
+		}
+		if _, ok := v.collisions[original]; ok {
+			continue
+		}
+		if v.collisions == nil {
+			v.collisions = make(map[string]struct{}, 1)
+		}
+		v.collisions[original] = struct{}{}
+		t.tagCollisions++
+		zap.L().Warn("different tags normalised to the same tag",
+			zap.String("tag", tag.keyValue),
+			zap.String("original", original),
+			zap.String("previous", v.original),
+		)
+	}
+}
+
+// unregisterOriginal must be called with the lock, once the value left the index
+func (t *Tagger) unregisterOriginal(key tagKey, value tagValue) {
+	values, ok := t.originals[key]
+	if !ok {
+		return
+	}
+	delete(values, value)
+	if len(values) == 0 {
+		delete(t.originals, key)
+	}
+}
@@ -0,0 +1,84 @@
+package tagger
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormaliseTag(t *testing.T) {
+	for name, tc := range map[string]struct {
+		tag string
+		exp string
+	}{
+		"valid": {
+			tag: "mac:aa-bb-cc-dd-ee-ff",
+			exp: "mac:aa-bb-cc-dd-ee-ff",
+		},
+		"lowercase": {
+			tag: "SSID:Home",
+			exp: "ssid:home",
+		},
+		"invalid characters": {
+			tag: "device-name:Living Room #2",
+			exp: "device-name:living_room_2",
+		},
+		"consecutive and trailing underscores": {
+			tag: "lease:phone__(old)",
+			exp: "lease:phone_old",
+		},
+		"leading characters": {
+			tag: "_2ghz:ssid",
+			exp: "ghz:ssid",
+		},
+		"unicode letters": {
+			tag: "alias:Écouteurs",
+			exp: "alias:écouteurs",
+		},
+		"too long": {
+			tag: "name:" + strings.Repeat("a", 300),
+			exp: "name:" + strings.Repeat("a", MaxTagLength-5),
+		},
+		"without letter": {
+			tag: "1234",
+			exp: "",
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.exp, NormaliseTag(tc.tag))
+		})
+	}
+}
+
+func TestNormaliseTags(t *testing.T) {
+	tags := []string{"ssid:home", "device:wlan0"}
+	assert.Equal(t, &tags[0], &NormaliseTags(tags)[0])
+
+	normalised := NormaliseTags([]string{"ssid:home", "Name:My Phone", "!!"})
+	assert.Equal(t, []string{"ssid:home", "name:my_phone"}, normalised)
+}
+
+func TestTagCollisions(t *testing.T) {
+	tagger := NewTagger()
+	phone, err := NewTag("lease", "My Phone")
+	assert.NoError(t, err)
+	other, err := NewTag("lease", "my_phone")
+	assert.NoError(t, err)
+	assert.Equal(t, "lease:My Phone", phone.Original())
+	assert.Equal(t, "lease:my_phone", other.Original())
+
+	tagger.Update("192.168.1.10", phone)
+	tagger.Update("192.168.1.10", phone)
+	assert.Equal(t, 0.0, tagger.Stats().TagCollisions)
+	tagger.Update("192.168.1.11", other)
+	tagger.Update("192.168.1.11", other)
+	assert.Equal(t, 1.0, tagger.Stats().TagCollisions)
+
+	// the value left the tagger
+	tagger.Replace("192.168.1.10")
+	tagger.Replace("192.168.1.11")
+	tagger.Update("192.168.1.11", other)
+	tagger.Update("192.168.1.10", other)
+	assert.Equal(t, 1.0, tagger.Stats().TagCollisions)
+}
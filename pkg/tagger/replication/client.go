@@ -0,0 +1,174 @@
+package replication
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/JulienBalestra/monitoring/pkg/tagger"
+	"go.uber.org/zap"
+)
+
+const (
+	minReconnectInterval = time.Second * 5
+	maxReconnectInterval = time.Minute * 2
+	maxMessageSize       = 16 << 20
+)
+
+// Client imports the tagger of a peer
+type Client struct {
+	tagger     *tagger.Tagger
+	peer       *Peer
+	token      string
+	httpClient *http.Client
+
+	// heartbeatTimeout without any message reconnects to the peer
+	heartbeatTimeout time.Duration
+}
+
+func NewClient(t *tagger.Tagger, peer *Peer, token string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = &http.Client{}
+	}
+	return &Client{
+		tagger:           t,
+		peer:             peer,
+		token:            token,
+		httpClient:       httpClient,
+		heartbeatTimeout: DefaultHeartbeatInterval * 3,
+	}
+}
+
+func (c *Client) streamURL() string {
+	u := strings.TrimSuffix(c.peer.URL, "/") + Path
+	if len(c.peer.Keys) == 0 {
+		return u
+	}
+	q := url.Values{}
+	for _, key := range c.peer.Keys {
+		q.Add(queryKey, key)
+	}
+	return u + "?" + q.Encode()
+}
+
+func (c *Client) filter(entries []tagger.Entry) []tagger.Entry {
+	if len(c.peer.Keys) == 0 {
+		return entries
+	}
+	filtered := entries[:0]
+	for _, e := range entries {
+		for _, key := range c.peer.Keys {
+			if strings.HasPrefix(e.Tag, key+":") {
+				filtered = append(filtered, e)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// apply imports a message of the stream, it returns the number of imported tags
+func (c *Client) apply(m *message) (int, error) {
+	switch m.Type {
+	case messageHeartbeat:
+		return 0, nil
+
+	case messageSnapshot:
+		entities := make(map[string][]tagger.Entry, len(m.Entities))
+		for entity, entries := range m.Entities {
+			if !c.peer.importsEntity(entity) {
+				continue
+			}
+			entities[entity] = c.filter(entries)
+		}
+		return c.tagger.ImportSnapshot(c.peer.Name, entities, c.peer.Conflict), nil
+
+	case messageEntity:
+		if !c.peer.importsEntity(m.Entity) {
+			return 0, nil
+		}
+		return c.tagger.ImportEntity(c.peer.Name, m.Entity, c.filter(m.Tags), c.peer.Conflict), nil
+	}
+	return 0, fmt.Errorf("unknown replication message %q", m.Type)
+}
+
+// replicate imports the stream of the peer until it fails or the context is done
+func (c *Client) replicate(ctx context.Context, zctx *zap.Logger) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.streamURL(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("replication stream of peer %s returned %d: %s", c.peer.Name, resp.StatusCode, strings.TrimSpace(string(b)))
+	}
+
+	// a silent peer is reconnected
+	watchdog := time.AfterFunc(c.heartbeatTimeout, cancel)
+	defer watchdog.Stop()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageSize)
+	for scanner.Scan() {
+		watchdog.Reset(c.heartbeatTimeout)
+		m := &message{}
+		err = json.Unmarshal(scanner.Bytes(), m)
+		if err != nil {
+			return fmt.Errorf("invalid replication message of peer %s: %v", c.peer.Name, err)
+		}
+		imported, err := c.apply(m)
+		if err != nil {
+			return err
+		}
+		if m.Type == messageSnapshot {
+			zctx.Info("imported tagger snapshot", zap.Int("tags", imported), zap.Int("entities", len(m.Entities)))
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return err
+	}
+	return io.ErrUnexpectedEOF
+}
+
+// Run imports the tagger of the peer until the context is done, it reconnects with a backoff
+func (c *Client) Run(ctx context.Context) {
+	zctx := zap.L().With(zap.String("peer", c.peer.Name), zap.String("url", c.peer.URL))
+	backoff := minReconnectInterval
+	for {
+		start := time.Now()
+		err := c.replicate(ctx, zctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(start) > maxReconnectInterval {
+			backoff = minReconnectInterval
+		}
+		zctx.Error("tagger replication interrupted", zap.Error(err), zap.Duration("reconnectIn", backoff))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > maxReconnectInterval {
+			backoff = maxReconnectInterval
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package replication
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/JulienBalestra/monitoring/pkg/tagger"
+)
+
+const (
+	// Path of the replication stream
+	Path = "/v1/tagger/replication"
+
+	// DefaultHeartbeatInterval keeps the idle streams alive, the peers reconnect after missing a few of them
+	DefaultHeartbeatInterval = time.Second * 30
+
+	queryKey = "key"
+
+	messageSnapshot  = "snapshot"
+	messageEntity    = "entity"
+	messageHeartbeat = "heartbeat"
+)
+
+// message is a line of the newline-delimited JSON stream
+// the stream starts with the snapshot of the exported entities, then sends the tags of each changed entity
+type message struct {
+	Type     string                    `json:"type"`
+	Entities map[string][]tagger.Entry `json:"entities,omitempty"`
+	Entity   string                    `json:"entity,omitempty"`
+	Tags     []tagger.Entry            `json:"tags,omitempty"`
+}
+
+// Peer is an instance exporting its tagger
+type Peer struct {
+	// Name is the source of the imported tags, defaults to the host of the URL
+	Name string
+	URL  string
+	// Keys are the imported tag keys, empty imports all of them
+	Keys []string
+	// Entities are the patterns of the imported entities, like 192.168.1.*, empty imports all of them
+	Entities []string
+	Conflict tagger.ConflictPolicy
+}
+
+// ParsePeer parses the comma separated fields of a peer like url=http://router:8125,keys=lease|vendor,conflict=newest-wins
+func ParsePeer(s string) (*Peer, error) {
+	p := &Peer{}
+	for _, kv := range strings.Split(s, ",") {
+		i := strings.Index(kv, "=")
+		if i == -1 {
+			return nil, fmt.Errorf("invalid peer field %q, must be key=value", kv)
+		}
+		k, v := strings.TrimSpace(kv[:i]), strings.TrimSpace(kv[i+1:])
+		switch k {
+		case "name":
+			p.Name = v
+		case "url":
+			p.URL = v
+		case "keys":
+			p.Keys = strings.Split(v, "|")
+		case "entities":
+			p.Entities = strings.Split(v, "|")
+		case "conflict":
+			p.Conflict = tagger.ConflictPolicy(v)
+		default:
+			return nil, fmt.Errorf("unknown peer field %q", k)
+		}
+	}
+	err := p.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Validate the peer and set its defaults
+func (p *Peer) Validate() error {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return fmt.Errorf("invalid peer URL %q: %v", p.URL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid peer URL %q, must be http(s)://host:port", p.URL)
+	}
+	if p.Name == "" {
+		p.Name = u.Host
+	}
+	p.Conflict, err = tagger.ParseConflictPolicy(string(p.Conflict))
+	if err != nil {
+		return fmt.Errorf("invalid peer %q: %v", p.Name, err)
+	}
+	for _, pattern := range p.Entities {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf("invalid entity pattern %q of peer %q: %v", pattern, p.Name, err)
+		}
+	}
+	return nil
+}
+
+func (p *Peer) String() string {
+	return p.Name
+}
+
+func (p *Peer) importsEntity(entity string) bool {
+	if len(p.Entities) == 0 {
+		return true
+	}
+	for _, pattern := range p.Entities {
+		ok, _ := path.Match(pattern, entity)
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadTokenFile returns the shared token of the file
+func ReadTokenFile(file string) (string, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("empty replication token in %s", file)
+	}
+	return token, nil
+}
@@ -0,0 +1,113 @@
+package replication
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/JulienBalestra/monitoring/pkg/tagger"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestParsePeer(t *testing.T) {
+	for name, tc := range map[string]struct {
+		peer string
+		exp  *Peer
+		err  bool
+	}{
+		"url": {
+			peer: "url=http://router:8126",
+			exp:  &Peer{Name: "router:8126", URL: "http://router:8126", Conflict: tagger.LocalWins},
+		},
+		"every field": {
+			peer: "name=router, url=https://192.168.1.1:8126,keys=lease|vendor,entities=192.168.1.*|aa-bb-*,conflict=newest-wins",
+			exp: &Peer{
+				Name:     "router",
+				URL:      "https://192.168.1.1:8126",
+				Keys:     []string{"lease", "vendor"},
+				Entities: []string{"192.168.1.*", "aa-bb-*"},
+				Conflict: tagger.NewestWins,
+			},
+		},
+		"missing url": {
+			peer: "name=router",
+			err:  true,
+		},
+		"invalid scheme": {
+			peer: "url=ftp://router",
+			err:  true,
+		},
+		"invalid conflict": {
+			peer: "url=http://router:8126,conflict=router-wins",
+			err:  true,
+		},
+		"invalid pattern": {
+			peer: "url=http://router:8126,entities=[192",
+			err:  true,
+		},
+		"unknown field": {
+			peer: "url=http://router:8126,token=secret",
+			err:  true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p, err := ParsePeer(tc.peer)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.exp, p)
+		})
+	}
+}
+
+func TestReplication(t *testing.T) {
+	router := tagger.NewTagger()
+	router.Update("192.168.1.10", tagger.NewTagUnsafe("lease", "phone"), tagger.NewTagUnsafe("mac", "aa-bb-cc-dd-ee-ff"))
+	router.Update("10.0.0.1", tagger.NewTagUnsafe("lease", "vpn"))
+
+	srv := httptest.NewServer(NewHandler(router, "secret"))
+	defer srv.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	repeater := tagger.NewTagger()
+	repeater.Update("192.168.1.10", tagger.NewTagUnsafe("device", "wlan0"))
+	peer, err := ParsePeer("name=router,url=" + srv.URL + ",keys=lease,entities=192.168.1.*")
+	require.NoError(t, err)
+	go NewClient(repeater, peer, "secret", srv.Client()).Run(ctx)
+
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"device:wlan0", "lease:phone"}, repeater.Get("192.168.1.10"))
+	}, time.Second*5, time.Millisecond*10)
+	assert.Equal(t, []string{}, repeater.Get("10.0.0.1"))
+
+	// incremental changes
+	router.Update("192.168.1.11", tagger.NewTagUnsafe("lease", "laptop"))
+	router.Replace("192.168.1.10", tagger.NewTagUnsafe("mac", "aa-bb-cc-dd-ee-ff"))
+	require.Eventually(t, func() bool {
+		return assert.ObjectsAreEqual([]string{"lease:laptop"}, repeater.Get("192.168.1.11")) &&
+			assert.ObjectsAreEqual([]string{"device:wlan0"}, repeater.Get("192.168.1.10"))
+	}, time.Second*5, time.Millisecond*10)
+}
+
+func TestReplicationUnauthorized(t *testing.T) {
+	srv := httptest.NewServer(NewHandler(tagger.NewTagger(), "secret"))
+	defer srv.Close()
+
+	peer, err := ParsePeer("url=" + srv.URL)
+	require.NoError(t, err)
+	err = NewClient(tagger.NewTagger(), peer, "wrong", srv.Client()).replicate(context.Background(), zap.NewNop())
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "401")
+
+	resp, err := srv.Client().Post(srv.URL+Path, "application/json", nil)
+	require.NoError(t, err)
+	_ = resp.Body.Close()
+	assert.Equal(t, http.StatusMethodNotAllowed, resp.StatusCode)
+}
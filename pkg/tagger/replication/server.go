@@ -0,0 +1,153 @@
+package replication
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/JulienBalestra/monitoring/pkg/tagger"
+	"go.uber.org/zap"
+)
+
+// Handler streams the tagger to the peers presenting the shared token
+type Handler struct {
+	tagger            *tagger.Tagger
+	token             []byte
+	heartbeatInterval time.Duration
+}
+
+func NewHandler(t *tagger.Tagger, token string) *Handler {
+	return &Handler{
+		tagger:            t,
+		token:             []byte(token),
+		heartbeatInterval: DefaultHeartbeatInterval,
+	}
+}
+
+func (h *Handler) authorized(r *http.Request) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), h.token) == 1
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != Path {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if !h.authorized(r) {
+		zap.L().Warn("unauthorized tagger replication", zap.String("remote", r.RemoteAddr))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	keys := r.URL.Query()[queryKey]
+
+	// the watcher starts before the snapshot to not miss a change
+	watcher := h.tagger.Watch(&tagger.WatchFilter{Keys: keys})
+	defer watcher.Close()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	enc := json.NewEncoder(w)
+	zctx := zap.L().With(zap.String("remote", r.RemoteAddr), zap.Strings("keys", keys))
+	zctx.Info("streaming tagger replication")
+
+	send := func(m *message) bool {
+		err := enc.Encode(m)
+		if err != nil {
+			zctx.Debug("stopped tagger replication", zap.Error(err))
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+	if !send(&message{Type: messageSnapshot, Entities: h.tagger.Export(keys)}) {
+		return
+	}
+	dropped := watcher.Dropped()
+	ticker := time.NewTicker(h.heartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			zctx.Info("stopped tagger replication")
+			return
+
+		case <-ticker.C:
+			if !send(&message{Type: messageHeartbeat}) {
+				return
+			}
+
+		case e, ok := <-watcher.C:
+			if !ok {
+				return
+			}
+			if d := watcher.Dropped(); d > dropped {
+				// the peer missed changes
+				dropped = d
+				zctx.Warn("tagger replication is late, sending a new snapshot")
+				if !send(&message{Type: messageSnapshot, Entities: h.tagger.Export(keys)}) {
+					return
+				}
+				continue
+			}
+			if e.Source != "" {
+				// the imported tags aren't exported again
+				continue
+			}
+			if !send(&message{Type: messageEntity, Entity: e.Entity, Tags: h.tagger.ExportEntity(e.Entity, keys)}) {
+				return
+			}
+		}
+	}
+}
+
+// ListenAndServe serves the replication until the context is done
+// the certificate and its key are optional, the stream is in plain HTTP without them
+func ListenAndServe(ctx context.Context, address string, handler http.Handler, certFile, keyFile string) error {
+	srv := &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: time.Second * 10,
+	}
+	if certFile != "" {
+		srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	errs := make(chan error, 1)
+	go func() {
+		zap.L().Info("listening for the tagger replication", zap.String("address", address), zap.Bool("tls", certFile != ""))
+		if certFile != "" {
+			errs <- srv.ListenAndServeTLS(certFile, keyFile)
+			return
+		}
+		errs <- srv.ListenAndServe()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		// the streams never end by themselves, a graceful shutdown would wait for them
+		_ = srv.Close()
+		err := <-errs
+		if errors.Is(err, http.ErrServerClosed) {
+			return nil
+		}
+		return err
+	}
+}
@@ -0,0 +1,275 @@
+package tagger
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	selectorAnd = "AND"
+)
+
+type selectorTerm struct {
+	key   tagKey
+	value string
+	glob  bool
+}
+
+// Selector matches the entities having a tag for every term, like vendor:apple AND device:eth1
+// a value accepts wildcards, like lease:*phone* or vendor:* for any value
+type Selector struct {
+	terms []selectorTerm
+}
+
+// ParseSelector parses terms key:value separated by AND
+func ParseSelector(s string) (*Selector, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("empty selector")
+	}
+	selector := &Selector{}
+	for i, field := range fields {
+		if i%2 == 1 {
+			if !strings.EqualFold(field, selectorAnd) {
+				return nil, fmt.Errorf("invalid selector %q: expected %s, got %q", s, selectorAnd, field)
+			}
+			continue
+		}
+		index := strings.Index(field, keyValueJoin)
+		if index <= 0 || index+1 >= len(field) {
+			return nil, fmt.Errorf("invalid selector %q: invalid term %q", s, field)
+		}
+		term := selectorTerm{
+			key:   tagKey(field[:index]),
+			value: field[index+1:],
+		}
+		term.glob = strings.ContainsAny(term.value, "*?[")
+		if term.glob {
+			_, err := path.Match(term.value, "")
+			if err != nil {
+				return nil, fmt.Errorf("invalid selector %q: %q: %v", s, field, err)
+			}
+		}
+		selector.terms = append(selector.terms, term)
+	}
+	if len(fields)%2 == 0 {
+		return nil, fmt.Errorf("invalid selector %q: missing term after %s", s, selectorAnd)
+	}
+	return selector, nil
+}
+
+func (s *Selector) String() string {
+	terms := make([]string, 0, len(s.terms))
+	for _, term := range s.terms {
+		terms = append(terms, string(term.key)+keyValueJoin+term.value)
+	}
+	return strings.Join(terms, " "+selectorAnd+" ")
+}
+
+func (term *selectorTerm) match(value string) bool {
+	if !term.glob {
+		return value == term.value
+	}
+	ok, _ := path.Match(term.value, value)
+	return ok
+}
+
+// candidates must be called with the lock, it returns the entities indexed with a tag of the term
+// along with the identifiers of their devices and the identifiers matching the term, they share their tags
+func (t *Tagger) candidates(term *selectorTerm, now time.Time) map[string]struct{} {
+	values := t.index[term.key]
+	entities := make(map[string]struct{})
+	for value, indexed := range values {
+		if !term.match(string(value)) {
+			continue
+		}
+		for entity := range indexed {
+			entities[entity] = struct{}{}
+		}
+	}
+	if len(t.links) == 0 {
+		return entities
+	}
+	for _, name := range t.matchIdentifiers(term) {
+		entities[name] = struct{}{}
+	}
+	for entity := range entities {
+		for _, name := range t.groupLocked(entity, now) {
+			entities[name] = struct{}{}
+		}
+	}
+	return entities
+}
+
+// matchIdentifiers must be called with the lock, it returns the aliases and the devices matching the term
+func (t *Tagger) matchIdentifiers(term *selectorTerm) []string {
+	var identifiers []string
+	if !term.glob {
+		if l, ok := t.links[term.value]; ok && l.alias.key == term.key {
+			identifiers = append(identifiers, term.value)
+		}
+		if d, ok := t.devices[term.value]; ok && d.tag.key == term.key {
+			identifiers = append(identifiers, term.value)
+		}
+		return identifiers
+	}
+	for name, l := range t.links {
+		if l.alias.key == term.key && term.match(name) {
+			identifiers = append(identifiers, name)
+		}
+	}
+	for name, d := range t.devices {
+		if d.tag.key == term.key && term.match(name) {
+			identifiers = append(identifiers, name)
+		}
+	}
+	return identifiers
+}
+
+// matchTerm checks the view of the entity and the enrichment the index doesn't know about
+func matchTerm(term *selectorTerm, view []viewTag, enrichment []*Tag) bool {
+	for _, tag := range view {
+		if tag.key == term.key && term.match(string(tag.value)) {
+			return true
+		}
+	}
+	for _, tag := range enrichment {
+		if tag.key == term.key && term.match(string(tag.value)) {
+			return true
+		}
+	}
+	return false
+}
+
+// selectLocked must be called with the lock
+func (t *Tagger) selectLocked(selector *Selector, now time.Time) []string {
+	enrichmentKeys := t.enrichment.keys()
+	var candidates map[string]struct{}
+	scan := false
+	for i := range selector.terms {
+		term := &selector.terms[i]
+		if _, ok := enrichmentKeys[term.key]; ok {
+			// the static tags aren't indexed
+			continue
+		}
+		c := t.candidates(term, now)
+		if candidates == nil || len(c) < len(candidates) {
+			candidates = c
+		}
+		if len(candidates) == 0 {
+			return []string{}
+		}
+	}
+	if candidates == nil {
+		scan = true
+	}
+
+	entities := make([]string, 0)
+	check := func(entity string) {
+		view := t.view(entity, 0, now)
+		var enrichment []*Tag
+		if len(enrichmentKeys) > 0 {
+			enrichment = t.enrich(entity, view)
+		}
+		for i := range selector.terms {
+			if !matchTerm(&selector.terms[i], view, enrichment) {
+				return
+			}
+		}
+		entities = append(entities, entity)
+	}
+	if scan {
+		for entity := range t.store {
+			check(entity)
+		}
+		for _, identifier := range t.identifiersLocked() {
+			check(identifier)
+		}
+	} else {
+		for entity := range candidates {
+			check(entity)
+		}
+	}
+	sort.Strings(entities)
+	return entities
+}
+
+// Select returns the sorted entities matching the selector
+func (t *Tagger) Select(selector *Selector) []string {
+	now := t.now()
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.selectLocked(selector, now)
+}
+
+// Count returns the number of entities matching the selector by value of the key, like the devices per vendor
+// a nil selector counts every entity with the key
+func (t *Tagger) Count(key string, selector *Selector) map[string]int {
+	now := t.now()
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	terms := []selectorTerm{{key: tagKey(key), value: "*", glob: true}}
+	if selector != nil {
+		terms = append(terms, selector.terms...)
+	}
+	counts := make(map[string]int)
+	for _, entity := range t.selectLocked(&Selector{terms: terms}, now) {
+		view := t.view(entity, 0, now)
+		seen := false
+		for _, tag := range view {
+			if tag.key == tagKey(key) {
+				counts[string(tag.value)]++
+				seen = true
+			}
+		}
+		if seen {
+			continue
+		}
+		for _, tag := range t.enrich(entity, view) {
+			if tag.key == tagKey(key) {
+				counts[string(tag.value)]++
+			}
+		}
+	}
+	return counts
+}
+
+// indexAdd must be called with the lock
+func (t *Tagger) indexAdd(entity string, key tagKey, value tagValue) {
+	values, ok := t.index[key]
+	if !ok {
+		values = make(map[tagValue]map[string]struct{})
+		t.index[key] = values
+	}
+	entities, ok := values[value]
+	if !ok {
+		entities = make(map[string]struct{}, 1)
+		values[value] = entities
+	}
+	entities[entity] = struct{}{}
+}
+
+// indexRemove must be called with the lock
+func (t *Tagger) indexRemove(entity string, key tagKey, value tagValue) {
+	values, ok := t.index[key]
+	if !ok {
+		return
+	}
+	entities, ok := values[value]
+	if !ok {
+		return
+	}
+	delete(entities, entity)
+	if len(entities) > 0 {
+		return
+	}
+	delete(values, value)
+	t.unregisterOriginal(key, value)
+	if len(values) == 0 {
+		delete(t.index, key)
+	}
+}
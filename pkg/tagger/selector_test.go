@@ -0,0 +1,118 @@
+package tagger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSelector(t *testing.T) {
+	for name, tc := range map[string]struct {
+		selector string
+		exp      string
+		err      bool
+	}{
+		"one term": {
+			selector: "vendor:apple",
+			exp:      "vendor:apple",
+		},
+		"and": {
+			selector: "vendor:apple  and device:eth1 AND lease:*phone*",
+			exp:      "vendor:apple AND device:eth1 AND lease:*phone*",
+		},
+		"value with colons": {
+			selector: "mac:aa:bb:*",
+			exp:      "mac:aa:bb:*",
+		},
+		"empty": {
+			selector: " ",
+			err:      true,
+		},
+		"missing term": {
+			selector: "vendor:apple AND",
+			err:      true,
+		},
+		"or": {
+			selector: "vendor:apple OR vendor:google",
+			err:      true,
+		},
+		"missing value": {
+			selector: "vendor:",
+			err:      true,
+		},
+		"missing key": {
+			selector: ":apple",
+			err:      true,
+		},
+		"invalid pattern": {
+			selector: "lease:[phone",
+			err:      true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			s, err := ParseSelector(tc.selector)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.exp, s.String())
+		})
+	}
+}
+
+func TestSelect(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tagger := NewTagger()
+	tagger.now = func() time.Time { return now }
+
+	tagger.Update("192.168.1.10", NewTagUnsafe("vendor", "apple"), NewTagUnsafe("device", "eth1"), NewTagUnsafe("lease", "alice-phone"))
+	tagger.Update("192.168.1.11", NewTagUnsafe("vendor", "apple"), NewTagUnsafe("device", "wlan0"), NewTagUnsafe("lease", "alice-laptop"))
+	tagger.Update("192.168.1.12", NewTagUnsafe("vendor", "google"), NewTagUnsafe("device", "eth1"), NewTagUnsafe("lease", "bob-phone"))
+	tagger.UpdateWithTTL("192.168.1.13", time.Minute, NewTagUnsafe("vendor", "apple"), NewTagUnsafe("device", "eth1"))
+	tagger.Add("192.168.1.14", NewTagUnsafe("ssid", "home"), NewTagUnsafe("ssid", "guest"))
+
+	sel := func(s string) *Selector {
+		selector, err := ParseSelector(s)
+		require.NoError(t, err)
+		return selector
+	}
+	assert.Equal(t, []string{"192.168.1.10", "192.168.1.13"}, tagger.Select(sel("vendor:apple AND device:eth1")))
+	assert.Equal(t, []string{"192.168.1.10", "192.168.1.12"}, tagger.Select(sel("lease:*phone*")))
+	assert.Equal(t, []string{"192.168.1.14"}, tagger.Select(sel("ssid:guest AND ssid:home")))
+	assert.Equal(t, []string{}, tagger.Select(sel("vendor:samsung")))
+	assert.Equal(t, map[string]int{"apple": 3, "google": 1}, tagger.Count("vendor", nil))
+	assert.Equal(t, map[string]int{"apple": 2, "google": 1}, tagger.Count("vendor", sel("device:eth1")))
+
+	// expired before the sweep
+	now = now.Add(time.Minute * 2)
+	assert.Equal(t, []string{"192.168.1.10"}, tagger.Select(sel("vendor:apple AND device:eth1")))
+
+	// the index follows the changes
+	tagger.Update("192.168.1.10", NewTagUnsafe("device", "wlan0"))
+	tagger.Replace("192.168.1.12", NewTagUnsafe("vendor", "google"))
+	tagger.Sweep()
+	assert.Equal(t, []string{}, tagger.Select(sel("vendor:apple AND device:eth1")))
+	assert.Equal(t, []string{"192.168.1.10"}, tagger.Select(sel("lease:*phone*")))
+	assert.Equal(t, reverseIndex{
+		"vendor": {
+			"apple":  {"192.168.1.10": {}, "192.168.1.11": {}},
+			"google": {"192.168.1.12": {}},
+		},
+		"device": {"wlan0": {"192.168.1.10": {}, "192.168.1.11": {}}},
+		"lease": {
+			"alice-phone":  {"192.168.1.10": {}},
+			"alice-laptop": {"192.168.1.11": {}},
+		},
+		"ssid": {"home": {"192.168.1.14": {}}, "guest": {"192.168.1.14": {}}},
+	}, tagger.index)
+
+	// the static tags are selected
+	e, err := NewEnrichment([]EnrichmentRule{{Lease: "alice-*", Tags: []string{"owner:alice"}}})
+	require.NoError(t, err)
+	tagger.SetEnrichment(e)
+	assert.Equal(t, []string{"192.168.1.11"}, tagger.Select(sel("owner:alice AND device:wlan0 AND lease:*laptop")))
+	assert.Equal(t, map[string]int{"alice": 2}, tagger.Count("owner", nil))
+}
@@ -0,0 +1,212 @@
+package tagger
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/JulienBalestra/monitoring/pkg/state"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultSnapshotMaxAge is the age after which a tag of the snapshot isn't restored
+	DefaultSnapshotMaxAge = time.Hour * 24
+)
+
+type snapshot struct {
+	Time     time.Time          `json:"time"`
+	Entities map[string][]Entry `json:"entities"`
+	Links    []snapshotLink     `json:"links,omitempty"`
+}
+
+// snapshotLink is the link of an alias to its device, both as tags like ip:192.168.1.10 and mac:aa-bb-cc-dd-ee-ff
+type snapshotLink struct {
+	Alias   string    `json:"alias"`
+	Device  string    `json:"device"`
+	Updated time.Time `json:"updated"`
+}
+
+// SaveSnapshot atomically writes the tags of every entity to the file
+func (t *Tagger) SaveSnapshot(path string) error {
+	s := &snapshot{
+		Time:     t.now(),
+		Entities: make(map[string][]Entry),
+	}
+	t.mu.RLock()
+	for entity, entityTags := range t.store {
+		var tags []Entry
+		for _, values := range entityTags {
+			for _, e := range values {
+				tags = append(tags, e.entry())
+			}
+		}
+		if len(tags) == 0 {
+			continue
+		}
+		sort.Slice(tags, func(i, j int) bool { return tags[i].Tag < tags[j].Tag })
+		s.Entities[entity] = tags
+	}
+	for _, l := range t.links {
+		s.Links = append(s.Links, snapshotLink{
+			Alias:   l.alias.keyValue,
+			Device:  t.devices[l.device].tag.keyValue,
+			Updated: l.updated,
+		})
+	}
+	t.mu.RUnlock()
+	sort.Slice(s.Links, func(i, j int) bool { return s.Links[i].Alias < s.Links[j].Alias })
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return state.WriteFileAtomic(path, data, 0644)
+}
+
+// RestoreSnapshot adds the tags and the links of the file younger than maxAge, the tags are stale until a collector sets them
+// the keys already set on an entity are not restored, a missing or invalid snapshot is ignored
+func (t *Tagger) RestoreSnapshot(path string, maxAge time.Duration) (int, error) {
+	if maxAge <= 0 {
+		maxAge = DefaultSnapshotMaxAge
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	s := &snapshot{}
+	err = json.Unmarshal(data, s)
+	if err != nil {
+		// a corrupted state must not prevent the daemon to start
+		zap.L().Warn("ignoring invalid tagger snapshot", zap.String("path", path), zap.Error(err))
+		return 0, nil
+	}
+
+	now := t.now()
+	restored := 0
+	t.mu.Lock()
+	watched := t.hasWatchers()
+	for entity, tags := range s.Entities {
+		entityTags, ok := t.store[entity]
+		if !ok {
+			entityTags = make(entityStore, len(tags))
+		}
+		var before map[string]map[string]struct{}
+		if watched {
+			before = t.viewsLocked(t.groupLocked(entity, now), now)
+		}
+		live := make(map[tagKey]struct{}, len(entityTags))
+		for key := range entityTags {
+			live[key] = struct{}{}
+		}
+		for _, st := range tags {
+			age := now.Sub(st.Updated)
+			if age < 0 || age > maxAge {
+				continue
+			}
+			i := strings.Index(st.Tag, keyValueJoin)
+			if i <= 0 || i+1 >= len(st.Tag) {
+				continue
+			}
+			key, value := tagKey(st.Tag[:i]), tagValue(st.Tag[i+1:])
+			if _, ok := live[key]; ok {
+				continue
+			}
+			values := entityTags[key]
+			if values == nil {
+				values = make(map[tagValue]*tagEntry, 1)
+				entityTags[key] = values
+			}
+			t.indexAdd(entity, key, value)
+			values[value] = &tagEntry{
+				keyValue: st.Tag,
+				updated:  st.Updated,
+				ttl:      st.TTL,
+				stale:    true,
+				source:   st.Source,
+			}
+			restored++
+		}
+		if len(entityTags) > 0 {
+			t.store[entity] = entityTags
+		}
+		if watched {
+			t.notifyViews("", before, t.groupLocked(entity, now), now)
+		}
+	}
+	t.restoreLinks(s.Links, maxAge, now)
+	t.mu.Unlock()
+	zap.L().Info("restored tagger snapshot",
+		zap.String("path", path),
+		zap.Int("tags", restored),
+		zap.Duration("snapshotAge", now.Sub(s.Time)),
+	)
+	return restored, nil
+}
+
+// restoreLinks must be called with the lock, the aliases already linked are not restored
+func (t *Tagger) restoreLinks(links []snapshotLink, maxAge time.Duration, now time.Time) {
+	for _, sl := range links {
+		age := now.Sub(sl.Updated)
+		if age < 0 || age > maxAge {
+			continue
+		}
+		alias, err := parseTag(sl.Alias)
+		if err != nil {
+			continue
+		}
+		device, err := parseTag(sl.Device)
+		if err != nil {
+			continue
+		}
+		if _, ok := t.links[string(alias.value)]; ok {
+			continue
+		}
+		if _, ok := t.links[string(device.value)]; ok {
+			// the device became the alias of another one
+			continue
+		}
+		var before map[string]map[string]struct{}
+		watched := t.hasWatchers()
+		if watched {
+			before = t.viewsLocked(t.groupLocked(string(device.value), now), now)
+		}
+		t.linkAliasLocked(device, alias, sl.Updated)
+		if watched {
+			t.notifyViews("", before, t.groupLocked(string(device.value), now), now)
+		}
+	}
+}
+
+// RunSnapshots periodically saves the snapshot until the context is done, then saves it a last time
+func (t *Tagger) RunSnapshots(ctx context.Context, path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	zctx := zap.L().With(zap.String("path", path))
+	for {
+		select {
+		case <-ctx.Done():
+			err := t.SaveSnapshot(path)
+			if err != nil {
+				zctx.Error("failed to save tagger snapshot", zap.Error(err))
+				return
+			}
+			zctx.Info("saved tagger snapshot")
+			return
+
+		case <-ticker.C:
+			err := t.SaveSnapshot(path)
+			if err != nil {
+				zctx.Error("failed to save tagger snapshot", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package tagger
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tagger.json")
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+	saved := NewTagger()
+	saved.now = func() time.Time { return now.Add(-time.Hour * 48) }
+	saved.Update("192.168.1.2", NewTagUnsafe("lease", "old-phone"))
+	saved.now = func() time.Time { return now.Add(-time.Minute) }
+	saved.Update("192.168.1.1", NewTagUnsafe("lease", "laptop"), NewTagUnsafe("mac", "aa:bb:cc:dd:ee:ff"))
+	saved.Add("192.168.1.1", NewTagUnsafe("ssid", "home"), NewTagUnsafe("ssid", "guest"))
+	saved.Update("router", NewTagUnsafe("role", "router"))
+	require.NoError(t, saved.SaveSnapshot(path))
+
+	restored := NewTagger()
+	restored.now = func() time.Time { return now }
+	restored.Update("router", NewTagUnsafe("role", "gateway"))
+	n, err := restored.RestoreSnapshot(path, time.Hour*24)
+	require.NoError(t, err)
+	assert.Equal(t, 4, n)
+	assert.Equal(t, 4.0, restored.Stats().Stale)
+
+	assert.Equal(t, []string{"lease:laptop", "mac:aa:bb:cc:dd:ee:ff", "ssid:guest", "ssid:home"}, restored.Get("192.168.1.1"))
+	// too old
+	assert.Equal(t, []string{}, restored.Get("192.168.1.2"))
+	// the live keys are kept
+	assert.Equal(t, []string{"role:gateway"}, restored.Get("router"))
+
+	// confirmed by a collector
+	restored.Update("192.168.1.1", NewTagUnsafe("lease", "laptop"))
+	assert.Equal(t, 3.0, restored.Stats().Stale)
+	restored.Add("192.168.1.1", NewTagUnsafe("ssid", "home"))
+	assert.Equal(t, []string{"lease:laptop", "mac:aa:bb:cc:dd:ee:ff", "ssid:home"}, restored.Get("192.168.1.1"))
+	assert.Equal(t, 1.0, restored.Stats().Stale)
+	restored.Replace("192.168.1.1", NewTagUnsafe("lease", "laptop"))
+	assert.Equal(t, 0.0, restored.Stats().Stale)
+}
+
+func TestRestoreSnapshotInvalid(t *testing.T) {
+	dir := t.TempDir()
+	tagger := NewTagger()
+
+	n, err := tagger.RestoreSnapshot(filepath.Join(dir, "missing.json"), 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	corrupted := filepath.Join(dir, "tagger.json")
+	require.NoError(t, os.WriteFile(corrupted, []byte(`{"entities":`), 0644))
+	n, err = tagger.RestoreSnapshot(corrupted, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
@@ -7,6 +7,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"time"
 )
 
 const (
@@ -19,12 +20,22 @@ type Tag struct {
 	key      tagKey
 	value    tagValue
 	keyValue string
+	// original is the tag before its normalisation, empty when unchanged
+	original string
 }
 
 func (t *Tag) String() string {
 	return t.keyValue
 }
 
+// Original returns the tag before its normalisation, like lease:John's iPhone for lease:john_s_iphone
+func (t *Tag) Original() string {
+	if t.original == "" {
+		return t.keyValue
+	}
+	return t.original
+}
+
 /*
 
 Add "entity" -> "keyOne" -> "valueOne" -> "keyOne:valueOne"
@@ -35,6 +46,7 @@ Update "entity" -> "keyOne" -> "valueThree" -> "keyOne:valueThree"
 with the same entity/key we have the updated following tags "keyOne:valueThree"
 */
 
+// NewTagUnsafe doesn't normalise the tag, the key and the value must already follow the Datadog rules
 func NewTagUnsafe(key, value string) *Tag {
 	return &Tag{
 		key:      tagKey(key),
@@ -43,6 +55,7 @@ func NewTagUnsafe(key, value string) *Tag {
 	}
 }
 
+// NewTag returns the tag normalised with the Datadog rules, like the values of untrusted sources such as the DHCP hostnames
 func NewTag(key, value string) (*Tag, error) {
 	if key == "" {
 		return nil, errors.New("empty key")
@@ -50,13 +63,23 @@ func NewTag(key, value string) (*Tag, error) {
 	if value == "" {
 		return nil, errors.New("empty value")
 	}
-	return &Tag{
-		key:      tagKey(key),
-		value:    tagValue(value),
-		keyValue: key + keyValueJoin + value,
-	}, nil
+	tag, ok := normaliseTag(key, value)
+	if !ok {
+		return nil, errors.New("invalid tag: " + key + keyValueJoin + value)
+	}
+	return tag, nil
 }
 
+// parseTag returns the tag key:value as is
+func parseTag(s string) (*Tag, error) {
+	index := strings.Index(s, keyValueJoin)
+	if index <= 0 || index+1 >= len(s) {
+		return nil, errors.New("invalid tag: " + s)
+	}
+	return NewTagUnsafe(s[:index], s[index+1:]), nil
+}
+
+// CreateTags returns the tags key:value normalised with the Datadog rules
 func CreateTags(s ...string) ([]*Tag, error) {
 	var tags []*Tag
 	for _, t := range s {
@@ -64,7 +87,11 @@ func CreateTags(s ...string) ([]*Tag, error) {
 		if index == -1 || index+1 >= len(t) {
 			return tags, errors.New("invalid tag: " + t)
 		}
-		tags = append(tags, NewTagUnsafe(t[:index], t[index+1:]))
+		tag, err := NewTag(t[:index], t[index+1:])
+		if err != nil {
+			return tags, err
+		}
+		tags = append(tags, tag)
 	}
 	return tags, nil
 }
@@ -73,70 +100,206 @@ type (
 	tagKey   string
 	tagValue string
 
-	//             "key"      "value"  "key:value"
-	entityStore map[tagKey]map[tagValue]string
+	//             "key"      "value"
+	entityStore map[tagKey]map[tagValue]*tagEntry
 
 	//          "host-a"
 	tagStore map[string]entityStore
+
+	//                "key"      "value"          "host-a"
+	reverseIndex map[tagKey]map[tagValue]map[string]struct{}
 )
 
+// tagEntry is a tag of an entity
+type tagEntry struct {
+	keyValue string
+	// updated is the last time a collector set the tag
+	updated time.Time
+	// ttl of the tag set by the call, 0 uses the TTL of the key
+	ttl time.Duration
+	// stale tags are restored from a snapshot and not confirmed yet by a collector
+	stale bool
+	// source is the peer of an imported tag, empty for the local ones
+	source string
+}
+
+// Entry is an exported tag of an entity
+type Entry struct {
+	Tag     string        `json:"tag"`
+	Updated time.Time     `json:"updated"`
+	TTL     time.Duration `json:"ttl,omitempty"`
+	// Source is the peer of an imported tag, empty for the local ones
+	Source string `json:"source,omitempty"`
+}
+
+func (e *tagEntry) entry() Entry {
+	return Entry{Tag: e.keyValue, Updated: e.updated, TTL: e.ttl, Source: e.source}
+}
+
 type Tagger struct {
 	store tagStore
+	// index of the entities by tag, updated along with the store
+	index reverseIndex
 
-	mu *sync.RWMutex
+	// defaultTTL and keyTTLs are 0 when the tags never expire
+	defaultTTL      time.Duration
+	keyTTLs         map[tagKey]time.Duration
+	expiredTags     float64
+	expiredEntities float64
+
+	// enrichment adds static tags to the entities
+	enrichment *Enrichment
+
+	// links of the aliases to their device and the devices with their aliases
+	links        map[string]*identityLink
+	devices      map[string]*identityDevice
+	expiredLinks float64
+
+	// originals of the normalised values of the index, to report the collisions
+	originals     map[tagKey]map[tagValue]*normalisedValue
+	tagCollisions float64
+
+	// watchers receive the changes of the tags
+	watchers     map[*Watcher]struct{}
+	watchDropped float64
+
+	mu  *sync.RWMutex
+	now func() time.Time
 }
 
 func NewTagger() *Tagger {
 	return &Tagger{
-		store: make(tagStore),
-		mu:    &sync.RWMutex{},
+		store:     make(tagStore),
+		index:     make(reverseIndex),
+		originals: make(map[tagKey]map[tagValue]*normalisedValue),
+		keyTTLs:   make(map[tagKey]time.Duration),
+		watchers:  make(map[*Watcher]struct{}),
+		links:     make(map[string]*identityLink),
+		devices:   make(map[string]*identityDevice),
+		mu:        &sync.RWMutex{},
+		now:       time.Now,
+	}
+}
+
+func newEntry(tag *Tag, now time.Time, ttl time.Duration) *tagEntry {
+	return &tagEntry{
+		keyValue: tag.keyValue,
+		updated:  now,
+		ttl:      ttl,
 	}
 }
 
 func (t *Tagger) Add(entity string, tags ...*Tag) {
+	t.AddWithTTL(entity, 0, tags...)
+}
+
+// AddWithTTL adds the tags expiring after ttl, 0 uses the TTL of their key
+func (t *Tagger) AddWithTTL(entity string, ttl time.Duration, tags ...*Tag) {
+	now := t.now()
 	t.mu.Lock()
-	entityTags, hasEntity := t.store[entity]
-	if !hasEntity {
+	t.registerOriginals(tags)
+	entityTags, ok := t.store[entity]
+	if !ok {
 		entityTags = make(entityStore, 1)
 	}
+	var before map[string]map[string]struct{}
+	watched := t.hasWatchers()
+	if watched {
+		before = t.viewsLocked(t.groupLocked(entity, now), now)
+	}
 	for _, tag := range tags {
-		if len(entityTags[tag.key]) > 0 {
-			entityTags[tag.key][tag.value] = tag.keyValue
+		t.indexAdd(entity, tag.key, tag.value)
+		values := entityTags[tag.key]
+		if len(values) == 0 {
+			entityTags[tag.key] = map[tagValue]*tagEntry{
+				tag.value: newEntry(tag, now, ttl),
+			}
 			continue
 		}
-		entityTags[tag.key] = map[tagValue]string{
-			tag.value: tag.keyValue,
+		// the restored values of the key are replaced by the confirmed ones
+		for value, e := range values {
+			if e.stale && value != tag.value {
+				delete(values, value)
+				t.indexRemove(entity, tag.key, value)
+			}
 		}
+		values[tag.value] = newEntry(tag, now, ttl)
 	}
 	t.store[entity] = entityTags
+	if watched {
+		t.notifyViews("", before, t.groupLocked(entity, now), now)
+	}
 	t.mu.Unlock()
 }
 
 // Update any existing tag key regardless of the value
 func (t *Tagger) Update(entity string, tags ...*Tag) {
+	t.UpdateWithTTL(entity, 0, tags...)
+}
+
+// UpdateWithTTL updates the tags expiring after ttl, 0 uses the TTL of their key
+func (t *Tagger) UpdateWithTTL(entity string, ttl time.Duration, tags ...*Tag) {
+	now := t.now()
 	t.mu.Lock()
-	entityTags, hasEntity := t.store[entity]
-	if !hasEntity {
+	t.registerOriginals(tags)
+	entityTags, ok := t.store[entity]
+	if !ok {
 		entityTags = make(entityStore, 1)
 	}
+	var before map[string]map[string]struct{}
+	watched := t.hasWatchers()
+	if watched {
+		before = t.viewsLocked(t.groupLocked(entity, now), now)
+	}
 	for _, tag := range tags {
-		entityTags[tag.key] = map[tagValue]string{
-			tag.value: tag.keyValue,
+		for value := range entityTags[tag.key] {
+			if value != tag.value {
+				t.indexRemove(entity, tag.key, value)
+			}
+		}
+		t.indexAdd(entity, tag.key, tag.value)
+		entityTags[tag.key] = map[tagValue]*tagEntry{
+			tag.value: newEntry(tag, now, ttl),
 		}
 	}
 	t.store[entity] = entityTags
+	if watched {
+		t.notifyViews("", before, t.groupLocked(entity, now), now)
+	}
 	t.mu.Unlock()
 }
 
 func (t *Tagger) Replace(entity string, tags ...*Tag) {
+	t.ReplaceWithTTL(entity, 0, tags...)
+}
+
+// ReplaceWithTTL replaces the tags of the entity by tags expiring after ttl, 0 uses the TTL of their key
+func (t *Tagger) ReplaceWithTTL(entity string, ttl time.Duration, tags ...*Tag) {
+	now := t.now()
 	t.mu.Lock()
+	t.registerOriginals(tags)
+	previous := t.store[entity]
+	var before map[string]map[string]struct{}
+	watched := t.hasWatchers()
+	if watched {
+		before = t.viewsLocked(t.groupLocked(entity, now), now)
+	}
+	for key, values := range previous {
+		for value := range values {
+			t.indexRemove(entity, key, value)
+		}
+	}
 	entityTags := make(entityStore, 1)
 	for _, tag := range tags {
-		entityTags[tag.key] = map[tagValue]string{
-			tag.value: tag.keyValue,
+		t.indexAdd(entity, tag.key, tag.value)
+		entityTags[tag.key] = map[tagValue]*tagEntry{
+			tag.value: newEntry(tag, now, ttl),
 		}
 	}
 	t.store[entity] = entityTags
+	if watched {
+		t.notifyViews("", before, t.groupLocked(entity, now), now)
+	}
 	t.mu.Unlock()
 }
 
@@ -153,14 +316,27 @@ func (t *Tagger) GetWithDefault(entity string, defaultTags ...*Tag) []string {
 }
 
 func (t *Tagger) GetUnstableWithDefault(entity string, defaultTags ...*Tag) []string {
+	now := t.now()
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 
 	tags := make([]string, 0)
 
-	entityTags, ok := t.store[entity]
-	if !ok {
+	view := t.view(entity, 0, now)
+	present := make(map[tagKey]struct{}, len(view))
+	for _, tag := range view {
+		present[tag.key] = struct{}{}
+		tags = append(tags, tag.keyValue)
+	}
+	for _, tag := range t.enrich(entity, view) {
+		present[tag.key] = struct{}{}
+		tags = append(tags, tag.keyValue)
+	}
+	if _, ok := t.store[entity]; !ok {
 		for _, t := range defaultTags {
+			if _, ok := present[t.key]; ok {
+				continue
+			}
 			tags = append(tags, t.keyValue)
 		}
 		return tags
@@ -169,14 +345,8 @@ func (t *Tagger) GetUnstableWithDefault(entity string, defaultTags ...*Tag) []st
 	for _, t := range defaultTags {
 		meetDefault[t.key] = t
 	}
-	for tagKey := range entityTags {
-		for _, keyValue := range entityTags[tagKey] {
-			_, ok := meetDefault[tagKey]
-			if ok {
-				delete(meetDefault, tagKey)
-			}
-			tags = append(tags, keyValue)
-		}
+	for key := range present {
+		delete(meetDefault, key)
 	}
 	for _, t := range meetDefault {
 		tags = append(tags, t.keyValue)
@@ -185,53 +355,106 @@ func (t *Tagger) GetUnstableWithDefault(entity string, defaultTags ...*Tag) []st
 }
 
 func (t *Tagger) GetUnstable(entity string) []string {
+	return t.getUnstable(entity, 0)
+}
+
+// getUnstable ignores the expired tags and, when maxAge > 0, the restored tags, the ones older than maxAge, the identities and the enrichment
+func (t *Tagger) getUnstable(entity string, maxAge time.Duration) []string {
+	now := t.now()
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 
 	tags := make([]string, 0)
 
-	entityTags, ok := t.store[entity]
-	if !ok {
-		return tags
-	}
-	for tagKey := range entityTags {
-		for _, keyValue := range entityTags[tagKey] {
-			tags = append(tags, keyValue)
+	view := t.view(entity, maxAge, now)
+	if maxAge <= 0 {
+		for _, tag := range t.enrich(entity, view) {
+			tags = append(tags, tag.keyValue)
 		}
 	}
+	for _, tag := range view {
+		tags = append(tags, tag.keyValue)
+	}
 	return tags
 }
 
 func (t *Tagger) GetIndexed(entity string) map[string]struct{} {
+	now := t.now()
 	t.mu.RLock()
 	defer t.mu.RUnlock()
 
 	tags := make(map[string]struct{})
 
-	entityTags, ok := t.store[entity]
-	if !ok {
-		return tags
+	view := t.view(entity, 0, now)
+	for _, tag := range t.enrich(entity, view) {
+		tags[tag.keyValue] = struct{}{}
 	}
-	for tagKey := range entityTags {
-		for _, keyValue := range entityTags[tagKey] {
-			tags[keyValue] = struct{}{}
-		}
+	for _, tag := range view {
+		tags[tag.keyValue] = struct{}{}
 	}
 	return tags
 }
 
-func (t *Tagger) Stats() (float64, float64, float64) {
+// Stats of the tagger, the expired tags and entities are counted since the start
+type Stats struct {
+	Entities float64
+	Keys     float64
+	Tags     float64
+	// Stale are the restored tags not confirmed yet by a collector
+	Stale float64
+
+	ExpiredTags     float64
+	ExpiredEntities float64
+
+	EnrichmentRules float64
+
+	Watchers float64
+	// WatchDropped are the events not sent to the watchers with a full buffer
+	WatchDropped float64
+
+	// Imported are the tags imported from the peers
+	Imported float64
+
+	// TagCollisions are the different tags normalised to the same tag, counted since the start
+	TagCollisions float64
+
+	// Devices have Aliases linked to them, the expired links are counted since the start
+	Devices      float64
+	Aliases      float64
+	ExpiredLinks float64
+}
+
+func (t *Tagger) Stats() *Stats {
 	t.mu.RLock()
+	defer t.mu.RUnlock()
 
-	entities, keys, tags := len(t.store), 0, 0
+	stats := &Stats{
+		Entities:        float64(len(t.store)),
+		ExpiredTags:     t.expiredTags,
+		ExpiredEntities: t.expiredEntities,
+		EnrichmentRules: float64(t.enrichment.Len()),
+		Watchers:        float64(len(t.watchers)),
+		WatchDropped:    t.watchDropped,
+		Devices:         float64(len(t.devices)),
+		Aliases:         float64(len(t.links)),
+		ExpiredLinks:    t.expiredLinks,
+		TagCollisions:   t.tagCollisions,
+	}
 	for _, entityTags := range t.store {
-		keys += len(entityTags)
-		for tagKey := range entityTags {
-			tags += len(entityTags[tagKey])
+		stats.Keys += float64(len(entityTags))
+		for _, values := range entityTags {
+			stats.Tags += float64(len(values))
+			for _, e := range values {
+				if e.stale {
+					stats.Stale++
+				}
+				if e.source != "" {
+					stats.Imported++
+				}
+			}
 		}
 	}
-	t.mu.RUnlock()
-	return float64(entities), float64(keys), float64(tags)
+	return stats
 }
 
 func (t *Tagger) Print() {
@@ -242,8 +465,8 @@ func (t *Tagger) Print() {
 	for entity, entityTags := range t.store {
 		tags := make([]string, 0)
 		for _, values := range entityTags {
-			for _, keyValue := range values {
-				tags = append(tags, keyValue)
+			for _, e := range values {
+				tags = append(tags, e.keyValue)
 			}
 		}
 		sort.Strings(tags)
@@ -322,7 +322,7 @@ func TestCreateTags(t *testing.T) {
 		hasError   bool
 	}{
 		"error missing value": {
-			[]string{"1:"},
+			[]string{"a:"},
 			[]*Tag{},
 			true,
 		},
@@ -332,70 +332,87 @@ func TestCreateTags(t *testing.T) {
 			true,
 		},
 		"error 2nd empty": {
-			[]string{"1:1", ""},
+			[]string{"a:1", ""},
 			[]*Tag{},
 			true,
 		},
 		"error 1st empty": {
-			[]string{"", "1:1"},
+			[]string{"", "a:1"},
 			[]*Tag{},
 			true,
 		},
-		"1:1": {
+		"error key without letter": {
 			[]string{"1:1"},
+			[]*Tag{},
+			true,
+		},
+		"a:1": {
+			[]string{"a:1"},
 			[]*Tag{
 				{
-					key:      "1",
+					key:      "a",
 					value:    "1",
-					keyValue: "1:1",
+					keyValue: "a:1",
 				},
 			},
 			false,
 		},
-		"1:1 * 2": {
-			[]string{"1:1", "1:1"},
+		"a:1 * 2": {
+			[]string{"a:1", "a:1"},
 			[]*Tag{
 				{
-					key:      "1",
+					key:      "a",
 					value:    "1",
-					keyValue: "1:1",
+					keyValue: "a:1",
 				},
 				{
-					key:      "1",
+					key:      "a",
 					value:    "1",
-					keyValue: "1:1",
+					keyValue: "a:1",
 				},
 			},
 			false,
 		},
-		"1:1 2:2": {
-			[]string{"1:1", "2:2"},
+		"a:1 b:2": {
+			[]string{"a:1", "b:2"},
 			[]*Tag{
 				{
-					key:      "1",
+					key:      "a",
 					value:    "1",
-					keyValue: "1:1",
+					keyValue: "a:1",
 				},
 				{
-					key:      "2",
+					key:      "b",
 					value:    "2",
-					keyValue: "2:2",
+					keyValue: "b:2",
 				},
 			},
 			false,
 		},
-		"1:1 1:2": {
-			[]string{"1:1", "1:2"},
+		"a:1 a:2": {
+			[]string{"a:1", "a:2"},
 			[]*Tag{
 				{
-					key:      "1",
+					key:      "a",
 					value:    "1",
-					keyValue: "1:1",
+					keyValue: "a:1",
 				},
 				{
-					key:      "1",
+					key:      "a",
 					value:    "2",
-					keyValue: "1:2",
+					keyValue: "a:2",
+				},
+			},
+			false,
+		},
+		"normalised": {
+			[]string{"Env:Home Lab"},
+			[]*Tag{
+				{
+					key:      "env",
+					value:    "home_lab",
+					keyValue: "env:home_lab",
+					original: "Env:Home Lab",
 				},
 			},
 			false,
@@ -420,18 +437,18 @@ func TestNewTag(t *testing.T) {
 		exTag    *Tag
 		hasError bool
 	}{
-		"1:1": {
-			"1",
+		"a:1": {
+			"a",
 			"1",
 			&Tag{
-				"1",
-				"1",
-				"1:1",
+				key:      "a",
+				value:    "1",
+				keyValue: "a:1",
 			},
 			false,
 		},
-		"1:": {
-			"1",
+		"a:": {
+			"a",
 			"",
 			nil,
 			true,
@@ -448,6 +465,23 @@ func TestNewTag(t *testing.T) {
 			nil,
 			true,
 		},
+		"value without valid character": {
+			"lease",
+			"!!",
+			nil,
+			true,
+		},
+		"untrusted value": {
+			"lease",
+			"John's iPhone (2)",
+			&Tag{
+				key:      "lease",
+				value:    "john_s_iphone_2",
+				keyValue: "lease:john_s_iphone_2",
+				original: "lease:John's iPhone (2)",
+			},
+			false,
+		},
 	} {
 		t.Run(desc, func(t *testing.T) {
 			tag, err := NewTag(tc.key, tc.value)
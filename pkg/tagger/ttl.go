@@ -0,0 +1,138 @@
+package tagger
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	DefaultSweepInterval = time.Minute
+)
+
+// SetDefaultTTL sets the TTL of the tags without TTL for their call or their key, 0 never expires them
+func (t *Tagger) SetDefaultTTL(ttl time.Duration) {
+	t.mu.Lock()
+	t.defaultTTL = ttl
+	t.mu.Unlock()
+}
+
+// SetKeyTTL sets the TTL of the tags of the key, like 24h for the DHCP leases, 0 uses the default TTL
+func (t *Tagger) SetKeyTTL(key string, ttl time.Duration) {
+	t.mu.Lock()
+	if ttl <= 0 {
+		delete(t.keyTTLs, tagKey(key))
+	} else {
+		t.keyTTLs[tagKey(key)] = ttl
+	}
+	t.mu.Unlock()
+}
+
+// expired must be called with the lock
+func (t *Tagger) expired(key tagKey, e *tagEntry, now time.Time) bool {
+	ttl := e.ttl
+	if ttl <= 0 {
+		ttl = t.keyTTLs[key]
+	}
+	if ttl <= 0 {
+		ttl = t.defaultTTL
+	}
+	return ttl > 0 && now.Sub(e.updated) > ttl
+}
+
+// LastSeen returns the last time a tag of the entity was set
+func (t *Tagger) LastSeen(entity string) (time.Time, bool) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	var lastSeen time.Time
+	entityTags, ok := t.store[entity]
+	if !ok {
+		return lastSeen, false
+	}
+	for _, values := range entityTags {
+		for _, e := range values {
+			if e.updated.After(lastSeen) {
+				lastSeen = e.updated
+			}
+		}
+	}
+	return lastSeen, true
+}
+
+// GetFresh returns the sorted tags of the entity set by a collector in the last maxAge
+// the restored tags not confirmed yet are ignored
+func (t *Tagger) GetFresh(entity string, maxAge time.Duration) []string {
+	if maxAge <= 0 {
+		return t.Get(entity)
+	}
+	tags := t.getUnstable(entity, maxAge)
+	sort.Strings(tags)
+	return tags
+}
+
+// Sweep removes the expired tags, links and the entities left without tags, it returns the number of removed tags and entities
+func (t *Tagger) Sweep() (int, int) {
+	now := t.now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	tags, entities := 0, 0
+	watched := t.hasWatchers()
+	for entity, entityTags := range t.store {
+		var removed []string
+		for key, values := range entityTags {
+			for value, e := range values {
+				if t.expired(key, e, now) {
+					delete(values, value)
+					t.indexRemove(entity, key, value)
+					removed = append(removed, e.keyValue)
+				}
+			}
+			if len(values) == 0 {
+				delete(entityTags, key)
+			}
+		}
+		if len(removed) == 0 {
+			continue
+		}
+		tags += len(removed)
+		eventType := EventUpdate
+		if len(entityTags) == 0 {
+			delete(t.store, entity)
+			entities++
+			eventType = EventDelete
+		}
+		if watched {
+			sort.Strings(removed)
+			t.notify(eventType, "", entity, nil, removed, now)
+		}
+	}
+	t.expiredTags += float64(tags)
+	t.expiredEntities += float64(entities)
+	t.sweepLinks(now)
+	return tags, entities
+}
+
+// RunSweep periodically removes the expired tags until the context is done
+func (t *Tagger) RunSweep(ctx context.Context, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultSweepInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			tags, entities := t.Sweep()
+			if tags == 0 && entities == 0 {
+				continue
+			}
+			zap.L().Debug("swept expired tags", zap.Int("tags", tags), zap.Int("entities", entities))
+		}
+	}
+}
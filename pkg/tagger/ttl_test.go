@@ -0,0 +1,67 @@
+package tagger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTTL(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tagger := NewTagger()
+	tagger.now = func() time.Time { return now }
+	tagger.SetKeyTTL("lease", time.Hour)
+
+	tagger.Update("192.168.1.1", NewTagUnsafe("lease", "phone"), NewTagUnsafe("mac", "aa:bb:cc:dd:ee:ff"))
+	tagger.UpdateWithTTL("192.168.1.2", time.Minute, NewTagUnsafe("lease", "laptop"))
+	tagger.Add("192.168.1.3", NewTagUnsafe("vendor", "apple"))
+
+	now = now.Add(time.Minute * 30)
+	assert.Equal(t, []string{"lease:phone", "mac:aa:bb:cc:dd:ee:ff"}, tagger.Get("192.168.1.1"))
+	// expired before the sweep
+	assert.Equal(t, []string{}, tagger.Get("192.168.1.2"))
+	assert.Equal(t, []string{"lease:unknown"}, tagger.GetWithDefault("192.168.1.2", NewTagUnsafe("lease", MissingTagValue)))
+	assert.Empty(t, tagger.GetIndexed("192.168.1.2"))
+
+	tags, entities := tagger.Sweep()
+	assert.Equal(t, 1, tags)
+	assert.Equal(t, 1, entities)
+
+	now = now.Add(time.Hour)
+	tagger.SetDefaultTTL(time.Hour * 2)
+	tags, entities = tagger.Sweep()
+	assert.Equal(t, 1, tags)
+	assert.Equal(t, 0, entities)
+	assert.Equal(t, []string{"mac:aa:bb:cc:dd:ee:ff"}, tagger.Get("192.168.1.1"))
+
+	now = now.Add(time.Hour)
+	tags, entities = tagger.Sweep()
+	assert.Equal(t, 2, tags)
+	assert.Equal(t, 2, entities)
+
+	stats := tagger.Stats()
+	assert.Equal(t, 0.0, stats.Entities)
+	assert.Equal(t, 4.0, stats.ExpiredTags)
+	assert.Equal(t, 3.0, stats.ExpiredEntities)
+}
+
+func TestGetFresh(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tagger := NewTagger()
+	tagger.now = func() time.Time { return now }
+
+	tagger.Update("192.168.1.1", NewTagUnsafe("lease", "phone"))
+	now = now.Add(time.Minute * 10)
+	tagger.Update("192.168.1.1", NewTagUnsafe("mac", "aa:bb:cc:dd:ee:ff"))
+
+	lastSeen, ok := tagger.LastSeen("192.168.1.1")
+	require.True(t, ok)
+	assert.Equal(t, now, lastSeen)
+	_, ok = tagger.LastSeen("192.168.1.2")
+	assert.False(t, ok)
+
+	assert.Equal(t, []string{"mac:aa:bb:cc:dd:ee:ff"}, tagger.GetFresh("192.168.1.1", time.Minute*5))
+	assert.Equal(t, []string{"lease:phone", "mac:aa:bb:cc:dd:ee:ff"}, tagger.GetFresh("192.168.1.1", 0))
+}
@@ -0,0 +1,219 @@
+package tagger
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultWatchBuffer is the number of events buffered for a watcher before they are dropped
+	DefaultWatchBuffer = 256
+
+	// EventAdd is sent when an entity gets its first tags
+	EventAdd EventType = "add"
+	// EventUpdate is sent when tags of an entity are added, changed or removed
+	EventUpdate EventType = "update"
+	// EventDelete is sent when an entity loses its last tags
+	EventDelete EventType = "delete"
+)
+
+type EventType string
+
+// Event is a change of the tags of an entity, setting an existing tag again isn't a change
+// the changes of the identities are notified to every identifier of the device, the static tags of the enrichment aren't notified
+type Event struct {
+	Type EventType
+	// Source is the peer of the imported tags, empty for the local changes
+	Source  string
+	Entity  string
+	Added   []string
+	Removed []string
+	Time    time.Time
+}
+
+// WatchFilter selects the events of the Entities and the tags of the Keys, empty selects all of them
+type WatchFilter struct {
+	Entities []string
+	Keys     []string
+}
+
+// Watcher receives the events in C until Close
+type Watcher struct {
+	C <-chan *Event
+
+	c        chan *Event
+	entities map[string]struct{}
+	keys     map[tagKey]struct{}
+	tagger   *Tagger
+	// dropped is updated with the lock of the tagger
+	dropped float64
+}
+
+// Watch returns a Watcher of the changes selected by the filter, nil selects all of them
+func (t *Tagger) Watch(filter *WatchFilter) *Watcher {
+	return t.WatchWithBuffer(filter, DefaultWatchBuffer)
+}
+
+// WatchWithBuffer returns a Watcher buffering size events, the events are dropped when the buffer is full
+func (t *Tagger) WatchWithBuffer(filter *WatchFilter, size int) *Watcher {
+	if size < 0 {
+		size = 0
+	}
+	c := make(chan *Event, size)
+	w := &Watcher{
+		C:      c,
+		c:      c,
+		tagger: t,
+	}
+	if filter != nil {
+		if len(filter.Entities) > 0 {
+			w.entities = make(map[string]struct{}, len(filter.Entities))
+			for _, entity := range filter.Entities {
+				w.entities[entity] = struct{}{}
+			}
+		}
+		if len(filter.Keys) > 0 {
+			w.keys = make(map[tagKey]struct{}, len(filter.Keys))
+			for _, key := range filter.Keys {
+				w.keys[tagKey(key)] = struct{}{}
+			}
+		}
+	}
+	t.mu.Lock()
+	t.watchers[w] = struct{}{}
+	t.mu.Unlock()
+	return w
+}
+
+// Dropped returns the number of events dropped because the buffer was full
+func (w *Watcher) Dropped() float64 {
+	w.tagger.mu.RLock()
+	defer w.tagger.mu.RUnlock()
+	return w.dropped
+}
+
+// Close stops the events and closes C
+func (w *Watcher) Close() {
+	w.tagger.mu.Lock()
+	defer w.tagger.mu.Unlock()
+	if _, ok := w.tagger.watchers[w]; !ok {
+		return
+	}
+	delete(w.tagger.watchers, w)
+	close(w.c)
+}
+
+func (w *Watcher) filterTags(tags []string) []string {
+	if w.keys == nil {
+		return tags
+	}
+	var filtered []string
+	for _, tag := range tags {
+		i := strings.Index(tag, keyValueJoin)
+		if i == -1 {
+			continue
+		}
+		if _, ok := w.keys[tagKey(tag[:i])]; ok {
+			filtered = append(filtered, tag)
+		}
+	}
+	return filtered
+}
+
+// filter returns the event restricted to the keys of the watcher, nil if it isn't selected
+func (w *Watcher) filter(e *Event) *Event {
+	if w.entities != nil {
+		if _, ok := w.entities[e.Entity]; !ok {
+			return nil
+		}
+	}
+	if w.keys == nil {
+		return e
+	}
+	added, removed := w.filterTags(e.Added), w.filterTags(e.Removed)
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+	return &Event{
+		Type:    e.Type,
+		Source:  e.Source,
+		Entity:  e.Entity,
+		Added:   added,
+		Removed: removed,
+		Time:    e.Time,
+	}
+}
+
+// hasWatchers must be called with the lock, it avoids computing the changes without watchers
+func (t *Tagger) hasWatchers() bool {
+	return len(t.watchers) > 0
+}
+
+// notify must be called with the lock, the events are sent without blocking
+func (t *Tagger) notify(eventType EventType, source, entity string, added, removed []string, now time.Time) {
+	if len(added) == 0 && len(removed) == 0 {
+		return
+	}
+	e := &Event{
+		Type:    eventType,
+		Source:  source,
+		Entity:  entity,
+		Added:   added,
+		Removed: removed,
+		Time:    now,
+	}
+	for w := range t.watchers {
+		we := w.filter(e)
+		if we == nil {
+			continue
+		}
+		select {
+		case w.c <- we:
+		default:
+			w.dropped++
+			t.watchDropped++
+		}
+	}
+}
+
+// notifyViews must be called with the lock, it compares the views captured before a change with the current ones
+// the entities are the ones affected after the change, like the new identifiers of a device
+func (t *Tagger) notifyViews(source string, before map[string]map[string]struct{}, entities []string, now time.Time) {
+	affected := make([]string, 0, len(before)+len(entities))
+	for entity := range before {
+		affected = append(affected, entity)
+	}
+	for _, entity := range entities {
+		if _, ok := before[entity]; !ok {
+			affected = append(affected, entity)
+		}
+	}
+	sort.Strings(affected)
+	for _, entity := range affected {
+		previous := before[entity]
+		current := t.viewSet(entity, now)
+		var added, removed []string
+		for tag := range current {
+			if _, ok := previous[tag]; !ok {
+				added = append(added, tag)
+			}
+		}
+		for tag := range previous {
+			if _, ok := current[tag]; !ok {
+				removed = append(removed, tag)
+			}
+		}
+		sort.Strings(added)
+		sort.Strings(removed)
+
+		eventType := EventUpdate
+		switch {
+		case len(previous) == 0 && len(current) > 0:
+			eventType = EventAdd
+		case len(previous) > 0 && len(current) == 0:
+			eventType = EventDelete
+		}
+		t.notify(eventType, source, entity, added, removed, now)
+	}
+}
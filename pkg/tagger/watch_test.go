@@ -0,0 +1,75 @@
+package tagger
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receive(t *testing.T, w *Watcher) *Event {
+	select {
+	case e := <-w.C:
+		return e
+	default:
+		require.FailNow(t, "no event")
+		return nil
+	}
+}
+
+func TestWatch(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	tagger := NewTagger()
+	tagger.now = func() time.Time { return now }
+	tagger.SetKeyTTL("lease", time.Minute)
+	tagger.SetKeyTTL("mac", time.Minute)
+	all := tagger.Watch(nil)
+	leases := tagger.Watch(&WatchFilter{Keys: []string{"lease"}})
+	router := tagger.Watch(&WatchFilter{Entities: []string{"192.168.1.1"}})
+
+	tagger.Update("192.168.1.10", NewTagUnsafe("lease", "phone"), NewTagUnsafe("mac", "aa-bb-cc-dd-ee-ff"))
+	assert.Equal(t, &Event{Type: EventAdd, Entity: "192.168.1.10", Added: []string{"lease:phone", "mac:aa-bb-cc-dd-ee-ff"}, Time: now}, receive(t, all))
+	assert.Equal(t, &Event{Type: EventAdd, Entity: "192.168.1.10", Added: []string{"lease:phone"}, Time: now}, receive(t, leases))
+
+	// not a change
+	tagger.Update("192.168.1.10", NewTagUnsafe("lease", "phone"))
+	tagger.Add("192.168.1.10", NewTagUnsafe("mac", "aa-bb-cc-dd-ee-ff"))
+
+	tagger.Update("192.168.1.10", NewTagUnsafe("mac", "aa-bb-cc-dd-ee-00"))
+	assert.Equal(t, &Event{Type: EventUpdate, Entity: "192.168.1.10", Added: []string{"mac:aa-bb-cc-dd-ee-00"}, Removed: []string{"mac:aa-bb-cc-dd-ee-ff"}, Time: now}, receive(t, all))
+
+	tagger.Replace("192.168.1.1", NewTagUnsafe("role", "router"))
+	assert.Equal(t, &Event{Type: EventAdd, Entity: "192.168.1.1", Added: []string{"role:router"}, Time: now}, receive(t, all))
+	assert.Equal(t, &Event{Type: EventAdd, Entity: "192.168.1.1", Added: []string{"role:router"}, Time: now}, receive(t, router))
+
+	now = now.Add(time.Minute * 2)
+	tagger.Sweep()
+	assert.Equal(t, &Event{Type: EventDelete, Entity: "192.168.1.10", Removed: []string{"lease:phone", "mac:aa-bb-cc-dd-ee-00"}, Time: now}, receive(t, all))
+	assert.Equal(t, &Event{Type: EventDelete, Entity: "192.168.1.10", Removed: []string{"lease:phone"}, Time: now}, receive(t, leases))
+
+	assert.Empty(t, all.C)
+	assert.Empty(t, leases.C)
+	assert.Empty(t, router.C)
+	assert.Equal(t, 3.0, tagger.Stats().Watchers)
+
+	all.Close()
+	all.Close()
+	_, ok := <-all.C
+	assert.False(t, ok)
+	assert.Equal(t, 2.0, tagger.Stats().Watchers)
+}
+
+func TestWatchDropped(t *testing.T) {
+	tagger := NewTagger()
+	w := tagger.WatchWithBuffer(nil, 1)
+	defer w.Close()
+
+	tagger.Update("192.168.1.10", NewTagUnsafe("lease", "phone"))
+	tagger.Update("192.168.1.11", NewTagUnsafe("lease", "laptop"))
+	tagger.Update("192.168.1.12", NewTagUnsafe("lease", "tv"))
+
+	assert.Equal(t, "192.168.1.10", receive(t, w).Entity)
+	assert.Equal(t, 2.0, w.Dropped())
+	assert.Equal(t, 2.0, tagger.Stats().WatchDropped)
+}
@@ -0,0 +1,8 @@
+package generated
+
+import _ "embed"
+
+// CABundle is the Mozilla CA bundle in PEM, updated with make generate
+//
+//go:embed cacert.pem
+var CABundle []byte
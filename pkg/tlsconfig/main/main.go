@@ -0,0 +1,47 @@
+//go:build ignore
+
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	bundleURL  = "https://curl.se/ca/cacert.pem"
+	bundlePath = "pkg/tlsconfig/generated/cacert.pem"
+)
+
+func generateBundle() error {
+	c := &http.Client{
+		Timeout: time.Second * 30,
+	}
+	resp, err := c.Get(bundleURL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("request for URL %s returned HTTP status %s", bundleURL, resp.Status)
+	}
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(b) {
+		return errors.New("no certificate in " + bundleURL)
+	}
+	return os.WriteFile(bundlePath, b, 0644)
+}
+
+func main() {
+	err := generateBundle()
+	if err != nil {
+		panic(err)
+	}
+}
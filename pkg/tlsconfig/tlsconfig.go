@@ -0,0 +1,186 @@
+package tlsconfig
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/JulienBalestra/monitoring/pkg/tlsconfig/generated"
+	"go.uber.org/zap"
+)
+
+const (
+	// OptionCAFile replaces the default authorities of a collector
+	OptionCAFile   = "tls-ca-file"
+	OptionCertFile = "tls-cert-file"
+	OptionKeyFile  = "tls-key-file"
+	// OptionServerName overrides the SNI and the name verified in the certificate
+	OptionServerName = "tls-server-name"
+	// OptionPinnedSHA256 is a comma separated list of SHA-256 fingerprints of the accepted server certificates
+	OptionPinnedSHA256 = "tls-pinned-sha256"
+	// OptionInsecureSkipVerify accepts any server certificate when "true"
+	OptionInsecureSkipVerify = "tls-insecure-skip-verify"
+)
+
+var (
+	rootCAs     *x509.CertPool
+	rootCAsOnce sync.Once
+)
+
+// RootCAs returns the system authorities or the embedded bundle when the system has none, like on dd-wrt
+func RootCAs() *x509.CertPool {
+	rootCAsOnce.Do(func() {
+		pool, err := x509.SystemCertPool()
+		if err == nil && !pool.Equal(x509.NewCertPool()) {
+			rootCAs = pool
+			return
+		}
+		zap.L().Info("using the embedded certificate authorities", zap.Error(err))
+		rootCAs = x509.NewCertPool()
+		rootCAs.AppendCertsFromPEM(generated.CABundle)
+	})
+	return rootCAs
+}
+
+// LoadCAFile returns the authorities of the PEM file
+func LoadCAFile(path string) (*x509.CertPool, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("no certificate in CA file %s", path)
+	}
+	return pool, nil
+}
+
+// Config of a TLS client, the zero value verifies the server with RootCAs
+type Config struct {
+	// CAFile replaces RootCAs
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate and its key
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the SNI and the name verified in the certificate
+	ServerName string
+	// PinnedSHA256 are the fingerprints of the accepted server certificates, see Fingerprint
+	// the chain isn't verified when set, this allows the self-signed certificates of devices like Google Home
+	PinnedSHA256 []string
+	// InsecureSkipVerify accepts any server certificate
+	InsecureSkipVerify bool
+}
+
+// FromOptions reads the TLS options of a collector, defaultCAFile is used when OptionCAFile isn't set
+func FromOptions(options map[string]string, defaultCAFile string) (*Config, error) {
+	c := &Config{
+		CAFile:     options[OptionCAFile],
+		CertFile:   options[OptionCertFile],
+		KeyFile:    options[OptionKeyFile],
+		ServerName: options[OptionServerName],
+	}
+	if c.CAFile == "" {
+		c.CAFile = defaultCAFile
+	}
+	for _, pin := range strings.Split(options[OptionPinnedSHA256], ",") {
+		pin = strings.TrimSpace(pin)
+		if pin != "" {
+			c.PinnedSHA256 = append(c.PinnedSHA256, pin)
+		}
+	}
+	insecure, ok := options[OptionInsecureSkipVerify]
+	if ok && insecure != "" {
+		var err error
+		c.InsecureSkipVerify, err = strconv.ParseBool(insecure)
+		if err != nil {
+			return nil, fmt.Errorf("invalid option %s=%q: %v", OptionInsecureSkipVerify, insecure, err)
+		}
+	}
+	return c, nil
+}
+
+// Fingerprint returns the hex SHA-256 of the DER certificate, like openssl x509 -noout -fingerprint -sha256 without the colons
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func normalisePin(pin string) string {
+	return strings.ToLower(strings.ReplaceAll(pin, ":", ""))
+}
+
+// TLSConfig builds the tls.Config, the pinned certificates take precedence over InsecureSkipVerify
+func (c *Config) TLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		RootCAs:    RootCAs(),
+		ServerName: c.ServerName,
+	}
+	if c.CAFile != "" {
+		pool, err := LoadCAFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	if len(c.PinnedSHA256) > 0 {
+		pins := make(map[string]struct{}, len(c.PinnedSHA256))
+		for _, pin := range c.PinnedSHA256 {
+			pin = normalisePin(pin)
+			if len(pin) != sha256.Size*2 {
+				return nil, fmt.Errorf("invalid SHA-256 fingerprint %q", pin)
+			}
+			pins[pin] = struct{}{}
+		}
+		// the chain is replaced by the pins
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if len(rawCerts) == 0 {
+				return errors.New("no server certificate")
+			}
+			cert, err := x509.ParseCertificate(rawCerts[0])
+			if err != nil {
+				return err
+			}
+			fingerprint := Fingerprint(cert)
+			if _, ok := pins[fingerprint]; !ok {
+				return fmt.Errorf("server certificate %s isn't pinned", fingerprint)
+			}
+			return nil
+		}
+		return tlsConfig, nil
+	}
+	tlsConfig.InsecureSkipVerify = c.InsecureSkipVerify
+	return tlsConfig, nil
+}
+
+// NewHTTPClient returns a client with the TLS options of a collector, see FromOptions
+func NewHTTPClient(options map[string]string, defaultCAFile string) (*http.Client, error) {
+	c, err := FromOptions(options, defaultCAFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := c.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+		},
+	}, nil
+}
@@ -0,0 +1,137 @@
+package tlsconfig
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/JulienBalestra/monitoring/pkg/tlsconfig/generated"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEmbeddedBundle(t *testing.T) {
+	assert.True(t, x509.NewCertPool().AppendCertsFromPEM(generated.CABundle))
+	assert.NotNil(t, RootCAs())
+}
+
+func TestFromOptions(t *testing.T) {
+	for name, tc := range map[string]struct {
+		options       map[string]string
+		defaultCAFile string
+		exp           *Config
+		err           bool
+	}{
+		"empty": {
+			options: map[string]string{},
+			exp:     &Config{},
+		},
+		"default CA file": {
+			options:       map[string]string{},
+			defaultCAFile: "/etc/ssl/ca.pem",
+			exp:           &Config{CAFile: "/etc/ssl/ca.pem"},
+		},
+		"every option": {
+			options: map[string]string{
+				OptionCAFile:             "/etc/monitoring/ca.pem",
+				OptionCertFile:           "/etc/monitoring/client.pem",
+				OptionKeyFile:            "/etc/monitoring/client-key.pem",
+				OptionServerName:         "router.lan",
+				OptionPinnedSHA256:       "aa, bb,",
+				OptionInsecureSkipVerify: "false",
+			},
+			defaultCAFile: "/etc/ssl/ca.pem",
+			exp: &Config{
+				CAFile:       "/etc/monitoring/ca.pem",
+				CertFile:     "/etc/monitoring/client.pem",
+				KeyFile:      "/etc/monitoring/client-key.pem",
+				ServerName:   "router.lan",
+				PinnedSHA256: []string{"aa", "bb"},
+			},
+		},
+		"insecure": {
+			options: map[string]string{OptionInsecureSkipVerify: "true"},
+			exp:     &Config{InsecureSkipVerify: true},
+		},
+		"invalid insecure": {
+			options: map[string]string{OptionInsecureSkipVerify: "yes please"},
+			err:     true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, err := FromOptions(tc.options, tc.defaultCAFile)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.exp, c)
+		})
+	}
+}
+
+func TestHTTPClient(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	cert := srv.Certificate()
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0644))
+	fingerprint := Fingerprint(cert)
+	colonFingerprint := make([]string, 0, len(fingerprint)/2)
+	for i := 0; i < len(fingerprint); i += 2 {
+		colonFingerprint = append(colonFingerprint, strings.ToUpper(fingerprint[i:i+2]))
+	}
+
+	for name, tc := range map[string]struct {
+		options map[string]string
+		err     bool
+	}{
+		"unknown authority": {
+			options: map[string]string{},
+			err:     true,
+		},
+		"CA file": {
+			options: map[string]string{OptionCAFile: caFile},
+		},
+		"wrong server name": {
+			options: map[string]string{OptionCAFile: caFile, OptionServerName: "router.lan"},
+			err:     true,
+		},
+		"pinned": {
+			options: map[string]string{OptionPinnedSHA256: fingerprint},
+		},
+		"pinned with colons": {
+			options: map[string]string{OptionPinnedSHA256: strings.Join(colonFingerprint, ":")},
+		},
+		"not pinned": {
+			options: map[string]string{OptionPinnedSHA256: strings.Repeat("0", 64), OptionInsecureSkipVerify: "true"},
+			err:     true,
+		},
+		"insecure": {
+			options: map[string]string{OptionInsecureSkipVerify: "true"},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, err := NewHTTPClient(tc.options, "")
+			require.NoError(t, err)
+			resp, err := c.Get(srv.URL)
+			if tc.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+		})
+	}
+
+	_, err := NewHTTPClient(map[string]string{OptionPinnedSHA256: "aa"}, "")
+	assert.Error(t, err)
+	_, err = NewHTTPClient(map[string]string{}, filepath.Join(t.TempDir(), "missing.pem"))
+	assert.Error(t, err)
+}